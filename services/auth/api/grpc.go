@@ -1,12 +1,11 @@
-package main
+package api
 
 import (
 	"context"
 
 	"github.com/Omar-Belghaouti/pdash/services/auth/data"
-	"github.com/Omar-Belghaouti/pdash/services/auth/pb"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
 )
 
 type server struct {
@@ -16,11 +15,12 @@ type server struct {
 // VerifyToken implementation for Auth gRPC server
 func (s *server) VerifyToken(ctx context.Context, in *pb.Auth) (*pb.Auth, error) {
 	accessToken := in.GetAccessToken()
-	_, err := data.TokenMaker.VerifyToken(accessToken)
+	payload, err := data.TokenMaker.VerifyToken(accessToken)
 	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %s", err.Error())
+		return nil, problem.Unauthorized("invalid_token", "invalid token: "+err.Error())
 	}
 	return &pb.Auth{
 		AccessToken: accessToken,
+		Username:    payload.Username,
 	}, nil
 }
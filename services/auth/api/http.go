@@ -0,0 +1,101 @@
+// Package api contains the http and gRPC servers of the auth service
+package api
+
+import (
+	"net/http"
+
+	"github.com/Omar-Belghaouti/pdash/services/auth/data"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	swagger "github.com/arsmn/fiber-swagger/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"google.golang.org/grpc"
+)
+
+// NewApp creates the http application of the service
+func NewApp() *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+
+	// CORS
+	app.Use(cors.New(cors.Config{
+		AllowOrigins: "*",
+		AllowMethods: "POST",
+	}))
+
+	// Request timeout
+	app.Use(middleware.Timeout(data.Config().RequestTimeout))
+
+	// Swagger
+	app.Get("/swagger/*", swagger.HandlerDefault)
+
+	// Create a new user
+	app.Post("/users", CreateUser)
+
+	// Login a user
+	app.Post("/users/login", LoginUser)
+
+	return app
+}
+
+// NewGRPCServer creates the gRPC server of the service
+func NewGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(problem.UnaryServerInterceptor),
+		grpc.StreamInterceptor(problem.StreamServerInterceptor),
+	)
+	pb.RegisterAuthServiceServer(s, &server{})
+	return s
+}
+
+// CreateUser creates a new user
+// @Summary Create a new user
+// @Description Create a new user
+// @ID create-user
+// @Accept  json
+// @Produce  json
+// @Param user body data.User true "User"
+// @Success 201 {object} data.User
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users [post]
+func CreateUser(c *fiber.Ctx) error {
+	user := data.User{}
+	if err := c.BodyParser(&user); err != nil {
+		return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+	}
+	user, err := data.CreateUser(c.UserContext(), user)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusCreated).JSON(user)
+}
+
+// LoginUser logs in a user
+// @Summary Login a user
+// @Description Login a user
+// @ID login-user
+// @Accept  json
+// @Produce  json
+// @Param user body data.LoginUserRequest true "User"
+// @Success 200 {object} data.LoginUserResponse
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/login [post]
+func LoginUser(c *fiber.Ctx) error {
+	req := data.LoginUserRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+	}
+	user, err := data.LoginUser(c.UserContext(), req)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(user)
+}
@@ -2,13 +2,18 @@ package data
 
 import (
 	"context"
-	"errors"
 	"log"
-	"net/http"
 	"time"
 
 	"github.com/Omar-Belghaouti/pdash/services/auth/token"
 	"github.com/Omar-Belghaouti/pdash/services/auth/util"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/outbox"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/tx"
+	"github.com/go-redis/redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -16,12 +21,26 @@ import (
 )
 
 var (
-	ctx        context.Context
-	collection *mongo.Collection
+	db         *mongo.Database
+	collection Collection
+	box        *outbox.Outbox
+	transact   tx.Func
+	bus        *events.Publisher
 	config     util.Config
 	TokenMaker *token.PasetoMaker
 )
 
+// Collection is the subset of *mongo.Collection used by the data package
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+}
+
+// Config returns the configuration loaded by the data package
+func Config() util.Config {
+	return config
+}
+
 func init() {
 	var err error
 	config, err = util.LoadConfig(".")
@@ -32,12 +51,72 @@ func init() {
 	if err != nil {
 		log.Fatalf("cannot create token maker: %s", err.Error())
 	}
-	ctx = context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %s", err.Error())
 	}
-	collection = client.Database("db").Collection("users")
+	db = client.Database("db")
+	collection = db.Collection("users")
+	box = outbox.New(db.Collection("users_outbox"))
+	transact = tx.Mongo(client)
+	bus = events.NewPublisher(redis.NewClient(&redis.Options{
+		Addr: config.RedisAddr,
+	}))
+}
+
+// Use replaces the collection, the outbox collection and the Redis client
+// used by the data package, e.g. with in-memory stand-ins that have no
+// transactions nor migrations. The usernames of c must be unique.
+func Use(c Collection, o outbox.Collection, r *redis.Client) {
+	db = nil
+	collection = c
+	box = outbox.New(o)
+	transact = tx.None
+	bus = events.NewPublisher(r)
+}
+
+// Migrations are the schema migrations of the users collection
+var Migrations = []migrate.Migration{
+	migrate.CreateIndexes(1, "make the usernames unique", "users", mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}),
+	migrate.CreateIndexes(2, "index the users outbox", "users_outbox", outbox.Indexes()...),
+}
+
+// Migrator returns the Migrator of the users database, it is only available
+// with MongoDB
+func Migrator() *migrate.Migrator {
+	return migrate.New(db, "auth", Migrations)
+}
+
+// Migrate applies the pending migrations, in-memory collections have none
+func Migrate(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	_, err := Migrator().Up(ctx, 0)
+	return err
+}
+
+// record adds the event typ of user, made by the user, to the outbox. ctx
+// must be the one of the transaction storing the change, if any.
+func record(ctx context.Context, typ string, user User, data interface{}) error {
+	e, err := events.New(typ, user.ID.Hex(), user.Username, data)
+	if err != nil {
+		return err
+	}
+	return box.Add(ctx, e)
+}
+
+// RelayOutbox publishes the events of the outbox to the event bus every
+// interval and as soon as an event is stored, until ctx is done
+func RelayOutbox(ctx context.Context, interval time.Duration) {
+	box.Run(ctx, interval, "users outbox relay", func(ctx context.Context, e events.Event) error {
+		redisCtx, cancel := middleware.WithTimeout(ctx, config.RedisTimeout)
+		defer cancel()
+		return bus.PublishEvent(redisCtx, e)
+	})
 }
 
 // User struct is a representation of a User document
@@ -64,54 +143,83 @@ type LoginUserResponse struct {
 }
 
 // CreateUser creates a new user
-func CreateUser(user User) (User, int, error) {
+func CreateUser(ctx context.Context, user User) (User, error) {
 	// check if user already exists
-	existingUser, _ := getUserByUsername(user.Username)
-	if existingUser.ID != primitive.NilObjectID {
-		return user, http.StatusConflict, errors.New("user already exists")
+	_, err := getUserByUsername(ctx, user.Username)
+	if err == nil {
+		return user, problem.Conflict("username_taken", "user already exists")
+	} else if problem.From(err).Kind != problem.KindNotFound {
+		return user, err
 	}
 	user.ID = primitive.NewObjectID()
 	user.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	user.UpdatedAt = user.CreatedAt
 	hashedPassword, err := util.HashPassword(user.Password)
 	if err != nil {
-		return user, http.StatusInternalServerError, err
+		return user, problem.From(err)
 	}
 	user.Password = hashedPassword
-	_, err = collection.InsertOne(ctx, user)
-	if err != nil {
-		return user, http.StatusInternalServerError, err
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		// the unique index on the usernames settles concurrent creations
+		if _, err := collection.InsertOne(ctx, user); err != nil {
+			return err
+		}
+		// the password hash is left out of the event
+		after := user
+		after.Password = ""
+		return record(ctx, "user.created", user, events.Change{After: after})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return user, problem.Conflict("username_taken", "user already exists")
+	} else if err != nil {
+		return user, problem.From(err)
 	}
-	return user, http.StatusCreated, nil
+	box.Notify()
+	return user, nil
 }
 
 // LoginUser logs in a user
-func LoginUser(req LoginUserRequest) (LoginUserResponse, int, error) {
-	user, err := getUserByUsername(req.Username)
+func LoginUser(ctx context.Context, req LoginUserRequest) (LoginUserResponse, error) {
+	user, err := getUserByUsername(ctx, req.Username)
 	if err != nil {
-		return LoginUserResponse{}, http.StatusNotFound, err
+		return LoginUserResponse{}, err
 	}
 	if err := util.CheckPassword(req.Password, user.Password); err != nil {
-		return LoginUserResponse{}, http.StatusUnauthorized, err
+		return LoginUserResponse{}, problem.Unauthorized("invalid_credentials", "invalid username or password")
 	}
 	accessToken, err := TokenMaker.CreateToken(user.Username, config.AccessTokenDuration)
 	if err != nil {
-		return LoginUserResponse{}, http.StatusInternalServerError, err
+		return LoginUserResponse{}, problem.From(err)
+	}
+	// nothing else is stored with a login, the token is issued even when
+	// its event cannot be
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	if err := record(dbCtx, "user.logged_in", user, nil); err != nil {
+		log.Printf("cannot store the user.logged_in event of user %s: %s", user.ID.Hex(), err.Error())
+	} else {
+		box.Notify()
 	}
 	res := LoginUserResponse{
 		AccessToken: accessToken,
 		User:        user,
 	}
-	return res, http.StatusOK, nil
+	return res, nil
 }
 
 // getUserByUsername returns a user by username
-func getUserByUsername(username string) (User, error) {
+func getUserByUsername(ctx context.Context, username string) (User, error) {
 	var user User
 	filter := bson.M{"username": username}
-	err := collection.FindOne(ctx, filter).Decode(&user)
-	if err != nil {
-		return user, err
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err := collection.FindOne(dbCtx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, problem.NotFound("user_not_found", "user not found")
+	} else if err != nil {
+		return user, problem.From(err)
 	}
 	return user, nil
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/auth/api"
+	"github.com/Omar-Belghaouti/pdash/services/auth/data"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setup(t *testing.T) (*fiber.App, pb.AuthServiceClient) {
+	t.Helper()
+	_, rdb := testutil.Redis(t)
+	data.Use(memdb.NewCollection().Unique("username"), memdb.NewCollection(), rdb)
+	cc := testutil.ServeGRPC(t, api.NewGRPCServer())
+	return api.NewApp(), pb.NewAuthServiceClient(cc)
+}
+
+func TestCreateUser(t *testing.T) {
+	app, _ := setup(t)
+	user := data.User{Username: "omar", Password: "secret", Fullname: "Omar", Email: "omar@example.com"}
+
+	res, body := testutil.Request(t, app, http.MethodPost, "/users", user)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", res.StatusCode, body)
+	}
+	var created data.User
+	json.Unmarshal(body, &created)
+	if created.ID.IsZero() || created.Password == user.Password {
+		t.Fatalf("unexpected user %+v", created)
+	}
+
+	res, body = testutil.Request(t, app, http.MethodPost, "/users", user)
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestConcurrentCreateUser(t *testing.T) {
+	app, _ := setup(t)
+	user := data.User{Username: "omar", Password: "secret"}
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _ := testutil.Request(t, app, http.MethodPost, "/users", user)
+			codes <- res.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("expected 201 or 409, got %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("expected a single user to be created, got %d", created)
+	}
+}
+
+func TestLoginAndVerifyToken(t *testing.T) {
+	app, client := setup(t)
+	user := data.User{Username: "omar", Password: "secret"}
+	if res, body := testutil.Request(t, app, http.MethodPost, "/users", user); res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", res.StatusCode, body)
+	}
+
+	tests := []struct {
+		name     string
+		req      data.LoginUserRequest
+		expected int
+	}{
+		{"unknown user", data.LoginUserRequest{Username: "nobody", Password: "secret"}, http.StatusNotFound},
+		{"wrong password", data.LoginUserRequest{Username: "omar", Password: "wrong"}, http.StatusUnauthorized},
+		{"valid credentials", data.LoginUserRequest{Username: "omar", Password: "secret"}, http.StatusOK},
+	}
+	var login data.LoginUserResponse
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodPost, "/users/login", tt.req)
+		if res.StatusCode != tt.expected {
+			t.Fatalf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+		}
+		if res.StatusCode == http.StatusOK {
+			json.Unmarshal(body, &login)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := client.VerifyToken(ctx, &pb.Auth{AccessToken: login.AccessToken}); err != nil {
+		t.Fatalf("expected valid token, got %s", err)
+	}
+	_, err := client.VerifyToken(ctx, &pb.Auth{AccessToken: "not-a-token"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %s", err)
+	}
+}
+
+func TestUserEvents(t *testing.T) {
+	app, _ := setup(t)
+	_, rdb := testutil.Redis(t)
+	data.Use(memdb.NewCollection().Unique("username"), memdb.NewCollection(), rdb)
+	user := data.User{Username: "omar", Password: "secret"}
+	testutil.Request(t, app, http.MethodPost, "/users", user)
+	testutil.Request(t, app, http.MethodPost, "/users/login", data.LoginUserRequest{Username: "omar", Password: "secret"})
+
+	// the events are stored in the outbox and published once it is relayed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if n, _ := rdb.XLen(ctx, events.Stream("user")).Result(); n != 0 {
+		t.Fatalf("expected no event before the relay, got %d", n)
+	}
+	go data.RelayOutbox(ctx, 10*time.Millisecond)
+	testutil.Eventually(2*time.Second, func() bool {
+		n, _ := rdb.XLen(ctx, events.Stream("user")).Result()
+		return n >= 2
+	})
+	msgs, err := rdb.XRange(ctx, events.Stream("user"), "-", "+").Result()
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("expected 2 events, got %v (%v)", msgs, err)
+	}
+	var created struct {
+		After data.User `json:"after"`
+	}
+	json.Unmarshal([]byte(msgs[0].Values["data"].(string)), &created)
+	if msgs[0].Values["type"] != "user.created" || msgs[0].Values["actor"] != "omar" || created.After.Username != "omar" || created.After.Password != "" {
+		t.Errorf("unexpected created event %v", msgs[0].Values)
+	}
+	if msgs[1].Values["type"] != "user.logged_in" {
+		t.Errorf("unexpected login event %v", msgs[1].Values)
+	}
+}
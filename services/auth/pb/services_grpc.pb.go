@@ -1,1005 +0,0 @@
-// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
-// versions:
-// - protoc-gen-go-grpc v1.2.0
-// - protoc             v3.21.5
-// source: pb/services.proto
-
-package pb
-
-import (
-	context "context"
-	grpc "google.golang.org/grpc"
-	codes "google.golang.org/grpc/codes"
-	status "google.golang.org/grpc/status"
-)
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
-
-// OrderServiceClient is the client API for OrderService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type OrderServiceClient interface {
-	GetOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
-	GetAllOrders(ctx context.Context, in *Empty, opts ...grpc.CallOption) (OrderService_GetAllOrdersClient, error)
-	GetAllOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (OrderService_GetAllOrdersByCustomerClient, error)
-	GetAllOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (OrderService_GetAllOrdersBySupplierClient, error)
-	CreateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
-	UpdateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
-	DeleteOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
-}
-
-type orderServiceClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewOrderServiceClient(cc grpc.ClientConnInterface) OrderServiceClient {
-	return &orderServiceClient{cc}
-}
-
-func (c *orderServiceClient) GetOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error) {
-	out := new(Order)
-	err := c.cc.Invoke(ctx, "/pb.OrderService/GetOrder", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *orderServiceClient) GetAllOrders(ctx context.Context, in *Empty, opts ...grpc.CallOption) (OrderService_GetAllOrdersClient, error) {
-	stream, err := c.cc.NewStream(ctx, &OrderService_ServiceDesc.Streams[0], "/pb.OrderService/GetAllOrders", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &orderServiceGetAllOrdersClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
-	return x, nil
-}
-
-type OrderService_GetAllOrdersClient interface {
-	Recv() (*Order, error)
-	grpc.ClientStream
-}
-
-type orderServiceGetAllOrdersClient struct {
-	grpc.ClientStream
-}
-
-func (x *orderServiceGetAllOrdersClient) Recv() (*Order, error) {
-	m := new(Order)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func (c *orderServiceClient) GetAllOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (OrderService_GetAllOrdersByCustomerClient, error) {
-	stream, err := c.cc.NewStream(ctx, &OrderService_ServiceDesc.Streams[1], "/pb.OrderService/GetAllOrdersByCustomer", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &orderServiceGetAllOrdersByCustomerClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
-	return x, nil
-}
-
-type OrderService_GetAllOrdersByCustomerClient interface {
-	Recv() (*Order, error)
-	grpc.ClientStream
-}
-
-type orderServiceGetAllOrdersByCustomerClient struct {
-	grpc.ClientStream
-}
-
-func (x *orderServiceGetAllOrdersByCustomerClient) Recv() (*Order, error) {
-	m := new(Order)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func (c *orderServiceClient) GetAllOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (OrderService_GetAllOrdersBySupplierClient, error) {
-	stream, err := c.cc.NewStream(ctx, &OrderService_ServiceDesc.Streams[2], "/pb.OrderService/GetAllOrdersBySupplier", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &orderServiceGetAllOrdersBySupplierClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
-	return x, nil
-}
-
-type OrderService_GetAllOrdersBySupplierClient interface {
-	Recv() (*Order, error)
-	grpc.ClientStream
-}
-
-type orderServiceGetAllOrdersBySupplierClient struct {
-	grpc.ClientStream
-}
-
-func (x *orderServiceGetAllOrdersBySupplierClient) Recv() (*Order, error) {
-	m := new(Order)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func (c *orderServiceClient) CreateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error) {
-	out := new(Order)
-	err := c.cc.Invoke(ctx, "/pb.OrderService/CreateOrder", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *orderServiceClient) UpdateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error) {
-	out := new(Order)
-	err := c.cc.Invoke(ctx, "/pb.OrderService/UpdateOrder", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *orderServiceClient) DeleteOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error) {
-	out := new(Order)
-	err := c.cc.Invoke(ctx, "/pb.OrderService/DeleteOrder", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// OrderServiceServer is the server API for OrderService service.
-// All implementations must embed UnimplementedOrderServiceServer
-// for forward compatibility
-type OrderServiceServer interface {
-	GetOrder(context.Context, *Order) (*Order, error)
-	GetAllOrders(*Empty, OrderService_GetAllOrdersServer) error
-	GetAllOrdersByCustomer(*Customer, OrderService_GetAllOrdersByCustomerServer) error
-	GetAllOrdersBySupplier(*Supplier, OrderService_GetAllOrdersBySupplierServer) error
-	CreateOrder(context.Context, *Order) (*Order, error)
-	UpdateOrder(context.Context, *Order) (*Order, error)
-	DeleteOrder(context.Context, *Order) (*Order, error)
-	mustEmbedUnimplementedOrderServiceServer()
-}
-
-// UnimplementedOrderServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedOrderServiceServer struct {
-}
-
-func (UnimplementedOrderServiceServer) GetOrder(context.Context, *Order) (*Order, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
-}
-func (UnimplementedOrderServiceServer) GetAllOrders(*Empty, OrderService_GetAllOrdersServer) error {
-	return status.Errorf(codes.Unimplemented, "method GetAllOrders not implemented")
-}
-func (UnimplementedOrderServiceServer) GetAllOrdersByCustomer(*Customer, OrderService_GetAllOrdersByCustomerServer) error {
-	return status.Errorf(codes.Unimplemented, "method GetAllOrdersByCustomer not implemented")
-}
-func (UnimplementedOrderServiceServer) GetAllOrdersBySupplier(*Supplier, OrderService_GetAllOrdersBySupplierServer) error {
-	return status.Errorf(codes.Unimplemented, "method GetAllOrdersBySupplier not implemented")
-}
-func (UnimplementedOrderServiceServer) CreateOrder(context.Context, *Order) (*Order, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreateOrder not implemented")
-}
-func (UnimplementedOrderServiceServer) UpdateOrder(context.Context, *Order) (*Order, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method UpdateOrder not implemented")
-}
-func (UnimplementedOrderServiceServer) DeleteOrder(context.Context, *Order) (*Order, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DeleteOrder not implemented")
-}
-func (UnimplementedOrderServiceServer) mustEmbedUnimplementedOrderServiceServer() {}
-
-// UnsafeOrderServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to OrderServiceServer will
-// result in compilation errors.
-type UnsafeOrderServiceServer interface {
-	mustEmbedUnimplementedOrderServiceServer()
-}
-
-func RegisterOrderServiceServer(s grpc.ServiceRegistrar, srv OrderServiceServer) {
-	s.RegisterService(&OrderService_ServiceDesc, srv)
-}
-
-func _OrderService_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Order)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(OrderServiceServer).GetOrder(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.OrderService/GetOrder",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(OrderServiceServer).GetOrder(ctx, req.(*Order))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _OrderService_GetAllOrders_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(Empty)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(OrderServiceServer).GetAllOrders(m, &orderServiceGetAllOrdersServer{stream})
-}
-
-type OrderService_GetAllOrdersServer interface {
-	Send(*Order) error
-	grpc.ServerStream
-}
-
-type orderServiceGetAllOrdersServer struct {
-	grpc.ServerStream
-}
-
-func (x *orderServiceGetAllOrdersServer) Send(m *Order) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _OrderService_GetAllOrdersByCustomer_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(Customer)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(OrderServiceServer).GetAllOrdersByCustomer(m, &orderServiceGetAllOrdersByCustomerServer{stream})
-}
-
-type OrderService_GetAllOrdersByCustomerServer interface {
-	Send(*Order) error
-	grpc.ServerStream
-}
-
-type orderServiceGetAllOrdersByCustomerServer struct {
-	grpc.ServerStream
-}
-
-func (x *orderServiceGetAllOrdersByCustomerServer) Send(m *Order) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _OrderService_GetAllOrdersBySupplier_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(Supplier)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(OrderServiceServer).GetAllOrdersBySupplier(m, &orderServiceGetAllOrdersBySupplierServer{stream})
-}
-
-type OrderService_GetAllOrdersBySupplierServer interface {
-	Send(*Order) error
-	grpc.ServerStream
-}
-
-type orderServiceGetAllOrdersBySupplierServer struct {
-	grpc.ServerStream
-}
-
-func (x *orderServiceGetAllOrdersBySupplierServer) Send(m *Order) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _OrderService_CreateOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Order)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(OrderServiceServer).CreateOrder(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.OrderService/CreateOrder",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(OrderServiceServer).CreateOrder(ctx, req.(*Order))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _OrderService_UpdateOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Order)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(OrderServiceServer).UpdateOrder(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.OrderService/UpdateOrder",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(OrderServiceServer).UpdateOrder(ctx, req.(*Order))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _OrderService_DeleteOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Order)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(OrderServiceServer).DeleteOrder(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.OrderService/DeleteOrder",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(OrderServiceServer).DeleteOrder(ctx, req.(*Order))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// OrderService_ServiceDesc is the grpc.ServiceDesc for OrderService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var OrderService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.OrderService",
-	HandlerType: (*OrderServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetOrder",
-			Handler:    _OrderService_GetOrder_Handler,
-		},
-		{
-			MethodName: "CreateOrder",
-			Handler:    _OrderService_CreateOrder_Handler,
-		},
-		{
-			MethodName: "UpdateOrder",
-			Handler:    _OrderService_UpdateOrder_Handler,
-		},
-		{
-			MethodName: "DeleteOrder",
-			Handler:    _OrderService_DeleteOrder_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "GetAllOrders",
-			Handler:       _OrderService_GetAllOrders_Handler,
-			ServerStreams: true,
-		},
-		{
-			StreamName:    "GetAllOrdersByCustomer",
-			Handler:       _OrderService_GetAllOrdersByCustomer_Handler,
-			ServerStreams: true,
-		},
-		{
-			StreamName:    "GetAllOrdersBySupplier",
-			Handler:       _OrderService_GetAllOrdersBySupplier_Handler,
-			ServerStreams: true,
-		},
-	},
-	Metadata: "pb/services.proto",
-}
-
-// SupplierServiceClient is the client API for SupplierService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type SupplierServiceClient interface {
-	GetSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
-	GetAllSuppliers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (SupplierService_GetAllSuppliersClient, error)
-	CreateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
-	UpdateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
-	DeleteSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
-}
-
-type supplierServiceClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewSupplierServiceClient(cc grpc.ClientConnInterface) SupplierServiceClient {
-	return &supplierServiceClient{cc}
-}
-
-func (c *supplierServiceClient) GetSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error) {
-	out := new(Supplier)
-	err := c.cc.Invoke(ctx, "/pb.SupplierService/GetSupplier", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *supplierServiceClient) GetAllSuppliers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (SupplierService_GetAllSuppliersClient, error) {
-	stream, err := c.cc.NewStream(ctx, &SupplierService_ServiceDesc.Streams[0], "/pb.SupplierService/GetAllSuppliers", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &supplierServiceGetAllSuppliersClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
-	return x, nil
-}
-
-type SupplierService_GetAllSuppliersClient interface {
-	Recv() (*Supplier, error)
-	grpc.ClientStream
-}
-
-type supplierServiceGetAllSuppliersClient struct {
-	grpc.ClientStream
-}
-
-func (x *supplierServiceGetAllSuppliersClient) Recv() (*Supplier, error) {
-	m := new(Supplier)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func (c *supplierServiceClient) CreateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error) {
-	out := new(Supplier)
-	err := c.cc.Invoke(ctx, "/pb.SupplierService/CreateSupplier", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *supplierServiceClient) UpdateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error) {
-	out := new(Supplier)
-	err := c.cc.Invoke(ctx, "/pb.SupplierService/UpdateSupplier", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *supplierServiceClient) DeleteSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error) {
-	out := new(Supplier)
-	err := c.cc.Invoke(ctx, "/pb.SupplierService/DeleteSupplier", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// SupplierServiceServer is the server API for SupplierService service.
-// All implementations must embed UnimplementedSupplierServiceServer
-// for forward compatibility
-type SupplierServiceServer interface {
-	GetSupplier(context.Context, *Supplier) (*Supplier, error)
-	GetAllSuppliers(*Empty, SupplierService_GetAllSuppliersServer) error
-	CreateSupplier(context.Context, *Supplier) (*Supplier, error)
-	UpdateSupplier(context.Context, *Supplier) (*Supplier, error)
-	DeleteSupplier(context.Context, *Supplier) (*Supplier, error)
-	mustEmbedUnimplementedSupplierServiceServer()
-}
-
-// UnimplementedSupplierServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedSupplierServiceServer struct {
-}
-
-func (UnimplementedSupplierServiceServer) GetSupplier(context.Context, *Supplier) (*Supplier, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetSupplier not implemented")
-}
-func (UnimplementedSupplierServiceServer) GetAllSuppliers(*Empty, SupplierService_GetAllSuppliersServer) error {
-	return status.Errorf(codes.Unimplemented, "method GetAllSuppliers not implemented")
-}
-func (UnimplementedSupplierServiceServer) CreateSupplier(context.Context, *Supplier) (*Supplier, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreateSupplier not implemented")
-}
-func (UnimplementedSupplierServiceServer) UpdateSupplier(context.Context, *Supplier) (*Supplier, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method UpdateSupplier not implemented")
-}
-func (UnimplementedSupplierServiceServer) DeleteSupplier(context.Context, *Supplier) (*Supplier, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DeleteSupplier not implemented")
-}
-func (UnimplementedSupplierServiceServer) mustEmbedUnimplementedSupplierServiceServer() {}
-
-// UnsafeSupplierServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to SupplierServiceServer will
-// result in compilation errors.
-type UnsafeSupplierServiceServer interface {
-	mustEmbedUnimplementedSupplierServiceServer()
-}
-
-func RegisterSupplierServiceServer(s grpc.ServiceRegistrar, srv SupplierServiceServer) {
-	s.RegisterService(&SupplierService_ServiceDesc, srv)
-}
-
-func _SupplierService_GetSupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Supplier)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(SupplierServiceServer).GetSupplier(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.SupplierService/GetSupplier",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SupplierServiceServer).GetSupplier(ctx, req.(*Supplier))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _SupplierService_GetAllSuppliers_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(Empty)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(SupplierServiceServer).GetAllSuppliers(m, &supplierServiceGetAllSuppliersServer{stream})
-}
-
-type SupplierService_GetAllSuppliersServer interface {
-	Send(*Supplier) error
-	grpc.ServerStream
-}
-
-type supplierServiceGetAllSuppliersServer struct {
-	grpc.ServerStream
-}
-
-func (x *supplierServiceGetAllSuppliersServer) Send(m *Supplier) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _SupplierService_CreateSupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Supplier)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(SupplierServiceServer).CreateSupplier(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.SupplierService/CreateSupplier",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SupplierServiceServer).CreateSupplier(ctx, req.(*Supplier))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _SupplierService_UpdateSupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Supplier)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(SupplierServiceServer).UpdateSupplier(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.SupplierService/UpdateSupplier",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SupplierServiceServer).UpdateSupplier(ctx, req.(*Supplier))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _SupplierService_DeleteSupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Supplier)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(SupplierServiceServer).DeleteSupplier(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.SupplierService/DeleteSupplier",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SupplierServiceServer).DeleteSupplier(ctx, req.(*Supplier))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// SupplierService_ServiceDesc is the grpc.ServiceDesc for SupplierService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var SupplierService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.SupplierService",
-	HandlerType: (*SupplierServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetSupplier",
-			Handler:    _SupplierService_GetSupplier_Handler,
-		},
-		{
-			MethodName: "CreateSupplier",
-			Handler:    _SupplierService_CreateSupplier_Handler,
-		},
-		{
-			MethodName: "UpdateSupplier",
-			Handler:    _SupplierService_UpdateSupplier_Handler,
-		},
-		{
-			MethodName: "DeleteSupplier",
-			Handler:    _SupplierService_DeleteSupplier_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "GetAllSuppliers",
-			Handler:       _SupplierService_GetAllSuppliers_Handler,
-			ServerStreams: true,
-		},
-	},
-	Metadata: "pb/services.proto",
-}
-
-// CustomerServiceClient is the client API for CustomerService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type CustomerServiceClient interface {
-	GetCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
-	GetAllCustomers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (CustomerService_GetAllCustomersClient, error)
-	CreateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
-	UpdateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
-	DeleteCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
-}
-
-type customerServiceClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewCustomerServiceClient(cc grpc.ClientConnInterface) CustomerServiceClient {
-	return &customerServiceClient{cc}
-}
-
-func (c *customerServiceClient) GetCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
-	out := new(Customer)
-	err := c.cc.Invoke(ctx, "/pb.CustomerService/GetCustomer", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *customerServiceClient) GetAllCustomers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (CustomerService_GetAllCustomersClient, error) {
-	stream, err := c.cc.NewStream(ctx, &CustomerService_ServiceDesc.Streams[0], "/pb.CustomerService/GetAllCustomers", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &customerServiceGetAllCustomersClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
-	return x, nil
-}
-
-type CustomerService_GetAllCustomersClient interface {
-	Recv() (*Customer, error)
-	grpc.ClientStream
-}
-
-type customerServiceGetAllCustomersClient struct {
-	grpc.ClientStream
-}
-
-func (x *customerServiceGetAllCustomersClient) Recv() (*Customer, error) {
-	m := new(Customer)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
-	}
-	return m, nil
-}
-
-func (c *customerServiceClient) CreateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
-	out := new(Customer)
-	err := c.cc.Invoke(ctx, "/pb.CustomerService/CreateCustomer", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *customerServiceClient) UpdateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
-	out := new(Customer)
-	err := c.cc.Invoke(ctx, "/pb.CustomerService/UpdateCustomer", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (c *customerServiceClient) DeleteCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
-	out := new(Customer)
-	err := c.cc.Invoke(ctx, "/pb.CustomerService/DeleteCustomer", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// CustomerServiceServer is the server API for CustomerService service.
-// All implementations must embed UnimplementedCustomerServiceServer
-// for forward compatibility
-type CustomerServiceServer interface {
-	GetCustomer(context.Context, *Customer) (*Customer, error)
-	GetAllCustomers(*Empty, CustomerService_GetAllCustomersServer) error
-	CreateCustomer(context.Context, *Customer) (*Customer, error)
-	UpdateCustomer(context.Context, *Customer) (*Customer, error)
-	DeleteCustomer(context.Context, *Customer) (*Customer, error)
-	mustEmbedUnimplementedCustomerServiceServer()
-}
-
-// UnimplementedCustomerServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedCustomerServiceServer struct {
-}
-
-func (UnimplementedCustomerServiceServer) GetCustomer(context.Context, *Customer) (*Customer, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetCustomer not implemented")
-}
-func (UnimplementedCustomerServiceServer) GetAllCustomers(*Empty, CustomerService_GetAllCustomersServer) error {
-	return status.Errorf(codes.Unimplemented, "method GetAllCustomers not implemented")
-}
-func (UnimplementedCustomerServiceServer) CreateCustomer(context.Context, *Customer) (*Customer, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreateCustomer not implemented")
-}
-func (UnimplementedCustomerServiceServer) UpdateCustomer(context.Context, *Customer) (*Customer, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method UpdateCustomer not implemented")
-}
-func (UnimplementedCustomerServiceServer) DeleteCustomer(context.Context, *Customer) (*Customer, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DeleteCustomer not implemented")
-}
-func (UnimplementedCustomerServiceServer) mustEmbedUnimplementedCustomerServiceServer() {}
-
-// UnsafeCustomerServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to CustomerServiceServer will
-// result in compilation errors.
-type UnsafeCustomerServiceServer interface {
-	mustEmbedUnimplementedCustomerServiceServer()
-}
-
-func RegisterCustomerServiceServer(s grpc.ServiceRegistrar, srv CustomerServiceServer) {
-	s.RegisterService(&CustomerService_ServiceDesc, srv)
-}
-
-func _CustomerService_GetCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Customer)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CustomerServiceServer).GetCustomer(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.CustomerService/GetCustomer",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CustomerServiceServer).GetCustomer(ctx, req.(*Customer))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _CustomerService_GetAllCustomers_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(Empty)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(CustomerServiceServer).GetAllCustomers(m, &customerServiceGetAllCustomersServer{stream})
-}
-
-type CustomerService_GetAllCustomersServer interface {
-	Send(*Customer) error
-	grpc.ServerStream
-}
-
-type customerServiceGetAllCustomersServer struct {
-	grpc.ServerStream
-}
-
-func (x *customerServiceGetAllCustomersServer) Send(m *Customer) error {
-	return x.ServerStream.SendMsg(m)
-}
-
-func _CustomerService_CreateCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Customer)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CustomerServiceServer).CreateCustomer(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.CustomerService/CreateCustomer",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CustomerServiceServer).CreateCustomer(ctx, req.(*Customer))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _CustomerService_UpdateCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Customer)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CustomerServiceServer).UpdateCustomer(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.CustomerService/UpdateCustomer",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CustomerServiceServer).UpdateCustomer(ctx, req.(*Customer))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-func _CustomerService_DeleteCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Customer)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(CustomerServiceServer).DeleteCustomer(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.CustomerService/DeleteCustomer",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CustomerServiceServer).DeleteCustomer(ctx, req.(*Customer))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// CustomerService_ServiceDesc is the grpc.ServiceDesc for CustomerService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var CustomerService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.CustomerService",
-	HandlerType: (*CustomerServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetCustomer",
-			Handler:    _CustomerService_GetCustomer_Handler,
-		},
-		{
-			MethodName: "CreateCustomer",
-			Handler:    _CustomerService_CreateCustomer_Handler,
-		},
-		{
-			MethodName: "UpdateCustomer",
-			Handler:    _CustomerService_UpdateCustomer_Handler,
-		},
-		{
-			MethodName: "DeleteCustomer",
-			Handler:    _CustomerService_DeleteCustomer_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "GetAllCustomers",
-			Handler:       _CustomerService_GetAllCustomers_Handler,
-			ServerStreams: true,
-		},
-	},
-	Metadata: "pb/services.proto",
-}
-
-// AuthServiceClient is the client API for AuthService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type AuthServiceClient interface {
-	VerifyToken(ctx context.Context, in *Auth, opts ...grpc.CallOption) (*Auth, error)
-}
-
-type authServiceClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewAuthServiceClient(cc grpc.ClientConnInterface) AuthServiceClient {
-	return &authServiceClient{cc}
-}
-
-func (c *authServiceClient) VerifyToken(ctx context.Context, in *Auth, opts ...grpc.CallOption) (*Auth, error) {
-	out := new(Auth)
-	err := c.cc.Invoke(ctx, "/pb.AuthService/VerifyToken", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// AuthServiceServer is the server API for AuthService service.
-// All implementations must embed UnimplementedAuthServiceServer
-// for forward compatibility
-type AuthServiceServer interface {
-	VerifyToken(context.Context, *Auth) (*Auth, error)
-	mustEmbedUnimplementedAuthServiceServer()
-}
-
-// UnimplementedAuthServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedAuthServiceServer struct {
-}
-
-func (UnimplementedAuthServiceServer) VerifyToken(context.Context, *Auth) (*Auth, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method VerifyToken not implemented")
-}
-func (UnimplementedAuthServiceServer) mustEmbedUnimplementedAuthServiceServer() {}
-
-// UnsafeAuthServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to AuthServiceServer will
-// result in compilation errors.
-type UnsafeAuthServiceServer interface {
-	mustEmbedUnimplementedAuthServiceServer()
-}
-
-func RegisterAuthServiceServer(s grpc.ServiceRegistrar, srv AuthServiceServer) {
-	s.RegisterService(&AuthService_ServiceDesc, srv)
-}
-
-func _AuthService_VerifyToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Auth)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(AuthServiceServer).VerifyToken(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/pb.AuthService/VerifyToken",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(AuthServiceServer).VerifyToken(ctx, req.(*Auth))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// AuthService_ServiceDesc is the grpc.ServiceDesc for AuthService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var AuthService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.AuthService",
-	HandlerType: (*AuthServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "VerifyToken",
-			Handler:    _AuthService_VerifyToken_Handler,
-		},
-	},
-	Streams:  []grpc.StreamDesc{},
-	Metadata: "pb/services.proto",
-}
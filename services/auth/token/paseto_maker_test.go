@@ -0,0 +1,33 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasetoMaker(t *testing.T) {
+	maker, err := NewPasetoMaker("12345678901234567890123456789012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := maker.CreateToken("omar", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := maker.VerifyToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Username != "omar" {
+		t.Fatalf("expected username omar, got %s", payload.Username)
+	}
+	if _, err := maker.VerifyToken(token + "x"); err != InvalidTokenError {
+		t.Fatalf("expected InvalidTokenError, got %v", err)
+	}
+}
+
+func TestPasetoMakerInvalidKey(t *testing.T) {
+	if _, err := NewPasetoMaker("short"); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"errors"
+	"os"
 	"time"
 
 	"github.com/spf13/viper"
@@ -8,18 +10,33 @@ import (
 
 // Config stores all configuration for the service
 type Config struct {
+	MongoURI            string        `mapstructure:"MONGO_URI"`
 	TokenSymmetricKey   string        `mapstructure:"TOKEN_SYMMETRIC_KEY"`
 	AccessTokenDuration time.Duration `mapstructure:"ACCESS_TOKEN_DURATION"`
+	RequestTimeout      time.Duration `mapstructure:"REQUEST_TIMEOUT"`
+	DBTimeout           time.Duration `mapstructure:"DB_TIMEOUT"`
+	RedisAddr           string        `mapstructure:"REDIS_ADDR"`
+	RedisTimeout        time.Duration `mapstructure:"REDIS_TIMEOUT"`
+	OutboxInterval      time.Duration `mapstructure:"OUTBOX_INTERVAL"`
 }
 
-// LoadConfig loads the configuration from the given file
+// LoadConfig loads the configuration from the given file, falling back to
+// the environment and defaults when the file does not exist
 func LoadConfig(path string) (Config, error) {
 	var config Config
+	viper.SetDefault("MONGO_URI", "mongodb://mongo:27017/?replicaSet=rs0")
+	viper.SetDefault("TOKEN_SYMMETRIC_KEY", "")
+	viper.SetDefault("ACCESS_TOKEN_DURATION", 15*time.Minute)
+	viper.SetDefault("REQUEST_TIMEOUT", 10*time.Second)
+	viper.SetDefault("DB_TIMEOUT", 5*time.Second)
+	viper.SetDefault("REDIS_ADDR", "redis:6379")
+	viper.SetDefault("REDIS_TIMEOUT", 500*time.Millisecond)
+	viper.SetDefault("OUTBOX_INTERVAL", 5*time.Second)
 	viper.AddConfigPath(path)
 	viper.SetConfigFile(".env")
 	viper.AutomaticEnv()
 	err := viper.ReadInConfig()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return Config{}, err
 	}
 	err = viper.Unmarshal(&config)
@@ -0,0 +1,465 @@
+// Package cache implements the cache-aside pattern on Redis. The keys are
+// prefixed with the service and the version of the cached representation so
+// that services sharing a Redis do not collide and a change of representation
+// ignores the entries of the previous one. Concurrent misses of a key are
+// collapsed into a single load, the keys not found are cached too for a
+// shorter time, and Redis errors are logged and treated as misses so that
+// reads are served by the database while Redis is down. Every Set and Delete
+// bumps the generation of the ids in Redis, a loaded value is only cached
+// when the generation did not move during the load and no other value was
+// cached meanwhile, so that a load racing with a change never writes back a
+// stale value.
+//
+// A Cache can keep the values it uses most in memory too, in front of Redis.
+// The changes made through a Cache are broadcast over Redis pub/sub so that
+// the other replicas remove them from their memory, Listen receives them.
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/go-redis/redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultTTL is the time values are cached for when no TTL is given
+	DefaultTTL = 5 * time.Minute
+	// DefaultNegativeTTL is the time missing values are cached for when no
+	// NegativeTTL is given
+	DefaultNegativeTTL = 30 * time.Second
+	// DefaultLocalTTL is the time values are kept in memory for when no
+	// LocalTTL is given
+	DefaultLocalTTL = 30 * time.Second
+)
+
+// notFound is the cached value of the keys not found, JSON values are never
+// empty
+var notFound = []byte{}
+
+// Config configures a Cache
+type Config struct {
+	// Service prefixes the keys of the cache, e.g. "customers"
+	Service string
+	// Version is the version of the cached representation, bump it when the
+	// representation changes
+	Version int
+	// TTL is the time values are cached for
+	TTL time.Duration
+	// NegativeTTL is the time missing values are cached for
+	NegativeTTL time.Duration
+	// Timeout bounds every Redis call, 0 leaves them bounded by the context
+	Timeout time.Duration
+	// LocalSize is the number of values kept in memory, 0 keeps none
+	LocalSize int
+	// LocalTTL is the time values are kept in memory for, it bounds how long
+	// a replica serves a value changed elsewhere when an invalidation is lost
+	LocalTTL time.Duration
+}
+
+// Cache caches JSON encoded values in Redis by id
+type Cache struct {
+	rdb    *redis.Client
+	config Config
+	group  singleflight.Group
+	// local is the in-memory tier, nil when it is disabled
+	local *local
+	// origin identifies the invalidations of the Cache
+	origin string
+}
+
+// invalidation is the message broadcast when ids change
+type invalidation struct {
+	Origin string   `json:"origin"`
+	IDs    []string `json:"ids"`
+}
+
+// New returns the Cache of config stored in rdb
+func New(rdb *redis.Client, config Config) *Cache {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = DefaultNegativeTTL
+	}
+	if config.LocalTTL <= 0 {
+		config.LocalTTL = DefaultLocalTTL
+	}
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	c := &Cache{rdb: rdb, config: config, origin: hex.EncodeToString(origin)}
+	if config.LocalSize > 0 {
+		c.local = newLocal(config.LocalSize)
+	}
+	return c
+}
+
+// Key returns the Redis key of id
+func (c *Cache) Key(id string) string {
+	return fmt.Sprintf("%s:v%d:%s", c.config.Service, c.config.Version, id)
+}
+
+// generationKey returns the Redis key of the generation of id, it is shared
+// by the versions of the representation
+func (c *Cache) generationKey(id string) string {
+	return fmt.Sprintf("%s:gen:%s", c.config.Service, id)
+}
+
+// Channel returns the Redis channel the changes of the ids are broadcast on,
+// it is shared by the versions of the representation
+func (c *Cache) Channel() string {
+	return c.config.Service + ":invalidations"
+}
+
+// Loader loads the value of a missing id from the database, found is false
+// when it does not exist
+type Loader func(ctx context.Context) (value interface{}, found bool, err error)
+
+// BatchLoader loads the values of missing ids from the database by id, the
+// ids left out do not exist
+type BatchLoader func(ctx context.Context, ids []string) (map[string]interface{}, error)
+
+// Get decodes the value of id into v, found is false when it does not exist.
+// The value is read from memory, then from Redis. On a miss it is loaded with
+// load and cached, concurrent misses of id share a single load. The load is
+// not canceled with ctx since other callers may wait for it, a caller whose
+// ctx is done stops waiting. The errors of load are returned as is.
+func (c *Cache) Get(ctx context.Context, id string, v interface{}, load Loader) (bool, error) {
+	data, ok := c.local.get(id)
+	if !ok {
+		generation := c.local.generation()
+		var gen version
+		data, gen, ok = c.get(ctx, id)
+		if !ok {
+			loaded := c.group.DoChan(c.Key(id), func() (interface{}, error) {
+				// a load shared by concurrent misses outlives the caller
+				// that started it
+				ctx := middleware.Detach(ctx)
+				value, found, err := load(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if !found {
+					c.fill(ctx, map[string][]byte{id: notFound}, map[string]version{id: gen}, c.config.NegativeTTL)
+					return notFound, nil
+				}
+				data, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				c.fill(ctx, map[string][]byte{id: data}, map[string]version{id: gen}, c.config.TTL)
+				return data, nil
+			})
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case res := <-loaded:
+				if res.Err != nil {
+					return false, res.Err
+				}
+				data = res.Val.([]byte)
+			}
+		}
+		c.keep(id, data, generation)
+	}
+	if len(data) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// GetMany calls decode with the value of each of ids that exists, once per
+// id. The ids missing from the cache are loaded at once with load and cached.
+func (c *Cache) GetMany(ctx context.Context, ids []string, load BatchLoader, decode func(id string, data []byte) error) error {
+	var unique []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	// the ids kept in memory are not read from Redis
+	cold := unique[:0]
+	for _, id := range unique {
+		data, ok := c.local.get(id)
+		if !ok {
+			cold = append(cold, id)
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err := decode(id, data); err != nil {
+			return err
+		}
+	}
+	unique = cold
+	if len(unique) == 0 {
+		return nil
+	}
+	generation := c.local.generation()
+	// the generations are read along with the values, before the load
+	keys := make([]string, 2*len(unique))
+	for i, id := range unique {
+		keys[i] = c.Key(id)
+		keys[len(unique)+i] = c.generationKey(id)
+	}
+	vals := make([]interface{}, len(keys))
+	read := false
+	redisCtx, cancel := middleware.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	if cached, err := c.rdb.MGet(redisCtx, keys...).Result(); err != nil {
+		log.Printf("cache %s: failed to read %d keys: %s", c.config.Service, len(unique), err.Error())
+	} else {
+		vals = cached
+		read = true
+	}
+	var missing []string
+	generations := map[string]version{}
+	for i, id := range unique {
+		s, ok := vals[i].(string)
+		if !ok {
+			generations[id] = newVersion(vals[len(unique)+i], read)
+			missing = append(missing, id)
+			continue
+		}
+		c.keep(id, []byte(s), generation)
+		if s == "" {
+			continue
+		}
+		if err := decode(id, []byte(s)); err != nil {
+			return err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	loaded, err := load(ctx, missing)
+	if err != nil {
+		return err
+	}
+	found := map[string][]byte{}
+	absent := map[string][]byte{}
+	for _, id := range missing {
+		value, ok := loaded[id]
+		if !ok {
+			absent[id] = notFound
+			c.keep(id, notFound, generation)
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err := decode(id, data); err != nil {
+			return err
+		}
+		found[id] = data
+		c.keep(id, data, generation)
+	}
+	c.fill(ctx, found, generations, c.config.TTL)
+	c.fill(ctx, absent, generations, c.config.NegativeTTL)
+	return nil
+}
+
+// Set caches value as the value of id, e.g. after it was written to the
+// database, and broadcasts the change
+func (c *Cache) Set(ctx context.Context, id string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("cache %s: failed to encode %s: %s", c.config.Service, id, err.Error())
+		c.Delete(ctx, id)
+		return
+	}
+	c.change(ctx, []string{id}, data)
+	c.local.remove(id)
+	c.keep(id, data, c.local.generation())
+	c.broadcast(ctx, id)
+}
+
+// Delete removes ids from the cache, e.g. after they were changed in the
+// database, and broadcasts the change. The changes are stored already so a
+// failure is only logged, the entries expire on their own.
+func (c *Cache) Delete(ctx context.Context, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	c.change(ctx, ids, nil)
+	c.local.remove(ids...)
+	c.broadcast(ctx, ids...)
+}
+
+// Listen removes from memory the ids changed by the other replicas, until ctx
+// is done. The invalidations sent while the subscription is down are lost so
+// the memory is cleared whenever it is established again. Listen returns
+// right away when no value is kept in memory.
+func (c *Cache) Listen(ctx context.Context) {
+	if c.local == nil {
+		return
+	}
+	pubsub := c.rdb.Subscribe(ctx, c.Channel())
+	defer pubsub.Close()
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	failing := false
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.local.clear()
+			if !failing {
+				log.Printf("cache %s: invalidations lost: %s", c.config.Service, err.Error())
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			c.local.clear()
+			failing = false
+		case *redis.Message:
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Printf("cache %s: invalid invalidation %q: %s", c.config.Service, msg.Payload, err.Error())
+				continue
+			}
+			if inv.Origin != c.origin {
+				c.local.remove(inv.IDs...)
+			}
+		}
+	}
+}
+
+// broadcast tells the other replicas that ids changed, failures are logged
+// and the values they keep expire on their own
+func (c *Cache) broadcast(ctx context.Context, ids ...string) {
+	msg, err := json.Marshal(invalidation{Origin: c.origin, IDs: ids})
+	if err != nil {
+		log.Printf("cache %s: failed to encode the invalidation of %d ids: %s", c.config.Service, len(ids), err.Error())
+		return
+	}
+	ctx, cancel := middleware.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	if err := c.rdb.Publish(ctx, c.Channel(), msg).Err(); err != nil {
+		log.Printf("cache %s: failed to broadcast the invalidation of %d ids: %s", c.config.Service, len(ids), err.Error())
+	}
+}
+
+// keep keeps the value of id in memory unless ids were invalidated since
+// generation, the value read may predate the invalidation
+func (c *Cache) keep(id string, data []byte, generation uint64) {
+	ttl := c.config.LocalTTL
+	if len(data) == 0 && c.config.NegativeTTL < ttl {
+		ttl = c.config.NegativeTTL
+	}
+	c.local.putIf(id, data, ttl, generation)
+}
+
+// version is the generation of an id read before a load, known is false
+// when it could not be read
+type version struct {
+	value string
+	known bool
+}
+
+// newVersion returns the version of the generation val read from Redis, val
+// is nil when the id was never changed
+func newVersion(val interface{}, read bool) version {
+	s, _ := val.(string)
+	return version{value: s, known: read}
+}
+
+// get returns the cached value of id and the generation it was read at, ok
+// is false on a miss or a failure
+func (c *Cache) get(ctx context.Context, id string) ([]byte, version, bool) {
+	ctx, cancel := middleware.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	vals, err := c.rdb.MGet(ctx, c.Key(id), c.generationKey(id)).Result()
+	if err != nil {
+		log.Printf("cache %s: failed to read %s: %s", c.config.Service, c.Key(id), err.Error())
+		return nil, version{}, false
+	}
+	gen := newVersion(vals[1], true)
+	s, ok := vals[0].(string)
+	if !ok {
+		return nil, gen, false
+	}
+	return []byte(s), gen, true
+}
+
+// fillScript caches ARGV[2] at KEYS[1] for ARGV[3] milliseconds unless the
+// generation KEYS[2] moved past ARGV[1] or a value was cached meanwhile
+var fillScript = redis.NewScript(`
+local generation = redis.call('GET', KEYS[2]) or ''
+if generation ~= ARGV[1] then
+	return 0
+end
+if redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3], 'NX') then
+	return 1
+end
+return 0
+`)
+
+// fill caches the values loaded by id for ttl unless they changed since
+// their generations were read, failures are logged
+func (c *Cache) fill(ctx context.Context, values map[string][]byte, generations map[string]version, ttl time.Duration) {
+	ctx, cancel := middleware.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	pipe := c.rdb.Pipeline()
+	for id, data := range values {
+		gen := generations[id]
+		if !gen.known {
+			continue
+		}
+		fillScript.Eval(ctx, pipe, []string{c.Key(id), c.generationKey(id)}, gen.value, data, ttl.Milliseconds())
+	}
+	if pipe.Len() == 0 {
+		return
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("cache %s: failed to write %d keys: %s", c.config.Service, len(values), err.Error())
+	}
+}
+
+// change bumps the generation of ids, then caches data as their value or
+// removes them when it is nil. Failures are logged, the changes are stored
+// already and the entries expire on their own.
+func (c *Cache) change(ctx context.Context, ids []string, data []byte) {
+	ctx, cancel := middleware.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	keys := make([]string, len(ids))
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			keys[i] = c.Key(id)
+			// the generation moves first so that no load fills the key in between
+			pipe.Incr(ctx, c.generationKey(id))
+			pipe.PExpire(ctx, c.generationKey(id), c.config.TTL)
+		}
+		if data != nil {
+			pipe.Set(ctx, keys[0], data, c.config.TTL)
+		} else {
+			pipe.Del(ctx, keys...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("cache %s: failed to write %d keys: %s", c.config.Service, len(keys), err.Error())
+	}
+}
@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+func TestGet(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items", Version: 2, TTL: time.Minute, NegativeTTL: time.Second, Timeout: time.Second})
+	ctx := context.Background()
+	loads := 0
+	load := func(name string, found bool, err error) Loader {
+		return func(ctx context.Context) (interface{}, bool, error) {
+			loads++
+			return item{Name: name}, found, err
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		var got item
+		found, err := c.Get(ctx, "a", &got, load("Omar", true, nil))
+		if err != nil || !found || got.Name != "Omar" {
+			t.Fatalf("get %d: expected Omar, got %v %v (%v)", i, got, found, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected a single load, got %d", loads)
+	}
+	if !mr.Exists("items:v2:a") || mr.TTL("items:v2:a") != time.Minute {
+		t.Errorf("expected items:v2:a to be cached for a minute, got keys %v", mr.Keys())
+	}
+
+	loads = 0
+	for i := 0; i < 2; i++ {
+		var got item
+		found, err := c.Get(ctx, "b", &got, load("", false, nil))
+		if err != nil || found {
+			t.Fatalf("get missing %d: expected not found, got %v (%v)", i, found, err)
+		}
+	}
+	if loads != 1 || mr.TTL("items:v2:b") != time.Second {
+		t.Errorf("expected the missing item to be cached for a second after a single load, got %d loads and ttl %s", loads, mr.TTL("items:v2:b"))
+	}
+
+	failure := errors.New("database down")
+	var got item
+	if _, err := c.Get(ctx, "c", &got, load("", false, failure)); err != failure {
+		t.Errorf("expected the load error, got %v", err)
+	}
+	if mr.Exists("items:v2:c") {
+		t.Error("failed loads should not be cached")
+	}
+
+	c.Set(ctx, "a", item{Name: "Belghaouti"})
+	if _, err := c.Get(ctx, "a", &got, load("", false, nil)); err != nil || got.Name != "Belghaouti" {
+		t.Errorf("expected the value set, got %v (%v)", got, err)
+	}
+	c.Delete(ctx, "a", "b")
+	if mr.Exists("items:v2:a") || mr.Exists("items:v2:b") {
+		t.Error("expected the deleted keys to be removed")
+	}
+}
+
+func TestGetCollapsesMisses(t *testing.T) {
+	_, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items"})
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, bool, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return item{Name: "Omar"}, true, nil
+	}
+
+	var wg sync.WaitGroup
+	var started sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			var got item
+			found, err := c.Get(context.Background(), "a", &got, load)
+			if err == nil && (!found || got.Name != "Omar") {
+				err = errors.New("unexpected value")
+			}
+			errs <- err
+		}()
+	}
+	started.Wait()
+	// leave the goroutines the time to miss before the load completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected the concurrent misses to share a load, got %d loads", loads)
+	}
+}
+
+func TestGetKeepsConcurrentChanges(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items", Timeout: time.Second})
+	ctx := context.Background()
+	// the value changes while the previous one is loaded from the database
+	stale := func(change func()) Loader {
+		return func(ctx context.Context) (interface{}, bool, error) {
+			change()
+			return item{Name: "Omar"}, true, nil
+		}
+	}
+
+	var got item
+	if _, err := c.Get(ctx, "a", &got, stale(func() { c.Set(ctx, "a", item{Name: "Belghaouti"}) })); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := mr.Get("items:v0:a"); data != `{"name":"Belghaouti"}` {
+		t.Errorf("set: expected the value set to be kept, got %q", data)
+	}
+
+	c.Delete(ctx, "b")
+	if _, err := c.Get(ctx, "b", &got, stale(func() { c.Delete(ctx, "b") })); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("items:v0:b") {
+		t.Error("delete: expected the value loaded before the delete not to be cached")
+	}
+	if _, err := c.Get(ctx, "b", &got, stale(func() {})); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("items:v0:b") {
+		t.Error("expected the next load to be cached")
+	}
+}
+
+func TestGetDetachesTheLoad(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items", Timeout: time.Second})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loaded := make(chan error, 1)
+	load := func(ctx context.Context) (interface{}, bool, error) {
+		close(started)
+		<-release
+		loaded <- ctx.Err()
+		return item{Name: "Omar"}, true, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		var got item
+		_, err := c.Get(ctx, "a", &got, load)
+		done <- err
+	}()
+	<-started
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected the caller to give up with its context, got %v", err)
+	}
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Fatalf("expected the load to go on, got %v", err)
+	}
+	if !testutil.Eventually(time.Second, func() bool { return mr.Exists("items:v0:a") }) {
+		t.Error("expected the load to be cached")
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items", Timeout: 100 * time.Millisecond})
+	mr.Close()
+	ctx := context.Background()
+	loads := 0
+	load := func(ctx context.Context) (interface{}, bool, error) {
+		loads++
+		return item{Name: "Omar"}, true, nil
+	}
+	for i := 0; i < 2; i++ {
+		var got item
+		found, err := c.Get(ctx, "a", &got, load)
+		if err != nil || !found || got.Name != "Omar" {
+			t.Fatalf("get %d: expected Omar from the database, got %v %v (%v)", i, got, found, err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("expected every get to load while Redis is down, got %d loads", loads)
+	}
+	var names []string
+	err := c.GetMany(ctx, []string{"a", "b"}, func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		return map[string]interface{}{"a": item{Name: "Omar"}, "b": item{Name: "Belghaouti"}}, nil
+	}, func(id string, data []byte) error {
+		names = append(names, id)
+		return nil
+	})
+	if err != nil || len(names) != 2 {
+		t.Errorf("expected both items from the database, got %v (%v)", names, err)
+	}
+	c.Set(ctx, "a", item{Name: "Omar"})
+	c.Delete(ctx, "a")
+}
+
+func TestGetMany(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := New(rdb, Config{Service: "items", Version: 1})
+	ctx := context.Background()
+	var loaded [][]string
+	load := func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		loaded = append(loaded, ids)
+		values := map[string]interface{}{}
+		for _, id := range ids {
+			if id != "missing" {
+				values[id] = item{Name: "name of " + id}
+			}
+		}
+		return values, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		got := map[string]string{}
+		err := c.GetMany(ctx, []string{"b", "missing", "a", "b"}, load, func(id string, data []byte) error {
+			if _, seen := got[id]; seen {
+				t.Errorf("%s decoded twice", id)
+			}
+			got[id] = string(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{"a": `{"name":"name of a"}`, "b": `{"name":"name of b"}`}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("get %d: expected %v, got %v", i, expected, got)
+		}
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("expected a single load, got %v", loaded)
+	}
+	sort.Strings(loaded[0])
+	if expected := []string{"a", "b", "missing"}; !reflect.DeepEqual(loaded[0], expected) {
+		t.Errorf("expected %v to be loaded, got %v", expected, loaded[0])
+	}
+	if v, _ := mr.Get("items:v1:missing"); !mr.Exists("items:v1:missing") || v != "" {
+		t.Error("expected the missing item to be cached as not found")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l := newLocal(2)
+	l.putIf("a", []byte("1"), time.Minute, 0)
+	l.putIf("b", []byte("2"), time.Minute, 0)
+	l.get("a")
+	l.putIf("c", []byte("3"), time.Minute, 0)
+	if _, ok := l.get("b"); ok {
+		t.Error("expected the least recently used value to be evicted")
+	}
+	if data, ok := l.get("a"); !ok || string(data) != "1" {
+		t.Errorf("expected a to be kept, got %q %v", data, ok)
+	}
+	l.putIf("d", []byte("4"), -time.Second, 0)
+	if _, ok := l.get("d"); ok {
+		t.Error("expected the expired value to be missing")
+	}
+
+	generation := l.generation()
+	l.remove("a")
+	l.putIf("a", []byte("stale"), time.Minute, generation)
+	if _, ok := l.get("a"); ok {
+		t.Error("expected a value read before a removal not to be kept")
+	}
+	l.clear()
+	if l.len() != 0 {
+		t.Errorf("expected an empty tier after a clear, got %d values", l.len())
+	}
+}
+
+func TestTwoTiers(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	config := Config{Service: "items", Version: 1, LocalSize: 10, LocalTTL: time.Minute}
+	replicas := []*Cache{New(rdb, config), New(rdb, config)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, c := range replicas {
+		go c.Listen(ctx)
+	}
+	eventually := func(what string, ok func() bool) {
+		t.Helper()
+		if !testutil.Eventually(5*time.Second, ok) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	eventually("the replicas to subscribe", func() bool {
+		return mr.PubSubNumSub(replicas[0].Channel())[replicas[0].Channel()] == 2
+	})
+
+	loads := 0
+	load := func(ctx context.Context) (interface{}, bool, error) {
+		loads++
+		return item{Name: "Omar"}, true, nil
+	}
+	get := func(c *Cache) item {
+		t.Helper()
+		var got item
+		if _, err := c.Get(ctx, "a", &got, load); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	get(replicas[0])
+	get(replicas[1])
+	// the replicas keep the value in memory, Redis is not read anymore
+	mr.Del("items:v1:a")
+	if got := get(replicas[1]); got.Name != "Omar" || loads != 1 {
+		t.Fatalf("expected Omar from memory after a single load, got %v after %d loads", got, loads)
+	}
+
+	replicas[0].Set(ctx, "a", item{Name: "Belghaouti"})
+	if got := get(replicas[0]); got.Name != "Belghaouti" {
+		t.Errorf("expected the replica setting the value to serve it, got %v", got)
+	}
+	eventually("the other replica to drop its value", func() bool {
+		return replicas[1].local.len() == 0
+	})
+	if got := get(replicas[1]); got.Name != "Belghaouti" {
+		t.Errorf("expected the other replica to read the new value, got %v", got)
+	}
+
+	replicas[1].Delete(ctx, "a")
+	eventually("the first replica to drop its value", func() bool {
+		return replicas[0].local.len() == 0
+	})
+	if replicas[1].local.len() != 0 {
+		t.Error("expected the replica deleting the value to drop it")
+	}
+}
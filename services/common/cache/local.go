@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// local is the in-process tier of a Cache, it keeps the values of the ids
+// used most recently, at most size of them and each for at most its ttl. A
+// nil local keeps nothing.
+type local struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+	// gen counts the removals, a value read before a removal may be stale
+	gen uint64
+}
+
+// entry is a value of the local tier, the most recently used entries are at
+// the front of the order
+type entry struct {
+	id      string
+	data    []byte
+	expires time.Time
+}
+
+func newLocal(size int) *local {
+	return &local{size: size, items: make(map[string]*list.Element, size), order: list.New()}
+}
+
+// get returns the value of id, ok is false when it is missing or expired
+func (l *local) get(id string) ([]byte, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.items[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.Value.(*entry).expires) {
+		l.order.Remove(e)
+		delete(l.items, id)
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*entry).data, true
+}
+
+// generation returns the number of removals so far
+func (l *local) generation() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gen
+}
+
+// putIf stores the value of id for ttl unless values were removed since
+// generation, evicting the least recently used value when the tier is full
+func (l *local) putIf(id string, data []byte, ttl time.Duration, generation uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gen != generation {
+		return
+	}
+	expires := time.Now().Add(ttl)
+	if e, ok := l.items[id]; ok {
+		e.Value = &entry{id: id, data: data, expires: expires}
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[id] = l.order.PushFront(&entry{id: id, data: data, expires: expires})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*entry).id)
+	}
+}
+
+// remove removes the values of ids
+func (l *local) remove(ids ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen++
+	for _, id := range ids {
+		if e, ok := l.items[id]; ok {
+			l.order.Remove(e)
+			delete(l.items, id)
+		}
+	}
+}
+
+// clear removes every value
+func (l *local) clear() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen++
+	l.items = make(map[string]*list.Element, l.size)
+	l.order.Init()
+}
+
+// len returns the number of values stored, expired ones included
+func (l *local) len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
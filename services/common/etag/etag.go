@@ -0,0 +1,46 @@
+// Package etag maps the versions of the documents stored by the services to
+// ETag and If-Match headers
+package etag
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Format returns the ETag of a document at version
+func Format(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// Set sets the ETag header of the response to the one of version
+func Set(c *fiber.Ctx, version int64) {
+	c.Set(fiber.HeaderETag, Format(version))
+}
+
+// IfMatch returns the version required by the If-Match header of the
+// request, 0 when any version matches. A missing header is an error when
+// required is set.
+func IfMatch(c *fiber.Ctx, required bool) (int64, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	switch header {
+	case "":
+		if required {
+			return 0, problem.PreconditionRequired("if_match_required", "an If-Match header with the current ETag is required")
+		}
+		return 0, nil
+	case "*":
+		return 0, nil
+	}
+	tag, err := strconv.Unquote(strings.TrimPrefix(header, "W/"))
+	if err != nil {
+		return 0, problem.PreconditionFailed("invalid_etag", "the If-Match header must be a single ETag")
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, problem.PreconditionFailed("invalid_etag", "the If-Match header must be a single ETag")
+	}
+	return version, nil
+}
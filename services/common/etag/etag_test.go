@@ -0,0 +1,63 @@
+package etag
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIfMatch(t *testing.T) {
+	app := fiber.New()
+	app.Put("/", func(c *fiber.Ctx) error {
+		version, err := IfMatch(c, c.Query("required") == "true")
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		Set(c, version+1)
+		return c.SendString(strconv.FormatInt(version, 10))
+	})
+	tests := []struct {
+		name     string
+		target   string
+		header   string
+		expected int
+		version  string
+		code     string
+	}{
+		{"missing", "/", "", http.StatusOK, "0", ""},
+		{"missing when required", "/?required=true", "", http.StatusPreconditionRequired, "", "if_match_required"},
+		{"any", "/?required=true", "*", http.StatusOK, "0", ""},
+		{"strong", "/", `"3"`, http.StatusOK, "3", ""},
+		{"weak", "/", `W/"3"`, http.StatusOK, "3", ""},
+		{"unquoted", "/", "3", http.StatusPreconditionFailed, "", "invalid_etag"},
+		{"list", "/", `"3", "4"`, http.StatusPreconditionFailed, "", "invalid_etag"},
+		{"not a version", "/", `"abc"`, http.StatusPreconditionFailed, "", "invalid_etag"},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodPut, tt.target, nil, fiber.HeaderIfMatch, tt.header)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+			continue
+		}
+		if tt.code != "" {
+			var p problem.Problem
+			json.Unmarshal(body, &p)
+			if p.Code != tt.code {
+				t.Errorf("%s: expected code %s, got %s", tt.name, tt.code, p.Code)
+			}
+			continue
+		}
+		if string(body) != tt.version {
+			t.Errorf("%s: expected version %s, got %s", tt.name, tt.version, body)
+		}
+		v, _ := strconv.ParseInt(tt.version, 10, 64)
+		if got := res.Header.Get(fiber.HeaderETag); got != Format(v+1) {
+			t.Errorf("%s: expected ETag %s, got %s", tt.name, Format(v+1), got)
+		}
+	}
+}
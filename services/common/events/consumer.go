@@ -0,0 +1,235 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Handler handles an event, an error makes the event be delivered again
+type Handler func(ctx context.Context, e Event) error
+
+// ConsumerConfig configures a Consumer
+type ConsumerConfig struct {
+	// Group is the consumer group, every group receives every event
+	Group string
+	// Name is the consumer in the group, the events of a group are spread
+	// over its consumers
+	Name string
+	// Streams are the streams consumed, e.g. Stream("customer.deleted")
+	Streams []string
+	// Block is how long a read waits for new events, 5s by default,
+	// negative not to wait
+	Block time.Duration
+	// RetryAfter is how long a failed event waits before it is delivered
+	// again, 30s by default
+	RetryAfter time.Duration
+	// MaxDeliveries is how many times an event is delivered before it is
+	// moved to DeadLetters, 5 by default
+	MaxDeliveries int64
+	// Batch is how many events are read at once, 10 by default
+	Batch int64
+	// Lease makes the group only live as long as its consumer when set: the
+	// group is destroyed when Run returns, and the groups whose consumer has
+	// not polled for Lease, e.g. after a crash, are destroyed by the other
+	// consumers of the streams
+	Lease time.Duration
+}
+
+// Consumer delivers the events of its streams to a Handler at least once,
+// the events that keep failing are moved to DeadLetters
+type Consumer struct {
+	rdb     *redis.Client
+	config  ConsumerConfig
+	handler Handler
+}
+
+// NewConsumer returns a Consumer of the events read from rdb
+func NewConsumer(rdb *redis.Client, config ConsumerConfig, handler Handler) *Consumer {
+	if config.Block == 0 {
+		config.Block = 5 * time.Second
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = 30 * time.Second
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = 5
+	}
+	if config.Batch <= 0 {
+		config.Batch = 10
+	}
+	return &Consumer{rdb: rdb, config: config, handler: handler}
+}
+
+// Run consumes events until ctx is done, the groups are created on the first
+// run and only receive the events published after that
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := c.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("%s: cannot consume events: %s", c.config.Group, err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	if c.config.Lease > 0 {
+		// nobody reads the group anymore, the events would pile up in it
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, stream := range c.config.Streams {
+			if err := c.destroy(ctx, stream, c.config.Group); err != nil {
+				log.Printf("%s: cannot destroy the group of %s: %s", c.config.Group, stream, err.Error())
+			}
+		}
+	}
+}
+
+// Poll handles the failed events due for another delivery, then the new
+// events, waiting for them up to the Block of the configuration
+func (c *Consumer) Poll(ctx context.Context) error {
+	if c.config.Lease > 0 {
+		if err := c.renew(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.createGroups(ctx); err != nil {
+		return err
+	}
+	for _, stream := range c.config.Streams {
+		msgs, _, err := c.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    c.config.Group,
+			Consumer: c.config.Name,
+			MinIdle:  c.config.RetryAfter,
+			Start:    "0-0",
+			Count:    c.config.Batch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := c.handle(ctx, stream, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	ids := make([]string, len(c.config.Streams))
+	for i := range ids {
+		ids[i] = ">"
+	}
+	streams, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.config.Group,
+		Consumer: c.config.Name,
+		Streams:  append(append([]string{}, c.config.Streams...), ids...),
+		Count:    c.config.Batch,
+		Block:    c.config.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, s := range streams {
+		for _, msg := range s.Messages {
+			if err := c.handle(ctx, s.Stream, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createGroups creates the consumer group on every stream, along with the
+// streams that do not exist yet
+func (c *Consumer) createGroups(ctx context.Context) error {
+	for _, stream := range c.config.Streams {
+		err := c.rdb.XGroupCreateMkStream(ctx, stream, c.config.Group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+	return nil
+}
+
+// leases returns the key of the sorted set of the groups of stream with a
+// Lease, scored by when their lease expires in Unix milliseconds
+func leases(stream string) string {
+	return stream + ":leases"
+}
+
+// renew extends the lease of the group on every stream and destroys the
+// groups whose lease expired
+func (c *Consumer) renew(ctx context.Context) error {
+	now := time.Now()
+	for _, stream := range c.config.Streams {
+		err := c.rdb.ZAdd(ctx, leases(stream), redis.Z{
+			Score:  float64(now.Add(c.config.Lease).UnixMilli()),
+			Member: c.config.Group,
+		}).Err()
+		if err != nil {
+			return err
+		}
+		expired, err := c.rdb.ZRangeByScore(ctx, leases(stream), &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(now.UnixMilli(), 10),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, group := range expired {
+			if err := c.destroy(ctx, stream, group); err != nil {
+				return err
+			}
+			log.Printf("%s: destroyed the expired group %s of %s", c.config.Group, group, stream)
+		}
+	}
+	return nil
+}
+
+// destroy destroys group on stream along with its lease
+func (c *Consumer) destroy(ctx context.Context, stream, group string) error {
+	if err := c.rdb.XGroupDestroy(ctx, stream, group).Err(); err != nil {
+		return err
+	}
+	return c.rdb.ZRem(ctx, leases(stream), group).Err()
+}
+
+// handle passes the event stored in msg to the handler and acknowledges it
+// once handled. An event failing for the last time is moved to DeadLetters,
+// the errors returned are the ones of Redis.
+func (c *Consumer) handle(ctx context.Context, stream string, msg redis.XMessage) error {
+	herr := c.handler(ctx, parse(msg))
+	if herr != nil {
+		pending, err := c.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  c.config.Group,
+			Start:  msg.ID,
+			End:    msg.ID,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 && pending[0].RetryCount < c.config.MaxDeliveries {
+			return nil
+		}
+		log.Printf("%s: giving up on event %s of %s: %s", c.config.Group, msg.ID, stream, herr.Error())
+		values := parse(msg).values()
+		values["stream"] = stream
+		values["stream_id"] = msg.ID
+		values["group"] = c.config.Group
+		values["error"] = herr.Error()
+		if err := c.rdb.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetters, Values: values}).Err(); err != nil {
+			return err
+		}
+	}
+	return c.rdb.XAck(ctx, stream, c.config.Group, msg.ID).Err()
+}
@@ -0,0 +1,125 @@
+// Package events publishes the domain events of the services to Redis
+// Streams, one stream per entity, and consumes them with consumer groups
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	// StreamPrefix prefixes the streams of the events
+	StreamPrefix = "events:"
+	// DeadLetters is the stream of the events consumers gave up on
+	DeadLetters = StreamPrefix + "dead"
+	// DefaultMaxLen is roughly how many events a stream keeps
+	DefaultMaxLen = 100000
+)
+
+// Event is a change made to a record, its Type is the entity and what
+// happened to the record, e.g. "customer.deleted"
+type Event struct {
+	// ID is the ID of the event in its stream, set when it is read
+	ID      string          `bson:"id,omitempty" json:"id,omitempty"`
+	Type    string          `bson:"type" json:"type"`
+	Subject string          `bson:"subject" json:"subject"`
+	Actor   string          `bson:"actor,omitempty" json:"actor,omitempty"`
+	At      string          `bson:"at" json:"at"`
+	Data    json.RawMessage `bson:"data,omitempty" json:"data,omitempty"`
+}
+
+// New returns the event typ of the record subject, made by actor now. data
+// is encoded to JSON.
+func New(typ, subject, actor string, data interface{}) (Event, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("cannot encode %s event: %w", typ, err)
+	}
+	return Event{
+		Type:    typ,
+		Subject: subject,
+		Actor:   actor,
+		At:      time.Now().UTC().Format(time.RFC3339),
+		Data:    b,
+	}, nil
+}
+
+// Stream returns the stream of the events of type typ, the one of its entity
+func Stream(typ string) string {
+	entity, _, _ := strings.Cut(typ, ".")
+	return StreamPrefix + entity
+}
+
+// values returns the fields of the stream entry of e
+func (e Event) values() map[string]interface{} {
+	return map[string]interface{}{
+		"type":    e.Type,
+		"subject": e.Subject,
+		"actor":   e.Actor,
+		"at":      e.At,
+		"data":    string(e.Data),
+	}
+}
+
+// parse returns the event stored in the stream entry msg
+func parse(msg redis.XMessage) Event {
+	field := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	e := Event{
+		ID:      msg.ID,
+		Type:    field("type"),
+		Subject: field("subject"),
+		Actor:   field("actor"),
+		At:      field("at"),
+	}
+	if data := field("data"); data != "" {
+		e.Data = json.RawMessage(data)
+	}
+	return e
+}
+
+// Publisher appends events to their streams
+type Publisher struct {
+	rdb    *redis.Client
+	maxLen int64
+}
+
+// NewPublisher returns a Publisher writing to rdb, the streams are trimmed
+// to about DefaultMaxLen events
+func NewPublisher(rdb *redis.Client) *Publisher {
+	return &Publisher{rdb: rdb, maxLen: DefaultMaxLen}
+}
+
+// Publish appends the event typ of the record subject, made by actor now, to
+// its stream. data is encoded to JSON.
+func (p *Publisher) Publish(ctx context.Context, typ, subject, actor string, data interface{}) error {
+	e, err := New(typ, subject, actor, data)
+	if err != nil {
+		return err
+	}
+	return p.PublishEvent(ctx, e)
+}
+
+// PublishEvent appends e to its stream
+func (p *Publisher) PublishEvent(ctx context.Context, e Event) error {
+	return p.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: Stream(e.Type),
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: e.values(),
+	}).Err()
+}
+
+// Change is the Data of the events of a record changing, Before is nil for
+// a creation
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/go-redis/redis/v9"
+)
+
+// recorder is a Handler recording the subjects of the events, failing for
+// the subjects in fail
+type recorder struct {
+	subjects []string
+	fail     map[string]bool
+}
+
+func (r *recorder) handle(ctx context.Context, e Event) error {
+	r.subjects = append(r.subjects, e.Subject)
+	if r.fail[e.Subject] {
+		return errors.New("cannot handle " + e.Subject)
+	}
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	for typ, expected := range map[string]string{"customer.deleted": "events:customer", "user": "events:user"} {
+		if got := Stream(typ); got != expected {
+			t.Errorf("%s: expected %s, got %s", typ, expected, got)
+		}
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := testutil.Redis(t)
+	p := NewPublisher(rdb)
+	r := &recorder{fail: map[string]bool{"2": true}}
+	config := ConsumerConfig{Group: "test", Name: "a", Streams: []string{Stream("customer")}, Block: -1, RetryAfter: time.Millisecond, MaxDeliveries: 2}
+	c := NewConsumer(rdb, config, r.handle)
+	if err := c.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subject := range []string{"1", "2"} {
+		if err := p.Publish(ctx, "customer.updated", subject, "omar", map[string]string{"name": "Omar"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Publish(ctx, "supplier.updated", "3", "omar", nil)
+	if err := c.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.subjects, []string{"1", "2"}) {
+		t.Fatalf("first poll: expected 1 and 2, got %v", r.subjects)
+	}
+
+	// the failed event is delivered again, then given up on
+	time.Sleep(2 * time.Millisecond)
+	c.Poll(ctx)
+	time.Sleep(2 * time.Millisecond)
+	c.Poll(ctx)
+	if !reflect.DeepEqual(r.subjects, []string{"1", "2", "2"}) {
+		t.Fatalf("retries: expected 2 to be delivered twice, got %v", r.subjects)
+	}
+	pending, _ := rdb.XPending(ctx, Stream("customer"), "test").Result()
+	if pending.Count != 0 {
+		t.Errorf("expected no pending event, got %d", pending.Count)
+	}
+	dead, _ := rdb.XRange(ctx, DeadLetters, "-", "+").Result()
+	if len(dead) != 1 || dead[0].Values["subject"] != "2" || dead[0].Values["error"] != "cannot handle 2" || dead[0].Values["group"] != "test" {
+		t.Fatalf("expected event 2 in the dead letters, got %v", dead)
+	}
+
+	// redriven dead letters are delivered again
+	r.fail = nil
+	if n, err := Redrive(ctx, rdb, 0); n != 1 || err != nil {
+		t.Fatalf("redrive: expected 1 event, got %d (%v)", n, err)
+	}
+	c.Poll(ctx)
+	if n, _ := rdb.XLen(ctx, DeadLetters).Result(); n != 0 || !reflect.DeepEqual(r.subjects, []string{"1", "2", "2", "2"}) {
+		t.Fatalf("redrive: expected 2 to be delivered again, got %v", r.subjects)
+	}
+
+	// replayed events are delivered again, with their data
+	var data map[string]string
+	c = NewConsumer(rdb, config, func(ctx context.Context, e Event) error {
+		r.subjects = append(r.subjects, e.Subject)
+		return json.Unmarshal(e.Data, &data)
+	})
+	if n, err := Replay(ctx, rdb, Stream("customer"), "-", "+"); n != 3 || err != nil {
+		t.Fatalf("replay: expected 3 events, got %d (%v)", n, err)
+	}
+	c.Poll(ctx)
+	if !reflect.DeepEqual(r.subjects[4:], []string{"1", "2", "2"}) || data["name"] != "Omar" {
+		t.Fatalf("replay: expected 1, 2 and 2, got %v %v", r.subjects[4:], data)
+	}
+}
+
+func TestConsumerLease(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := testutil.Redis(t)
+	stream := Stream("customer")
+
+	// the group of a consumer that crashed, its lease expired long ago
+	rdb.XGroupCreateMkStream(ctx, stream, "crashed", "$")
+	rdb.ZAdd(ctx, leases(stream), redis.Z{Score: 1, Member: "crashed"})
+
+	config := ConsumerConfig{Group: "replica", Name: "replica", Streams: []string{stream}, Block: -1, Lease: time.Minute}
+	c := NewConsumer(rdb, config, (&recorder{}).handle)
+	if err := c.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.XPending(ctx, stream, "crashed").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOGROUP") {
+		t.Errorf("expired group: expected it to be destroyed, got %v", err)
+	}
+	if err := rdb.XPending(ctx, stream, "replica").Err(); err != nil {
+		t.Errorf("group: expected it to be created, got %v", err)
+	}
+	if groups, _ := rdb.ZRange(ctx, leases(stream), 0, -1).Result(); !reflect.DeepEqual(groups, []string{"replica"}) {
+		t.Errorf("leases: expected the lease of the group only, got %v", groups)
+	}
+
+	// the group is destroyed once its consumer stops
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	c.Run(stopped)
+	if err := rdb.XPending(ctx, stream, "replica").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOGROUP") {
+		t.Errorf("stopped: expected the group to be destroyed, got %v", err)
+	}
+	if n, _ := rdb.ZCard(ctx, leases(stream)).Result(); n != 0 {
+		t.Errorf("stopped: expected no lease, got %d", n)
+	}
+}
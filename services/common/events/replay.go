@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Replay appends the events of stream with an ID between from and to again,
+// "-" and "+" being the first and last events, so that every consumer group
+// receives them once more. It returns how many events were replayed.
+func Replay(ctx context.Context, rdb *redis.Client, stream, from, to string) (int, error) {
+	msgs, err := rdb.XRange(ctx, stream, from, to).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range msgs {
+		values := parse(msg).values()
+		values["replay_of"] = msg.ID
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: DefaultMaxLen, Approx: true, Values: values}).Err(); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// Redrive moves up to count events of DeadLetters back to the streams they
+// came from, all of them when count is 0. Every consumer group of a stream
+// receives a redriven event, not only the one that gave up on it. It
+// returns how many events were moved.
+func Redrive(ctx context.Context, rdb *redis.Client, count int64) (int, error) {
+	var msgs []redis.XMessage
+	var err error
+	if count > 0 {
+		msgs, err = rdb.XRangeN(ctx, DeadLetters, "-", "+", count).Result()
+	} else {
+		msgs, err = rdb.XRange(ctx, DeadLetters, "-", "+").Result()
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range msgs {
+		stream, _ := msg.Values["stream"].(string)
+		values := parse(msg).values()
+		values["replay_of"] = msg.Values["stream_id"]
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: DefaultMaxLen, Approx: true, Values: values}).Err(); err != nil {
+			return i, err
+		}
+		if err := rdb.XDel(ctx, DeadLetters, msg.ID).Err(); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
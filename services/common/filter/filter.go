@@ -0,0 +1,447 @@
+// Package filter parses the filter query parameter of the list endpoints, a
+// small expression language such as
+//
+//	total_price >= 100 and created_at > "2026-01-01" and supplier_id in ("...", "...")
+//
+// into a validated AST translated to Mongo queries. Comparisons (=, !=, >,
+// >=, <, <=) and [not] in lists are combined with and, or, not and
+// parentheses, on the fields whitelisted by each entity only.
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxLength is the length of the longest filter
+	MaxLength = 2000
+	// MaxTerms is the largest number of comparisons of a filter
+	MaxTerms = 50
+	// MaxDepth is the deepest nesting of parentheses and not of a filter
+	MaxDepth = 10
+)
+
+// Kind is the kind of the values of a field
+type Kind int
+
+const (
+	// String fields are compared to string literals, timestamps are stored
+	// as RFC 3339 strings and compare as such
+	String Kind = iota
+	// Number fields are compared to number literals
+	Number
+	// ID fields are compared to string literals holding ObjectIDs
+	ID
+)
+
+// Fields maps the fields that can be filtered on to the kind of their values
+type Fields map[string]Kind
+
+// Node is a node of the AST of a filter
+type Node interface {
+	mongo() bson.M
+}
+
+// And matches the documents matched by all its nodes
+type And []Node
+
+// Or matches the documents matched by any of its nodes
+type Or []Node
+
+// Not matches the documents not matched by its node
+type Not struct {
+	Node Node
+}
+
+// Comparison matches the documents whose field compares to value with op
+type Comparison struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// In matches the documents whose field is one of values, or none of them
+// when negated
+type In struct {
+	Field   string
+	Values  []interface{}
+	Negated bool
+}
+
+// operators maps the comparison operators to their Mongo operators
+var operators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+func (n And) mongo() bson.M {
+	conds := make(bson.A, len(n))
+	for i, node := range n {
+		conds[i] = node.mongo()
+	}
+	return bson.M{"$and": conds}
+}
+
+func (n Or) mongo() bson.M {
+	conds := make(bson.A, len(n))
+	for i, node := range n {
+		conds[i] = node.mongo()
+	}
+	return bson.M{"$or": conds}
+}
+
+func (n Not) mongo() bson.M {
+	return bson.M{"$nor": bson.A{n.Node.mongo()}}
+}
+
+func (n Comparison) mongo() bson.M {
+	return bson.M{field(n.Field): bson.M{operators[n.Op]: n.Value}}
+}
+
+func (n In) mongo() bson.M {
+	op := "$in"
+	if n.Negated {
+		op = "$nin"
+	}
+	return bson.M{field(n.Field): bson.M{op: bson.A(n.Values)}}
+}
+
+// field returns the name of a field in the documents, ids are stored as _id
+func field(name string) string {
+	if name == "id" {
+		return "_id"
+	}
+	return name
+}
+
+// Mongo translates node to a Mongo query, a nil node matches every document
+func Mongo(node Node) bson.M {
+	if node == nil {
+		return bson.M{}
+	}
+	return node.mongo()
+}
+
+// Restrict restricts query to the documents matched by node
+func Restrict(query bson.M, node Node) bson.M {
+	if node == nil {
+		return query
+	}
+	return bson.M{"$and": bson.A{query, node.mongo()}}
+}
+
+// Parse parses s into its AST, validating the fields against fields and their
+// values against their kinds. An empty s is a nil Node.
+func Parse(s string, fields Fields) (Node, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	if len(s) > MaxLength {
+		return nil, problem.Validation("invalid_filter", fmt.Sprintf("filter must be at most %d characters long", MaxLength))
+	}
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, fields: fields}
+	node, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != eof {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return node, nil
+}
+
+type tokenKind int
+
+const (
+	eof tokenKind = iota
+	ident
+	str
+	num
+	op
+	lparen
+	rparen
+	comma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == eof {
+		return "end of filter"
+	}
+	return strconv.Quote(t.text)
+}
+
+// invalid returns the invalid_filter error of the character at pos
+func invalid(pos int, format string, args ...interface{}) error {
+	return problem.Validation("invalid_filter", fmt.Sprintf("at %d: %s", pos+1, fmt.Sprintf(format, args...)))
+}
+
+// lex splits s into tokens
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{lparen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{rparen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{comma, ",", i})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{op, "=", i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				tokens = append(tokens, token{op, s[i : i+2], i})
+				i += 2
+			} else if c == '!' {
+				return nil, invalid(i, "expected !=")
+			} else {
+				tokens = append(tokens, token{op, s[i : i+1], i})
+				i++
+			}
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, invalid(i, "unterminated string")
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, invalid(i, "invalid string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{str, text, i})
+			i = end + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '.' || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{num, s[i:end], i})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(s) && (s[end] == '_' || unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{ident, s[i:end], i})
+			i = end
+		default:
+			return nil, invalid(i, "unexpected %q", c)
+		}
+	}
+	return append(tokens, token{eof, "", len(s)}), nil
+}
+
+// parser is a recursive descent parser of the grammar
+//
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" or ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+type parser struct {
+	tokens []token
+	next   int
+	terms  int
+	fields Fields
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != eof {
+		p.next++
+	}
+	return t
+}
+
+// keyword reports whether t is the keyword word, keywords are case insensitive
+func keyword(t token, word string) bool {
+	return t.kind == ident && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return invalid(t.pos, format, args...)
+}
+
+func (p *parser) or(depth int) (Node, error) {
+	node, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	nodes := Or{node}
+	for keyword(p.peek(), "or") {
+		p.take()
+		node, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) and(depth int) (Node, error) {
+	node, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	nodes := And{node}
+	for keyword(p.peek(), "and") {
+		p.take()
+		node, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *parser) unary(depth int) (Node, error) {
+	t := p.peek()
+	if depth > MaxDepth {
+		return nil, p.errorf(t, "filter must be nested at most %d levels deep", MaxDepth)
+	}
+	switch {
+	case keyword(t, "not"):
+		p.take()
+		node, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Node: node}, nil
+	case t.kind == lparen:
+		p.take()
+		node, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.take(); t.kind != rparen {
+			return nil, p.errorf(t, "expected ) instead of %s", t)
+		}
+		return node, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Node, error) {
+	t := p.take()
+	if t.kind != ident {
+		return nil, p.errorf(t, "expected a field instead of %s", t)
+	}
+	kind, ok := p.fields[t.text]
+	if !ok {
+		return nil, p.errorf(t, "%q cannot be filtered on, only %s can", t.text, strings.Join(p.fields.names(), ", "))
+	}
+	if p.terms++; p.terms > MaxTerms {
+		return nil, p.errorf(t, "filter must have at most %d comparisons", MaxTerms)
+	}
+	name := t.text
+	t = p.take()
+	switch {
+	case t.kind == op:
+		value, err := p.value(kind)
+		if err != nil {
+			return nil, err
+		}
+		return Comparison{Field: name, Op: t.text, Value: value}, nil
+	case keyword(t, "in"), keyword(t, "not") && keyword(p.peek(), "in"):
+		negated := keyword(t, "not")
+		if negated {
+			p.take()
+		}
+		if t := p.take(); t.kind != lparen {
+			return nil, p.errorf(t, "expected ( instead of %s", t)
+		}
+		var values []interface{}
+		for {
+			value, err := p.value(kind)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if t := p.take(); t.kind == rparen {
+				break
+			} else if t.kind != comma {
+				return nil, p.errorf(t, "expected , or ) instead of %s", t)
+			}
+		}
+		return In{Field: name, Values: values, Negated: negated}, nil
+	}
+	return nil, p.errorf(t, "expected an operator or in instead of %s", t)
+}
+
+// value parses a literal of kind
+func (p *parser) value(kind Kind) (interface{}, error) {
+	t := p.take()
+	switch {
+	case kind == Number && t.kind == num:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t)
+		}
+		return n, nil
+	case kind == String && t.kind == str:
+		return t.text, nil
+	case kind == ID && t.kind == str:
+		id, err := primitive.ObjectIDFromHex(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "invalid id %s", t)
+		}
+		return id, nil
+	case kind == Number:
+		return nil, p.errorf(t, "expected a number instead of %s", t)
+	}
+	return nil, p.errorf(t, "expected a string instead of %s", t)
+}
+
+// names returns the sorted names of fields
+func (fields Fields) names() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var fields = Fields{"id": ID, "name": String, "price": Number, "supplier_id": ID}
+
+type item struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Name       string             `bson:"name"`
+	Price      float64            `bson:"price"`
+	SupplierID primitive.ObjectID `bson:"supplier_id"`
+}
+
+func TestFilter(t *testing.T) {
+	c := memdb.NewCollection()
+	acme, globex := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, it := range []item{{Name: "a", Price: 50, SupplierID: acme}, {Name: "b", Price: 100, SupplierID: acme}, {Name: "c", Price: 150, SupplierID: globex}, {Name: `d "quoted"`, Price: 200, SupplierID: globex}} {
+		it.ID = primitive.NewObjectID()
+		if _, err := c.InsertOne(context.Background(), it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{"", "a,b,c,d \"quoted\""},
+		{"price >= 100", "b,c,d \"quoted\""},
+		{`price >= 100 and name < "c"`, "b"},
+		{`price < 100 or name = "c"`, "a,c"},
+		{`supplier_id in ("` + globex.Hex() + `")`, "c,d \"quoted\""},
+		{`supplier_id not in ("` + globex.Hex() + `", "` + acme.Hex() + `")`, ""},
+		{`not (price > 50 AND price <= 150)`, "a,d \"quoted\""},
+		{`name = "d \"quoted\""`, "d \"quoted\""},
+		{`price != 100 and (name = "a" or name = "b")`, "a"},
+		{`price > -1.5`, "a,b,c,d \"quoted\""},
+	}
+	for _, tt := range tests {
+		node, err := Parse(tt.filter, fields)
+		if err != nil {
+			t.Errorf("%s: %s", tt.filter, err)
+			continue
+		}
+		cursor, err := c.Find(context.Background(), Mongo(node))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var items []item
+		if err := cursor.All(context.Background(), &items); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, it := range items {
+			names = append(names, it.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.filter, tt.expected, got)
+		}
+	}
+}
+
+func TestMongo(t *testing.T) {
+	node, err := Parse(`id = "000000000000000000000001" or not price in (1, 2)`, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := primitive.ObjectIDFromHex("000000000000000000000001")
+	expected := bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$eq": id}},
+		bson.M{"$nor": bson.A{bson.M{"price": bson.M{"$in": bson.A{1.0, 2.0}}}}},
+	}}
+	got, _ := json.Marshal(Mongo(node))
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestInvalidFilters(t *testing.T) {
+	tests := []struct {
+		filter string
+		detail string
+	}{
+		{"secret = 1", `"secret" cannot be filtered on`},
+		{"price >= ", "expected a number instead of end of filter"},
+		{`price = "100"`, "expected a number"},
+		{"name = 1", "expected a string"},
+		{`supplier_id = "nope"`, "invalid id"},
+		{`name = "a`, "unterminated string"},
+		{"price ! 1", "expected !="},
+		{"price = 1 price = 2", `unexpected "price"`},
+		{"(price = 1", "expected )"},
+		{"price in 1", "expected ("},
+		{"price in (1; 2)", "unexpected ';'"},
+		{"price 1", "expected an operator or in"},
+		{strings.Repeat("not ", MaxDepth+1) + "price = 1", "nested"},
+		{strings.Repeat("price = 1 or ", MaxTerms) + "price = 1", "comparisons"},
+		{"name = \"" + strings.Repeat("a", MaxLength) + "\"", "characters long"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.filter, fields)
+		e := problem.From(err)
+		if e == nil || e.Code != "invalid_filter" || !strings.Contains(e.Detail, tt.detail) {
+			t.Errorf("%.40s: expected an invalid_filter error about %q, got %v", tt.filter, tt.detail, err)
+		}
+	}
+}
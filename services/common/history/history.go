@@ -0,0 +1,244 @@
+// Package history records an immutable trail of the changes made to the
+// records of the services, with who made them and the fields they changed
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The actions recorded in the history
+const (
+	Created  = "create"
+	Updated  = "update"
+	Deleted  = "delete"
+	Restored = "restore"
+)
+
+// DefaultLimit and MaxLimit bound the number of entries returned by a query
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// ignored are the bookkeeping fields left out of the diffs, they change on
+// every write
+var ignored = map[string]bool{"id": true, "version": true, "updated_at": true}
+
+// Change is the value of a field before and after a change, a missing
+// value is a field that did not exist
+type Change struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// Entry is a change made to a record
+type Entry struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Entity   string             `bson:"entity" json:"entity"`
+	RecordID string             `bson:"record_id" json:"record_id"`
+	Action   string             `bson:"action" json:"action"`
+	Actor    string             `bson:"actor" json:"actor"`
+	At       string             `bson:"at" json:"at"`
+	Version  int64              `bson:"version" json:"version"`
+	Changes  []Change           `bson:"changes" json:"changes"`
+}
+
+// Entries is a slice of Entry structs
+type Entries []Entry
+
+// Query selects entries, the empty fields match any entry
+type Query struct {
+	RecordID string
+	Actor    string
+	Action   string
+	// Since and Until bound the time of the entries, as RFC 3339 timestamps
+	Since string
+	Until string
+	Limit int64
+}
+
+// ParseQuery reads a Query from the record_id, actor, action, since, until
+// and limit query parameters of the request
+func ParseQuery(c *fiber.Ctx) (Query, error) {
+	q := Query{
+		RecordID: c.Query("record_id"),
+		Actor:    c.Query("actor"),
+		Action:   c.Query("action"),
+		Limit:    DefaultLimit,
+	}
+	for _, p := range []struct {
+		name string
+		dst  *string
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, problem.Validation("invalid_query", p.name+" must be an RFC 3339 timestamp")
+		}
+		*p.dst = t.UTC().Format(time.RFC3339)
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 || limit > MaxLimit {
+			return q, problem.Validation("invalid_query", "limit must be between 1 and "+strconv.Itoa(MaxLimit))
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// Collection is the subset of *mongo.Collection used by a Log, entries are
+// only ever inserted
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
+
+// Log is the history of the records of an entity
+type Log struct {
+	collection Collection
+	entity     string
+}
+
+// New returns the Log of entity stored in collection, the collection can be
+// shared by several entities
+func New(collection Collection, entity string) *Log {
+	return &Log{collection: collection, entity: entity}
+}
+
+// Indexes returns the indexes of the queries of the Logs, the entries of a
+// record or of an entity most recent first
+func Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "record_id", Value: 1}, {Key: "at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "at", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+}
+
+// Migration returns the migration creating the Indexes of the collection
+// named collection. The collection is shared by the services, so the
+// migration is not reverted: the indexes are left for the others.
+func Migration(version int, collection string) migrate.Migration {
+	m := migrate.CreateIndexes(version, "index the history", collection, Indexes()...)
+	m.Down = nil
+	return m
+}
+
+// Record adds an entry for the change of the record with id from before to
+// after, made by actor. before is nil for a creation.
+func (l *Log) Record(ctx context.Context, id, action, actor string, version int64, before, after interface{}) error {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return problem.Internal(err)
+	}
+	entry := Entry{
+		ID:       primitive.NewObjectID(),
+		Entity:   l.entity,
+		RecordID: id,
+		Action:   action,
+		Actor:    actor,
+		At:       time.Now().UTC().Format(time.RFC3339),
+		Version:  version,
+		Changes:  changes,
+	}
+	if _, err := l.collection.InsertOne(ctx, entry); err != nil {
+		return problem.From(err)
+	}
+	return nil
+}
+
+// Find returns the entries matching q, most recent first
+func (l *Log) Find(ctx context.Context, q Query) (Entries, error) {
+	entries := Entries{}
+	filter := bson.M{"entity": l.entity}
+	for field, v := range map[string]string{"record_id": q.RecordID, "actor": q.Actor, "action": q.Action} {
+		if v != "" {
+			filter[field] = v
+		}
+	}
+	at := bson.M{}
+	if q.Since != "" {
+		at["$gte"] = q.Since
+	}
+	if q.Until != "" {
+		at["$lte"] = q.Until
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return entries, problem.From(err)
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return entries, problem.From(err)
+	}
+	return entries, nil
+}
+
+// Diff returns the changes of the fields of before and after, as named by
+// their json tags, sorted by field. A nil before or after has no fields.
+func Diff(before, after interface{}) ([]Change, error) {
+	a, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{}
+	for field, v := range a {
+		if w, found := b[field]; !found || !reflect.DeepEqual(v, w) {
+			changes = append(changes, Change{Field: field, Before: v, After: w})
+		}
+	}
+	for field, w := range b {
+		if _, found := a[field]; !found {
+			changes = append(changes, Change{Field: field, After: w})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// fields returns the json fields of v but the ignored ones
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for field := range ignored {
+		delete(m, field)
+	}
+	return m, nil
+}
@@ -0,0 +1,74 @@
+package history
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+)
+
+type record struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Price   float64 `json:"price"`
+	Note    string  `json:"note,omitempty"`
+	Version int64   `json:"version"`
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after interface{}
+		expected      []Change
+	}{
+		{"create", nil, record{ID: "1", Name: "a", Version: 1}, []Change{{Field: "name", After: "a"}, {Field: "price", After: 0.0}}},
+		{"update", record{ID: "1", Name: "a", Price: 1, Version: 1}, record{ID: "1", Name: "a", Price: 2, Version: 2}, []Change{{Field: "price", Before: 1.0, After: 2.0}}},
+		{"added and removed", record{Note: "x"}, record{Name: "a"}, []Change{{Field: "name", Before: "", After: "a"}, {Field: "note", Before: "x"}}},
+		{"unchanged", record{Name: "a", Version: 1}, record{Name: "a", Version: 2}, []Change{}},
+	}
+	for _, tt := range tests {
+		got, err := Diff(tt.before, tt.after)
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.name, tt.expected, got, err)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	ctx := context.Background()
+	c := memdb.NewCollection()
+	customers, orders := New(c, "customer"), New(c, "order")
+	customers.Record(ctx, "1", Created, "omar", 1, nil, record{Name: "a"})
+	customers.Record(ctx, "1", Updated, "ali", 2, record{Name: "a"}, record{Name: "b"})
+	customers.Record(ctx, "2", Created, "ali", 1, nil, record{Name: "c"})
+	orders.Record(ctx, "3", Created, "omar", 1, nil, record{Price: 1})
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{"all", Query{}, []string{"2:create", "1:update", "1:create"}},
+		{"record", Query{RecordID: "1"}, []string{"1:update", "1:create"}},
+		{"actor", Query{Actor: "ali"}, []string{"2:create", "1:update"}},
+		{"action", Query{Action: Created}, []string{"2:create", "1:create"}},
+		{"limit", Query{Limit: 1}, []string{"2:create"}},
+		{"until", Query{Until: "2000-01-01T00:00:00Z"}, []string{}},
+	}
+	for _, tt := range tests {
+		entries, err := customers.Find(ctx, tt.query)
+		got := []string{}
+		for _, e := range entries {
+			got = append(got, e.RecordID+":"+e.Action)
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.name, tt.expected, got, err)
+		}
+	}
+
+	entries, _ := customers.Find(ctx, Query{RecordID: "1", Action: Updated})
+	if len(entries) != 1 || entries[0].Actor != "ali" || entries[0].Version != 2 || !reflect.DeepEqual(entries[0].Changes, []Change{{Field: "name", Before: "a", After: "b"}}) {
+		t.Errorf("update entry: unexpected %+v", entries)
+	}
+}
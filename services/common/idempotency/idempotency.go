@@ -0,0 +1,181 @@
+// Package idempotency makes retried requests safe by replaying the response
+// stored in Redis for the Idempotency-Key they carry
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Header carries the key chosen by the client for a request
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on the responses replayed from a previous request
+	ReplayedHeader = "Idempotent-Replayed"
+	// maxKeyLength bounds the keys accepted from clients
+	maxKeyLength = 255
+	// maxReservations bounds the attempts to reserve a key released by
+	// failed requests while it was read
+	maxReservations = 3
+)
+
+// Config configures the idempotency middleware
+type Config struct {
+	// TTL is how long responses are kept for replay
+	TTL time.Duration
+	// LockTTL bounds how long a key stays reserved by a request in progress,
+	// it should outlive the request timeout
+	LockTTL time.Duration
+	// Timeout bounds every Redis call
+	Timeout time.Duration
+}
+
+// replayedHeaders are the response headers stored and replayed along with the
+// body, the ETag lets a client retrying an update send If-Match afterwards
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderLocation, fiber.HeaderETag}
+
+// record is what is stored in Redis for every key, a zero Status means the
+// first request is still in progress
+type record struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// New returns a middleware storing the response of the requests carrying an
+// Idempotency-Key header and replaying it when the same request is retried
+// with the same key. Reusing a key for a different request, or while the
+// first request is still in progress, is a conflict. Keys are scoped to the
+// authenticated user and responses with a 5xx status are not stored so they
+// can be retried. Requests are processed normally when Redis cannot be
+// reached.
+func New(rdb *redis.Client, config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(Header)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxKeyLength {
+			return problem.Write(c, problem.Validation("invalid_idempotency_key", "the idempotency key must be at most 255 characters"))
+		}
+		user, _ := c.Locals("user").(string)
+		key = "idempotency:" + user + ":" + c.Method() + ":" + c.Path() + ":" + key
+		fingerprint := fingerprint(c)
+
+		lock, err := json.Marshal(record{Fingerprint: fingerprint})
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		ctx, cancel := middleware.WithTimeout(c.UserContext(), config.Timeout)
+		defer cancel()
+		for attempt := 1; ; attempt++ {
+			reserved, err := rdb.SetNX(ctx, key, lock, config.LockTTL).Result()
+			if err != nil {
+				log.Printf("idempotency store unavailable: %s", err.Error())
+				return c.Next()
+			}
+			if reserved {
+				break
+			}
+			// the key is free again when the request holding it failed
+			// before its response could be read
+			if replayed, err := replay(c, rdb, key, fingerprint, config); replayed || err != nil {
+				return err
+			}
+			if attempt == maxReservations {
+				return problem.Write(c, problem.Conflict("idempotency_key_in_use", "a request with this idempotency key is in progress"))
+			}
+		}
+
+		if err := c.Next(); err != nil {
+			release(rdb, key, config)
+			return err
+		}
+		res := c.Response()
+		if res.StatusCode() >= fiber.StatusInternalServerError {
+			release(rdb, key, config)
+			return nil
+		}
+		headers := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := res.Header.Peek(name); len(value) > 0 {
+				headers[name] = string(value)
+			}
+		}
+		stored, err := json.Marshal(record{
+			Fingerprint: fingerprint,
+			Status:      res.StatusCode(),
+			Headers:     headers,
+			Body:        res.Body(),
+		})
+		if err != nil {
+			log.Printf("failed to store idempotent response: %s", err.Error())
+			return nil
+		}
+		ctx, cancel = middleware.WithTimeout(context.Background(), config.Timeout)
+		defer cancel()
+		if err := rdb.Set(ctx, key, stored, config.TTL).Err(); err != nil {
+			log.Printf("failed to store idempotent response: %s", err.Error())
+		}
+		return nil
+	}
+}
+
+// replay writes the response stored at key if it was produced by the same
+// request, replayed is false when nothing is stored at key anymore and
+// nothing was written
+func replay(c *fiber.Ctx, rdb *redis.Client, key, fingerprint string, config Config) (bool, error) {
+	ctx, cancel := middleware.WithTimeout(c.UserContext(), config.Timeout)
+	defer cancel()
+	val, err := rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return true, problem.Write(c, problem.Upstream("idempotency_store_unavailable", "cannot check the idempotency key", err))
+	}
+	var r record
+	if err := json.Unmarshal(val, &r); err != nil {
+		return true, problem.Write(c, err)
+	}
+	if r.Fingerprint != fingerprint {
+		return true, problem.Write(c, problem.Conflict("idempotency_key_reused", "the idempotency key was already used for a different request"))
+	}
+	if r.Status == 0 {
+		return true, problem.Write(c, problem.Conflict("idempotency_key_in_use", "a request with this idempotency key is in progress"))
+	}
+	c.Set(ReplayedHeader, "true")
+	for name, value := range r.Headers {
+		c.Set(name, value)
+	}
+	return true, c.Status(r.Status).Send(r.Body)
+}
+
+// release frees key so the request can be retried
+func release(rdb *redis.Client, key string, config Config) {
+	ctx, cancel := middleware.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	if err := rdb.Del(ctx, key).Err(); err != nil {
+		log.Printf("failed to release idempotency key: %s", err.Error())
+	}
+}
+
+// fingerprint identifies a request by its method, path and body
+func fingerprint(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Path()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,151 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIdempotency(t *testing.T) {
+	_, rdb := testutil.Redis(t)
+	created := 0
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", c.Get("X-Test-User"))
+		return c.Next()
+	})
+	app.Post("/orders", New(rdb, Config{TTL: time.Hour, LockTTL: time.Minute, Timeout: time.Second}), func(c *fiber.Ctx) error {
+		if c.Get("X-Test-Fail") != "" {
+			return problem.Write(c, problem.Upstream("backend_unavailable", "backend unavailable", nil))
+		}
+		created++
+		c.Set(fiber.HeaderETag, `"1"`)
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"id": strconv.Itoa(created)})
+	})
+
+	tests := []struct {
+		name     string
+		headers  []string
+		body     interface{}
+		expected int
+		id       string
+		code     string
+		replayed bool
+	}{
+		{"no key", nil, fiber.Map{"total_price": 1}, http.StatusCreated, "1", "", false},
+		{"first request", []string{Header, "a"}, fiber.Map{"total_price": 1}, http.StatusCreated, "2", "", false},
+		{"retry", []string{Header, "a"}, fiber.Map{"total_price": 1}, http.StatusCreated, "2", "", true},
+		{"key reused with another body", []string{Header, "a"}, fiber.Map{"total_price": 2}, http.StatusConflict, "", "idempotency_key_reused", false},
+		{"key of another user", []string{Header, "a", "X-Test-User", "ali"}, fiber.Map{"total_price": 1}, http.StatusCreated, "3", "", false},
+		{"failed request", []string{Header, "b", "X-Test-Fail", "1"}, fiber.Map{"total_price": 1}, http.StatusServiceUnavailable, "", "backend_unavailable", false},
+		{"failed request retried", []string{Header, "b"}, fiber.Map{"total_price": 1}, http.StatusCreated, "4", "", false},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodPost, "/orders", tt.body, tt.headers...)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+			continue
+		}
+		if replayed := res.Header.Get(ReplayedHeader) == "true"; replayed != tt.replayed {
+			t.Errorf("%s: expected replayed %v, got %v", tt.name, tt.replayed, replayed)
+		}
+		if tt.code == "" && (res.Header.Get(fiber.HeaderETag) != `"1"` || res.Header.Get(fiber.HeaderContentType) != fiber.MIMEApplicationJSON) {
+			t.Errorf("%s: expected the ETag and content type, got %v", tt.name, res.Header)
+		}
+		if tt.code != "" {
+			var p problem.Problem
+			json.Unmarshal(body, &p)
+			if p.Code != tt.code {
+				t.Errorf("%s: expected code %s, got %s", tt.name, tt.code, p.Code)
+			}
+			continue
+		}
+		var got struct{ ID string }
+		json.Unmarshal(body, &got)
+		if got.ID != tt.id {
+			t.Errorf("%s: expected id %s, got %s", tt.name, tt.id, got.ID)
+		}
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	_, rdb := testutil.Redis(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Post("/customers", New(rdb, Config{TTL: time.Hour, LockTTL: time.Minute, Timeout: time.Second}), func(c *fiber.Ctx) error {
+		close(started)
+		<-release
+		return c.SendStatus(http.StatusCreated)
+	})
+
+	done := make(chan int)
+	go func() {
+		res, _ := testutil.Request(t, app, http.MethodPost, "/customers", nil, Header, "a")
+		done <- res.StatusCode
+	}()
+	<-started
+	res, body := testutil.Request(t, app, http.MethodPost, "/customers", nil, Header, "a")
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if res.StatusCode != http.StatusConflict || p.Code != "idempotency_key_in_use" {
+		t.Errorf("expected 409 idempotency_key_in_use, got %d: %s", res.StatusCode, body)
+	}
+	close(release)
+	if status := <-done; status != http.StatusCreated {
+		t.Errorf("expected the first request to succeed, got %d", status)
+	}
+}
+
+// releaser frees the key a reservation failed on once, as a failing request
+// holding it does
+type releaser struct {
+	mr   *miniredis.Miniredis
+	done bool
+}
+
+func (r *releaser) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (r *releaser) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if set, ok := cmd.(*redis.BoolCmd); ok && cmd.Name() == "set" && !set.Val() && !r.done {
+		r.done = true
+		r.mr.Del(cmd.Args()[1].(string))
+	}
+	return nil
+}
+
+func (r *releaser) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (r *releaser) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestIdempotencyKeyReleased(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Post("/customers", New(rdb, Config{TTL: time.Hour, LockTTL: time.Minute, Timeout: time.Second}), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusCreated)
+	})
+	lock, _ := json.Marshal(record{Fingerprint: "another request"})
+	mr.Set("idempotency::POST:/customers:a", string(lock))
+	rdb.AddHook(&releaser{mr: mr})
+
+	// the key is released between the failed reservation and the read
+	res, body := testutil.Request(t, app, http.MethodPost, "/customers", nil, Header, "a")
+	if res.StatusCode != http.StatusCreated || res.Header.Get(ReplayedHeader) != "" {
+		t.Errorf("expected the key to be reserved again and the request processed, got %d: %s", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,501 @@
+// Package memdb provides an in-memory stand-in for MongoDB collections, it
+// implements the subset of the *mongo.Collection API used by the services so
+// they can run and be tested without a MongoDB server.
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is an in-memory collection of documents
+type Collection struct {
+	mu     sync.RWMutex
+	docs   []bson.D
+	unique []string
+}
+
+// NewCollection creates a new empty Collection
+func NewCollection() *Collection {
+	return &Collection{}
+}
+
+// Unique makes c reject the insertion of documents with the value of one of
+// fields of another document, as unique indexes do, and returns c
+func (c *Collection) Unique(fields ...string) *Collection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unique = append(c.unique, fields...)
+	return c
+}
+
+// duplicateKeyError mimics the error returned by MongoDB on duplicate keys
+func duplicateKeyError(key string) error {
+	return mongo.WriteException{
+		WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error dup key: { %s }", key),
+		}},
+	}
+}
+
+// InsertOne inserts a single document
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	doc, err := toDoc(document)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := lookup(doc, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range c.docs {
+		if existing, _ := lookup(d, "_id"); equal(existing, id) {
+			return nil, duplicateKeyError("_id")
+		}
+		for _, field := range c.unique {
+			value, ok := lookup(doc, field)
+			if existing, exists := lookup(d, field); ok && exists && equal(existing, value) {
+				return nil, duplicateKeyError(field)
+			}
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+// filter returns the documents matching filter
+func (c *Collection) filter(filter interface{}) ([]bson.D, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.D
+	for _, doc := range c.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// sortDocs sorts docs by the given sort specification
+func sortDocs(docs []bson.D, spec interface{}) error {
+	if spec == nil {
+		return nil
+	}
+	keys, err := toDoc(spec)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			a, _ := lookup(docs[i], key.Key)
+			b, _ := lookup(docs[j], key.Key)
+			c := sortValue(a, b)
+			if c == 0 {
+				continue
+			}
+			if dir, _ := number(key.Value); dir < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// project returns the fields of doc selected by the projection spec, either
+// the included fields, with _id unless excluded, or all but the excluded ones
+func project(doc bson.D, spec interface{}) (bson.D, error) {
+	if spec == nil {
+		return doc, nil
+	}
+	fields, err := toDoc(spec)
+	if err != nil {
+		return nil, err
+	}
+	included := map[string]bool{"_id": true}
+	inclusive := false
+	for _, field := range fields {
+		on, _ := number(field.Value)
+		included[field.Key] = on != 0
+		inclusive = inclusive || (on != 0 && field.Key != "_id")
+	}
+	var projected bson.D
+	for _, e := range doc {
+		if on, listed := included[e.Key]; on || (!listed && !inclusive) {
+			projected = append(projected, e)
+		}
+	}
+	return projected, nil
+}
+
+// Find returns a cursor over the documents matching filter
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	docs, err := c.filter(filter)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	opt := options.MergeFindOptions(opts...)
+	if err := sortDocs(docs, opt.Sort); err != nil {
+		return nil, err
+	}
+	if opt.Skip != nil {
+		if int(*opt.Skip) >= len(docs) {
+			docs = nil
+		} else {
+			docs = docs[*opt.Skip:]
+		}
+	}
+	if opt.Limit != nil && *opt.Limit > 0 && int(*opt.Limit) < len(docs) {
+		docs = docs[:*opt.Limit]
+	}
+	result := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		if result[i], err = project(doc, opt.Projection); err != nil {
+			return nil, err
+		}
+	}
+	return mongo.NewCursorFromDocuments(result, nil, nil)
+}
+
+// FindOne returns the first document matching filter
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	if err := ctx.Err(); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	c.mu.RLock()
+	docs, err := c.filter(filter)
+	c.mu.RUnlock()
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	opt := options.MergeFindOneOptions(opts...)
+	if err := sortDocs(docs, opt.Sort); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+// applyUpdate applies the update operators to a copy of doc
+func applyUpdate(doc bson.D, update bson.D) (bson.D, error) {
+	updated, err := toDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a document", op.Key)
+		}
+		for _, field := range fields {
+			switch op.Key {
+			case "$set":
+				updated = set(updated, field.Key, field.Value)
+			case "$unset":
+				updated = unset(updated, field.Key)
+			case "$inc":
+				current, _ := lookup(updated, field.Key)
+				updated = set(updated, field.Key, add(current, field.Value))
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", op.Key)
+			}
+		}
+	}
+	return updated, nil
+}
+
+// add adds two bson numbers keeping integer types when possible
+func add(a, b interface{}) interface{} {
+	switch x := a.(type) {
+	case nil:
+		return b
+	case int32:
+		if y, ok := b.(int32); ok {
+			return x + y
+		}
+	}
+	x, _ := number(a)
+	y, _ := number(b)
+	if _, isFloat := a.(float64); !isFloat {
+		if _, isFloat := b.(float64); !isFloat {
+			return int64(x + y)
+		}
+	}
+	return x + y
+}
+
+// UpdateOne updates the first document matching filter
+func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range c.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		updated, err := applyUpdate(doc, u)
+		if err != nil {
+			return nil, err
+		}
+		c.docs[i] = updated
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	if opt := options.MergeUpdateOptions(opts...); opt.Upsert == nil || !*opt.Upsert {
+		return &mongo.UpdateResult{}, nil
+	}
+	// the document inserted has the fields the filter is equal to
+	var doc bson.D
+	for _, e := range f {
+		if _, ok := isOperatorDoc(e.Value); !ok && e.Key[0] != '$' {
+			doc = set(doc, e.Key, e.Value)
+		}
+	}
+	upserted, err := applyUpdate(doc, u)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := lookup(upserted, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		upserted = append(bson.D{{Key: "_id", Value: id}}, upserted...)
+	}
+	c.docs = append(c.docs, upserted)
+	return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: id}, nil
+}
+
+// FindOneAndUpdate updates the first document matching filter in the sort
+// order and returns it as it was before or after the update
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	if err := ctx.Err(); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	docs, err := c.filter(filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if err := sortDocs(docs, opt.Sort); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	id, _ := lookup(docs[0], "_id")
+	for i, doc := range c.docs {
+		if current, _ := lookup(doc, "_id"); current != id {
+			continue
+		}
+		updated, err := applyUpdate(doc, u)
+		if err != nil {
+			return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+		}
+		c.docs[i] = updated
+		if opt.ReturnDocument != nil && *opt.ReturnDocument == options.After {
+			return mongo.NewSingleResultFromDocument(updated, nil, nil)
+		}
+		return mongo.NewSingleResultFromDocument(doc, nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+}
+
+// DeleteOne deletes the first document matching filter
+func (c *Collection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range c.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c.docs = append(c.docs[:i], c.docs[i+1:]...)
+			return &mongo.DeleteResult{DeletedCount: 1}, nil
+		}
+	}
+	return &mongo.DeleteResult{}, nil
+}
+
+// DeleteMany deletes the documents matching filter
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var kept []bson.D
+	var deleted int64
+	for _, doc := range c.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return &mongo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+// CountDocuments returns the number of documents matching filter
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs, err := c.filter(filter)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+// Aggregate runs pipeline over the documents and returns a cursor over the
+// results, only the $match and $group stages are supported
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var stages []interface{}
+	switch p := pipeline.(type) {
+	case mongo.Pipeline:
+		for _, stage := range p {
+			stages = append(stages, stage)
+		}
+	case []bson.D:
+		for _, stage := range p {
+			stages = append(stages, stage)
+		}
+	case bson.A:
+		stages = p
+	case []interface{}:
+		stages = p
+	default:
+		return nil, fmt.Errorf("unsupported pipeline %T", pipeline)
+	}
+	c.mu.RLock()
+	docs, err := c.filter(nil)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, stage := range stages {
+		d, err := toDoc(stage)
+		if err != nil {
+			return nil, err
+		}
+		if len(d) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must have a single field")
+		}
+		if d[0].Key == "$limit" {
+			if n, ok := number(d[0].Value); ok && int(n) < len(docs) {
+				docs = docs[:int(n)]
+			}
+			continue
+		}
+		spec, ok := d[0].Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a document", d[0].Key)
+		}
+		switch d[0].Key {
+		case "$match":
+			var matched []bson.D
+			for _, doc := range docs {
+				ok, err := match(doc, spec)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, doc)
+				}
+			}
+			docs = matched
+		case "$group":
+			if docs, err = group(docs, spec); err != nil {
+				return nil, err
+			}
+		case "$addFields":
+			added := make([]bson.D, len(docs))
+			for i, doc := range docs {
+				added[i] = doc
+				for _, field := range spec {
+					added[i] = set(added[i], field.Key, eval(field.Value, doc))
+				}
+			}
+			docs = added
+		case "$sort":
+			if err := sortDocs(docs, spec); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported pipeline stage %s", d[0].Key)
+		}
+	}
+	result := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		result[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(result, nil, nil)
+}
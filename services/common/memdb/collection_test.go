@@ -0,0 +1,272 @@
+package memdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type item struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name  string             `bson:"name"`
+	Price float64            `bson:"price"`
+}
+
+func seed(t *testing.T) *Collection {
+	t.Helper()
+	c := NewCollection()
+	for _, it := range []item{{Name: "a", Price: 10}, {Name: "b", Price: 20}, {Name: "c", Price: 30}} {
+		if _, err := c.InsertOne(context.Background(), it); err != nil {
+			t.Fatalf("insert: %s", err)
+		}
+	}
+	return c
+}
+
+func TestFindFilterSortLimit(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	cursor, err := c.Find(ctx, bson.M{"price": bson.M{"$gte": 15}}, options.Find().SetSort(bson.D{{Key: "price", Value: -1}}).SetLimit(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []item
+	if err := cursor.All(ctx, &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "c" {
+		t.Fatalf("unexpected items %+v", items)
+	}
+	n, err := c.CountDocuments(ctx, bson.M{"$or": bson.A{bson.M{"name": "a"}, bson.M{"name": bson.M{"$in": bson.A{"b"}}}}})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 documents, got %d (%v)", n, err)
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	res, err := c.UpdateOne(ctx, bson.M{"name": "a"}, bson.M{"$set": bson.M{"price": 15}, "$inc": bson.M{"version": 1}})
+	if err != nil || res.MatchedCount != 1 {
+		t.Fatalf("update failed: %v %+v", err, res)
+	}
+	var doc bson.M
+	if err := c.FindOne(ctx, bson.M{"name": "a"}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["price"] != int32(15) || doc["version"] != int32(1) {
+		t.Fatalf("unexpected document %v", doc)
+	}
+	if _, err := c.DeleteOne(ctx, bson.M{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	err = c.FindOne(ctx, bson.M{"name": "a"}).Decode(&doc)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected ErrNoDocuments, got %v", err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	c := NewCollection()
+	ctx := context.Background()
+	upsert := options.Update().SetUpsert(true)
+	for i := 0; i < 2; i++ {
+		if _, err := c.UpdateOne(ctx, bson.M{"_id": "stats"}, bson.M{"$inc": bson.M{"count": 2.5}}, upsert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var doc bson.M
+	if err := c.FindOne(ctx, bson.M{"_id": "stats"}).Decode(&doc); err != nil || doc["count"] != 5.0 {
+		t.Fatalf("expected the document to be inserted then updated, got %v (%v)", doc, err)
+	}
+	res, err := c.UpdateOne(ctx, bson.M{"_id": "other"}, bson.M{"$inc": bson.M{"count": 1}})
+	if err != nil || res.UpsertedCount != 0 {
+		t.Fatalf("expected no document to be inserted without upsert, got %+v (%v)", res, err)
+	}
+}
+
+func TestFindOneAndUpdate(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	var before, after item
+	cheapest := options.FindOneAndUpdate().SetSort(bson.D{{Key: "price", Value: 1}})
+	if err := c.FindOneAndUpdate(ctx, bson.M{"price": bson.M{"$gt": 10}}, bson.M{"$inc": bson.M{"price": 5}}, cheapest).Decode(&before); err != nil {
+		t.Fatal(err)
+	}
+	if before.Name != "b" || before.Price != 20 {
+		t.Fatalf("expected b as it was before the update, got %+v", before)
+	}
+	err := c.FindOneAndUpdate(ctx, bson.M{"name": "b"}, bson.M{"$set": bson.M{"name": "d"}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err != nil || after.Name != "d" || after.Price != 25 || after.ID != before.ID {
+		t.Fatalf("expected d as it is after the update, got %+v (%v)", after, err)
+	}
+	err = c.FindOneAndUpdate(ctx, bson.M{"name": "b"}, bson.M{"$set": bson.M{"name": "e"}}).Decode(&after)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected ErrNoDocuments, got %v", err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	res, err := c.DeleteMany(ctx, bson.M{"price": bson.M{"$lt": 25}})
+	if err != nil || res.DeletedCount != 2 {
+		t.Fatalf("expected 2 deleted documents, got %+v (%v)", res, err)
+	}
+	n, err := c.CountDocuments(ctx, bson.M{})
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 document left, got %d (%v)", n, err)
+	}
+}
+
+func TestDuplicateID(t *testing.T) {
+	c := NewCollection()
+	ctx := context.Background()
+	id := primitive.NewObjectID()
+	if _, err := c.InsertOne(ctx, item{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.InsertOne(ctx, item{ID: id}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	c := seed(t).Unique("name")
+	if _, err := c.InsertOne(context.Background(), item{Name: "a"}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+	if _, err := c.InsertOne(context.Background(), item{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNilMatchesMissingField(t *testing.T) {
+	c := seed(t)
+	n, err := c.CountDocuments(context.Background(), bson.M{"deleted_at": nil})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 documents, got %d (%v)", n, err)
+	}
+}
+
+func TestFindProjection(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		projection bson.M
+		expected   []string
+	}{
+		{bson.M{"name": 1}, []string{"_id", "name"}},
+		{bson.M{"name": 1, "_id": 0}, []string{"name"}},
+		{bson.M{"price": 0}, []string{"_id", "name"}},
+	} {
+		cursor, err := c.Find(ctx, bson.M{"name": "a"}, options.Find().SetProjection(tt.projection))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var docs []bson.D
+		if err := cursor.All(ctx, &docs); err != nil || len(docs) != 1 {
+			t.Fatalf("expected a document, got %v (%v)", docs, err)
+		}
+		var keys []string
+		for _, e := range docs[0] {
+			keys = append(keys, e.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%v: expected %v, got %v", tt.projection, tt.expected, keys)
+		}
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	c := seed(t)
+	for search, expected := range map[string]int64{"A": 1, "b x": 1, "x": 0, "a, c": 2} {
+		n, err := c.CountDocuments(context.Background(), bson.M{"$text": bson.M{"$search": search}})
+		if err != nil || n != expected {
+			t.Errorf("%q: expected %d documents, got %d (%v)", search, expected, n, err)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	c := seed(t)
+	ctx := context.Background()
+	cursor, err := c.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"price": bson.M{"$gt": 10}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			{Key: "total", Value: bson.M{"$sum": "$price"}},
+			{Key: "average", Value: bson.M{"$avg": "$price"}},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Count   int64   `bson:"count"`
+		Total   float64 `bson:"total"`
+		Average float64 `bson:"average"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Count != 2 || results[0].Total != 50 || results[0].Average != 25 {
+		t.Errorf("expected 2 items totalling 50, got %+v", results)
+	}
+
+	cursor, err = c.Aggregate(ctx, bson.A{bson.M{"$group": bson.M{"_id": "$name", "n": bson.M{"$sum": 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []bson.M
+	if err := cursor.All(ctx, &groups); err != nil || len(groups) != 3 {
+		t.Errorf("expected a group per name, got %v (%v)", groups, err)
+	}
+	if _, err := c.Aggregate(ctx, bson.A{bson.M{"$unwind": "$name"}}); err == nil {
+		t.Error("expected unsupported stages to fail")
+	}
+}
+
+func TestAggregateSortLimit(t *testing.T) {
+	c := NewCollection()
+	ctx := context.Background()
+	for _, doc := range []bson.M{
+		{"name": "a", "tags": bson.A{"x"}},
+		{"name": "b", "tags": bson.A{"x", "y", "z"}},
+		{"name": "c"},
+		{"name": "d", "tags": bson.A{"y", "x"}},
+	} {
+		if _, err := c.InsertOne(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor, err := c.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.M{"shared": bson.M{"$size": bson.M{"$setIntersection": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, bson.A{"x", "y"}}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "shared", Value: -1}, {Key: "name", Value: 1}}}},
+		{{Key: "$limit", Value: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Name   string `bson:"name"`
+		Shared int    `bson:"shared"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Name+":"+strings.Repeat("+", r.Shared))
+	}
+	if strings.Join(got, " ") != "b:++ d:++ a:+" {
+		t.Errorf("expected the documents sharing the most tags first, got %v", got)
+	}
+}
@@ -0,0 +1,521 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// toDoc normalizes a document, filter or update given as a struct, bson.M
+// or bson.D into a bson.D holding only primitive bson values
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// lookup returns the value at the dotted path in doc
+func lookup(doc bson.D, path string) (interface{}, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	for _, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if !nested {
+			return e.Value, true
+		}
+		if sub, ok := e.Value.(bson.D); ok {
+			return lookup(sub, rest)
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// set sets the value at the dotted path in doc, creating sub documents as needed
+func set(doc bson.D, path string, value interface{}) bson.D {
+	key, rest, nested := strings.Cut(path, ".")
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if nested {
+			sub, _ := e.Value.(bson.D)
+			doc[i].Value = set(sub, rest, value)
+		} else {
+			doc[i].Value = value
+		}
+		return doc
+	}
+	if nested {
+		return append(doc, bson.E{Key: key, Value: set(bson.D{}, rest, value)})
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// unset removes the value at the dotted path in doc
+func unset(doc bson.D, path string) bson.D {
+	key, rest, nested := strings.Cut(path, ".")
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if nested {
+			if sub, ok := e.Value.(bson.D); ok {
+				doc[i].Value = unset(sub, rest)
+			}
+			return doc
+		}
+		return append(doc[:i:i], doc[i+1:]...)
+	}
+	return doc
+}
+
+// match reports whether doc matches the query filter
+func match(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			clauses, ok := e.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s must be an array", e.Key)
+			}
+			matched := 0
+			for _, clause := range clauses {
+				sub, ok := clause.(bson.D)
+				if !ok {
+					return false, fmt.Errorf("%s entries must be documents", e.Key)
+				}
+				ok, err := match(doc, sub)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched++
+				}
+			}
+			switch {
+			case e.Key == "$and" && matched != len(clauses),
+				e.Key == "$or" && matched == 0,
+				e.Key == "$nor" && matched != 0:
+				return false, nil
+			}
+		case "$text":
+			ok, err := matchText(doc, e.Value)
+			if err != nil || !ok {
+				return false, err
+			}
+		default:
+			value, exists := lookup(doc, e.Key)
+			ok, err := matchField(value, exists, e.Value)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// matchText implements the $text operator as if every string field of doc
+// was text indexed, doc matches when one of its words is a word of the search
+func matchText(doc bson.D, cond interface{}) (bool, error) {
+	d, _ := cond.(bson.D)
+	search, _ := lookup(d, "$search")
+	s, ok := search.(string)
+	if !ok {
+		return false, fmt.Errorf("$text needs a $search string")
+	}
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	terms := map[string]bool{}
+	for _, term := range strings.FieldsFunc(strings.ToLower(s), isSeparator) {
+		terms[term] = true
+	}
+	for _, e := range doc {
+		text, ok := e.Value.(string)
+		if !ok {
+			continue
+		}
+		for _, w := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+			if terms[w] {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isOperatorDoc reports whether v is a document of query operators
+func isOperatorDoc(v interface{}) (bson.D, bool) {
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 {
+		return nil, false
+	}
+	for _, e := range d {
+		if !strings.HasPrefix(e.Key, "$") {
+			return nil, false
+		}
+	}
+	return d, true
+}
+
+// matchField reports whether a field value satisfies a filter condition
+func matchField(value interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		return matchEqual(value, exists, cond), nil
+	}
+	for _, op := range ops {
+		var ok bool
+		switch op.Key {
+		case "$eq":
+			ok = matchEqual(value, exists, op.Value)
+		case "$ne":
+			ok = !matchEqual(value, exists, op.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && matchCompare(value, op.Key, op.Value)
+		case "$in", "$nin":
+			list, isList := op.Value.(bson.A)
+			if !isList {
+				return false, fmt.Errorf("%s needs an array", op.Key)
+			}
+			for _, v := range list {
+				if matchEqual(value, exists, v) {
+					ok = true
+					break
+				}
+			}
+			if op.Key == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			want, _ := op.Value.(bool)
+			ok = exists == want
+		case "$regex":
+			pattern, _ := op.Value.(string)
+			if options, found := lookup(ops, "$options"); found {
+				if flags, _ := options.(string); strings.Contains(flags, "i") {
+					pattern = "(?i)" + pattern
+				}
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, err
+			}
+			s, isString := value.(string)
+			ok = isString && re.MatchString(s)
+		case "$options":
+			ok = true
+		case "$not":
+			matched, err := matchField(value, exists, op.Value)
+			if err != nil {
+				return false, err
+			}
+			ok = !matched
+		default:
+			return false, fmt.Errorf("unsupported query operator %s", op.Key)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEqual implements equality matching, a nil condition matches missing
+// fields and arrays match when any of their elements is equal
+func matchEqual(value interface{}, exists bool, cond interface{}) bool {
+	if cond == nil {
+		return !exists || value == nil
+	}
+	if !exists {
+		return false
+	}
+	if arr, ok := value.(bson.A); ok {
+		if _, condIsArr := cond.(bson.A); !condIsArr {
+			for _, v := range arr {
+				if equal(v, cond) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return equal(value, cond)
+}
+
+// matchCompare implements the $gt, $gte, $lt and $lte operators
+func matchCompare(value interface{}, op string, cond interface{}) bool {
+	c, ok := compare(value, cond)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	default:
+		return c <= 0
+	}
+}
+
+// number returns v as a float64 if it is a bson number
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// equal reports whether two bson values are equal
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two bson values of the same kind, ok is false when
+// the values cannot be compared
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), ok
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		switch {
+		case x < y:
+			return -1, ok
+		case x > y:
+			return 1, ok
+		}
+		return 0, ok
+	case bool:
+		y, ok := b.(bool)
+		switch {
+		case x == y:
+			return 0, ok
+		case !x:
+			return -1, ok
+		}
+		return 1, ok
+	}
+	return 0, false
+}
+
+// sortValue orders two values for sorting, missing and null values first
+func sortValue(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compare(a, b)
+	return c
+}
+
+// eval returns the value of the expression expr for doc, a field path such as
+// "$total_price", an array of expressions, one of the $size, $setIntersection
+// and $ifNull operators or a constant
+func eval(expr interface{}, doc bson.D) interface{} {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			value, _ := lookup(doc, e[1:])
+			return value
+		}
+	case bson.A:
+		values := make(bson.A, len(e))
+		for i, v := range e {
+			values[i] = eval(v, doc)
+		}
+		return values
+	case bson.D:
+		if len(e) != 1 {
+			break
+		}
+		args, _ := eval(e[0].Value, doc).(bson.A)
+		switch e[0].Key {
+		case "$size":
+			return int32(len(args))
+		case "$setIntersection":
+			return intersection(args)
+		case "$ifNull":
+			for _, arg := range args {
+				if arg != nil {
+					return arg
+				}
+			}
+			return nil
+		}
+	}
+	return expr
+}
+
+// intersection returns the distinct values found in every array of arrays
+func intersection(arrays bson.A) bson.A {
+	common := bson.A{}
+	if len(arrays) == 0 {
+		return common
+	}
+	first, _ := arrays[0].(bson.A)
+	for _, v := range first {
+		in := true
+		for _, other := range arrays[1:] {
+			array, _ := other.(bson.A)
+			in = in && contains(array, v)
+		}
+		if in && !contains(common, v) {
+			common = append(common, v)
+		}
+	}
+	return common
+}
+
+// contains reports whether array has a value equal to v
+func contains(array bson.A, v interface{}) bool {
+	for _, value := range array {
+		if equal(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// accumulator accumulates the values of a field of a group
+type accumulator struct {
+	op    string
+	sum   float64
+	float bool
+	count int
+}
+
+// result returns the value accumulated, $sum returns an integer when every
+// value summed was one and $avg returns null when there was none
+func (a *accumulator) result() interface{} {
+	if a.op == "$avg" {
+		if a.count == 0 {
+			return nil
+		}
+		return a.sum / float64(a.count)
+	}
+	if a.float {
+		return a.sum
+	}
+	return int64(a.sum)
+}
+
+// group implements the $group stage, the documents are grouped by the value
+// of the _id expression and their fields accumulated with $sum and $avg
+func group(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpr, ok := lookup(spec, "_id")
+	if !ok {
+		return nil, fmt.Errorf("$group needs an _id")
+	}
+	type bucket struct {
+		id   interface{}
+		accs []*accumulator
+	}
+	var buckets []*bucket
+	for _, doc := range docs {
+		id := eval(idExpr, doc)
+		var b *bucket
+		for _, candidate := range buckets {
+			if equal(candidate.id, id) {
+				b = candidate
+				break
+			}
+		}
+		if b == nil {
+			b = &bucket{id: id}
+			buckets = append(buckets, b)
+		}
+		i := 0
+		for _, field := range spec {
+			if field.Key == "_id" {
+				continue
+			}
+			op, ok := field.Value.(bson.D)
+			if !ok || len(op) != 1 || (op[0].Key != "$sum" && op[0].Key != "$avg") {
+				return nil, fmt.Errorf("unsupported accumulator for %s, only $sum and $avg are", field.Key)
+			}
+			if len(b.accs) <= i {
+				b.accs = append(b.accs, &accumulator{op: op[0].Key})
+			}
+			acc := b.accs[i]
+			i++
+			value := eval(op[0].Value, doc)
+			n, ok := number(value)
+			if !ok {
+				continue
+			}
+			if _, isFloat := value.(float64); isFloat {
+				acc.float = true
+			}
+			acc.sum += n
+			acc.count++
+		}
+	}
+	results := make([]bson.D, len(buckets))
+	for i, b := range buckets {
+		result := bson.D{{Key: "_id", Value: b.id}}
+		j := 0
+		for _, field := range spec {
+			if field.Key == "_id" {
+				continue
+			}
+			result = append(result, bson.E{Key: field.Key, Value: b.accs[j].result()})
+			j++
+		}
+		results[i] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UserHeader carries the username of a caller already authenticated by the
+// gateway
+const UserHeader = "X-User"
+
+// Auth verifies the bearer token of every request with the auth service and
+// stores the username of the caller in the "user" local. When trustGateway is
+// set, requests carrying UserHeader are let through without verifying the
+// token again, so it must only be set when the service is reachable through
+// the gateway alone. The token is forwarded to the gRPC calls made with the
+// user context of the request, the called services verify it on their own.
+func Auth(client pb.AuthServiceClient, trustGateway bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		fields := strings.Fields(c.Get("Authorization"))
+		bearer := len(fields) == 2 && fields[0] == "Bearer"
+		if bearer {
+			c.SetUserContext(rpc.WithToken(c.UserContext(), fields[1]))
+		}
+		if user := c.Get(UserHeader); trustGateway && user != "" {
+			c.Locals("user", user)
+			return c.Next()
+		}
+		if !bearer {
+			return problem.Write(c, problem.Unauthorized("missing_token", "a bearer token is required"))
+		}
+		auth, err := client.VerifyToken(c.UserContext(), &pb.Auth{AccessToken: fields[1]})
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		c.Locals("user", auth.GetUsername())
+		return c.Next()
+	}
+}
+
+// User returns the username of the caller stored by Auth, empty when the
+// request did not go through it
+func User(c *fiber.Ctx) string {
+	user, _ := c.Locals("user").(string)
+	return user
+}
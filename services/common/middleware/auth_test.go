@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAuth(t *testing.T) {
+	_, client := testutil.Auth(t)
+
+	newApp := func(trustGateway bool) *fiber.App {
+		app := fiber.New()
+		app.Use(Auth(client, trustGateway))
+		app.Get("/", func(c *fiber.Ctx) error {
+			return c.SendString(c.Locals("user").(string))
+		})
+		return app
+	}
+
+	tests := []struct {
+		name         string
+		trustGateway bool
+		headers      []string
+		expected     int
+		user         string
+	}{
+		{"valid token", false, []string{"Authorization", "Bearer " + testutil.Token}, http.StatusOK, testutil.User},
+		{"invalid token", false, []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized, ""},
+		{"untrusted user header", false, []string{UserHeader, "mallory"}, http.StatusUnauthorized, ""},
+		{"trusted user header", true, []string{UserHeader, "omar"}, http.StatusOK, testutil.User},
+		{"trusted without user header", true, []string{"Authorization", "Bearer " + testutil.Token}, http.StatusOK, testutil.User},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, newApp(tt.trustGateway), http.MethodGet, "/", nil, tt.headers...)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+		}
+		if tt.user != "" && string(body) != tt.user {
+			t.Errorf("%s: expected user %s, got %s", tt.name, tt.user, body)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Timeout bounds the user context of every request by timeout, so that the
+// database, cache and upstream calls made on its behalf are cancelled once
+// the deadline is reached
+func Timeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// WithTimeout returns ctx bounded by timeout, a timeout of 0 leaves it
+// bounded by ctx only
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// detached keeps the values of a context without its deadline and
+// cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// Detach returns a context with the values of ctx, e.g. the token of the
+// caller, that is neither cancelled nor bounded by its deadline, for the work
+// that must outlive the request it is done for
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
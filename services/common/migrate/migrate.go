@@ -0,0 +1,259 @@
+// Package migrate applies the versioned schema migrations of a service to
+// its MongoDB database. The migrations applied are recorded in the
+// schema_migrations collection, one document per service and version, so
+// that each of them runs once. Steps must be idempotent nonetheless: replicas
+// starting at the same time may both run a step before either records it.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName is the collection recording the migrations applied
+const CollectionName = "schema_migrations"
+
+// Step changes the schema or the documents of db
+type Step func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a versioned change of the schema of a service
+type Migration struct {
+	// Version orders the migrations of a service, it starts at 1
+	Version int
+	// Description says what the migration does
+	Description string
+	// Up applies the migration
+	Up Step
+	// Down reverts the migration, nil when there is nothing to revert
+	Down Step
+}
+
+// Record is a migration applied to a database
+type Record struct {
+	ID          string `bson:"_id"`
+	Service     string `bson:"service"`
+	Version     int    `bson:"version"`
+	Description string `bson:"description"`
+	AppliedAt   string `bson:"applied_at"`
+}
+
+// Status is a migration of a service and when it was applied, AppliedAt is
+// empty while it is pending
+type Status struct {
+	Service     string
+	Version     int
+	Description string
+	AppliedAt   string
+}
+
+// Collection is the subset of *mongo.Collection used to record migrations
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+}
+
+// Migrator applies the migrations of a service
+type Migrator struct {
+	db         *mongo.Database
+	records    Collection
+	service    string
+	migrations []Migration
+}
+
+// New creates the Migrator applying migrations to db on behalf of service
+func New(db *mongo.Database, service string, migrations []Migration) *Migrator {
+	return &Migrator{db: db, records: db.Collection(CollectionName), service: service, migrations: migrations}
+}
+
+// Service returns the name of the service of m
+func (m *Migrator) Service() string {
+	return m.service
+}
+
+// sorted returns the migrations of m in the order of their versions
+func (m *Migrator) sorted() ([]Migration, error) {
+	migrations := append([]Migration(nil), m.migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version < 1 {
+			return nil, fmt.Errorf("%s migration %q: versions start at 1", m.service, migration.Description)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("%s migration %d is defined twice", m.service, migration.Version)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("%s migration %d has no up step", m.service, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// applied returns the records of the migrations of m applied, by version
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := m.records.Find(ctx, bson.M{"service": m.service})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Up applies the pending migrations up to version target in order, all of
+// them when target is 0, and returns the number of migrations applied
+func (m *Migrator) Up(ctx context.Context, target int) (int, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, migration := range migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := migration.Up(ctx, m.db); err != nil {
+			return n, fmt.Errorf("%s migration %d (%s): %w", m.service, migration.Version, migration.Description, err)
+		}
+		record := Record{
+			ID:          fmt.Sprintf("%s/%d", m.service, migration.Version),
+			Service:     m.service,
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC().Format(time.RFC3339),
+		}
+		// another replica may have applied it meanwhile
+		if _, err := m.records.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return n, err
+		}
+		log.Printf("Applied %s migration %d: %s", m.service, migration.Version, migration.Description)
+		n++
+	}
+	return n, nil
+}
+
+// Down reverts the applied migrations above version target, the most recent
+// first, and returns the number of migrations reverted
+func (m *Migrator) Down(ctx context.Context, target int) (int, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := len(migrations) - 1; i >= 0 && migrations[i].Version > target; i-- {
+		migration := migrations[i]
+		record, ok := applied[migration.Version]
+		if !ok {
+			continue
+		}
+		if migration.Down != nil {
+			if err := migration.Down(ctx, m.db); err != nil {
+				return n, fmt.Errorf("%s migration %d (%s): %w", m.service, migration.Version, migration.Description, err)
+			}
+		}
+		if _, err := m.records.DeleteOne(ctx, bson.M{"_id": record.ID}); err != nil {
+			return n, err
+		}
+		log.Printf("Reverted %s migration %d: %s", m.service, migration.Version, migration.Description)
+		n++
+	}
+	return n, nil
+}
+
+// Status returns the migrations of m in order and when they were applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = Status{
+			Service:     m.service,
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   applied[migration.Version].AppliedAt,
+		}
+	}
+	return statuses, nil
+}
+
+// IndexName returns the name MongoDB gives by default to the index of keys
+func IndexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// CreateIndexes returns the migration creating indexes on collection,
+// reverted by dropping them. Creating an index that exists already is a no-op.
+// The keys of indexes are bson.D, unnamed indexes get their default name.
+func CreateIndexes(version int, description, collection string, indexes ...mongo.IndexModel) Migration {
+	named := make([]mongo.IndexModel, len(indexes))
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		opts := options.Index()
+		if index.Options != nil {
+			opts = index.Options
+		}
+		if opts.Name == nil {
+			opts.SetName(IndexName(index.Keys.(bson.D)))
+		}
+		named[i] = mongo.IndexModel{Keys: index.Keys, Options: opts}
+		names[i] = *opts.Name
+	}
+	return Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(collection).Indexes().CreateMany(ctx, named)
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range names {
+				_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+				// the index may be gone already
+				if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Name == "IndexNotFound" {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// journal records the steps run by the migrations of a test
+type journal []string
+
+func (j *journal) migration(version int, description string, down bool) Migration {
+	m := Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			*j = append(*j, "up "+description)
+			return nil
+		},
+	}
+	if down {
+		m.Down = func(ctx context.Context, db *mongo.Database) error {
+			*j = append(*j, "down "+description)
+			return nil
+		}
+	}
+	return m
+}
+
+func TestUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	records := memdb.NewCollection()
+	var steps journal
+	m := &Migrator{records: records, service: "orders", migrations: []Migration{
+		steps.migration(2, "b", false),
+		steps.migration(1, "a", true),
+		steps.migration(3, "c", true),
+	}}
+	// the migrations of other services are left alone
+	other := &Migrator{records: records, service: "customers", migrations: []Migration{steps.migration(1, "other", true)}}
+	if _, err := other.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	steps = nil
+
+	if n, err := m.Up(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("up to 2: expected 2 migrations, got %d (%v)", n, err)
+	}
+	if n, err := m.Up(ctx, 0); err != nil || n != 1 {
+		t.Fatalf("up: expected 1 migration, got %d (%v)", n, err)
+	}
+	if n, err := m.Up(ctx, 0); err != nil || n != 0 {
+		t.Fatalf("up again: expected no migration, got %d (%v)", n, err)
+	}
+	if expected := (journal{"up a", "up b", "up c"}); !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected %v, got %v", expected, steps)
+	}
+
+	steps = nil
+	if n, err := m.Down(ctx, 0); err != nil || n != 3 {
+		t.Fatalf("down: expected 3 migrations, got %d (%v)", n, err)
+	}
+	if expected := (journal{"down c", "down a"}); !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected %v, got %v", expected, steps)
+	}
+	statuses, err := other.Status(ctx)
+	if err != nil || len(statuses) != 1 || statuses[0].AppliedAt == "" {
+		t.Errorf("expected the migration of customers to be applied still, got %v (%v)", statuses, err)
+	}
+}
+
+func TestStatusAndFailures(t *testing.T) {
+	ctx := context.Background()
+	var steps journal
+	failing := steps.migration(2, "failing", true)
+	failing.Up = func(ctx context.Context, db *mongo.Database) error {
+		return errors.New("boom")
+	}
+	m := &Migrator{records: memdb.NewCollection(), service: "auth", migrations: []Migration{steps.migration(1, "a", true), failing, steps.migration(3, "c", true)}}
+	if n, err := m.Up(ctx, 0); err == nil || n != 1 {
+		t.Fatalf("expected the failing migration to stop the others, got %d (%v)", n, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []int
+	for _, s := range statuses {
+		if s.AppliedAt == "" {
+			pending = append(pending, s.Version)
+		}
+	}
+	if !reflect.DeepEqual(pending, []int{2, 3}) {
+		t.Errorf("expected 2 and 3 to be pending, got %v", pending)
+	}
+
+	for name, migrations := range map[string][]Migration{
+		"duplicate version": {steps.migration(1, "a", false), steps.migration(1, "b", false)},
+		"version 0":         {steps.migration(0, "a", false)},
+		"no up step":        {{Version: 1, Description: "a"}},
+	} {
+		m := &Migrator{records: memdb.NewCollection(), service: "auth", migrations: migrations}
+		if _, err := m.Up(ctx, 0); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	keys := bson.D{{Key: "customer_id", Value: 1}, {Key: "_id", Value: -1}}
+	if name := IndexName(keys); name != "customer_id_1__id_-1" {
+		t.Errorf("unexpected name %q", name)
+	}
+	m := CreateIndexes(1, "text", "customers", mongo.IndexModel{Keys: bson.D{{Key: "name", Value: "text"}}})
+	if m.Up == nil || m.Down == nil {
+		t.Errorf("expected the migration to be reversible")
+	}
+}
@@ -0,0 +1,181 @@
+// Package outbox stores the events of the services along with the changes
+// they describe and relays them to the event bus once stored, so that no
+// event is lost between a write and its publication
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Batch is how many events a relay publishes at most
+	Batch = 100
+	// SentRetention is how long the sent events stay in the outbox
+	SentRetention = 24 * time.Hour
+	// ClaimTTL is how long a relay holds the event it publishes, another
+	// relay takes it over after that, e.g. when the first one crashed
+	ClaimTTL = 30 * time.Second
+)
+
+// Collection is the subset of *mongo.Collection used by an Outbox
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+}
+
+// Record is an event in the outbox, SentAt is set once it is published.
+// ClaimedBy is the relay publishing it until ClaimedUntil.
+type Record struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Event        events.Event       `bson:"event"`
+	SentAt       string             `bson:"sent_at,omitempty"`
+	ClaimedBy    string             `bson:"claimed_by,omitempty"`
+	ClaimedUntil time.Time          `bson:"claimed_until,omitempty"`
+}
+
+// Outbox is the outbox of a service
+type Outbox struct {
+	collection Collection
+	wake       chan struct{}
+	// relay identifies the relay of the Outbox among the replicas
+	relay string
+}
+
+// New returns the Outbox stored in collection
+func New(collection Collection) *Outbox {
+	return &Outbox{collection: collection, wake: make(chan struct{}, 1), relay: primitive.NewObjectID().Hex()}
+}
+
+// Indexes returns the indexes of the queries of the Outbox, on the time the
+// events were sent
+func Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{Keys: bson.D{{Key: "sent_at", Value: 1}}}}
+}
+
+// Add stores e in the outbox, ctx must be the one of the transaction storing
+// the change e describes
+func (o *Outbox) Add(ctx context.Context, e events.Event) error {
+	_, err := o.collection.InsertOne(ctx, Record{ID: primitive.NewObjectID(), Event: e})
+	return err
+}
+
+// Notify makes the relay run without waiting for its next tick, it is
+// called once the transaction adding events is committed
+func (o *Outbox) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Relay publishes up to Batch events not sent yet in the order they were
+// added and marks them sent, it stops at the first event that cannot be
+// published so that it is retried first. Every event is claimed before it is
+// published so that the relays of the other replicas leave it alone, and a
+// relay stops at an event claimed by another one so that the events keep
+// their order. The sent events older than SentRetention are removed. It
+// returns how many events were published.
+func (o *Outbox) Relay(ctx context.Context, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	n, err := o.publish(ctx, publish)
+	if err != nil {
+		return n, err
+	}
+	cutoff := time.Now().UTC().Add(-SentRetention).Format(time.RFC3339)
+	if _, err := o.collection.DeleteMany(ctx, bson.M{"sent_at": bson.M{"$lt": cutoff}}); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// publish publishes the events Relay claims one at a time
+func (o *Outbox) publish(ctx context.Context, publish func(ctx context.Context, e events.Event) error) (int, error) {
+	for n := 0; n < Batch; n++ {
+		r, ok, err := o.claim(ctx)
+		if err != nil || !ok {
+			return n, err
+		}
+		claimed := bson.M{"_id": r.ID, "claimed_by": o.relay}
+		release := bson.M{"claimed_by": "", "claimed_until": ""}
+		if err := publish(ctx, r.Event); err != nil {
+			// released for the next run to retry it without waiting for
+			// the claim to expire
+			o.collection.UpdateOne(ctx, claimed, bson.M{"$unset": release})
+			return n, err
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err := o.collection.UpdateOne(ctx, claimed, bson.M{"$set": bson.M{"sent_at": now}, "$unset": release}); err != nil {
+			return n, err
+		}
+	}
+	return Batch, nil
+}
+
+// claim claims the oldest event not sent yet for the relay of o, ok is false
+// when there is none or when another relay holds it
+func (o *Outbox) claim(ctx context.Context) (r Record, ok bool, err error) {
+	oldest := options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})
+	err = o.collection.FindOne(ctx, bson.M{"sent_at": bson.M{"$exists": false}}, oldest).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		return r, false, nil
+	} else if err != nil {
+		return r, false, err
+	}
+	now := time.Now().UTC()
+	err = o.collection.FindOneAndUpdate(ctx, bson.M{
+		"_id":     r.ID,
+		"sent_at": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"claimed_by": bson.M{"$exists": false}},
+			bson.M{"claimed_by": o.relay},
+			bson.M{"claimed_until": bson.M{"$lt": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{"claimed_by": o.relay, "claimed_until": now.Add(ClaimTTL)},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		return r, false, nil
+	} else if err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+// Run relays the events every interval and whenever notified until ctx is
+// done, failures are logged and retried at the next run
+func (o *Outbox) Run(ctx context.Context, interval time.Duration, name string, publish func(ctx context.Context, e events.Event) error) {
+	if interval <= 0 {
+		log.Printf("%s disabled", name)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+		for {
+			n, err := o.Relay(ctx, publish)
+			if err != nil {
+				log.Printf("%s failed: %s", name, err.Error())
+			}
+			// a full batch means more events may be waiting
+			if err != nil || n < Batch {
+				break
+			}
+		}
+	}
+}
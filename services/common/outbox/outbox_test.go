@@ -0,0 +1,122 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	o := New(memdb.NewCollection())
+	for _, subject := range []string{"1", "2", "3"} {
+		e, _ := events.New("order.created", subject, "omar", map[string]int{"total_price": 42})
+		if err := o.Add(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// publishing stops at the first failure, the rest is kept for later
+	var published []string
+	down := true
+	publish := func(ctx context.Context, e events.Event) error {
+		if e.Subject == "2" && down {
+			down = false
+			return errors.New("bus down")
+		}
+		if string(e.Data) != `{"total_price":42}` {
+			t.Errorf("unexpected data %s", e.Data)
+		}
+		published = append(published, e.Subject)
+		return nil
+	}
+	if n, err := o.Relay(ctx, publish); n != 1 || err == nil {
+		t.Fatalf("first relay: expected 1 event and an error, got %d (%v)", n, err)
+	}
+	if n, err := o.Relay(ctx, publish); n != 2 || err != nil {
+		t.Fatalf("second relay: expected 2 events, got %d (%v)", n, err)
+	}
+	if n, _ := o.Relay(ctx, publish); n != 0 {
+		t.Fatalf("third relay: expected no event, got %d", n)
+	}
+	if !reflect.DeepEqual(published, []string{"1", "2", "3"}) {
+		t.Fatalf("expected 1, 2 and 3 in order, got %v", published)
+	}
+}
+
+func TestRunNotified(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := New(memdb.NewCollection())
+	published := make(chan string, 1)
+	go o.Run(ctx, time.Hour, "test relay", func(ctx context.Context, e events.Event) error {
+		published <- e.Subject
+		return nil
+	})
+
+	e, _ := events.New("order.created", "1", "omar", nil)
+	o.Add(ctx, e)
+	o.Notify()
+	select {
+	case subject := <-published:
+		if subject != "1" {
+			t.Fatalf("expected 1, got %s", subject)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the relay did not run when notified")
+	}
+}
+
+func TestRelayClaimed(t *testing.T) {
+	ctx := context.Background()
+	collection := memdb.NewCollection()
+	first, second := New(collection), New(collection)
+	for _, subject := range []string{"1", "2"} {
+		e, _ := events.New("order.created", subject, "omar", nil)
+		first.Add(ctx, e)
+	}
+
+	// the event claimed by the first relay is left alone by the second one
+	var published []string
+	var concurrent int
+	publish := func(ctx context.Context, e events.Event) error {
+		published = append(published, e.Subject)
+		return nil
+	}
+	n, err := first.Relay(ctx, func(ctx context.Context, e events.Event) error {
+		if e.Subject == "1" {
+			concurrent, _ = second.Relay(ctx, publish)
+		}
+		return publish(ctx, e)
+	})
+	if n != 2 || err != nil || concurrent != 0 {
+		t.Fatalf("expected the first relay to publish 2 events alone, got %d and %d (%v)", n, concurrent, err)
+	}
+	if !reflect.DeepEqual(published, []string{"1", "2"}) {
+		t.Fatalf("expected 1 and 2 once, got %v", published)
+	}
+
+	// an expired claim is taken over, e.g. after the relay holding it crashed
+	e, _ := events.New("order.created", "3", "omar", nil)
+	first.Add(ctx, e)
+	var r Record
+	if _, ok, err := first.claim(ctx); !ok || err != nil {
+		t.Fatalf("claim: expected the event, got %v", err)
+	}
+	if n, _ := second.Relay(ctx, publish); n != 0 {
+		t.Fatalf("claimed: expected no event, got %d", n)
+	}
+	collection.FindOneAndUpdate(ctx, bson.M{"sent_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"claimed_until": time.Now().Add(-time.Second)}}).Decode(&r)
+	if n, err := second.Relay(ctx, publish); n != 1 || err != nil || r.ClaimedBy != first.relay {
+		t.Fatalf("expired claim: expected the event of %s, got %d from %s (%v)", first.relay, n, r.ClaimedBy, err)
+	}
+	if !reflect.DeepEqual(published, []string{"1", "2", "3"}) {
+		t.Fatalf("expected 3 to be published once, got %v", published)
+	}
+}
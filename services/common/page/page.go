@@ -0,0 +1,267 @@
+// Package page selects a page of the documents of a list endpoint from its
+// limit, after, sort and fields query parameters and writes it in an envelope
+// linking to the next page
+package page
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultLimit is the number of documents of a page when no limit is given
+	DefaultLimit = 50
+	// MaxLimit is the largest number of documents of a page
+	MaxLimit = 500
+)
+
+// Query selects a page of documents, the zero Query selects all of them in
+// the order of their ids
+type Query struct {
+	// Limit is the number of documents of the page, 0 selects all of them
+	Limit int64
+	// Sort is the field the documents are ordered by, then by id. Empty
+	// orders them by id only.
+	Sort string
+	// Desc orders the documents in descending order
+	Desc bool
+	// Fields are the fields of the documents returned along with their id,
+	// empty returns all of them
+	Fields []string
+
+	after *cursor
+}
+
+// cursor is the position after which the next page starts, it is handed to
+// clients encoded as an opaque string
+type cursor struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v,omitempty"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// Info describes the page selected by a Query
+type Info struct {
+	// Total is the number of documents of all the pages
+	Total int64
+	// NextCursor is the cursor of the next page, empty on the last one
+	NextCursor string
+}
+
+// Page is the envelope of the documents of a page
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// Parse reads the Query of the request, sortable are the fields documents
+// can be sorted by and fields the ones that can be selected
+func Parse(c *fiber.Ctx, sortable, fields []string) (Query, error) {
+	q := Query{Limit: DefaultLimit}
+	if limit := strings.TrimSpace(c.Query("limit")); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 || n > MaxLimit {
+			return q, problem.Validation("invalid_limit", fmt.Sprintf("limit must be between 1 and %d", MaxLimit))
+		}
+		q.Limit = n
+	}
+	sort := strings.TrimSpace(c.Query("sort"))
+	if sort != "" {
+		q.Sort = strings.TrimPrefix(sort, "-")
+		q.Desc = q.Sort != sort
+		if !contains(sortable, q.Sort) {
+			return q, problem.Validation("invalid_sort", fmt.Sprintf("%q cannot be sorted by, only %s can", q.Sort, strings.Join(sortable, ", ")))
+		}
+	}
+	for _, field := range strings.Split(c.Query("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !contains(fields, field) {
+			return q, problem.Validation("invalid_fields", fmt.Sprintf("%q cannot be selected, only %s can", field, strings.Join(fields, ", ")))
+		}
+		q.Fields = append(q.Fields, field)
+	}
+	if after := strings.TrimSpace(c.Query("after")); after != "" {
+		b, err := base64.RawURLEncoding.DecodeString(after)
+		if err != nil {
+			return q, invalidCursor()
+		}
+		var cur cursor
+		if err := bson.Unmarshal(b, &cur); err != nil || cur.Sort != sort {
+			return q, invalidCursor()
+		}
+		q.after = &cur
+	}
+	return q, nil
+}
+
+func invalidCursor() error {
+	return problem.Validation("invalid_cursor", "after must be the next_cursor of a page listed with the same sort")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKey returns the sort parameter q was parsed from
+func (q Query) sortKey() string {
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+// Filter restricts filter to the documents after the cursor of q
+func (q Query) Filter(filter bson.M) bson.M {
+	if q.after == nil {
+		return filter
+	}
+	op := "$gt"
+	if q.Desc {
+		op = "$lt"
+	}
+	after := bson.M{"_id": bson.M{op: q.after.ID}}
+	if q.Sort != "" {
+		after = bson.M{"$or": bson.A{
+			bson.M{q.Sort: bson.M{op: q.after.Value}},
+			bson.M{q.Sort: q.after.Value, "_id": bson.M{op: q.after.ID}},
+		}}
+	}
+	return bson.M{"$and": bson.A{filter, after}}
+}
+
+// Options returns the options of the query finding the documents of q, one
+// more document than the limit is read to know whether there is a next page
+func (q Query) Options() *options.FindOptions {
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	sort := bson.D{{Key: "_id", Value: dir}}
+	if q.Sort != "" {
+		sort = append(bson.D{{Key: q.Sort, Value: dir}}, sort...)
+	}
+	opts := options.Find().SetSort(sort)
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit + 1)
+	}
+	if len(q.Fields) > 0 {
+		projection := bson.M{"_id": 1}
+		if q.Sort != "" {
+			projection[q.Sort] = 1
+		}
+		for _, field := range q.Fields {
+			if field != "id" {
+				projection[field] = 1
+			}
+		}
+		opts.SetProjection(projection)
+	}
+	return opts
+}
+
+// Indexes returns the indexes reading the documents sorted by any of the
+// sortable fields, then by id, in order
+func Indexes(sortable []string) []mongo.IndexModel {
+	indexes := make([]mongo.IndexModel, len(sortable))
+	for i, field := range sortable {
+		indexes[i] = mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: 1}}}
+	}
+	return indexes
+}
+
+// Collect decodes the documents of cursor, found with the Options of q, into
+// items, a pointer to a slice, and returns the cursor of the next page
+func (q Query) Collect(ctx context.Context, cur *mongo.Cursor, items interface{}) (string, error) {
+	var docs []bson.Raw
+	if err := cur.All(ctx, &docs); err != nil {
+		return "", err
+	}
+	var next string
+	if q.Limit > 0 && int64(len(docs)) > q.Limit {
+		docs = docs[:q.Limit]
+		last := docs[len(docs)-1]
+		c := cursor{Sort: q.sortKey(), ID: last.Lookup("_id").ObjectID()}
+		if value := last.Lookup(q.Sort); q.Sort != "" && value.Type != 0 {
+			c.Value = value
+		}
+		b, err := bson.Marshal(c)
+		if err != nil {
+			return "", err
+		}
+		next = base64.RawURLEncoding.EncodeToString(b)
+	}
+	slice := reflect.ValueOf(items).Elem()
+	decoded := reflect.MakeSlice(slice.Type(), len(docs), len(docs))
+	for i, doc := range docs {
+		if err := bson.Unmarshal(doc, decoded.Index(i).Addr().Interface()); err != nil {
+			return "", err
+		}
+	}
+	slice.Set(decoded)
+	return next, nil
+}
+
+// Write responds to c with items, the documents of the page selected by q,
+// in a Page. The Link header refers to the next page relatively to the
+// request so that it holds behind the gateway.
+func Write(c *fiber.Ctx, q Query, items interface{}, info Info) error {
+	if len(q.Fields) > 0 {
+		selected, err := pick(items, q.Fields)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		items = selected
+	}
+	if info.NextCursor != "" {
+		query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		query.Set("after", info.NextCursor)
+		c.Set(fiber.HeaderLink, fmt.Sprintf(`<?%s>; rel="next"`, query.Encode()))
+	}
+	return c.Status(http.StatusOK).JSON(Page{Items: items, Total: info.Total, NextCursor: info.NextCursor})
+}
+
+// pick leaves out of the JSON objects of items the fields other than fields
+// and the id
+func pick(items interface{}, fields []string) ([]map[string]json.RawMessage, error) {
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &objects); err != nil {
+		return nil, err
+	}
+	selected := make([]map[string]json.RawMessage, len(objects))
+	for i, object := range objects {
+		selected[i] = map[string]json.RawMessage{"id": object["id"]}
+		for _, field := range fields {
+			if value, ok := object[field]; ok {
+				selected[i][field] = value
+			}
+		}
+	}
+	return selected, nil
+}
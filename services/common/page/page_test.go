@@ -0,0 +1,153 @@
+package page
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type item struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name  string             `bson:"name" json:"name"`
+	Price float64            `bson:"price" json:"price"`
+}
+
+// list serves the items of c a page at a time
+func list(c *memdb.Collection) *fiber.App {
+	app := fiber.New()
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		q, err := Parse(ctx, []string{"name", "price"}, []string{"id", "name", "price"})
+		if err != nil {
+			return problem.Write(ctx, err)
+		}
+		total, err := c.CountDocuments(ctx.UserContext(), bson.M{})
+		if err != nil {
+			return problem.Write(ctx, err)
+		}
+		cursor, err := c.Find(ctx.UserContext(), q.Filter(bson.M{}), q.Options())
+		if err != nil {
+			return problem.Write(ctx, err)
+		}
+		var items []item
+		next, err := q.Collect(ctx.UserContext(), cursor, &items)
+		if err != nil {
+			return problem.Write(ctx, err)
+		}
+		return Write(ctx, q, items, Info{Total: total, NextCursor: next})
+	})
+	return app
+}
+
+func TestPages(t *testing.T) {
+	c := memdb.NewCollection()
+	for _, it := range []item{{Name: "b", Price: 20}, {Name: "a", Price: 30}, {Name: "c", Price: 20}, {Name: "d", Price: 10}, {Name: "e", Price: 20}} {
+		if _, err := c.InsertOne(context.Background(), item{ID: primitive.NewObjectID(), Name: it.Name, Price: it.Price}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := list(c)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"by id", "limit=2", "bacde"},
+		{"by name", "limit=2&sort=name", "abcde"},
+		{"by descending price", "limit=2&sort=-price", "aecbd"},
+		{"all at once", "limit=500&sort=price", "dbcea"},
+	}
+	for _, tt := range tests {
+		var names string
+		target := "/?" + tt.query
+		for pages := 0; target != ""; pages++ {
+			if pages > 5 {
+				t.Fatalf("%s: too many pages", tt.name)
+			}
+			res, body := testutil.Request(t, app, http.MethodGet, target, nil)
+			var p struct {
+				Items      []item `json:"items"`
+				Total      int64  `json:"total"`
+				NextCursor string `json:"next_cursor"`
+			}
+			if err := json.Unmarshal(body, &p); err != nil || res.StatusCode != http.StatusOK || p.Total != 5 {
+				t.Fatalf("%s: expected a page of 5 items, got %d: %s", tt.name, res.StatusCode, body)
+			}
+			for _, it := range p.Items {
+				names += it.Name
+			}
+			target = ""
+			if link := res.Header.Get("Link"); p.NextCursor != "" {
+				if !strings.HasPrefix(link, "<?") || !strings.HasSuffix(link, `>; rel="next"`) {
+					t.Fatalf("%s: unexpected Link %q", tt.name, link)
+				}
+				target = "/" + strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+		if names != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, names)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	c := memdb.NewCollection()
+	c.InsertOne(context.Background(), item{ID: primitive.NewObjectID(), Name: "a", Price: 10})
+	res, body := testutil.Request(t, list(c), http.MethodGet, "/?fields=name", nil)
+	var p struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil || res.StatusCode != http.StatusOK || len(p.Items) != 1 {
+		t.Fatalf("expected an item, got %d: %s", res.StatusCode, body)
+	}
+	if _, ok := p.Items[0]["price"]; ok || p.Items[0]["name"] != "a" || p.Items[0]["id"] == nil {
+		t.Errorf("expected the id and name only, got %v", p.Items[0])
+	}
+}
+
+func TestEmptyPage(t *testing.T) {
+	res, body := testutil.Request(t, list(memdb.NewCollection()), http.MethodGet, "/", nil)
+	if res.StatusCode != http.StatusOK || string(body) != `{"items":[],"total":0}` {
+		t.Errorf("expected an empty page, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	c := memdb.NewCollection()
+	c.InsertOne(context.Background(), item{ID: primitive.NewObjectID(), Name: "a"})
+	c.InsertOne(context.Background(), item{ID: primitive.NewObjectID(), Name: "b"})
+	app := list(c)
+	_, body := testutil.Request(t, app, http.MethodGet, "/?limit=1&sort=name", nil)
+	var first Page
+	json.Unmarshal(body, &first)
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"limit=0", "invalid_limit"},
+		{"limit=501", "invalid_limit"},
+		{"limit=ten", "invalid_limit"},
+		{"sort=version", "invalid_sort"},
+		{"fields=name,secret", "invalid_fields"},
+		{"after=%21%21", "invalid_cursor"},
+		{"after=" + first.NextCursor + "&sort=-name", "invalid_cursor"},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodGet, "/?"+tt.query, nil)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != http.StatusBadRequest || p.Code != tt.code {
+			t.Errorf("%s: expected 400 %s, got %d: %s", tt.query, tt.code, res.StatusCode, body)
+		}
+	}
+}
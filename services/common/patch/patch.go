@@ -0,0 +1,82 @@
+// Package patch implements JSON Merge Patch (RFC 7396) for the partial
+// updates of the services documents
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ContentType is the media type of merge patch documents
+const ContentType = "application/merge-patch+json"
+
+// IsMergePatch reports whether the body of the request is a merge patch,
+// plain JSON bodies are accepted as well
+func IsMergePatch(c *fiber.Ctx) bool {
+	mediaType, _, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	return err == nil && (mediaType == ContentType || mediaType == fiber.MIMEApplicationJSON)
+}
+
+// Merge applies the merge patch to the target JSON document and returns the
+// patched document
+func Merge(target, patch []byte) ([]byte, error) {
+	var t, p interface{}
+	if err := json.Unmarshal(target, &t); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(t, p))
+}
+
+// merge implements the MergePatch function of RFC 7396
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+// Apply applies the merge patch to the document v points to, the patch must
+// be a JSON object and the patched document must only contain the fields of
+// v. Fields removed by the patch are reset to their zero value.
+func Apply(v interface{}, patch []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return problem.Validation("invalid_patch", "the patch must be a JSON object")
+	}
+	target, err := json.Marshal(v)
+	if err != nil {
+		return problem.From(err)
+	}
+	merged, err := Merge(target, patch)
+	if err != nil {
+		return problem.Validation("invalid_patch", err.Error())
+	}
+	rv := reflect.ValueOf(v).Elem()
+	rv.Set(reflect.Zero(rv.Type()))
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return problem.Validation("invalid_patch", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+)
+
+// TestMerge runs the examples of RFC 7396 appendix A
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		target, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.target), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %s", tt.target, tt.patch, err)
+			continue
+		}
+		var g, e interface{}
+		json.Unmarshal(got, &g)
+		json.Unmarshal([]byte(tt.expected), &e)
+		if !reflect.DeepEqual(g, e) {
+			t.Errorf("%s + %s: expected %s, got %s", tt.target, tt.patch, tt.expected, got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	type doc struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+		Note  string  `json:"note,omitempty"`
+	}
+	tests := []struct {
+		name     string
+		patch    string
+		expected doc
+		code     string
+	}{
+		{"set", `{"price":2}`, doc{Name: "a", Price: 2, Note: "n"}, ""},
+		{"remove", `{"note":null}`, doc{Name: "a", Price: 1}, ""},
+		{"unknown field", `{"colour":"red"}`, doc{}, "invalid_patch"},
+		{"wrong type", `{"price":"free"}`, doc{}, "invalid_patch"},
+		{"not an object", `["price"]`, doc{}, "invalid_patch"},
+	}
+	for _, tt := range tests {
+		d := doc{Name: "a", Price: 1, Note: "n"}
+		err := Apply(&d, []byte(tt.patch))
+		if tt.code != "" {
+			if problem.From(err).Code != tt.code {
+				t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+			}
+			continue
+		}
+		if err != nil || d != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v (%v)", tt.name, tt.expected, d, err)
+		}
+	}
+}
@@ -29,6 +29,16 @@ type OrderServiceClient interface {
 	CreateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
 	UpdateOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
 	DeleteOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*Order, error)
+	CountOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error)
+	CountOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error)
+	DeleteOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error)
+	DeleteOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error)
+	RestoreOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error)
+	RestoreOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error)
+	ReassignOrdersByCustomer(ctx context.Context, in *Reassignment, opts ...grpc.CallOption) (*OrdersCount, error)
+	ReassignOrdersBySupplier(ctx context.Context, in *Reassignment, opts ...grpc.CallOption) (*OrdersCount, error)
+	SearchOrders(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*OrderHits, error)
+	GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Stats, error)
 }
 
 type orderServiceClient struct {
@@ -171,6 +181,96 @@ func (c *orderServiceClient) DeleteOrder(ctx context.Context, in *Order, opts ..
 	return out, nil
 }
 
+func (c *orderServiceClient) CountOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/CountOrdersByCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) CountOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/CountOrdersBySupplier", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) DeleteOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/DeleteOrdersByCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) DeleteOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/DeleteOrdersBySupplier", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) RestoreOrdersByCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/RestoreOrdersByCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) RestoreOrdersBySupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/RestoreOrdersBySupplier", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) ReassignOrdersByCustomer(ctx context.Context, in *Reassignment, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/ReassignOrdersByCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) ReassignOrdersBySupplier(ctx context.Context, in *Reassignment, opts ...grpc.CallOption) (*OrdersCount, error) {
+	out := new(OrdersCount)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/ReassignOrdersBySupplier", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) SearchOrders(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*OrderHits, error) {
+	out := new(OrderHits)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/SearchOrders", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderServiceClient) GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, "/pb.OrderService/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // OrderServiceServer is the server API for OrderService service.
 // All implementations must embed UnimplementedOrderServiceServer
 // for forward compatibility
@@ -182,6 +282,16 @@ type OrderServiceServer interface {
 	CreateOrder(context.Context, *Order) (*Order, error)
 	UpdateOrder(context.Context, *Order) (*Order, error)
 	DeleteOrder(context.Context, *Order) (*Order, error)
+	CountOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error)
+	CountOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error)
+	DeleteOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error)
+	DeleteOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error)
+	RestoreOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error)
+	RestoreOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error)
+	ReassignOrdersByCustomer(context.Context, *Reassignment) (*OrdersCount, error)
+	ReassignOrdersBySupplier(context.Context, *Reassignment) (*OrdersCount, error)
+	SearchOrders(context.Context, *SearchRequest) (*OrderHits, error)
+	GetStats(context.Context, *Empty) (*Stats, error)
 	mustEmbedUnimplementedOrderServiceServer()
 }
 
@@ -210,6 +320,36 @@ func (UnimplementedOrderServiceServer) UpdateOrder(context.Context, *Order) (*Or
 func (UnimplementedOrderServiceServer) DeleteOrder(context.Context, *Order) (*Order, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteOrder not implemented")
 }
+func (UnimplementedOrderServiceServer) CountOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountOrdersByCustomer not implemented")
+}
+func (UnimplementedOrderServiceServer) CountOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountOrdersBySupplier not implemented")
+}
+func (UnimplementedOrderServiceServer) DeleteOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteOrdersByCustomer not implemented")
+}
+func (UnimplementedOrderServiceServer) DeleteOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteOrdersBySupplier not implemented")
+}
+func (UnimplementedOrderServiceServer) RestoreOrdersByCustomer(context.Context, *Customer) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreOrdersByCustomer not implemented")
+}
+func (UnimplementedOrderServiceServer) RestoreOrdersBySupplier(context.Context, *Supplier) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreOrdersBySupplier not implemented")
+}
+func (UnimplementedOrderServiceServer) ReassignOrdersByCustomer(context.Context, *Reassignment) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReassignOrdersByCustomer not implemented")
+}
+func (UnimplementedOrderServiceServer) ReassignOrdersBySupplier(context.Context, *Reassignment) (*OrdersCount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReassignOrdersBySupplier not implemented")
+}
+func (UnimplementedOrderServiceServer) SearchOrders(context.Context, *SearchRequest) (*OrderHits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchOrders not implemented")
+}
+func (UnimplementedOrderServiceServer) GetStats(context.Context, *Empty) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
 func (UnimplementedOrderServiceServer) mustEmbedUnimplementedOrderServiceServer() {}
 
 // UnsafeOrderServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -358,6 +498,186 @@ func _OrderService_DeleteOrder_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OrderService_CountOrdersByCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Customer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).CountOrdersByCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/CountOrdersByCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).CountOrdersByCustomer(ctx, req.(*Customer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_CountOrdersBySupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Supplier)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).CountOrdersBySupplier(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/CountOrdersBySupplier",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).CountOrdersBySupplier(ctx, req.(*Supplier))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_DeleteOrdersByCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Customer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).DeleteOrdersByCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/DeleteOrdersByCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).DeleteOrdersByCustomer(ctx, req.(*Customer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_DeleteOrdersBySupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Supplier)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).DeleteOrdersBySupplier(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/DeleteOrdersBySupplier",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).DeleteOrdersBySupplier(ctx, req.(*Supplier))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_RestoreOrdersByCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Customer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).RestoreOrdersByCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/RestoreOrdersByCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).RestoreOrdersByCustomer(ctx, req.(*Customer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_RestoreOrdersBySupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Supplier)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).RestoreOrdersBySupplier(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/RestoreOrdersBySupplier",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).RestoreOrdersBySupplier(ctx, req.(*Supplier))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_ReassignOrdersByCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Reassignment)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).ReassignOrdersByCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/ReassignOrdersByCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).ReassignOrdersByCustomer(ctx, req.(*Reassignment))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_ReassignOrdersBySupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Reassignment)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).ReassignOrdersBySupplier(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/ReassignOrdersBySupplier",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).ReassignOrdersBySupplier(ctx, req.(*Reassignment))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_SearchOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).SearchOrders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/SearchOrders",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).SearchOrders(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderService_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderServiceServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.OrderService/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderServiceServer).GetStats(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // OrderService_ServiceDesc is the grpc.ServiceDesc for OrderService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -381,6 +701,46 @@ var OrderService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteOrder",
 			Handler:    _OrderService_DeleteOrder_Handler,
 		},
+		{
+			MethodName: "CountOrdersByCustomer",
+			Handler:    _OrderService_CountOrdersByCustomer_Handler,
+		},
+		{
+			MethodName: "CountOrdersBySupplier",
+			Handler:    _OrderService_CountOrdersBySupplier_Handler,
+		},
+		{
+			MethodName: "DeleteOrdersByCustomer",
+			Handler:    _OrderService_DeleteOrdersByCustomer_Handler,
+		},
+		{
+			MethodName: "DeleteOrdersBySupplier",
+			Handler:    _OrderService_DeleteOrdersBySupplier_Handler,
+		},
+		{
+			MethodName: "RestoreOrdersByCustomer",
+			Handler:    _OrderService_RestoreOrdersByCustomer_Handler,
+		},
+		{
+			MethodName: "RestoreOrdersBySupplier",
+			Handler:    _OrderService_RestoreOrdersBySupplier_Handler,
+		},
+		{
+			MethodName: "ReassignOrdersByCustomer",
+			Handler:    _OrderService_ReassignOrdersByCustomer_Handler,
+		},
+		{
+			MethodName: "ReassignOrdersBySupplier",
+			Handler:    _OrderService_ReassignOrdersBySupplier_Handler,
+		},
+		{
+			MethodName: "SearchOrders",
+			Handler:    _OrderService_SearchOrders_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _OrderService_GetStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -408,9 +768,12 @@ var OrderService_ServiceDesc = grpc.ServiceDesc{
 type SupplierServiceClient interface {
 	GetSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
 	GetAllSuppliers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (SupplierService_GetAllSuppliersClient, error)
+	BatchGetSuppliers(ctx context.Context, in *Ids, opts ...grpc.CallOption) (*Suppliers, error)
 	CreateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
 	UpdateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
 	DeleteSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error)
+	SearchSuppliers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SupplierHits, error)
+	CountSuppliers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Count, error)
 }
 
 type supplierServiceClient struct {
@@ -462,6 +825,15 @@ func (x *supplierServiceGetAllSuppliersClient) Recv() (*Supplier, error) {
 	return m, nil
 }
 
+func (c *supplierServiceClient) BatchGetSuppliers(ctx context.Context, in *Ids, opts ...grpc.CallOption) (*Suppliers, error) {
+	out := new(Suppliers)
+	err := c.cc.Invoke(ctx, "/pb.SupplierService/BatchGetSuppliers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *supplierServiceClient) CreateSupplier(ctx context.Context, in *Supplier, opts ...grpc.CallOption) (*Supplier, error) {
 	out := new(Supplier)
 	err := c.cc.Invoke(ctx, "/pb.SupplierService/CreateSupplier", in, out, opts...)
@@ -489,15 +861,36 @@ func (c *supplierServiceClient) DeleteSupplier(ctx context.Context, in *Supplier
 	return out, nil
 }
 
+func (c *supplierServiceClient) SearchSuppliers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SupplierHits, error) {
+	out := new(SupplierHits)
+	err := c.cc.Invoke(ctx, "/pb.SupplierService/SearchSuppliers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supplierServiceClient) CountSuppliers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Count, error) {
+	out := new(Count)
+	err := c.cc.Invoke(ctx, "/pb.SupplierService/CountSuppliers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SupplierServiceServer is the server API for SupplierService service.
 // All implementations must embed UnimplementedSupplierServiceServer
 // for forward compatibility
 type SupplierServiceServer interface {
 	GetSupplier(context.Context, *Supplier) (*Supplier, error)
 	GetAllSuppliers(*Empty, SupplierService_GetAllSuppliersServer) error
+	BatchGetSuppliers(context.Context, *Ids) (*Suppliers, error)
 	CreateSupplier(context.Context, *Supplier) (*Supplier, error)
 	UpdateSupplier(context.Context, *Supplier) (*Supplier, error)
 	DeleteSupplier(context.Context, *Supplier) (*Supplier, error)
+	SearchSuppliers(context.Context, *SearchRequest) (*SupplierHits, error)
+	CountSuppliers(context.Context, *Empty) (*Count, error)
 	mustEmbedUnimplementedSupplierServiceServer()
 }
 
@@ -511,6 +904,9 @@ func (UnimplementedSupplierServiceServer) GetSupplier(context.Context, *Supplier
 func (UnimplementedSupplierServiceServer) GetAllSuppliers(*Empty, SupplierService_GetAllSuppliersServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetAllSuppliers not implemented")
 }
+func (UnimplementedSupplierServiceServer) BatchGetSuppliers(context.Context, *Ids) (*Suppliers, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetSuppliers not implemented")
+}
 func (UnimplementedSupplierServiceServer) CreateSupplier(context.Context, *Supplier) (*Supplier, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateSupplier not implemented")
 }
@@ -520,6 +916,12 @@ func (UnimplementedSupplierServiceServer) UpdateSupplier(context.Context, *Suppl
 func (UnimplementedSupplierServiceServer) DeleteSupplier(context.Context, *Supplier) (*Supplier, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteSupplier not implemented")
 }
+func (UnimplementedSupplierServiceServer) SearchSuppliers(context.Context, *SearchRequest) (*SupplierHits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchSuppliers not implemented")
+}
+func (UnimplementedSupplierServiceServer) CountSuppliers(context.Context, *Empty) (*Count, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountSuppliers not implemented")
+}
 func (UnimplementedSupplierServiceServer) mustEmbedUnimplementedSupplierServiceServer() {}
 
 // UnsafeSupplierServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -572,6 +974,24 @@ func (x *supplierServiceGetAllSuppliersServer) Send(m *Supplier) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _SupplierService_BatchGetSuppliers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Ids)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupplierServiceServer).BatchGetSuppliers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.SupplierService/BatchGetSuppliers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupplierServiceServer).BatchGetSuppliers(ctx, req.(*Ids))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _SupplierService_CreateSupplier_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Supplier)
 	if err := dec(in); err != nil {
@@ -626,6 +1046,42 @@ func _SupplierService_DeleteSupplier_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SupplierService_SearchSuppliers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupplierServiceServer).SearchSuppliers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.SupplierService/SearchSuppliers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupplierServiceServer).SearchSuppliers(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SupplierService_CountSuppliers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupplierServiceServer).CountSuppliers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.SupplierService/CountSuppliers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupplierServiceServer).CountSuppliers(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SupplierService_ServiceDesc is the grpc.ServiceDesc for SupplierService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -637,6 +1093,10 @@ var SupplierService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetSupplier",
 			Handler:    _SupplierService_GetSupplier_Handler,
 		},
+		{
+			MethodName: "BatchGetSuppliers",
+			Handler:    _SupplierService_BatchGetSuppliers_Handler,
+		},
 		{
 			MethodName: "CreateSupplier",
 			Handler:    _SupplierService_CreateSupplier_Handler,
@@ -649,6 +1109,14 @@ var SupplierService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteSupplier",
 			Handler:    _SupplierService_DeleteSupplier_Handler,
 		},
+		{
+			MethodName: "SearchSuppliers",
+			Handler:    _SupplierService_SearchSuppliers_Handler,
+		},
+		{
+			MethodName: "CountSuppliers",
+			Handler:    _SupplierService_CountSuppliers_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -666,9 +1134,12 @@ var SupplierService_ServiceDesc = grpc.ServiceDesc{
 type CustomerServiceClient interface {
 	GetCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
 	GetAllCustomers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (CustomerService_GetAllCustomersClient, error)
+	BatchGetCustomers(ctx context.Context, in *Ids, opts ...grpc.CallOption) (*Customers, error)
 	CreateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
 	UpdateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
 	DeleteCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
+	SearchCustomers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*CustomerHits, error)
+	CountCustomers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Count, error)
 }
 
 type customerServiceClient struct {
@@ -720,6 +1191,15 @@ func (x *customerServiceGetAllCustomersClient) Recv() (*Customer, error) {
 	return m, nil
 }
 
+func (c *customerServiceClient) BatchGetCustomers(ctx context.Context, in *Ids, opts ...grpc.CallOption) (*Customers, error) {
+	out := new(Customers)
+	err := c.cc.Invoke(ctx, "/pb.CustomerService/BatchGetCustomers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *customerServiceClient) CreateCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
 	out := new(Customer)
 	err := c.cc.Invoke(ctx, "/pb.CustomerService/CreateCustomer", in, out, opts...)
@@ -747,15 +1227,36 @@ func (c *customerServiceClient) DeleteCustomer(ctx context.Context, in *Customer
 	return out, nil
 }
 
+func (c *customerServiceClient) SearchCustomers(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*CustomerHits, error) {
+	out := new(CustomerHits)
+	err := c.cc.Invoke(ctx, "/pb.CustomerService/SearchCustomers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *customerServiceClient) CountCustomers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Count, error) {
+	out := new(Count)
+	err := c.cc.Invoke(ctx, "/pb.CustomerService/CountCustomers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CustomerServiceServer is the server API for CustomerService service.
 // All implementations must embed UnimplementedCustomerServiceServer
 // for forward compatibility
 type CustomerServiceServer interface {
 	GetCustomer(context.Context, *Customer) (*Customer, error)
 	GetAllCustomers(*Empty, CustomerService_GetAllCustomersServer) error
+	BatchGetCustomers(context.Context, *Ids) (*Customers, error)
 	CreateCustomer(context.Context, *Customer) (*Customer, error)
 	UpdateCustomer(context.Context, *Customer) (*Customer, error)
 	DeleteCustomer(context.Context, *Customer) (*Customer, error)
+	SearchCustomers(context.Context, *SearchRequest) (*CustomerHits, error)
+	CountCustomers(context.Context, *Empty) (*Count, error)
 	mustEmbedUnimplementedCustomerServiceServer()
 }
 
@@ -769,6 +1270,9 @@ func (UnimplementedCustomerServiceServer) GetCustomer(context.Context, *Customer
 func (UnimplementedCustomerServiceServer) GetAllCustomers(*Empty, CustomerService_GetAllCustomersServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetAllCustomers not implemented")
 }
+func (UnimplementedCustomerServiceServer) BatchGetCustomers(context.Context, *Ids) (*Customers, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetCustomers not implemented")
+}
 func (UnimplementedCustomerServiceServer) CreateCustomer(context.Context, *Customer) (*Customer, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateCustomer not implemented")
 }
@@ -778,6 +1282,12 @@ func (UnimplementedCustomerServiceServer) UpdateCustomer(context.Context, *Custo
 func (UnimplementedCustomerServiceServer) DeleteCustomer(context.Context, *Customer) (*Customer, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteCustomer not implemented")
 }
+func (UnimplementedCustomerServiceServer) SearchCustomers(context.Context, *SearchRequest) (*CustomerHits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchCustomers not implemented")
+}
+func (UnimplementedCustomerServiceServer) CountCustomers(context.Context, *Empty) (*Count, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountCustomers not implemented")
+}
 func (UnimplementedCustomerServiceServer) mustEmbedUnimplementedCustomerServiceServer() {}
 
 // UnsafeCustomerServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -830,6 +1340,24 @@ func (x *customerServiceGetAllCustomersServer) Send(m *Customer) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CustomerService_BatchGetCustomers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Ids)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomerServiceServer).BatchGetCustomers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CustomerService/BatchGetCustomers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomerServiceServer).BatchGetCustomers(ctx, req.(*Ids))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _CustomerService_CreateCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Customer)
 	if err := dec(in); err != nil {
@@ -884,6 +1412,42 @@ func _CustomerService_DeleteCustomer_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CustomerService_SearchCustomers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomerServiceServer).SearchCustomers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CustomerService/SearchCustomers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomerServiceServer).SearchCustomers(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CustomerService_CountCustomers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomerServiceServer).CountCustomers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.CustomerService/CountCustomers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomerServiceServer).CountCustomers(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CustomerService_ServiceDesc is the grpc.ServiceDesc for CustomerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -895,6 +1459,10 @@ var CustomerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetCustomer",
 			Handler:    _CustomerService_GetCustomer_Handler,
 		},
+		{
+			MethodName: "BatchGetCustomers",
+			Handler:    _CustomerService_BatchGetCustomers_Handler,
+		},
 		{
 			MethodName: "CreateCustomer",
 			Handler:    _CustomerService_CreateCustomer_Handler,
@@ -907,6 +1475,14 @@ var CustomerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteCustomer",
 			Handler:    _CustomerService_DeleteCustomer_Handler,
 		},
+		{
+			MethodName: "SearchCustomers",
+			Handler:    _CustomerService_SearchCustomers_Handler,
+		},
+		{
+			MethodName: "CountCustomers",
+			Handler:    _CustomerService_CountCustomers_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,35 @@
+package problem
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor converts the errors returned by unary handlers into
+// the gRPC status of their domain error
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err != nil {
+		return nil, grpcError(info.FullMethod, err)
+	}
+	return res, nil
+}
+
+// StreamServerInterceptor converts the errors returned by stream handlers
+// into the gRPC status of their domain error
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := handler(srv, ss); err != nil {
+		return grpcError(info.FullMethod, err)
+	}
+	return nil
+}
+
+func grpcError(method string, err error) error {
+	e := From(err)
+	if e.Kind == KindInternal || e.Kind == KindUpstream {
+		log.Printf("%s: %s", method, err.Error())
+	}
+	return e.GRPCStatus().Err()
+}
@@ -0,0 +1,76 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ContentType is the media type of problem details documents
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document, extended with the stable
+// code of the error and the number of records involved
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	Count    int64  `json:"count,omitempty"`
+}
+
+// typeURI returns the problem type identifying code
+func typeURI(code string) string {
+	return "urn:pdash:problem:" + code
+}
+
+// Write responds to c with the problem details of err, internal errors are
+// logged since their cause is not part of the response
+func Write(c *fiber.Ctx, err error) error {
+	e := From(err)
+	if e.Kind == KindInternal || e.Kind == KindUpstream {
+		log.Printf("%s %s: %s", c.Method(), c.Path(), err.Error())
+	}
+	return write(c, Problem{
+		Type:     typeURI(e.Code),
+		Title:    http.StatusText(e.Status()),
+		Status:   e.Status(),
+		Detail:   e.Detail,
+		Instance: c.OriginalURL(),
+		Code:     e.Code,
+		Count:    e.Count,
+	})
+}
+
+// ErrorHandler is a fiber error handler writing problem details, errors
+// raised by fiber itself keep their status
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if !errors.As(err, &fe) {
+		return Write(c, err)
+	}
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(fe.Code)), " ", "_")
+	return write(c, Problem{
+		Type:     typeURI(code),
+		Title:    http.StatusText(fe.Code),
+		Status:   fe.Code,
+		Detail:   fe.Message,
+		Instance: c.OriginalURL(),
+		Code:     code,
+	})
+}
+
+func write(c *fiber.Ctx, p Problem) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, ContentType)
+	return c.Status(p.Status).Send(b)
+}
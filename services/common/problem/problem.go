@@ -0,0 +1,220 @@
+// Package problem defines the domain errors shared by the services and maps
+// them consistently to RFC 7807 problem details and gRPC statuses
+package problem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain is the ErrorInfo domain of the gRPC errors returned by the services
+const Domain = "pdash"
+
+// Kind classifies domain errors
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnauthorized
+	KindUpstream
+	KindTooManyRequests
+	KindPreconditionFailed
+	KindPreconditionRequired
+	KindReferenced
+)
+
+// kinds maps every Kind to its HTTP status, gRPC code and default error code
+var kinds = map[Kind]struct {
+	status int
+	code   codes.Code
+	name   string
+}{
+	KindInternal:             {http.StatusInternalServerError, codes.Internal, "internal"},
+	KindNotFound:             {http.StatusNotFound, codes.NotFound, "not_found"},
+	KindConflict:             {http.StatusConflict, codes.AlreadyExists, "conflict"},
+	KindValidation:           {http.StatusBadRequest, codes.InvalidArgument, "validation_failed"},
+	KindUnauthorized:         {http.StatusUnauthorized, codes.Unauthenticated, "unauthorized"},
+	KindUpstream:             {http.StatusServiceUnavailable, codes.Unavailable, "upstream_unavailable"},
+	KindTooManyRequests:      {http.StatusTooManyRequests, codes.ResourceExhausted, "rate_limited"},
+	KindPreconditionFailed:   {http.StatusPreconditionFailed, codes.FailedPrecondition, "precondition_failed"},
+	KindPreconditionRequired: {http.StatusPreconditionRequired, codes.FailedPrecondition, "precondition_required"},
+	KindReferenced:           {http.StatusConflict, codes.FailedPrecondition, "referenced"},
+}
+
+// Error is a domain error with a stable machine readable code, the wrapped
+// error is kept for logging and never exposed to clients
+type Error struct {
+	Kind   Kind
+	Code   string
+	Detail string
+	// Count is the number of records involved, e.g. the orders preventing a
+	// delete, 0 when not relevant
+	Count int64
+	Err   error
+}
+
+// NotFound returns an error for a missing resource
+func NotFound(code, detail string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Detail: detail}
+}
+
+// Conflict returns an error for a request conflicting with existing state
+func Conflict(code, detail string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Detail: detail}
+}
+
+// Validation returns an error for an invalid request
+func Validation(code, detail string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Detail: detail}
+}
+
+// Unauthorized returns an error for an unauthenticated caller
+func Unauthorized(code, detail string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Detail: detail}
+}
+
+// Upstream returns an error for a dependency that failed or timed out
+func Upstream(code, detail string, err error) *Error {
+	return &Error{Kind: KindUpstream, Code: code, Detail: detail, Err: err}
+}
+
+// TooManyRequests returns an error for a caller exceeding its rate limit
+func TooManyRequests(code, detail string) *Error {
+	return &Error{Kind: KindTooManyRequests, Code: code, Detail: detail}
+}
+
+// PreconditionFailed returns an error for a request whose precondition, e.g.
+// an If-Match header, does not hold
+func PreconditionFailed(code, detail string) *Error {
+	return &Error{Kind: KindPreconditionFailed, Code: code, Detail: detail}
+}
+
+// PreconditionRequired returns an error for a request missing a required
+// precondition
+func PreconditionRequired(code, detail string) *Error {
+	return &Error{Kind: KindPreconditionRequired, Code: code, Detail: detail}
+}
+
+// Referenced returns an error for a record that cannot change while other
+// records reference it, e.g. a customer with orders
+func Referenced(code, detail string) *Error {
+	return &Error{Kind: KindReferenced, Code: code, Detail: detail}
+}
+
+// Internal returns an error for an unexpected failure
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: kinds[KindInternal].name, Detail: "internal server error", Err: err}
+}
+
+// WithCount sets the number of records involved in the error
+func (e *Error) WithCount(count int64) *Error {
+	e.Count = count
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error
+func (e *Error) Status() int {
+	return kinds[e.Kind].status
+}
+
+// GRPCStatus returns the gRPC status of the error, carrying its code as the
+// reason of an ErrorInfo detail, along with its kind, since kinds can share
+// a gRPC code, and its count
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(kinds[e.Kind].code, e.Detail)
+	info := &errdetails.ErrorInfo{Reason: e.Code, Domain: Domain, Metadata: map[string]string{"kind": kinds[e.Kind].name}}
+	if e.Count != 0 {
+		info.Metadata["count"] = strconv.FormatInt(e.Count, 10)
+	}
+	if withDetails, err := st.WithDetails(info); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// From returns err as a domain error: domain errors are returned as is, gRPC
+// errors are converted back using their code and ErrorInfo detail, timeouts
+// become upstream errors and anything else is internal
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return Upstream("timeout", "request timed out", err)
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return Internal(err)
+	}
+	kind := kindOf(st.Code())
+	e = &Error{Kind: kind, Code: kinds[kind].name, Detail: st.Message(), Err: err}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == Domain {
+			e.Code = info.Reason
+			e.Count, _ = strconv.ParseInt(info.Metadata["count"], 10, 64)
+			if k, ok := kindNamed(info.Metadata["kind"]); ok {
+				e.Kind = k
+			}
+		}
+	}
+	if e.Kind == KindInternal {
+		e.Detail = "internal server error"
+	}
+	return e
+}
+
+// kindNamed returns the Kind whose default error code is name
+func kindNamed(name string) (Kind, bool) {
+	for kind, k := range kinds {
+		if k.name == name {
+			return kind, true
+		}
+	}
+	return KindInternal, false
+}
+
+// kindOf returns the Kind of a gRPC code
+func kindOf(code codes.Code) Kind {
+	switch code {
+	case codes.NotFound:
+		return KindNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return KindConflict
+	case codes.FailedPrecondition:
+		return KindPreconditionFailed
+	case codes.InvalidArgument, codes.OutOfRange:
+		return KindValidation
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return KindUnauthorized
+	case codes.ResourceExhausted:
+		return KindTooManyRequests
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return KindUpstream
+	default:
+		return KindInternal
+	}
+}
@@ -0,0 +1,79 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		kind   Kind
+		code   string
+		detail string
+	}{
+		{"domain error", NotFound("customer_not_found", "customer not found"), KindNotFound, "customer_not_found", "customer not found"},
+		{"grpc domain error", Conflict("username_taken", "user already exists").GRPCStatus().Err(), KindConflict, "username_taken", "user already exists"},
+		{"grpc error", status.Error(codes.Unavailable, "connection refused"), KindUpstream, "upstream_unavailable", "connection refused"},
+		{"grpc rate limit error", TooManyRequests("rate_limited", "rate limit exceeded").GRPCStatus().Err(), KindTooManyRequests, "rate_limited", "rate limit exceeded"},
+		{"grpc internal error", Internal(errors.New("mongo: boom")).GRPCStatus().Err(), KindInternal, "internal", "internal server error"},
+		{"timeout", context.DeadlineExceeded, KindUpstream, "timeout", "request timed out"},
+		{"unexpected error", errors.New("mongo: no documents in result"), KindInternal, "internal", "internal server error"},
+	}
+	for _, tt := range tests {
+		e := From(tt.err)
+		if e.Kind != tt.kind || e.Code != tt.code || e.Detail != tt.detail {
+			t.Errorf("%s: expected %d %s %q, got %d %s %q", tt.name, tt.kind, tt.code, tt.detail, e.Kind, e.Code, e.Detail)
+		}
+	}
+}
+
+func TestCountRoundTrip(t *testing.T) {
+	err := Referenced("customer_has_orders", "customer is referenced by 3 orders").WithCount(3).GRPCStatus().Err()
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+	e := From(err)
+	if e.Kind != KindReferenced || e.Status() != http.StatusConflict || e.Code != "customer_has_orders" || e.Count != 3 {
+		t.Fatalf("expected the conflict with its count, got %+v", e)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/customers/:id", func(c *fiber.Ctx) error {
+		return Write(c, Validation("invalid_id", "invalid customer id"))
+	})
+	app.Get("/internal", func(c *fiber.Ctx) error {
+		return Write(c, errors.New("mongo: no documents in result"))
+	})
+
+	tests := []struct {
+		target   string
+		expected Problem
+	}{
+		{"/customers/nope", Problem{Type: "urn:pdash:problem:invalid_id", Title: "Bad Request", Status: http.StatusBadRequest, Detail: "invalid customer id", Instance: "/customers/nope", Code: "invalid_id"}},
+		{"/internal", Problem{Type: "urn:pdash:problem:internal", Title: "Internal Server Error", Status: http.StatusInternalServerError, Detail: "internal server error", Instance: "/internal", Code: "internal"}},
+		{"/unknown", Problem{Type: "urn:pdash:problem:not_found", Title: "Not Found", Status: http.StatusNotFound, Detail: "Cannot GET /unknown", Instance: "/unknown", Code: "not_found"}},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodGet, tt.target, nil)
+		if res.StatusCode != tt.expected.Status || res.Header.Get("Content-Type") != ContentType {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.target, tt.expected.Status, ContentType, res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		var p Problem
+		json.Unmarshal(body, &p)
+		if p != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.target, tt.expected, p)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package ratelimit implements a sliding window rate limiter shared through
+// Redis, so every replica of a service enforces the same limits
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rule allows Limit requests per Window
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Rules maps routes to their Rule, a route is a path prefix optionally
+// preceded by a method, e.g. "POST /orders" or "/users/login"
+type Rules map[string]Rule
+
+// ParseRules parses comma separated rules of the form route=limit/window,
+// e.g. "POST /orders=60/1m,/users/login=10/1m"
+func ParseRules(s string) (Rules, error) {
+	rules := Rules{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		i := strings.LastIndex(field, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rate limit rule %q", field)
+		}
+		route, value := strings.TrimSpace(field[:i]), field[i+1:]
+		limit, window, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit rule %q", field)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit in rate limit rule %q", field)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid window in rate limit rule %q", field)
+		}
+		rules[route] = Rule{Limit: n, Window: d}
+	}
+	return rules, nil
+}
+
+// Config configures the rate limiter middleware
+type Config struct {
+	// Default applies to the routes without a rule of their own
+	Default Rule
+	// Routes overrides Default for specific routes
+	Routes Rules
+	// Timeout bounds the Redis call made for every request
+	Timeout time.Duration
+	// Key identifies the caller, it defaults to Identity
+	Key func(c *fiber.Ctx) string
+	// Now returns the current time, it defaults to time.Now
+	Now func() time.Time
+}
+
+// Identity identifies authenticated callers by their username and anonymous
+// ones by their IP address
+func Identity(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(string); ok && user != "" {
+		return "user:" + user
+	}
+	return "ip:" + c.IP()
+}
+
+// script records a request in the sliding window log stored at KEYS[1] if
+// fewer than ARGV[3] requests were recorded in the last ARGV[2]
+// milliseconds, it returns whether the request is allowed, the remaining
+// requests and the milliseconds until the oldest request leaves the window
+var script = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+// New returns a middleware limiting the requests of every caller per route
+// and setting the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, rejected requests get a 429 with a Retry-After header. Requests
+// are let through when Redis cannot be reached.
+func New(rdb *redis.Client, config Config) fiber.Handler {
+	if config.Key == nil {
+		config.Key = Identity
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	routes := make([]string, 0, len(config.Routes))
+	for route := range config.Routes {
+		routes = append(routes, route)
+	}
+	// the longest routes are the most specific ones
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i]) > len(routes[j]) })
+
+	return func(c *fiber.Ctx) error {
+		route, rule := match(c, routes, config)
+		if rule.Limit <= 0 {
+			return c.Next()
+		}
+		ctx, cancel := middleware.WithTimeout(c.UserContext(), config.Timeout)
+		defer cancel()
+		now := config.Now().UnixMilli()
+		key := "ratelimit:" + route + ":" + config.Key(c)
+		member := strconv.FormatInt(now, 10) + "-" + strconv.FormatInt(rand.Int63(), 36)
+		res, err := script.Run(ctx, rdb, []string{key}, now, rule.Window.Milliseconds(), rule.Limit, member).Int64Slice()
+		if err != nil {
+			log.Printf("rate limiter unavailable: %s", err.Error())
+			return c.Next()
+		}
+		allowed, remaining, reset := res[0] == 1, res[1], seconds(res[2])
+		c.Set("RateLimit-Limit", strconv.Itoa(rule.Limit))
+		c.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		c.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(reset, 10))
+			return problem.Write(c, problem.TooManyRequests("rate_limited", fmt.Sprintf("rate limit of %d requests per %s exceeded", rule.Limit, rule.Window)))
+		}
+		return c.Next()
+	}
+}
+
+// match returns the most specific route matching the request and its rule,
+// falling back to the default rule
+func match(c *fiber.Ctx, routes []string, config Config) (string, Rule) {
+	path := c.Path()
+	for _, route := range routes {
+		prefix := route
+		if method, p, ok := strings.Cut(route, " "); ok {
+			if method != c.Method() {
+				continue
+			}
+			prefix = p
+		}
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return route, config.Routes[route]
+		}
+	}
+	return "default", config.Default
+}
+
+// seconds rounds milliseconds up to whole seconds
+func seconds(ms int64) int64 {
+	return int64(math.Ceil(float64(ms) / 1000))
+}
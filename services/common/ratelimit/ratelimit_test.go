@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("POST /orders=60/1m, /users/login=10/30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Rules{
+		"POST /orders": {Limit: 60, Window: time.Minute},
+		"/users/login": {Limit: 10, Window: 30 * time.Second},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, rules)
+	}
+	for route, rule := range expected {
+		if rules[route] != rule {
+			t.Errorf("%s: expected %v, got %v", route, rule, rules[route])
+		}
+	}
+	for _, invalid := range []string{"/orders", "/orders=10", "/orders=ten/1m", "/orders=10/soon", "/orders=0/1m"} {
+		if _, err := ParseRules(invalid); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	now := time.Unix(1700000000, 0)
+	app := fiber.New(fiber.Config{ErrorHandler: problem.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		if user := c.Get("X-Test-User"); user != "" {
+			c.Locals("user", user)
+		}
+		return c.Next()
+	})
+	app.Use(New(rdb, Config{
+		Default: Rule{Limit: 2, Window: time.Minute},
+		Routes:  Rules{"POST /users/login": {Limit: 1, Window: 10 * time.Second}},
+		Timeout: time.Second,
+		Now:     func() time.Time { return now },
+	}))
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		method    string
+		target    string
+		user      string
+		expected  int
+		remaining string
+		reset     string
+	}{
+		{"first request", 0, http.MethodGet, "/orders", "omar", http.StatusOK, "1", "60"},
+		{"second request", 20 * time.Second, http.MethodGet, "/customers", "omar", http.StatusOK, "0", "40"},
+		{"limit reached", 10 * time.Second, http.MethodGet, "/orders", "omar", http.StatusTooManyRequests, "0", "30"},
+		{"other user", 0, http.MethodGet, "/orders", "ali", http.StatusOK, "1", "60"},
+		{"oldest request left the window", 30 * time.Second, http.MethodGet, "/orders", "omar", http.StatusOK, "0", "20"},
+		{"anonymous route", 0, http.MethodPost, "/users/login", "", http.StatusOK, "0", "10"},
+		{"anonymous route limit reached", 5 * time.Second, http.MethodPost, "/users/login", "", http.StatusTooManyRequests, "0", "5"},
+		{"route rule is per method", 0, http.MethodGet, "/users/login", "", http.StatusOK, "1", "60"},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		res, body := testutil.Request(t, app, tt.method, tt.target, nil, "X-Test-User", tt.user)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+			continue
+		}
+		if got := res.Header.Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("%s: expected %s remaining, got %s", tt.name, tt.remaining, got)
+		}
+		if got := res.Header.Get("RateLimit-Reset"); got != tt.reset {
+			t.Errorf("%s: expected reset in %s, got %s", tt.name, tt.reset, got)
+		}
+		if res.StatusCode == http.StatusTooManyRequests && res.Header.Get(fiber.HeaderRetryAfter) != tt.reset {
+			t.Errorf("%s: expected Retry-After %s, got %s", tt.name, tt.reset, res.Header.Get(fiber.HeaderRetryAfter))
+		}
+	}
+
+	// requests are let through when Redis is down
+	mr.Close()
+	if res, body := testutil.Request(t, app, http.MethodGet, "/orders", nil, "X-Test-User", "omar"); res.StatusCode != http.StatusOK {
+		t.Errorf("redis down: expected %d, got %d: %s", http.StatusOK, res.StatusCode, body)
+	}
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Different states a Breaker can be in
+const (
+	StateClosed = iota
+	StateOpen
+	StateHalfOpen
+)
+
+// Breaker is a consecutive failures circuit breaker
+type Breaker struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	probe     uint64
+	probes    uint64
+	probedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+// Call is a call allowed through by a Breaker, its outcome is given back to
+// Record
+type Call struct {
+	// probe identifies the half-open probe, 0 for the other calls
+	probe uint64
+}
+
+// NewBreaker creates a new Breaker that opens after threshold consecutive
+// failures and lets a single probe call through once cooldown has elapsed
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a call may go through
+func (b *Breaker) Allow() (Call, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return Call{}, false
+		}
+		b.state = StateHalfOpen
+		return b.startProbe(), true
+	case StateHalfOpen:
+		// only one probe call at a time, a probe whose outcome never comes,
+		// e.g. an abandoned stream, is replaced after the cooldown
+		if b.probe != 0 && time.Since(b.probedAt) < b.cooldown {
+			return Call{}, false
+		}
+		return b.startProbe(), true
+	default:
+		return Call{}, true
+	}
+}
+
+func (b *Breaker) startProbe() Call {
+	b.probes++
+	b.probe = b.probes
+	b.probedAt = time.Now()
+	return Call{probe: b.probe}
+}
+
+// Record records the outcome of a call that was allowed through, once the
+// circuit is open only the outcome of the probe counts
+func (b *Breaker) Record(call Call, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if call.probe != 0 {
+		if call.probe != b.probe {
+			// replaced by a later probe
+			return
+		}
+		b.probe = 0
+	} else if b.state != StateClosed {
+		return
+	}
+	if success {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isFailure reports whether a status code means the upstream itself is
+// unhealthy, as opposed to an application level error like NotFound
+func isFailure(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
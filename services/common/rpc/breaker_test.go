@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	b := NewBreaker(2, 20*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		call, ok := b.Allow()
+		if !ok {
+			t.Fatalf("call %d should be allowed", i)
+		}
+		b.Record(call, false)
+	}
+	if _, ok := b.Allow(); b.State() != StateOpen || ok {
+		t.Fatal("breaker should be open")
+	}
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("probe call should be allowed after cooldown")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("only one probe call should be allowed")
+	}
+	b.Record(probe, true)
+	if _, ok := b.Allow(); b.State() != StateClosed || !ok {
+		t.Fatal("breaker should be closed after a successful probe")
+	}
+}
+
+func TestBreakerOnlyCountsTheProbe(t *testing.T) {
+	b := NewBreaker(1, 20*time.Millisecond)
+	slow, _ := b.Allow()
+	failed, _ := b.Allow()
+	b.Record(failed, false)
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("probe call should be allowed after cooldown")
+	}
+	// a call allowed before the circuit opened ends while the probe is in flight
+	b.Record(slow, true)
+	if _, ok := b.Allow(); ok || b.State() != StateHalfOpen {
+		t.Fatal("the outcome of another call should not let a second probe through")
+	}
+	b.Record(probe, false)
+	if b.State() != StateOpen {
+		t.Fatal("breaker should open again after a failed probe")
+	}
+
+	// a probe whose outcome never comes is replaced after the cooldown
+	time.Sleep(30 * time.Millisecond)
+	abandoned, _ := b.Allow()
+	time.Sleep(30 * time.Millisecond)
+	probe, ok = b.Allow()
+	if !ok {
+		t.Fatal("an abandoned probe should be replaced after the cooldown")
+	}
+	b.Record(abandoned, false)
+	if b.State() != StateHalfOpen {
+		t.Fatal("the outcome of a replaced probe should be ignored")
+	}
+	b.Record(probe, true)
+	if b.State() != StateClosed {
+		t.Fatal("breaker should be closed after a successful probe")
+	}
+}
+
+// failingStream fails after it was created
+type failingStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s failingStream) RecvMsg(m interface{}) error {
+	return s.err
+}
+
+func TestBreakerRecordsStreamStatus(t *testing.T) {
+	b := NewBreaker(1, time.Minute)
+	interceptor := breakerStreamInterceptor(b)
+	open := func(err error) grpc.ClientStream {
+		t.Helper()
+		stream, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/pb.OrderService/GetAllOrders",
+			func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return failingStream{err: err}, nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stream
+	}
+
+	open(io.EOF).RecvMsg(nil)
+	if b.State() != StateClosed {
+		t.Fatal("a stream ending normally should not open the circuit")
+	}
+	stream := open(status.Error(codes.Unavailable, "connection reset"))
+	if b.State() != StateClosed {
+		t.Fatal("the stream should only be recorded once it ends")
+	}
+	stream.RecvMsg(nil)
+	if b.State() != StateOpen {
+		t.Fatal("a stream failing midway should open the circuit")
+	}
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Options configures the connection to an upstream gRPC server
+type Options struct {
+	// Service is the fully qualified gRPC service name, e.g. pb.AuthService
+	Service string
+	// Idempotent lists the methods of Service that are safe to retry
+	Idempotent []string
+	// Timeout is the deadline applied to unary calls without one
+	Timeout time.Duration
+	// MaxAttempts is the maximum number of attempts for idempotent calls
+	MaxAttempts int
+	// BreakerThreshold is the number of consecutive failures that opens the circuit
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit stays open before probing again
+	BreakerCooldown time.Duration
+}
+
+// DefaultOptions returns the default Options for the given service
+func DefaultOptions(service string, idempotent ...string) Options {
+	return Options{
+		Service:          service,
+		Idempotent:       idempotent,
+		Timeout:          3 * time.Second,
+		MaxAttempts:      3,
+		BreakerThreshold: 5,
+		BreakerCooldown:  10 * time.Second,
+	}
+}
+
+// ErrCircuitOpen is returned when calls are rejected by an open circuit breaker
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// Dial creates a long lived client connection to target. The connection is
+// established lazily and reconnects on its own, so it should be created once
+// and shared by every caller.
+func Dial(target string, opts Options, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	serviceConfig, err := serviceConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	breaker := NewBreaker(opts.BreakerThreshold, opts.BreakerCooldown)
+	dialOpts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithChainUnaryInterceptor(
+			breakerUnaryInterceptor(breaker),
+			timeoutUnaryInterceptor(opts.Timeout),
+		),
+		grpc.WithChainStreamInterceptor(breakerStreamInterceptor(breaker)),
+	}, dialOpts...)
+	return grpc.Dial(target, dialOpts...)
+}
+
+// serviceConfig builds the gRPC service config holding the retry policy
+// for the idempotent methods of opts.Service
+func serviceConfig(opts Options) (string, error) {
+	type name struct {
+		Service string `json:"service"`
+		Method  string `json:"method"`
+	}
+	type retryPolicy struct {
+		MaxAttempts          int      `json:"maxAttempts"`
+		InitialBackoff       string   `json:"initialBackoff"`
+		MaxBackoff           string   `json:"maxBackoff"`
+		BackoffMultiplier    float64  `json:"backoffMultiplier"`
+		RetryableStatusCodes []string `json:"retryableStatusCodes"`
+	}
+	type methodConfig struct {
+		Name        []name       `json:"name"`
+		RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+	}
+	config := struct {
+		MethodConfig []methodConfig `json:"methodConfig"`
+	}{}
+	if opts.MaxAttempts > 1 && len(opts.Idempotent) > 0 {
+		mc := methodConfig{
+			RetryPolicy: &retryPolicy{
+				MaxAttempts:          opts.MaxAttempts,
+				InitialBackoff:       "0.1s",
+				MaxBackoff:           "1s",
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: []string{"UNAVAILABLE"},
+			},
+		}
+		for _, method := range opts.Idempotent {
+			mc.Name = append(mc.Name, name{Service: opts.Service, Method: method})
+		}
+		config.MethodConfig = append(config.MethodConfig, mc)
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("invalid service config: %w", err)
+	}
+	return string(b), nil
+}
+
+// timeoutUnaryInterceptor applies timeout to calls whose context has no deadline
+func timeoutUnaryInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// breakerUnaryInterceptor rejects calls while the circuit is open
+func breakerUnaryInterceptor(breaker *Breaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		call, ok := breaker.Allow()
+		if !ok {
+			return ErrCircuitOpen
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		breaker.Record(call, !isFailure(status.Code(err)))
+		return err
+	}
+}
+
+// breakerStreamInterceptor rejects new streams while the circuit is open, the
+// outcome of a stream is its final status
+func breakerStreamInterceptor(breaker *Breaker) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		call, ok := breaker.Allow()
+		if !ok {
+			return nil, ErrCircuitOpen
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			breaker.Record(call, !isFailure(status.Code(err)))
+			return nil, err
+		}
+		return &breakerStream{ClientStream: stream, breaker: breaker, call: call}, nil
+	}
+}
+
+// breakerStream records the outcome of a stream once it ends
+type breakerStream struct {
+	grpc.ClientStream
+	breaker *Breaker
+	call    Call
+	once    sync.Once
+}
+
+func (s *breakerStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	// io.EOF means the stream ended, its status is returned by RecvMsg
+	if err != nil && err != io.EOF {
+		s.end(err)
+	}
+	return err
+}
+
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.end(err)
+	}
+	return err
+}
+
+func (s *breakerStream) end(err error) {
+	s.once.Do(func() {
+		s.breaker.Record(s.call, err == io.EOF || !isFailure(status.Code(err)))
+	})
+}
@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Pipe is an in-memory listener, services running in the same process talk
+// to each other through pipes without going through the network
+type Pipe struct {
+	lis *bufconn.Listener
+}
+
+// NewPipe creates a Pipe, connections can be dialed before a server serves
+// it since they are established lazily
+func NewPipe() *Pipe {
+	return &Pipe{lis: bufconn.Listen(1024 * 1024)}
+}
+
+// Serve serves server on p in the background
+func (p *Pipe) Serve(server *grpc.Server) {
+	go server.Serve(p.lis)
+}
+
+// Dial returns a client connection to the server of p configured like Dial
+func (p *Pipe) Dial(opts Options) (*grpc.ClientConn, error) {
+	return Dial("bufnet", opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return p.lis.DialContext(ctx)
+	}))
+}
+
+// DialInProcess serves server on a new Pipe and returns a client connection
+// to it configured like Dial
+func DialInProcess(server *grpc.Server, opts Options) (*grpc.ClientConn, error) {
+	p := NewPipe()
+	p.Serve(server)
+	return p.Dial(opts)
+}
@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenKey is the metadata key carrying the bearer token of the caller on
+// whose behalf a service calls another one, the token is verified by the
+// called service so the caller cannot be impersonated
+const tokenKey = "authorization"
+
+// userKey is the context key of the username verified by Authenticate
+type userKey struct{}
+
+// WithToken returns a copy of ctx whose outgoing calls carry the bearer token
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, tokenKey, "Bearer "+token)
+}
+
+// token returns the bearer token carried by an incoming call, empty when
+// there is none
+func token(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(tokenKey) {
+		if fields := strings.Fields(value); len(fields) == 2 && fields[0] == "Bearer" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// Authenticate verifies the bearer token of the incoming calls with the auth
+// service, the username it was issued to is then returned by User and the
+// token is forwarded to the calls made on behalf of the caller. Calls to the
+// methods listed in mutating are rejected without a valid token, the others
+// are served anonymously.
+func Authenticate(client pb.AuthServiceClient, mutating ...string) grpc.UnaryServerInterceptor {
+	required := make(map[string]bool, len(mutating))
+	for _, method := range mutating {
+		required[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		t := token(ctx)
+		if t == "" {
+			if required[path.Base(info.FullMethod)] {
+				return nil, status.Error(codes.Unauthenticated, "a bearer token is required")
+			}
+			return handler(ctx, req)
+		}
+		auth, err := client.VerifyToken(ctx, &pb.Auth{AccessToken: t})
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(WithToken(ctx, t), userKey{}, auth.GetUsername())
+		return handler(ctx, req)
+	}
+}
+
+// User returns the username verified by Authenticate for an incoming call,
+// empty when the call carried no token
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticate(t *testing.T) {
+	_, authClient := testutil.Auth(t)
+	interceptor := Authenticate(authClient, "DeleteCustomer")
+	tests := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		user   string
+		code   codes.Code
+	}{
+		{"verified caller", "/pb.CustomerService/DeleteCustomer", metadata.Pairs(tokenKey, "Bearer "+testutil.Token), testutil.User, codes.OK},
+		{"invalid token", "/pb.CustomerService/GetCustomer", metadata.Pairs(tokenKey, "Bearer nope"), "", codes.Unauthenticated},
+		{"forged user", "/pb.CustomerService/DeleteCustomer", metadata.Pairs("x-user", "mallory"), "", codes.Unauthenticated},
+		{"anonymous read", "/pb.CustomerService/GetCustomer", metadata.MD{}, "", codes.OK},
+	}
+	for _, tt := range tests {
+		var user, forwarded string
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			user = User(ctx)
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if values := md.Get(tokenKey); len(values) > 0 {
+				forwarded = values[0]
+			}
+			return nil, nil
+		})
+		if status.Code(err) != tt.code || user != tt.user {
+			t.Errorf("%s: expected %s %q, got %v %q", tt.name, tt.code, tt.user, err, user)
+		}
+		if tt.user != "" && forwarded != "Bearer "+testutil.Token {
+			t.Errorf("%s: expected the token to be forwarded, got %q", tt.name, forwarded)
+		}
+	}
+}
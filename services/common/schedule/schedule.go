@@ -0,0 +1,29 @@
+// Package schedule runs the periodic jobs of the services
+package schedule
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs job every interval until ctx is done, failures are logged and
+// the job runs again at the next tick
+func Every(ctx context.Context, interval time.Duration, name string, job func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("%s disabled", name)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := job(ctx); err != nil {
+				log.Printf("%s failed: %s", name, err.Error())
+			}
+		}
+	}
+}
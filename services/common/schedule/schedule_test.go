@@ -0,0 +1,32 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs int32
+	done := make(chan struct{})
+	go func() {
+		Every(ctx, time.Millisecond, "test job", func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) == 3 {
+				cancel()
+			}
+			return errors.New("failures do not stop the job")
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop with its context")
+	}
+	if n := atomic.LoadInt32(&runs); n < 3 {
+		t.Fatalf("expected at least 3 runs, got %d", n)
+	}
+}
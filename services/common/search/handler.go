@@ -0,0 +1,84 @@
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultLimit is the number of hits of each kind of a combined search
+	// when no limit is given
+	DefaultLimit = 10
+	// MaxLimit is the largest number of hits of each kind of a combined search
+	MaxLimit = 50
+)
+
+// Results are the hits of a combined search by kind, ranked
+type Results struct {
+	Customers []*pb.CustomerHit `json:"customers"`
+	Suppliers []*pb.SupplierHit `json:"suppliers"`
+	Orders    []*pb.OrderHit    `json:"orders"`
+}
+
+// Handler searches customers, suppliers and orders at once, the services
+// are searched concurrently and the search fails when any of them does
+func Handler(customers pb.CustomerServiceClient, suppliers pb.SupplierServiceClient, orders pb.OrderServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q := strings.TrimSpace(c.Query("q"))
+		if err := Validate(q); err != nil {
+			return problem.Write(c, err)
+		}
+		limit := int64(DefaultLimit)
+		if s := strings.TrimSpace(c.Query("limit")); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 1 || n > MaxLimit {
+				return problem.Write(c, problem.Validation("invalid_limit", fmt.Sprintf("limit must be between 1 and %d", MaxLimit)))
+			}
+			limit = n
+		}
+
+		// the services are searched on behalf of the authenticated user, whose
+		// token is carried by the user context
+		ctx := c.UserContext()
+		req := &pb.SearchRequest{Q: q, Limit: limit}
+		res := Results{Customers: []*pb.CustomerHit{}, Suppliers: []*pb.SupplierHit{}, Orders: []*pb.OrderHit{}}
+		var errs [3]error
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			hits, err := customers.SearchCustomers(ctx, req)
+			if errs[0] = err; err == nil {
+				res.Customers = append(res.Customers, hits.Hits...)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			hits, err := suppliers.SearchSuppliers(ctx, req)
+			if errs[1] = err; err == nil {
+				res.Suppliers = append(res.Suppliers, hits.Hits...)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			hits, err := orders.SearchOrders(ctx, req)
+			if errs[2] = err; err == nil {
+				res.Orders = append(res.Orders, hits.Hits...)
+			}
+		}()
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return problem.Write(c, err)
+			}
+		}
+		return c.Status(http.StatusOK).JSON(res)
+	}
+}
@@ -0,0 +1,311 @@
+// Package search ranks the documents matching a search by how well their
+// text matches it. Candidates are found with a text index for whole words
+// and with the trigrams of their words, stored along with the documents, for
+// the prefixes and typos a text index misses, the ones sharing the most
+// trigrams with the search first. They are then scored and highlighted term by
+// term.
+package search
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxLength is the length of the longest search
+	MaxLength = 200
+	// MaxCandidates is the largest number of documents ranked for a search,
+	// the ones sharing the most trigrams with it
+	MaxCandidates = 1000
+	// GramsField is the field storing the trigrams of the searchable text of
+	// the documents
+	GramsField = "search_grams"
+	// rankField is the field of the candidates counting the trigrams they
+	// share with the search
+	rankField = "search_rank"
+)
+
+// Hit is the score of a document matching a search, from 0 to 1, and its
+// text with the matches highlighted
+type Hit struct {
+	Score     float64 `json:"score"`
+	Highlight string  `json:"highlight"`
+}
+
+// Parse returns the search of the q query parameter of the request, empty
+// when there is none. The results of a search are ranked, q cannot be
+// combined with sort or after.
+func Parse(c *fiber.Ctx) (string, error) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return "", nil
+	}
+	if c.Query("sort") != "" || c.Query("after") != "" {
+		return "", problem.Validation("invalid_search", "search results are ranked, q cannot be combined with sort or after")
+	}
+	return q, Validate(q)
+}
+
+// Validate checks that q has words to search and is not too long
+func Validate(q string) error {
+	if len(q) > MaxLength {
+		return problem.Validation("invalid_search", fmt.Sprintf("q must be at most %d characters long", MaxLength))
+	}
+	if len(Terms(q)) == 0 {
+		return problem.Validation("invalid_search", "q must have at least a word")
+	}
+	return nil
+}
+
+// Terms returns the lowercase words of s
+func Terms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), notWord)
+}
+
+func notWord(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Grams returns the distinct trigrams of the words of s. Words are prefixed
+// with a space, and their first letter is a gram too, so that the prefixes
+// of words share grams with them.
+func Grams(s string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	add := func(gram string) {
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	for _, term := range Terms(s) {
+		runes := []rune(" " + term)
+		add(string(runes[:2]))
+		for i := 0; i+3 <= len(runes); i++ {
+			add(string(runes[i : i+3]))
+		}
+	}
+	return grams
+}
+
+// Query returns the query finding the candidate documents of the search q,
+// the ones with a word of q in their text index or sharing trigrams with q
+func Query(q string) bson.M {
+	grams := bson.A{}
+	for _, gram := range Grams(q) {
+		grams = append(grams, gram)
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"$text": bson.M{"$search": q}},
+		bson.M{GramsField: bson.M{"$in": grams}},
+	}}
+}
+
+// Candidates returns the aggregation pipeline selecting the MaxCandidates
+// documents matched by match, a query built on Query(q), that share the most
+// trigrams with q so that the best matches are not left out of the ranking
+func Candidates(q string, match bson.M) mongo.Pipeline {
+	grams := bson.A{}
+	for _, gram := range Grams(q) {
+		grams = append(grams, gram)
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{rankField: bson.M{"$size": bson.M{"$setIntersection": bson.A{
+			bson.M{"$ifNull": bson.A{"$" + GramsField, bson.A{}}},
+			grams,
+		}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: rankField, Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: MaxCandidates}},
+	}
+}
+
+// Indexes returns the text index of fields and the index of the trigrams
+func Indexes(fields ...string) []mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	return []mongo.IndexModel{{Keys: keys}, {Keys: bson.D{{Key: GramsField, Value: 1}}}}
+}
+
+// Backfill returns the migration storing the trigrams of field in the
+// documents of collection stored without them, reverted by removing them
+func Backfill(version int, description, collection, field string) migrate.Migration {
+	return migrate.Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			c := db.Collection(collection)
+			cursor, err := c.Find(ctx, bson.M{GramsField: bson.M{"$exists": false}}, options.Find().SetProjection(bson.M{field: 1}))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+			for cursor.Next(ctx) {
+				id := cursor.Current.Lookup("_id")
+				text, _ := cursor.Current.Lookup(field).StringValueOK()
+				if _, err := c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{GramsField: Grams(text)}}); err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(collection).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{GramsField: ""}})
+			return err
+		},
+	}
+}
+
+// word is a word of a text, from its start to its end byte offsets
+type word struct {
+	start, end int
+	lower      []rune
+}
+
+// words splits text into its words
+func words(text string) []word {
+	var words []word
+	start := -1
+	for i, r := range text + " " {
+		switch {
+		case !notWord(r) && start < 0:
+			start = i
+		case notWord(r) && start >= 0:
+			words = append(words, word{start, i, []rune(strings.ToLower(text[start:i]))})
+			start = -1
+		}
+	}
+	return words
+}
+
+// Match scores how well text matches the search q and highlights the
+// matches in text, which is HTML escaped. Every term of q must match a word
+// of text, exactly, as its prefix or with typos, the longer the term the
+// more typos.
+func Match(q, text string) (Hit, bool) {
+	terms := Terms(q)
+	words := words(text)
+	if len(terms) == 0 {
+		return Hit{}, false
+	}
+	// marks are the number of runes highlighted at the start of each word
+	marks := make([]int, len(words))
+	total := 0.0
+	for _, term := range terms {
+		best, matched, marked := 0.0, 0, 0
+		for i, w := range words {
+			if score, n := matchTerm([]rune(term), w.lower); score > best {
+				best, matched, marked = score, i, n
+			}
+		}
+		if best == 0 {
+			return Hit{}, false
+		}
+		total += best
+		if marked > marks[matched] {
+			marks[matched] = marked
+		}
+	}
+	return Hit{
+		Score:     math.Round(total/float64(len(terms))*1000) / 1000,
+		Highlight: highlight(text, words, marks),
+	}, true
+}
+
+// matchTerm scores how well term matches w and returns the number of runes
+// of w matched
+func matchTerm(term, w []rune) (float64, int) {
+	if string(term) == string(w) {
+		return 1, len(w)
+	}
+	if len(term) < len(w) && string(term) == string(w[:len(term)]) {
+		return 0.5 + 0.4*float64(len(term))/float64(len(w)), len(term)
+	}
+	typos := 0
+	switch {
+	case len(term) >= 8:
+		typos = 2
+	case len(term) >= 4:
+		typos = 1
+	default:
+		return 0, 0
+	}
+	if d := distance(term, w); d <= typos {
+		return 0.4 * (1 - float64(d)/float64(len(term))), len(w)
+	}
+	if len(term) < len(w) {
+		if d := distance(term, w[:len(term)]); d <= typos {
+			return 0.3 * (1 - float64(d)/float64(len(term))), len(term)
+		}
+	}
+	return 0, 0
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// highlight wraps the first marks runes of the words of text in mark
+// elements and escapes the rest
+func highlight(text string, words []word, marks []int) string {
+	var b strings.Builder
+	last := 0
+	for i, w := range words {
+		if marks[i] == 0 {
+			continue
+		}
+		end := w.start
+		for n := 0; n < marks[i] && end < w.end; n++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+		b.WriteString(html.EscapeString(text[last:w.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[w.start:end]))
+		b.WriteString("</mark>")
+		last = end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
@@ -0,0 +1,168 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrams(t *testing.T) {
+	grams := Grams("Acme acme Co")
+	if got := strings.Join(grams, "|"); got != " a| ac|acm|cme| c| co" {
+		t.Errorf("unexpected grams %q", got)
+	}
+	for _, prefix := range Grams("acm") {
+		found := false
+		for _, gram := range grams {
+			found = found || gram == prefix
+		}
+		if !found {
+			t.Errorf("the prefix gram %q is not a gram of its word", prefix)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	c := memdb.NewCollection()
+	ctx := context.Background()
+	// the names sharing only the first letter gram come first and outnumber
+	// the candidates
+	for i := 0; i < MaxCandidates; i++ {
+		name := "a" + strconv.Itoa(i)
+		if _, err := c.InsertOne(ctx, bson.M{"name": name, GramsField: Grams(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.InsertOne(ctx, bson.M{"name": "Acme", GramsField: Grams("Acme")}); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := c.Aggregate(ctx, Candidates("acme", Query("acme")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var candidates []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &candidates); err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != MaxCandidates || candidates[0].Name != "Acme" {
+		t.Fatalf("expected the best match first among %d candidates, got %d", MaxCandidates, len(candidates))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		q, text   string
+		matched   bool
+		highlight string
+	}{
+		{"acme", "Acme Corp", true, "<mark>Acme</mark> Corp"},
+		{"corp acme", "Acme Corp", true, "<mark>Acme</mark> <mark>Corp</mark>"},
+		{"ac", "Acme Corp", true, "<mark>Ac</mark>me Corp"},
+		{"acmr", "Acme Corp", true, "<mark>Acme</mark> Corp"},
+		{"globex", "Acme Corp", false, ""},
+		{"acme globex", "Acme Corp", false, ""},
+		{"acm", "<b>Acme</b> & Co", true, "&lt;b&gt;<mark>Acm</mark>e&lt;/b&gt; &amp; Co"},
+		{"café", "Le Café", true, "Le <mark>Café</mark>"},
+		{"cat", "Cut", false, ""},
+	}
+	for _, tt := range tests {
+		hit, ok := Match(tt.q, tt.text)
+		if ok != tt.matched {
+			t.Errorf("%q in %q: expected matched %v", tt.q, tt.text, tt.matched)
+			continue
+		}
+		if ok && hit.Highlight != tt.highlight {
+			t.Errorf("%q in %q: expected %q, got %q", tt.q, tt.text, tt.highlight, hit.Highlight)
+		}
+	}
+
+	exact, _ := Match("acme", "Acme")
+	prefix, _ := Match("acm", "Acme")
+	typo, _ := Match("acne", "Acme")
+	if !(exact.Score == 1 && exact.Score > prefix.Score && prefix.Score > typo.Score && typo.Score > 0) {
+		t.Errorf("expected exact > prefix > typo, got %v, %v, %v", exact.Score, prefix.Score, typo.Score)
+	}
+}
+
+type customerServer struct {
+	pb.UnimplementedCustomerServiceServer
+}
+
+func (customerServer) SearchCustomers(ctx context.Context, in *pb.SearchRequest) (*pb.CustomerHits, error) {
+	return &pb.CustomerHits{Hits: []*pb.CustomerHit{{Customer: &pb.Customer{Id: "1", Name: "Acme"}, Score: 1, Highlight: "<mark>Acme</mark>"}}}, nil
+}
+
+type supplierServer struct {
+	pb.UnimplementedSupplierServiceServer
+}
+
+func (supplierServer) SearchSuppliers(ctx context.Context, in *pb.SearchRequest) (*pb.SupplierHits, error) {
+	if in.Q == "fail" {
+		return nil, status.Error(codes.Unavailable, "suppliers are down")
+	}
+	return &pb.SupplierHits{}, nil
+}
+
+type orderServer struct {
+	pb.UnimplementedOrderServiceServer
+	limit *int64
+}
+
+func (s orderServer) SearchOrders(ctx context.Context, in *pb.SearchRequest) (*pb.OrderHits, error) {
+	*s.limit = in.Limit
+	return &pb.OrderHits{}, nil
+}
+
+func TestHandler(t *testing.T) {
+	customers, suppliers, orders := grpc.NewServer(), grpc.NewServer(), grpc.NewServer()
+	var limit int64
+	pb.RegisterCustomerServiceServer(customers, customerServer{})
+	pb.RegisterSupplierServiceServer(suppliers, supplierServer{})
+	pb.RegisterOrderServiceServer(orders, orderServer{limit: &limit})
+	app := fiber.New()
+	app.Get("/search", Handler(
+		pb.NewCustomerServiceClient(testutil.ServeGRPC(t, customers)),
+		pb.NewSupplierServiceClient(testutil.ServeGRPC(t, suppliers)),
+		pb.NewOrderServiceClient(testutil.ServeGRPC(t, orders)),
+	))
+
+	res, body := testutil.Request(t, app, http.MethodGet, "/search?q=acme&limit=5", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.StatusCode, body)
+	}
+	var results Results
+	if err := json.Unmarshal(body, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Customers) != 1 || results.Customers[0].Customer.Name != "Acme" || results.Suppliers == nil || results.Orders == nil {
+		t.Errorf("unexpected results %s", body)
+	}
+	if limit != 5 {
+		t.Errorf("expected the limit to be passed on, got %d", limit)
+	}
+
+	for target, expected := range map[string]int{
+		"/search":             http.StatusBadRequest,
+		"/search?q=%20!":      http.StatusBadRequest,
+		"/search?q=a&limit=0": http.StatusBadRequest,
+		"/search?q=fail":      http.StatusServiceUnavailable,
+	} {
+		if res, body := testutil.Request(t, app, http.MethodGet, target, nil); res.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d: %s", target, expected, res.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package stats keeps figures of a service, such as the number of its
+// records, in a counters document of its database. Every change adds its
+// deltas to the counters in the transaction storing it, and the counters are
+// computed again from the records every now and then to correct any drift,
+// e.g. from changes made behind the service. The counters are cached in a
+// Redis hash shared by its replicas, dropped once a change is stored. Every
+// invalidation bumps a generation, counters read while a change was stored
+// are not cached. The hash expires too so that an invalidation lost, e.g.
+// when Redis was down, cannot leave stale figures for long. While Redis is
+// down the counters are read on every read.
+package stats
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/go-redis/redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultTTL is the time the figures are kept for when no TTL is given
+const DefaultTTL = 10 * time.Minute
+
+// Compute computes the figures from the database, by name
+type Compute func(ctx context.Context) (map[string]float64, error)
+
+// Collection is the subset of *mongo.Collection storing the counters
+type Collection interface {
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// Config configures Figures
+type Config struct {
+	// Key is the key of the Redis hash of the figures, e.g. "orders:stats",
+	// and the id of their counters document
+	Key string
+	// TTL is the time the figures are cached for before they are read again
+	TTL time.Duration
+	// Timeout bounds every Redis call, 0 leaves them bounded by the context
+	Timeout time.Duration
+	// DBTimeout bounds the reads of the counters, 0 leaves them bounded by
+	// the context
+	DBTimeout time.Duration
+}
+
+// Figures are the figures of a service
+type Figures struct {
+	rdb      *redis.Client
+	counters Collection
+	config   Config
+	compute  Compute
+	group    singleflight.Group
+}
+
+// New returns the Figures of config counted in counters, cached in rdb and
+// computed with compute
+func New(rdb *redis.Client, counters Collection, config Config, compute Compute) *Figures {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	return &Figures{rdb: rdb, counters: counters, config: config, compute: compute}
+}
+
+// Add adds deltas to the counters, ctx must be the one of the transaction
+// storing the change they count. Nothing is added until the counters are
+// computed, they would otherwise miss what was stored before.
+func (f *Figures) Add(ctx context.Context, deltas map[string]float64) error {
+	inc := bson.M{}
+	for name, delta := range deltas {
+		if delta != 0 {
+			inc[name] = delta
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	_, err := f.counters.UpdateOne(ctx, bson.M{"_id": f.config.Key}, bson.M{"$inc": inc})
+	return err
+}
+
+// Recompute computes the figures from the database and stores them as the
+// counters. ctx should be the one of a transaction so that the deltas of the
+// changes stored meanwhile are not lost, the figures must be invalidated once
+// it is committed.
+func (f *Figures) Recompute(ctx context.Context) error {
+	figures, err := f.compute(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = f.counters.UpdateOne(ctx, bson.M{"_id": f.config.Key}, bson.M{"$set": figures}, options.Update().SetUpsert(true))
+	return err
+}
+
+// Get returns the figures, the counters are read when they are not cached and
+// concurrent reads are collapsed into one. They are computed when there are
+// no counters yet.
+func (f *Figures) Get(ctx context.Context) (map[string]float64, error) {
+	figures, gen, ok := f.get(ctx)
+	if ok {
+		return figures, nil
+	}
+	shared, err, _ := f.group.Do(f.config.Key, func() (interface{}, error) {
+		// the read is shared with the callers waiting for it, it must not be
+		// canceled along with the first one
+		ctx := middleware.Detach(ctx)
+		figures, err := f.read(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if err := f.Recompute(ctx); err != nil {
+				return nil, err
+			}
+			figures, err = f.read(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.set(ctx, figures, gen)
+		return figures, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// every caller gets its own copy
+	figures = map[string]float64{}
+	for name, value := range shared.(map[string]float64) {
+		figures[name] = value
+	}
+	return figures, nil
+}
+
+// read returns the counters stored in the database
+func (f *Figures) read(ctx context.Context) (map[string]float64, error) {
+	ctx, cancel := middleware.WithTimeout(ctx, f.config.DBTimeout)
+	defer cancel()
+	var counters bson.M
+	if err := f.counters.FindOne(ctx, bson.M{"_id": f.config.Key}).Decode(&counters); err != nil {
+		return nil, err
+	}
+	figures := make(map[string]float64, len(counters))
+	for name, value := range counters {
+		switch n := value.(type) {
+		case int32:
+			figures[name] = float64(n)
+		case int64:
+			figures[name] = float64(n)
+		case float64:
+			figures[name] = n
+		}
+	}
+	return figures, nil
+}
+
+// Invalidate drops the cached figures once a change is stored so that the
+// counters are read again, the ones being read meanwhile are not cached.
+// Failures are only logged, the figures expire on their own.
+func (f *Figures) Invalidate(ctx context.Context) {
+	ctx, cancel := middleware.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, f.generationKey())
+		pipe.Expire(ctx, f.generationKey(), f.config.TTL)
+		pipe.Del(ctx, f.config.Key)
+		return nil
+	})
+	if err != nil {
+		log.Printf("stats %s: failed to invalidate: %s", f.config.Key, err.Error())
+	}
+}
+
+// generationKey returns the key of the generation of the figures, bumped by
+// every invalidation
+func (f *Figures) generationKey() string {
+	return f.config.Key + ":gen"
+}
+
+// generation is the generation of the figures read before the counters,
+// known is false when it could not be read
+type generation struct {
+	value string
+	known bool
+}
+
+// get returns the figures stored and the generation they were read at, ok is
+// false when they are missing or cannot be read
+func (f *Figures) get(ctx context.Context) (map[string]float64, generation, bool) {
+	ctx, cancel := middleware.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+	var values *redis.MapStringStringCmd
+	var gen *redis.StringCmd
+	_, err := f.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		values = pipe.HGetAll(ctx, f.config.Key)
+		gen = pipe.Get(ctx, f.generationKey())
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("stats %s: failed to read: %s", f.config.Key, err.Error())
+		return nil, generation{}, false
+	}
+	read := generation{value: gen.Val(), known: true}
+	if len(values.Val()) == 0 {
+		return nil, read, false
+	}
+	figures := make(map[string]float64, len(values.Val()))
+	for name, value := range values.Val() {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Printf("stats %s: invalid %s %q", f.config.Key, name, value)
+			return nil, read, false
+		}
+		figures[name] = n
+	}
+	return figures, read, true
+}
+
+// script stores the figures ARGV[4], ARGV[6], ... named ARGV[3], ARGV[5],
+// ... in the hash KEYS[1] for ARGV[2] milliseconds unless the generation
+// KEYS[2] moved past ARGV[1]
+var script = redis.NewScript(`
+local generation = redis.call('GET', KEYS[2]) or ''
+if generation ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// set stores the figures read at gen for the TTL unless they were
+// invalidated meanwhile, failures are logged
+func (f *Figures) set(ctx context.Context, figures map[string]float64, gen generation) {
+	if len(figures) == 0 || !gen.known {
+		return
+	}
+	args := make([]interface{}, 0, 2+2*len(figures))
+	args = append(args, gen.value, f.config.TTL.Milliseconds())
+	for name, value := range figures {
+		args = append(args, name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	ctx, cancel := middleware.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+	if err := script.Run(ctx, f.rdb, []string{f.config.Key, f.generationKey()}, args...).Err(); err != nil {
+		log.Printf("stats %s: failed to store: %s", f.config.Key, err.Error())
+	}
+}
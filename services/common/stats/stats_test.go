@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// counters are in-memory counters calling read once they are read and
+// failing with err when it is set
+type counters struct {
+	*memdb.Collection
+	read func()
+	err  error
+}
+
+func (c *counters) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	res := c.Collection.FindOne(ctx, filter, opts...)
+	if c.err != nil {
+		res = mongo.NewSingleResultFromDocument(bson.D{}, c.err, nil)
+	}
+	if c.read != nil {
+		c.read()
+	}
+	return res
+}
+
+func TestFigures(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	computed := 0
+	f := New(rdb, &counters{Collection: memdb.NewCollection()}, Config{Key: "orders:stats", TTL: time.Minute, Timeout: time.Second}, func(ctx context.Context) (map[string]float64, error) {
+		computed++
+		return map[string]float64{"orders": 2, "revenue": 84.5}, nil
+	})
+	ctx := context.Background()
+
+	// nothing is counted until the counters are computed
+	if err := f.Add(ctx, map[string]float64{"orders": 1, "revenue": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the figures are computed once when there are no counters yet
+	for i := 0; i < 2; i++ {
+		figures, err := f.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[string]float64{"orders": 2, "revenue": 84.5}; !reflect.DeepEqual(figures, expected) {
+			t.Errorf("get %d: expected %v, got %v", i, expected, figures)
+		}
+	}
+	if computed != 1 || mr.TTL("orders:stats") != time.Minute {
+		t.Errorf("expected the figures to be computed once and kept for a minute, got %d computations and ttl %s", computed, mr.TTL("orders:stats"))
+	}
+
+	// the changes add their deltas to the counters
+	if err := f.Add(ctx, map[string]float64{"orders": 1, "revenue": 10}); err != nil {
+		t.Fatal(err)
+	}
+	f.Invalidate(ctx)
+	if mr.Exists("orders:stats") {
+		t.Fatal("expected the figures to be dropped once invalidated")
+	}
+	if figures, _ := f.Get(ctx); figures["orders"] != 3 || figures["revenue"] != 94.5 || computed != 1 {
+		t.Errorf("expected the counters to be read again, got %v after %d computations", figures, computed)
+	}
+
+	// the counters are read again once the figures expire
+	if err := f.Add(ctx, map[string]float64{"orders": -1, "revenue": -10}); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Minute)
+	if figures, _ := f.Get(ctx); figures["orders"] != 2 || computed != 1 {
+		t.Errorf("expected the counters to be read again, got %v after %d computations", figures, computed)
+	}
+
+	// recomputing corrects the counters
+	if err := f.Add(ctx, map[string]float64{"orders": 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.Invalidate(ctx)
+	if figures, _ := f.Get(ctx); figures["orders"] != 2 || computed != 2 {
+		t.Errorf("expected the counters to be corrected, got %v after %d computations", figures, computed)
+	}
+}
+
+func TestFiguresInvalidatedWhileRead(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	c := &counters{Collection: memdb.NewCollection()}
+	f := New(rdb, c, Config{Key: "orders:stats", Timeout: time.Second}, func(ctx context.Context) (map[string]float64, error) {
+		return map[string]float64{"orders": 1}, nil
+	})
+	ctx := context.Background()
+	if err := f.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// an order is stored once the counters were read
+	c.read = func() {
+		c.read = nil
+		f.Add(ctx, map[string]float64{"orders": 1})
+		f.Invalidate(ctx)
+	}
+
+	if figures, _ := f.Get(ctx); figures["orders"] != 1 {
+		t.Fatalf("expected the counters read, got %v", figures)
+	}
+	if mr.Exists("orders:stats") {
+		t.Fatal("the counters read before the invalidation should not be stored")
+	}
+	if figures, _ := f.Get(ctx); figures["orders"] != 2 || !mr.Exists("orders:stats") {
+		t.Errorf("expected the counters to be read again and stored, got %v", figures)
+	}
+}
+
+func TestFiguresSharedRead(t *testing.T) {
+	_, rdb := testutil.Redis(t)
+	f := New(rdb, &counters{Collection: memdb.NewCollection()}, Config{Key: "customers:stats", Timeout: time.Second}, func(ctx context.Context) (map[string]float64, error) {
+		return map[string]float64{"count": 3}, ctx.Err()
+	})
+	// the read is shared with other callers, the first one giving up does
+	// not fail it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if figures, err := f.Get(ctx); err != nil || figures["count"] != 3 {
+		t.Errorf("expected the figures despite the canceled caller, got %v (%v)", figures, err)
+	}
+}
+
+func TestFiguresWithoutRedis(t *testing.T) {
+	mr, rdb := testutil.Redis(t)
+	reads := 0
+	c := &counters{Collection: memdb.NewCollection()}
+	c.read = func() { reads++ }
+	f := New(rdb, c, Config{Key: "customers:stats", Timeout: 100 * time.Millisecond}, func(ctx context.Context) (map[string]float64, error) {
+		return map[string]float64{"count": 3}, nil
+	})
+	ctx := context.Background()
+	if err := f.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mr.Close()
+	for i := 0; i < 2; i++ {
+		figures, err := f.Get(ctx)
+		if err != nil || figures["count"] != 3 {
+			t.Fatalf("get %d: expected the counters, got %v (%v)", i, figures, err)
+		}
+	}
+	if reads != 2 {
+		t.Errorf("expected the counters to be read on every read while Redis is down, got %d reads", reads)
+	}
+	f.Invalidate(ctx)
+
+	failure := errors.New("database down")
+	c.err = failure
+	if _, got := f.Get(ctx); got != failure {
+		t.Errorf("expected the error of the read, got %v", got)
+	}
+}
@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Token is the only access token accepted by AuthServer
+	Token = "test-token"
+	// User is the user Token is issued to
+	User = "omar"
+)
+
+// AuthServer is an auth gRPC server accepting Token only
+type AuthServer struct {
+	pb.UnimplementedAuthServiceServer
+}
+
+func (AuthServer) VerifyToken(ctx context.Context, in *pb.Auth) (*pb.Auth, error) {
+	if in.AccessToken != Token {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return &pb.Auth{AccessToken: in.AccessToken, Username: User}, nil
+}
+
+// Auth serves an AuthServer and returns the server, e.g. to stop it, along
+// with a client connected to it
+func Auth(t testing.TB) (*grpc.Server, pb.AuthServiceClient) {
+	t.Helper()
+	server := grpc.NewServer()
+	pb.RegisterAuthServiceServer(server, AuthServer{})
+	return server, pb.NewAuthServiceClient(ServeGRPC(t, server))
+}
@@ -0,0 +1,35 @@
+// Package tx runs the writes of the services in MongoDB transactions, so
+// that a record, its history and its outbox events are stored together
+package tx
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Func runs fn in a transaction, fn must do its writes with the context it
+// is given and may run more than once when the transaction is retried
+type Func func(ctx context.Context, fn func(ctx context.Context) error) error
+
+// Mongo returns a Func running fn in a transaction of client, which needs
+// MongoDB to run as a replica set
+func Mongo(client *mongo.Client) Func {
+	return func(ctx context.Context, fn func(ctx context.Context) error) error {
+		session, err := client.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(ctx)
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	}
+}
+
+// None runs fn without a transaction, for the stores that have none such as
+// memdb
+func None(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/customers/data"
+)
+
+type server struct {
+	pb.UnimplementedCustomerServiceServer
+	ordersOnDelete string
+	orders         pb.OrderServiceClient
+}
+
+// toPB converts a Customer to its gRPC message
+func toPB(customer data.Customer) *pb.Customer {
+	return &pb.Customer{
+		Id:        customer.ID.Hex(),
+		Name:      customer.Name,
+		Version:   customer.Version,
+		CreatedAt: customer.CreatedAt,
+		UpdatedAt: customer.UpdatedAt,
+	}
+}
+
+// GetCustomer implementation for Customer gRPC server
+func (s *server) GetCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	customer, err := data.GetCustomer(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toPB(customer), nil
+}
+
+// GetAllCustomers implementation for Customer gRPC server, the Customers
+// are streamed as they are read from the database
+func (s *server) GetAllCustomers(in *pb.Empty, stream pb.CustomerService_GetAllCustomersServer) error {
+	return data.StreamCustomers(stream.Context(), func(customer data.Customer) error {
+		return stream.Send(toPB(customer))
+	})
+}
+
+// BatchGetCustomers implementation for Customer gRPC server, the Customers not found are
+// left out of the response
+func (s *server) BatchGetCustomers(ctx context.Context, in *pb.Ids) (*pb.Customers, error) {
+	customers, err := data.GetCustomersByIDs(ctx, in.Ids)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.Customers{}
+	for _, customer := range customers {
+		res.Customers = append(res.Customers, toPB(customer))
+	}
+	return res, nil
+}
+
+// SearchCustomers implementation for Customer gRPC server, the best
+// matching Customers come first
+func (s *server) SearchCustomers(ctx context.Context, in *pb.SearchRequest) (*pb.CustomerHits, error) {
+	limit := in.Limit
+	if limit <= 0 {
+		limit = search.DefaultLimit
+	}
+	hits, err := data.SearchCustomers(ctx, in.Q, nil, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.CustomerHits{}
+	for _, hit := range hits {
+		res.Hits = append(res.Hits, &pb.CustomerHit{Customer: toPB(hit.Customer), Score: hit.Score, Highlight: hit.Highlight})
+	}
+	return res, nil
+}
+
+// CountCustomers implementation for Customer gRPC server
+func (s *server) CountCustomers(ctx context.Context, in *pb.Empty) (*pb.Count, error) {
+	count, err := data.CountCustomers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Count{Count: count}, nil
+}
+
+// CreateCustomer implementation for Customer gRPC server
+func (s *server) CreateCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	customer, err := data.CreateCustomer(ctx, data.Customer{Name: in.Name}, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return toPB(customer), nil
+}
+
+// UpdateCustomer implementation for Customer gRPC server, the version of in is the
+// version the Customer is expected to be at, 0 updates whatever its current
+// version
+func (s *server) UpdateCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	customer, err := data.UpdateCustomer(ctx, in.Id, data.Customer{Name: in.Name}, in.Version, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return toPB(customer), nil
+}
+
+// DeleteCustomer implementation for Customer gRPC server, it returns the Customer as it
+// was before moving to the trash. The orders policy of the service applies,
+// reassigning the orders needs the HTTP API which takes the Customer to reassign
+// them to.
+func (s *server) DeleteCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	customer, err := data.GetCustomer(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.DeleteCustomer(ctx, in.Id, rpc.User(ctx), s.ordersOnDelete, "", s.orders); err != nil {
+		return nil, err
+	}
+	return toPB(customer), nil
+}
@@ -0,0 +1,415 @@
+// Package api contains the http and gRPC servers of the customers service
+package api
+
+import (
+	"net/http"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/etag"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/idempotency"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/customers/data"
+	"github.com/Omar-Belghaouti/pdash/services/customers/util"
+	swagger "github.com/arsmn/fiber-swagger/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type Response struct {
+	Message string `json:"message"`
+}
+
+// NewApp creates the http application of the service
+func NewApp(config util.Config, authClient pb.AuthServiceClient, grpcOrderClient pb.OrderServiceClient) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+
+	// CORS
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  "*",
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE",
+		ExposeHeaders: "ETag",
+	}))
+
+	// Request timeout
+	app.Use(middleware.Timeout(config.RequestTimeout))
+
+	// Swagger
+	app.Get("/swagger/*", swagger.HandlerDefault)
+
+	// Retried creations replay the response of the first attempt
+	idempotent := idempotency.New(data.Redis(), idempotency.Config{
+		TTL:     config.IdempotencyTTL,
+		LockTTL: 2 * config.RequestTimeout,
+		Timeout: config.CacheTimeout,
+	})
+
+	// Auth middleware
+	app.Use(middleware.Auth(authClient, config.TrustGateway))
+
+	// Create a new Customer
+	app.Post("/customers", idempotent, CreateCustomer)
+
+	// Get all Customers
+	app.Get("/customers", GetCustomers)
+
+	// Get the Customers in the trash
+	app.Get("/customers/trash", GetDeletedCustomers)
+
+	// Get the changes made to the Customers
+	app.Get("/customers/audit", GetCustomerAudit)
+
+	// Get a Customer by ID
+	app.Get("/customers/:id", GetCustomerByID)
+
+	// Update a Customer by ID
+	app.Put("/customers/:id", UpdateCustomerByID(config.RequireIfMatch))
+
+	// Patch a Customer by ID
+	app.Patch("/customers/:id", PatchCustomerByID(config.RequireIfMatch))
+
+	// Delete a Customer by ID
+	app.Delete("/customers/:id", DeleteCustomerByID(config.OrdersOnDelete, grpcOrderClient))
+
+	// Restore a Customer from the trash
+	app.Post("/customers/:id/restore", RestoreCustomerByID(grpcOrderClient))
+
+	// Get the changes made to a Customer by ID
+	app.Get("/customers/:id/history", GetCustomerHistoryByID)
+
+	return app
+}
+
+// NewGRPCServer creates the gRPC server of the service, deletes apply the
+// ordersOnDelete policy to the orders through grpcOrderClient. The changes
+// are only made on behalf of callers whose token authClient verifies.
+func NewGRPCServer(ordersOnDelete string, authClient pb.AuthServiceClient, grpcOrderClient pb.OrderServiceClient) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			problem.UnaryServerInterceptor,
+			rpc.Authenticate(authClient, "CreateCustomer", "UpdateCustomer", "DeleteCustomer"),
+		),
+		grpc.StreamInterceptor(problem.StreamServerInterceptor),
+	)
+	pb.RegisterCustomerServiceServer(s, &server{
+		ordersOnDelete: ordersOnDelete,
+		orders:         grpcOrderClient,
+	})
+	reflection.Register(s)
+	return s
+}
+
+// CreateCustomer creates a new Customer
+// @Summary Create a new Customer
+// @Description Create a new Customer
+// @ID create-customer
+// @Accept  json
+// @Produce  json
+// @Param customer body data.Customer true "Customer"
+// @Param Idempotency-Key header string false "Key making retries of the request safe"
+// @Success 201 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers [post]
+func CreateCustomer(c *fiber.Ctx) error {
+	customer := data.Customer{}
+	if err := c.BodyParser(&customer); err != nil {
+		return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+	}
+	customer, err := data.CreateCustomer(c.UserContext(), customer, middleware.User(c))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	etag.Set(c, customer.Version)
+	return c.Status(http.StatusCreated).JSON(customer)
+}
+
+// GetCustomers gets a page of Customers
+// @Summary Get a page of Customers
+// @Description Get a page of Customers sorted and with the selected fields, or the Customers whose name best matches a search along with their score and highlighted name
+// @ID get-customers
+// @Accept  json
+// @Produce  json
+// @Param q query string false "Search of the names, matching prefixes and typos, cannot be combined with sort or after"
+// @Param filter query string false "Filter on id, name, version, created_at and updated_at, e.g. version > 1 and not name in (...)"
+// @Param limit query int false "Number of Customers of the page, 50 by default and 500 at most"
+// @Param after query string false "next_cursor of the previous page"
+// @Param sort query string false "Field to sort by, name, created_at or updated_at, prefixed with - to sort in descending order"
+// @Param fields query string false "Fields to return along with the id, comma separated"
+// @Success 200 {object} page.Page{items=[]data.Customer}
+// @Header 200 {string} Link "Relative link to the next page"
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers [get]
+func GetCustomers(c *fiber.Ctx) error {
+	where, err := filter.Parse(c.Query("filter"), data.FilterFields)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	q, err := page.Parse(c, data.SortableFields, data.Fields)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	text, err := search.Parse(c)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	if text != "" {
+		hits, err := data.SearchCustomers(c.UserContext(), text, where, q.Limit)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		if len(q.Fields) > 0 {
+			q.Fields = append(q.Fields, "score", "highlight")
+		}
+		return page.Write(c, q, hits, page.Info{Total: int64(len(hits))})
+	}
+	customers, info, err := data.GetCustomers(c.UserContext(), where, q)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return page.Write(c, q, customers, info)
+}
+
+// GetCustomerByID gets a Customer by ID
+// @Summary Get a Customer by ID
+// @Description Get a Customer by ID
+// @ID get-customer-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {object} data.Customer
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id} [get]
+func GetCustomerByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+	customer, err := data.GetCustomer(c.UserContext(), id)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	etag.Set(c, customer.Version)
+	return c.Status(http.StatusOK).JSON(customer)
+}
+
+// UpdateCustomerByID returns the handler updating a Customer by ID,
+// requireIfMatch rejects the updates without an If-Match header
+// @Summary Update a Customer by ID
+// @Description Update a Customer by ID
+// @ID update-customer-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param If-Match header string false "ETag of the version being updated"
+// @Param customer body data.Customer true "Customer"
+// @Success 200 {object} data.Customer
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id} [put]
+func UpdateCustomerByID(requireIfMatch bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		customer := data.Customer{}
+		if err := c.BodyParser(&customer); err != nil {
+			return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		customer, err = data.UpdateCustomer(c.UserContext(), id, customer, version, middleware.User(c))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, customer.Version)
+		return c.Status(http.StatusOK).JSON(customer)
+	}
+}
+
+// PatchCustomerByID returns the handler applying a JSON merge patch to a
+// Customer by ID, requireIfMatch rejects the patches without an If-Match header
+// @Summary Patch a Customer by ID
+// @Description Apply a JSON merge patch (RFC 7396) to a Customer by ID, id and created_at cannot be changed
+// @ID patch-customer-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param If-Match header string false "ETag of the version being patched"
+// @Param customer body data.Customer true "Merge patch of the Customer"
+// @Success 200 {object} data.Customer
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id} [patch]
+func PatchCustomerByID(requireIfMatch bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !patch.IsMergePatch(c) {
+			return fiber.ErrUnsupportedMediaType
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		customer, err := data.PatchCustomer(c.UserContext(), c.Params("id"), c.Body(), version, middleware.User(c))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, customer.Version)
+		return c.Status(http.StatusOK).JSON(customer)
+	}
+}
+
+// DeleteCustomerByID returns the handler deleting a Customer by ID, policy applies to
+// the Orders referencing it
+// @Summary Delete a Customer by ID
+// @Description Move a Customer to the trash, it is purged after the retention period. Depending on the configured policy its Orders prevent the delete (409), go to the trash with it or are reassigned to reassign_to
+// @ID delete-customer-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param reassign_to query string false "Customer ID the Orders are reassigned to"
+// @Success 200 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id} [delete]
+func DeleteCustomerByID(policy string, grpcOrderClient pb.OrderServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		err := data.DeleteCustomer(c.UserContext(), id, middleware.User(c), policy, c.Query("reassign_to"), grpcOrderClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return c.Status(http.StatusOK).JSON(Response{Message: "Customer deleted successfully"})
+	}
+}
+
+// GetDeletedCustomers gets the Customers in the trash
+// @Summary Get the Customers in the trash
+// @Description Get the deleted Customers not purged yet, most recently deleted first
+// @ID get-deleted-customers
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} data.Customer
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/trash [get]
+func GetDeletedCustomers(c *fiber.Ctx) error {
+	customers, err := data.GetDeletedCustomers(c.UserContext())
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(customers)
+}
+
+// RestoreCustomerByID returns the handler restoring a Customer from the trash
+// along with the Orders deleted with it
+// @Summary Restore a Customer from the trash
+// @Description Restore a deleted Customer by ID, the Orders that went to the trash with it are restored too unless their other reference is gone
+// @ID restore-customer-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {object} data.Customer
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id}/restore [post]
+func RestoreCustomerByID(grpcOrderClient pb.OrderServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		customer, err := data.RestoreCustomer(c.UserContext(), c.Params("id"), middleware.User(c), grpcOrderClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, customer.Version)
+		return c.Status(http.StatusOK).JSON(customer)
+	}
+}
+
+// GetCustomerHistoryByID gets the changes made to a Customer by ID
+// @Summary Get the history of a Customer by ID
+// @Description Get the changes made to a Customer by ID with who made them and the fields they changed, most recent first
+// @ID get-customer-history-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/{id}/history [get]
+func GetCustomerHistoryByID(c *fiber.Ctx) error {
+	entries, err := data.GetCustomerHistory(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
+
+// GetCustomerAudit gets the changes made to the Customers
+// @Summary Get the audit trail of the Customers
+// @Description Get the changes made to the Customers, most recent first
+// @ID get-customer-audit
+// @Accept  json
+// @Produce  json
+// @Param record_id query string false "ID of the Customer"
+// @Param actor query string false "User who made the changes"
+// @Param action query string false "create, update, delete or restore"
+// @Param since query string false "RFC 3339 timestamp of the oldest change"
+// @Param until query string false "RFC 3339 timestamp of the newest change"
+// @Param limit query int false "Maximum number of changes, 100 by default"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /customers/audit [get]
+func GetCustomerAudit(c *fiber.Ctx) error {
+	q, err := history.ParseQuery(c)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	entries, err := data.GetCustomerAudit(c.UserContext(), q)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
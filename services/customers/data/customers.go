@@ -3,10 +3,27 @@ package data
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Omar-Belghaouti/pdash/services/common/cache"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/outbox"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/stats"
+	"github.com/Omar-Belghaouti/pdash/services/common/tx"
+	"github.com/Omar-Belghaouti/pdash/services/customers/util"
 	"github.com/go-redis/redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,20 +32,194 @@ import (
 )
 
 var (
-	ctx        context.Context
-	collection *mongo.Collection
+	db         *mongo.Database
+	collection Collection
+	changes    *history.Log
+	box        *outbox.Outbox
+	transact   tx.Func
 	rdb        *redis.Client
+	cached     *cache.Cache
+	figures    *stats.Figures
+	bus        *events.Publisher
+	config     util.Config
 )
 
+// Collection is the subset of *mongo.Collection used by the data package
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+}
+
+// SortableFields are the fields Customers can be listed sorted by
+var SortableFields = []string{"name", "created_at", "updated_at"}
+
+// Fields are the fields of Customers that can be selected when listing them
+var Fields = []string{"id", "name", "version", "created_at", "updated_at"}
+
+// FilterFields are the fields Customers can be filtered on when listing them
+var FilterFields = filter.Fields{
+	"id":         filter.ID,
+	"name":       filter.String,
+	"version":    filter.Number,
+	"created_at": filter.String,
+	"updated_at": filter.String,
+}
+
 func init() {
-	ctx = context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
+	var err error
+	config, err = util.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err.Error())
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %s", err.Error())
 	}
-	collection = client.Database("db").Collection("customers")
+	db = client.Database("db")
+	collection = db.Collection("customers")
+	changes = history.New(client.Database("db").Collection("history"), "customer")
+	box = outbox.New(client.Database("db").Collection("customers_outbox"))
+	transact = tx.Mongo(client)
 	rdb = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
+		Addr: config.RedisAddr,
+	})
+	bus = events.NewPublisher(rdb)
+	cached = newCache(rdb)
+	figures = newFigures(client.Database("db").Collection("stats"), rdb)
+}
+
+// Use replaces the collection, the history, outbox and stats collections and
+// the Redis client used by the data package, e.g. with in-memory stand-ins
+// that have no transactions nor migrations
+func Use(c Collection, h history.Collection, o outbox.Collection, s stats.Collection, r *redis.Client) {
+	db = nil
+	collection = c
+	changes = history.New(h, "customer")
+	box = outbox.New(o)
+	transact = tx.None
+	rdb = r
+	bus = events.NewPublisher(r)
+	cached = newCache(r)
+	figures = newFigures(s, r)
+}
+
+// cacheVersion is the version of the representation of the cached Customers,
+// bump it when Customer changes
+const cacheVersion = 1
+
+// newCache returns the cache of the Customers stored in r
+func newCache(r *redis.Client) *cache.Cache {
+	return cache.New(r, cache.Config{
+		Service:     "customers",
+		Version:     cacheVersion,
+		TTL:         config.CacheTTL,
+		NegativeTTL: config.CacheNegativeTTL,
+		Timeout:     config.CacheTimeout,
+		LocalSize:   config.CacheLocalSize,
+		LocalTTL:    config.CacheLocalTTL,
+	})
+}
+
+// newFigures returns the figures of the Customers counted in s and cached in r
+func newFigures(s stats.Collection, r *redis.Client) *stats.Figures {
+	return stats.New(r, s, stats.Config{Key: "customers:stats", TTL: config.StatsTTL, Timeout: config.CacheTimeout, DBTimeout: config.DBTimeout}, func(ctx context.Context) (map[string]float64, error) {
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		count, err := collection.CountDocuments(dbCtx, live(bson.M{}))
+		return map[string]float64{"count": float64(count)}, err
+	})
+}
+
+// ListenCacheInvalidations removes from memory the Customers changed by the other
+// replicas until ctx is done, when they are kept in memory
+func ListenCacheInvalidations(ctx context.Context) {
+	cached.Listen(ctx)
+}
+
+// Migrations are the schema migrations of the customers collections
+var Migrations = []migrate.Migration{
+	migrate.CreateIndexes(1, "index the sortable fields of the customers", "customers", page.Indexes(SortableFields)...),
+	migrate.CreateIndexes(2, "index the customers in the trash", "customers", mongo.IndexModel{Keys: bson.D{{Key: "deleted_at", Value: 1}}}),
+	migrate.CreateIndexes(3, "index the customers outbox", "customers_outbox", outbox.Indexes()...),
+	history.Migration(4, "history"),
+	migrate.CreateIndexes(5, "index the names of the customers for search", "customers", search.Indexes("name")...),
+	search.Backfill(6, "store the search grams of the names of the customers", "customers", "name"),
+}
+
+// Migrator returns the Migrator of the customers database, it is only
+// available with MongoDB
+func Migrator() *migrate.Migrator {
+	return migrate.New(db, "customers", Migrations)
+}
+
+// Migrate applies the pending migrations, in-memory collections have none
+func Migrate(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	_, err := Migrator().Up(ctx, 0)
+	return err
+}
+
+// Redis returns the Redis client used by the data package
+func Redis() *redis.Client {
+	return rdb
+}
+
+// versionFilter matches the documents at version, documents written before
+// versions were introduced have none and are at version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	return version
+}
+
+// live adds to filter the condition excluding the documents in the trash
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// eventTypes are the events published for the actions of the history
+var eventTypes = map[string]string{
+	history.Created:  "customer.created",
+	history.Updated:  "customer.updated",
+	history.Deleted:  "customer.deleted",
+	history.Restored: "customer.restored",
+}
+
+// record adds the change of a Customer from before to after, made by actor, to
+// its history and its event to the outbox, ctx must be the one of the
+// transaction storing the change
+func record(ctx context.Context, action, actor string, before interface{}, after Customer) error {
+	if err := changes.Record(ctx, after.ID.Hex(), action, actor, after.Version, before, after); err != nil {
+		return err
+	}
+	e, err := events.New(eventTypes[action], after.ID.Hex(), actor, events.Change{Before: before, After: after})
+	if err != nil {
+		return err
+	}
+	return box.Add(ctx, e)
+}
+
+// RelayOutbox publishes the events of the outbox to the event bus every
+// interval and as soon as a change is stored, until ctx is done
+func RelayOutbox(ctx context.Context, interval time.Duration) {
+	box.Run(ctx, interval, "customers outbox relay", func(ctx context.Context, e events.Event) error {
+		// the change of the event is stored, the figures are read again
+		// before anyone is told about it
+		figures.Invalidate(ctx)
+		cacheCtx, cancel := middleware.WithTimeout(ctx, config.CacheTimeout)
+		defer cancel()
+		return bus.PublishEvent(cacheCtx, e)
 	})
 }
 
@@ -36,8 +227,13 @@ func init() {
 type Customer struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Name      string             `bson:"name" json:"name"`
+	Version   int64              `bson:"version" json:"version"`
 	CreatedAt string             `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt string             `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	DeletedAt string             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	// SearchGrams are the trigrams of the name the Customer is searched by
+	SearchGrams []string `bson:"search_grams,omitempty" json:"-"`
 }
 
 // MarshalBinary is a marshalling function for Customer
@@ -48,110 +244,520 @@ func (c Customer) MarshalBinary() ([]byte, error) {
 // Customers is a slice of Customer structs
 type Customers []Customer
 
-// CreateCustomer creates a new Customer document
-func CreateCustomer(customer Customer) (Customer, int, error) {
+// CreateCustomer creates a new Customer document, actor is the user creating it
+func CreateCustomer(ctx context.Context, customer Customer, actor string) (Customer, error) {
+	if strings.TrimSpace(customer.Name) == "" {
+		return customer, problem.Validation("missing_field", "name is required")
+	}
 	customer.ID = primitive.NewObjectID()
 	customer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	customer.UpdatedAt = customer.CreatedAt
-	_, err := collection.InsertOne(ctx, customer)
+	customer.Version = 1
+	customer.SearchGrams = search.Grams(customer.Name)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err := transact(dbCtx, func(ctx context.Context) error {
+		if _, err := collection.InsertOne(ctx, customer); err != nil {
+			return err
+		}
+		if err := figures.Add(ctx, map[string]float64{"count": 1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Created, actor, nil, customer)
+	})
+	if err != nil {
+		return customer, problem.From(err)
+	}
+	box.Notify()
+	figures.Invalidate(ctx)
+	return customer, nil
+}
+
+// GetCustomers returns the page of the Customers matched by where selected by q
+func GetCustomers(ctx context.Context, where filter.Node, q page.Query) (Customers, page.Info, error) {
+	customers := Customers{}
+	var info page.Info
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	match := filter.Restrict(live(bson.M{}), where)
+	total, err := collection.CountDocuments(dbCtx, match)
+	if err != nil {
+		return customers, info, problem.From(err)
+	}
+	cursor, err := collection.Find(dbCtx, q.Filter(match), q.Options())
+	if err != nil {
+		return customers, info, problem.From(err)
+	}
+	next, err := q.Collect(dbCtx, cursor, &customers)
+	if err != nil {
+		return customers, info, problem.From(err)
+	}
+	return customers, page.Info{Total: total, NextCursor: next}, nil
+}
+
+// CustomerHit is a Customer matching a search
+type CustomerHit struct {
+	Customer
+	search.Hit
+}
+
+// SearchCustomers returns the limit Customers matched by where whose name
+// best matches the search q, best first
+func SearchCustomers(ctx context.Context, q string, where filter.Node, limit int64) ([]CustomerHit, error) {
+	hits := []CustomerHit{}
+	if err := search.Validate(q); err != nil {
+		return hits, err
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Aggregate(dbCtx, search.Candidates(q, filter.Restrict(live(search.Query(q)), where)))
 	if err != nil {
-		return customer, http.StatusInternalServerError, err
+		return hits, problem.From(err)
+	}
+	var candidates Customers
+	if err := cursor.All(dbCtx, &candidates); err != nil {
+		return hits, problem.From(err)
+	}
+	for _, customer := range candidates {
+		if hit, ok := search.Match(q, customer.Name); ok {
+			hits = append(hits, CustomerHit{Customer: customer, Hit: hit})
+		}
 	}
-	return customer, http.StatusCreated, err
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if limit > 0 && int64(len(hits)) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
 }
 
-// GetCustomers returns all Customers
-func GetCustomers() (Customers, int, error) {
-	var customers Customers
-	cursor, err := collection.Find(ctx, bson.M{})
+// StreamCustomers calls send with every Customer, one at a time as they are read
+// from the database
+func StreamCustomers(ctx context.Context, send func(Customer) error) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, live(bson.M{}))
 	if err != nil {
-		return customers, http.StatusInternalServerError, err
+		return problem.From(err)
 	}
-	if err := cursor.All(ctx, &customers); err != nil {
-		return customers, http.StatusInternalServerError, err
+	// the cursor outlives the timeout of the query, it is bound to ctx
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var customer Customer
+		if err := cursor.Decode(&customer); err != nil {
+			return problem.From(err)
+		}
+		if err := send(customer); err != nil {
+			return err
+		}
 	}
-	if customers == nil {
-		return Customers{}, http.StatusNotFound, nil
+	if err := cursor.Err(); err != nil {
+		return problem.From(err)
 	}
-	return customers, http.StatusOK, err
+	return nil
 }
 
 // GetCustomer returns a single Customer
-func GetCustomer(id string) (Customer, int, error) {
+func GetCustomer(ctx context.Context, id string) (Customer, error) {
 	var customer Customer
-	// get customer from cache
-	val, err := rdb.Get(ctx, id).Result()
-	if err == redis.Nil {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return customer, problem.Validation("invalid_id", "invalid customer id")
+	}
+	found, err := cached.Get(ctx, id, &customer, func(ctx context.Context) (interface{}, bool, error) {
 		// customer not in cache
-		objectID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return customer, http.StatusBadRequest, err
+		var stored Customer
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		err := collection.FindOne(dbCtx, live(bson.M{"_id": objectID})).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			return nil, false, nil
 		}
-		filter := bson.M{"_id": objectID}
-		err = collection.FindOne(ctx, filter).Decode(&customer)
-		if err != nil {
-			return customer, http.StatusNotFound, err
+		return stored, err == nil, err
+	})
+	if err != nil {
+		return customer, problem.From(err)
+	}
+	if !found {
+		return customer, problem.NotFound("customer_not_found", "customer not found")
+	}
+	return customer, nil
+}
+
+// MaxBatch is the maximum number of Customers looked up at once
+const MaxBatch = 1000
+
+// GetCustomersByIDs returns the Customers with the given IDs in the order of ids,
+// the missing ones are left out. The cached Customers are read from the cache and
+// the others from the database in a single query.
+func GetCustomersByIDs(ctx context.Context, ids []string) (Customers, error) {
+	customers := Customers{}
+	if len(ids) > MaxBatch {
+		return customers, problem.Validation("batch_too_large", fmt.Sprintf("at most %d customers can be looked up at once", MaxBatch))
+	}
+	for _, id := range ids {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			return customers, problem.Validation("invalid_id", "invalid customer id "+id)
+		}
+	}
+	found := make(map[string]Customer, len(ids))
+	err := cached.GetMany(ctx, ids, func(ctx context.Context, missing []string) (map[string]interface{}, error) {
+		// customers not in cache
+		objectIDs := make(bson.A, len(missing))
+		for i, id := range missing {
+			objectIDs[i], _ = primitive.ObjectIDFromHex(id)
 		}
-		// set customer in cache for 5 minutes
-		err = rdb.Set(ctx, id, customer, time.Minute*5).Err()
+		var stored Customers
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		cursor, err := collection.Find(dbCtx, live(bson.M{"_id": bson.M{"$in": objectIDs}}))
 		if err != nil {
-			return customer, http.StatusInternalServerError, err
+			return nil, err
 		}
-		return customer, http.StatusOK, err
-	} else if err != nil {
-		return customer, http.StatusInternalServerError, err
+		if err := cursor.All(dbCtx, &stored); err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(stored))
+		for _, customer := range stored {
+			values[customer.ID.Hex()] = customer
+		}
+		return values, nil
+	}, func(id string, data []byte) error {
+		var customer Customer
+		if err := json.Unmarshal(data, &customer); err != nil {
+			return err
+		}
+		found[id] = customer
+		return nil
+	})
+	if err != nil {
+		return customers, problem.From(err)
+	}
+	for _, id := range ids {
+		if customer, ok := found[id]; ok {
+			customers = append(customers, customer)
+			// every Customer is returned once
+			delete(found, id)
+		}
+	}
+	return customers, nil
+}
+
+// UpdateCustomer replaces a Customer by ID, version is the version the Customer is
+// expected to be at, 0 updates whatever its current version. actor is the
+// user updating it.
+func UpdateCustomer(ctx context.Context, id string, customer Customer, version int64, actor string) (Customer, error) {
+	return replaceCustomer(ctx, id, version, actor, func(current Customer) (Customer, error) {
+		return customer, nil
+	})
+}
+
+// PatchCustomer applies a JSON merge patch to a Customer by ID, version is the
+// version the Customer is expected to be at, 0 patches whatever its current
+// version. actor is the user patching it.
+func PatchCustomer(ctx context.Context, id string, p []byte, version int64, actor string) (Customer, error) {
+	return replaceCustomer(ctx, id, version, actor, func(current Customer) (Customer, error) {
+		customer := current
+		err := patch.Apply(&customer, p)
+		return customer, err
+	})
+}
+
+// replaceCustomer stores the Customer change makes of the current one in its place,
+// the fields managed by the service cannot be changed. The current Customer is
+// read in the transaction so that version is compared with the stored one
+// rather than a cached copy.
+func replaceCustomer(ctx context.Context, id string, version int64, actor string, change func(current Customer) (Customer, error)) (Customer, error) {
+	var customer Customer
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return customer, problem.Validation("invalid_id", "invalid customer id")
 	}
-	// customer in cache
-	err = json.Unmarshal([]byte(val), &customer)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		var current Customer
+		err := collection.FindOne(ctx, live(bson.M{"_id": objectID})).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("customer_not_found", "customer not found")
+		} else if err != nil {
+			return err
+		}
+		if version != 0 && current.Version != version {
+			return problem.PreconditionFailed("version_mismatch", fmt.Sprintf("customer is at version %d", current.Version))
+		}
+		if customer, err = change(current); err != nil {
+			return err
+		}
+		if !customer.ID.IsZero() && customer.ID != current.ID {
+			return problem.Validation("immutable_field", "id cannot be changed")
+		}
+		if customer.CreatedAt != "" && customer.CreatedAt != current.CreatedAt {
+			return problem.Validation("immutable_field", "created_at cannot be changed")
+		}
+		if customer.DeletedAt != current.DeletedAt || customer.DeletedBy != current.DeletedBy {
+			return problem.Validation("immutable_field", "deleted_at and deleted_by cannot be changed")
+		}
+		if strings.TrimSpace(customer.Name) == "" {
+			return problem.Validation("missing_field", "name is required")
+		}
+		customer.ID = current.ID
+		customer.CreatedAt = current.CreatedAt
+		customer.Version = current.Version + 1
+		customer.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		customer.SearchGrams = search.Grams(customer.Name)
+		// the version in the filter makes the update fail if the customer
+		// changed since it was read when there are no transactions
+		res, err := collection.UpdateOne(ctx, live(bson.M{"_id": current.ID, "version": versionFilter(current.Version)}), bson.M{"$set": customer})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			if version != 0 {
+				return problem.PreconditionFailed("version_mismatch", "customer was modified concurrently")
+			}
+			return problem.Conflict("concurrent_update", "customer was modified concurrently")
+		}
+		return record(ctx, history.Updated, actor, current, customer)
+	})
 	if err != nil {
-		return customer, http.StatusInternalServerError, err
+		return customer, problem.From(err)
 	}
-	return customer, http.StatusOK, err
+	box.Notify()
+	cached.Set(ctx, customer.ID.Hex(), customer)
+	return customer, nil
 }
 
-// UpdateCustomer updates a single Customer
-func UpdateCustomer(id string, customer Customer) (Customer, int, error) {
-	// check if supplier exists
-	_, status, err := GetCustomer(id)
+// The policies applied to the Orders referencing a deleted Customer
+const (
+	// RestrictOrders refuses to delete a Customer referenced by Orders
+	RestrictOrders = "restrict"
+	// CascadeOrders moves the Orders of a deleted Customer to the trash with it
+	CascadeOrders = "cascade"
+	// ReassignOrders moves the Orders of a deleted Customer to another Customer
+	ReassignOrders = "reassign"
+)
+
+// DeleteCustomer moves a Customer to the trash, actor is the user deleting it. The
+// Orders referencing it are then handled according to policy, reassignTo is the
+// Customer they are moved to with ReassignOrders. The customer goes to the trash first so
+// that no Order can reference it meanwhile, and it is restored when the Orders
+// cannot be handled.
+func DeleteCustomer(ctx context.Context, id string, actor string, policy string, reassignTo string, grpcOrderClient pb.OrderServiceClient) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return customer, status, err
+		return problem.Validation("invalid_id", "invalid customer id")
 	}
-	customerID, err := primitive.ObjectIDFromHex(id)
+	if err := checkOrdersPolicy(ctx, id, policy, reassignTo); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		// the change is recorded from the customer as it was when deleted
+		var deleted Customer
+		err := collection.FindOneAndUpdate(ctx, live(bson.M{"_id": objectID}), bson.M{
+			"$set": bson.M{"deleted_at": now, "deleted_by": actor, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("customer_not_found", "customer not found")
+		} else if err != nil {
+			return err
+		}
+		before := deleted
+		deleted.DeletedAt = now
+		deleted.DeletedBy = actor
+		deleted.UpdatedAt = now
+		deleted.Version++
+		if err := figures.Add(ctx, map[string]float64{"count": -1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Deleted, actor, before, deleted)
+	})
 	if err != nil {
-		return customer, http.StatusBadRequest, err
+		return problem.From(err)
+	}
+	box.Notify()
+	cached.Delete(ctx, id)
+	figures.Invalidate(ctx)
+
+	if err := applyOrdersPolicy(ctx, id, policy, reassignTo, grpcOrderClient); err != nil {
+		// the customer comes back along with the orders moved to the trash with
+		// it, even when the request timed out
+		if _, rerr := RestoreCustomer(middleware.Detach(ctx), id, actor, grpcOrderClient); rerr != nil {
+			log.Printf("cannot restore customer %s after failing to handle its orders: %s", id, rerr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// checkOrdersPolicy checks that policy can be applied to the Orders of the
+// Customer id before it is deleted
+func checkOrdersPolicy(ctx context.Context, id string, policy string, reassignTo string) error {
+	switch policy {
+	case RestrictOrders, CascadeOrders:
+		return nil
+	case ReassignOrders:
+		if reassignTo == "" {
+			return problem.Validation("missing_reassign_to", "reassign_to is required to reassign the orders of the customer")
+		}
+		if reassignTo == id {
+			return problem.Validation("invalid_reassign_to", "the orders cannot be reassigned to the deleted customer")
+		}
+		// check if the customer the orders move to exists
+		_, err := GetCustomer(ctx, reassignTo)
+		return err
+	}
+	return problem.Internal(fmt.Errorf("unknown orders policy %q", policy))
+}
+
+// applyOrdersPolicy handles the Orders referencing the Customer id once it is in
+// the trash, the calls to the orders service carry the token of the caller
+// from ctx
+func applyOrdersPolicy(ctx context.Context, id string, policy string, reassignTo string, grpcOrderClient pb.OrderServiceClient) error {
+	var err error
+	switch policy {
+	case RestrictOrders:
+		var res *pb.OrdersCount
+		res, err = grpcOrderClient.CountOrdersByCustomer(ctx, &pb.Customer{Id: id})
+		if err == nil && res.Count > 0 {
+			return problem.Referenced("customer_has_orders", fmt.Sprintf("customer is referenced by %d orders", res.Count)).WithCount(res.Count)
+		}
+	case CascadeOrders:
+		_, err = grpcOrderClient.DeleteOrdersByCustomer(ctx, &pb.Customer{Id: id})
+	case ReassignOrders:
+		_, err = grpcOrderClient.ReassignOrdersByCustomer(ctx, &pb.Reassignment{FromId: id, ToId: reassignTo})
 	}
-	customer.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": customerID}, bson.M{"$set": customer})
 	if err != nil {
-		return customer, http.StatusInternalServerError, err
+		return problem.From(err)
 	}
-	// set customer in cache for 5 minutes
-	err = rdb.Set(ctx, id, customer, time.Minute*5).Err()
+	return nil
+}
+
+// GetDeletedCustomers returns the Customers in the trash, most recently deleted first
+func GetDeletedCustomers(ctx context.Context) (Customers, error) {
+	customers := Customers{}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, bson.M{"deleted_at": bson.M{"$exists": true}}, options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}))
 	if err != nil {
-		return customer, http.StatusInternalServerError, err
+		return customers, problem.From(err)
+	}
+	if err := cursor.All(dbCtx, &customers); err != nil {
+		return customers, problem.From(err)
 	}
-	return customer, http.StatusOK, err
+	return customers, nil
 }
 
-// DeleteCustomer deletes a single Customer
-func DeleteCustomer(id string) (int, error) {
-	// check if customer exists
-	_, status, err := GetCustomer(id)
+// RestoreCustomer moves a Customer out of the trash along with the Orders deleted
+// with it through grpcOrderClient, actor is the user restoring it
+func RestoreCustomer(ctx context.Context, id string, actor string, grpcOrderClient pb.OrderServiceClient) (Customer, error) {
+	var customer Customer
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return status, err
+		return customer, problem.Validation("invalid_id", "invalid customer id")
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	deleted := bson.M{"_id": objectID, "deleted_at": bson.M{"$exists": true}}
+	err = collection.FindOne(dbCtx, deleted).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		return customer, problem.NotFound("customer_not_in_trash", "customer not found in the trash")
+	} else if err != nil {
+		return customer, problem.From(err)
 	}
-	customerID, err := primitive.ObjectIDFromHex(id)
+	// the orders come back first so that a failed restore can be retried
+	_, err = grpcOrderClient.RestoreOrdersByCustomer(ctx, &pb.Customer{Id: id})
 	if err != nil {
-		return http.StatusBadRequest, err
+		return customer, problem.From(err)
 	}
-	_, err = collection.DeleteOne(ctx, bson.M{"_id": customerID})
+	before := customer
+	customer.DeletedAt = ""
+	customer.DeletedBy = ""
+	customer.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	deleted["version"] = versionFilter(customer.Version)
+	customer.Version++
+	err = transact(dbCtx, func(ctx context.Context) error {
+		res, err := collection.UpdateOne(ctx, deleted, bson.M{
+			"$set":   bson.M{"updated_at": customer.UpdatedAt, "version": customer.Version},
+			"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return problem.Conflict("concurrent_update", "customer was modified concurrently")
+		}
+		if err := figures.Add(ctx, map[string]float64{"count": 1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Restored, actor, before, customer)
+	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return customer, problem.From(err)
 	}
-	// remove customer from cache
-	err = rdb.Del(ctx, id).Err()
+	box.Notify()
+	// the customer may be cached as not found since it was deleted
+	cached.Set(ctx, id, customer)
+	figures.Invalidate(ctx)
+	return customer, nil
+}
+
+// PurgeCustomers permanently deletes the Customers in the trash for longer than
+// retention
+func PurgeCustomers(ctx context.Context, retention time.Duration) error {
+	cutoff := time.Now().UTC().Add(-retention).Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	res, err := collection.DeleteMany(dbCtx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return err
+	}
+	if res.DeletedCount > 0 {
+		log.Printf("purged %d customers from the trash", res.DeletedCount)
 	}
-	return http.StatusOK, err
+	return nil
+}
+
+// CountCustomers returns the number of Customers, out of the trash
+func CountCustomers(ctx context.Context) (int64, error) {
+	counts, err := figures.Get(ctx)
+	if err != nil {
+		return 0, problem.From(err)
+	}
+	return int64(counts["count"]), nil
+}
+
+// RecomputeStats counts the Customers again to correct the drift of the counters
+// kept along with the changes
+func RecomputeStats(ctx context.Context) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	if err := transact(dbCtx, figures.Recompute); err != nil {
+		return err
+	}
+	figures.Invalidate(ctx)
+	return nil
+}
+
+// GetCustomerHistory returns the changes made to a Customer by ID, most recent first
+func GetCustomerHistory(ctx context.Context, id string) (history.Entries, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return history.Entries{}, problem.Validation("invalid_id", "invalid customer id")
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, history.Query{RecordID: id, Limit: history.MaxLimit})
+}
+
+// GetCustomerAudit returns the changes made to the Customers matching q, most recent
+// first
+func GetCustomerAudit(ctx context.Context, q history.Query) (history.Entries, error) {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, q)
 }
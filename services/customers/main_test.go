@@ -0,0 +1,788 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/Omar-Belghaouti/pdash/services/customers/api"
+	"github.com/Omar-Belghaouti/pdash/services/customers/data"
+	"github.com/Omar-Belghaouti/pdash/services/customers/util"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// orderServer pretends customers have the orders in counts and records the
+// orders policies applied to them
+type orderServer struct {
+	pb.UnimplementedOrderServiceServer
+	mu      sync.Mutex
+	counts  map[string]int64
+	applied []string
+}
+
+func (s *orderServer) CountOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) DeleteOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "delete "+in.Id+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) RestoreOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "restore "+in.Id+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) ReassignOrdersByCustomer(ctx context.Context, in *pb.Reassignment) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "reassign "+in.FromId+" to "+in.ToId+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.FromId]}, nil
+}
+
+type testEnv struct {
+	app         *fiber.App
+	mr          *miniredis.Miniredis
+	client      pb.CustomerServiceClient
+	authClient  pb.AuthServiceClient
+	authServer  *grpc.Server
+	orders      *orderServer
+	orderClient pb.OrderServiceClient
+}
+
+func setup(t *testing.T) testEnv {
+	t.Helper()
+	mr, rdb := testutil.Redis(t)
+	data.Use(memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), rdb)
+
+	auth, authClient := testutil.Auth(t)
+
+	orders := &orderServer{counts: map[string]int64{}}
+	ordersServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.Authenticate(authClient)))
+	pb.RegisterOrderServiceServer(ordersServer, orders)
+
+	config := util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.RestrictOrders}
+	orderClient := pb.NewOrderServiceClient(testutil.ServeGRPC(t, ordersServer))
+	return testEnv{
+		app:         api.NewApp(config, authClient, orderClient),
+		mr:          mr,
+		client:      pb.NewCustomerServiceClient(testutil.ServeGRPC(t, api.NewGRPCServer(config.OrdersOnDelete, authClient, orderClient))),
+		authClient:  authClient,
+		authServer:  auth,
+		orders:      orders,
+		orderClient: orderClient,
+	}
+}
+
+// cacheKey is the Redis key of the cached customer id
+func cacheKey(id string) string {
+	return "customers:v1:" + id
+}
+
+func (env testEnv) request(t *testing.T, method, target string, body interface{}) (int, []byte) {
+	t.Helper()
+	res, b := testutil.Request(t, env.app, method, target, body, "Authorization", "Bearer "+testutil.Token)
+	return res.StatusCode, b
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	env := setup(t)
+	tests := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"malformed header", testutil.Token, http.StatusUnauthorized},
+		{"invalid token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer " + testutil.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, env.app, http.MethodGet, "/customers", nil, "Authorization", tt.header)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+		}
+	}
+
+	env.authServer.Stop()
+	res, body := testutil.Request(t, env.app, http.MethodGet, "/customers", nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("auth down: expected 503, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestCustomerCRUD(t *testing.T) {
+	env := setup(t)
+	ctx := context.Background()
+
+	code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	id := customer.ID.Hex()
+
+	code, body = env.request(t, http.MethodGet, "/customers", nil)
+	var customers struct {
+		Items data.Customers `json:"items"`
+	}
+	json.Unmarshal(body, &customers)
+	if code != http.StatusOK || len(customers.Items) != 1 {
+		t.Fatalf("list: expected 1 customer, got %d: %s", code, body)
+	}
+	for filter, expected := range map[string]int{`name = "Omar"`: 1, `name != "Omar" or version > 1`: 0} {
+		code, body = env.request(t, http.MethodGet, "/customers?filter="+url.QueryEscape(filter), nil)
+		json.Unmarshal(body, &customers)
+		if code != http.StatusOK || len(customers.Items) != expected {
+			t.Fatalf("list %s: expected %d customers, got %d: %s", filter, expected, code, body)
+		}
+	}
+
+	code, body = env.request(t, http.MethodGet, "/customers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d: %s", code, body)
+	}
+	if !env.mr.Exists(cacheKey(id)) {
+		t.Fatal("customer should be cached after a get")
+	}
+
+	code, body = env.request(t, http.MethodPut, "/customers/"+id, data.Customer{Name: "Belghaouti"})
+	if code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", code, body)
+	}
+	res, err := env.client.GetCustomer(ctx, &pb.Customer{Id: id})
+	if err != nil || res.Name != "Belghaouti" {
+		t.Fatalf("grpc get: expected updated customer, got %v (%v)", res, err)
+	}
+
+	code, body = env.request(t, http.MethodDelete, "/customers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	if env.mr.Exists(cacheKey(id)) {
+		t.Fatal("customer should be evicted from the cache after a delete")
+	}
+	code, _ = env.request(t, http.MethodGet, "/customers/"+id, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("get deleted: expected 404, got %d", code)
+	}
+	_, err = env.client.GetCustomer(ctx, &pb.Customer{Id: id})
+	if status.Code(err) != codes.NotFound || problem.From(err).Code != "customer_not_found" {
+		t.Fatalf("grpc get deleted: expected NotFound, got %v", err)
+	}
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	env := setup(t)
+	app := api.NewApp(util.Config{RequestTimeout: 5 * time.Second, RequireIfMatch: true, OrdersOnDelete: data.RestrictOrders}, env.authClient, env.orderClient)
+	auth := []string{"Authorization", "Bearer " + testutil.Token}
+
+	res, body := testutil.Request(t, app, http.MethodPost, "/customers", data.Customer{Name: "Omar"}, auth...)
+	if res.StatusCode != http.StatusCreated || res.Header.Get(fiber.HeaderETag) != `"1"` {
+		t.Fatalf("create: expected 201 with ETag \"1\", got %d %s: %s", res.StatusCode, res.Header.Get(fiber.HeaderETag), body)
+	}
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	target := "/customers/" + customer.ID.Hex()
+
+	tests := []struct {
+		name     string
+		ifMatch  string
+		expected int
+		etag     string
+		code     string
+	}{
+		{"missing If-Match", "", http.StatusPreconditionRequired, "", "if_match_required"},
+		{"current version", `"1"`, http.StatusOK, `"2"`, ""},
+		{"stale version", `"1"`, http.StatusPreconditionFailed, "", "version_mismatch"},
+		{"any version", "*", http.StatusOK, `"3"`, ""},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodPut, target, data.Customer{Name: tt.name}, append(auth, fiber.HeaderIfMatch, tt.ifMatch)...)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != tt.expected || res.Header.Get(fiber.HeaderETag) != tt.etag || p.Code != tt.code {
+			t.Errorf("%s: expected %d %s %s, got %d %s: %s", tt.name, tt.expected, tt.etag, tt.code, res.StatusCode, res.Header.Get(fiber.HeaderETag), body)
+		}
+		var updated data.Customer
+		if json.Unmarshal(body, &updated); tt.expected == http.StatusOK && (updated.Name != tt.name || `"`+strconv.FormatInt(updated.Version, 10)+`"` != tt.etag) {
+			t.Errorf("%s: expected the updated customer, got %s", tt.name, body)
+		}
+	}
+
+	res, body = testutil.Request(t, app, http.MethodGet, target, nil, auth...)
+	json.Unmarshal(body, &customer)
+	if res.Header.Get(fiber.HeaderETag) != `"3"` || customer.Version != 3 || customer.Name != "any version" {
+		t.Errorf("get: expected the third version, got %s: %s", res.Header.Get(fiber.HeaderETag), body)
+	}
+	grpcCustomer, err := env.client.GetCustomer(context.Background(), &pb.Customer{Id: customer.ID.Hex()})
+	if err != nil || grpcCustomer.Version != 3 {
+		t.Errorf("grpc get: expected version 3, got %v (%v)", grpcCustomer, err)
+	}
+
+	// updates without If-Match are accepted when it is not required
+	code, body := env.request(t, http.MethodPut, target, data.Customer{Name: "Omar"})
+	if code != http.StatusOK {
+		t.Errorf("update without If-Match: expected 200, got %d: %s", code, body)
+	}
+}
+
+func TestPatchCustomer(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var created data.Customer
+	json.Unmarshal(body, &created)
+	target := "/customers/" + created.ID.Hex()
+
+	res, body := testutil.Request(t, env.app, http.MethodPatch, target, json.RawMessage(`{"name":"Belghaouti"}`), "Authorization", "Bearer "+testutil.Token, fiber.HeaderContentType, patch.ContentType)
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	if res.StatusCode != http.StatusOK || customer.Name != "Belghaouti" || customer.ID != created.ID || customer.CreatedAt != created.CreatedAt || customer.Version != 2 {
+		t.Fatalf("patch: expected the renamed customer at version 2, got %d: %s", res.StatusCode, body)
+	}
+	res, body = testutil.Request(t, env.app, http.MethodPatch, target, json.RawMessage(`{"id":"`+primitive.NewObjectID().Hex()+`"}`), "Authorization", "Bearer "+testutil.Token, fiber.HeaderContentType, patch.ContentType)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if res.StatusCode != http.StatusBadRequest || p.Code != "immutable_field" {
+		t.Fatalf("patch id: expected 400 immutable_field, got %d: %s", res.StatusCode, body)
+	}
+	grpcCustomer, err := env.client.GetCustomer(context.Background(), &pb.Customer{Id: created.ID.Hex()})
+	if err != nil || grpcCustomer.Name != "Belghaouti" || grpcCustomer.CreatedAt != created.CreatedAt {
+		t.Fatalf("grpc get: expected the patched customer, got %v (%v)", grpcCustomer, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	target := "/customers/" + customer.ID.Hex()
+	env.request(t, http.MethodPut, target, data.Customer{Name: "Belghaouti"})
+	env.request(t, http.MethodDelete, target, nil)
+	env.request(t, http.MethodPost, target+"/restore", nil)
+
+	code, body = env.request(t, http.MethodGet, target+"/history", nil)
+	var entries history.Entries
+	json.Unmarshal(body, &entries)
+	var actions []string
+	for _, e := range entries {
+		if e.Actor != testutil.User || e.RecordID != customer.ID.Hex() || e.Entity != "customer" {
+			t.Errorf("history: unexpected entry %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(actions, []string{history.Restored, history.Deleted, history.Updated, history.Created}) {
+		t.Fatalf("history: expected restore, delete, update and create, got %d: %s", code, body)
+	}
+	if rename := entries[2]; rename.Version != 2 || !reflect.DeepEqual(rename.Changes, []history.Change{{Field: "name", Before: "Omar", After: "Belghaouti"}}) {
+		t.Errorf("history: expected the rename at version 2, got %+v", rename)
+	}
+
+	// every change is stored in the outbox with its event and published once
+	// the outbox is relayed
+	if msgs, _ := env.mr.Stream(events.Stream("customer")); len(msgs) != 0 {
+		t.Errorf("events: expected no event before the relay")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go data.RelayOutbox(ctx, 10*time.Millisecond)
+	var types []string
+	testutil.Eventually(2*time.Second, func() bool {
+		msgs, _ := env.mr.Stream(events.Stream("customer"))
+		types = nil
+		for _, msg := range msgs {
+			for i := 0; i+1 < len(msg.Values); i += 2 {
+				if msg.Values[i] == "type" {
+					types = append(types, msg.Values[i+1])
+				}
+			}
+		}
+		return len(types) >= 4
+	})
+	if !reflect.DeepEqual(types, []string{"customer.created", "customer.updated", "customer.deleted", "customer.restored"}) {
+		t.Errorf("events: expected the 4 changes, got %v", types)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+		entries  int
+	}{
+		{"", http.StatusOK, 4},
+		{"?action=update", http.StatusOK, 1},
+		{"?actor=nobody", http.StatusOK, 0},
+		{"?record_id=" + customer.ID.Hex() + "&limit=2", http.StatusOK, 2},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		code, body := env.request(t, http.MethodGet, "/customers/audit"+tt.query, nil)
+		entries := history.Entries{}
+		json.Unmarshal(body, &entries)
+		if code != tt.expected || len(entries) != tt.entries {
+			t.Errorf("audit %s: expected %d with %d entries, got %d: %s", tt.query, tt.expected, tt.entries, code, body)
+		}
+	}
+}
+
+func TestGetCustomerInvalidID(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodGet, "/customers/not-an-id", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusBadRequest || p.Code != "invalid_id" {
+		t.Fatalf("expected 400 invalid_id, got %d: %s", code, body)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	env := setup(t)
+	ctx := context.Background()
+	code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	id := customer.ID.Hex()
+
+	code, body = env.request(t, http.MethodDelete, "/customers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/customers/trash", nil)
+	var trash data.Customers
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 1 || trash[0].DeletedBy != testutil.User || trash[0].DeletedAt == "" {
+		t.Fatalf("trash: expected the deleted customer, got %d: %s", code, body)
+	}
+	code, _ = env.request(t, http.MethodPut, "/customers/"+id, data.Customer{Name: "Belghaouti"})
+	if code != http.StatusNotFound {
+		t.Fatalf("update deleted: expected 404, got %d", code)
+	}
+
+	code, body = env.request(t, http.MethodPost, "/customers/"+id+"/restore", nil)
+	json.Unmarshal(body, &customer)
+	if code != http.StatusOK || customer.DeletedAt != "" || customer.Version != 3 {
+		t.Fatalf("restore: expected the customer at version 3, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodPost, "/customers/"+id+"/restore", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusNotFound || p.Code != "customer_not_in_trash" {
+		t.Fatalf("restore twice: expected 404 customer_not_in_trash, got %d: %s", code, body)
+	}
+	if _, err := env.client.GetCustomer(ctx, &pb.Customer{Id: id}); err != nil {
+		t.Fatalf("grpc get restored: %v", err)
+	}
+
+	// only the customers in the trash for longer than the retention are purged
+	env.request(t, http.MethodDelete, "/customers/"+id, nil)
+	if err := data.PurgeCustomers(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, body = env.request(t, http.MethodGet, "/customers/trash", nil)
+	json.Unmarshal(body, &trash)
+	if len(trash) != 1 {
+		t.Fatalf("purge: expected the customer to stay in the trash, got %s", body)
+	}
+	if err := data.PurgeCustomers(ctx, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	_, body = env.request(t, http.MethodGet, "/customers/trash", nil)
+	json.Unmarshal(body, &trash)
+	if len(trash) != 0 {
+		t.Fatalf("purge: expected an empty trash, got %s", body)
+	}
+	code, _ = env.request(t, http.MethodPost, "/customers/"+id+"/restore", nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("restore purged: expected 404, got %d", code)
+	}
+}
+
+func TestDeletePolicies(t *testing.T) {
+	env := setup(t)
+	create := func() string {
+		code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var customer data.Customer
+		json.Unmarshal(body, &customer)
+		env.orders.mu.Lock()
+		env.orders.counts[customer.ID.Hex()] = 2
+		env.orders.mu.Unlock()
+		return customer.ID.Hex()
+	}
+
+	// restrict refuses to delete a customer with orders
+	id := create()
+	code, body := env.request(t, http.MethodDelete, "/customers/"+id, nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusConflict || p.Code != "customer_has_orders" || p.Count != 2 {
+		t.Fatalf("restrict: expected 409 customer_has_orders with 2 orders, got %d: %s", code, body)
+	}
+	if code, _ := env.request(t, http.MethodGet, "/customers/"+id, nil); code != http.StatusOK {
+		t.Errorf("restrict: expected the customer to be restored, got %d", code)
+	}
+	if !reflect.DeepEqual(env.orders.applied, []string{"restore " + id + " by " + testutil.User}) {
+		t.Errorf("restrict: expected the customer to be restored along with its orders, got %v", env.orders.applied)
+	}
+
+	// cascade moves the orders to the trash on behalf of the caller
+	env.orders.applied = nil
+	app := api.NewApp(util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.CascadeOrders}, env.authClient, env.orderClient)
+	res, body := testutil.Request(t, app, http.MethodDelete, "/customers/"+id, nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusOK || !reflect.DeepEqual(env.orders.applied, []string{"delete " + id + " by " + testutil.User}) {
+		t.Fatalf("cascade: expected the orders to be deleted, got %d: %s (%v)", res.StatusCode, body, env.orders.applied)
+	}
+
+	// restoring the customer brings its orders back too
+	env.orders.applied = nil
+	res, body = testutil.Request(t, app, http.MethodPost, "/customers/"+id+"/restore", nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusOK || !reflect.DeepEqual(env.orders.applied, []string{"restore " + id + " by " + testutil.User}) {
+		t.Fatalf("cascade: expected the orders to be restored, got %d: %s (%v)", res.StatusCode, body, env.orders.applied)
+	}
+
+	// reassign moves the orders to an existing customer
+	app = api.NewApp(util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.ReassignOrders}, env.authClient, env.orderClient)
+	from, to := create(), create()
+	env.orders.applied = nil
+	tests := []struct {
+		query    string
+		expected int
+		code     string
+	}{
+		{"", http.StatusBadRequest, "missing_reassign_to"},
+		{"?reassign_to=" + from, http.StatusBadRequest, "invalid_reassign_to"},
+		{"?reassign_to=" + primitive.NewObjectID().Hex(), http.StatusNotFound, "customer_not_found"},
+		{"?reassign_to=" + to, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodDelete, "/customers/"+from+tt.query, nil, "Authorization", "Bearer "+testutil.Token)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != tt.expected || p.Code != tt.code {
+			t.Errorf("reassign %q: expected %d %s, got %d: %s", tt.query, tt.expected, tt.code, res.StatusCode, body)
+		}
+	}
+	if !reflect.DeepEqual(env.orders.applied, []string{"reassign " + from + " to " + to + " by " + testutil.User}) {
+		t.Errorf("reassign: expected the orders to be reassigned once, got %v", env.orders.applied)
+	}
+}
+
+func TestCustomerGRPC(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+
+	// changes are only made on behalf of a verified caller
+	if _, err := env.client.CreateCustomer(context.Background(), &pb.Customer{Name: "Acme"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("create without a token: expected Unauthenticated, got %v", err)
+	}
+
+	var ids []string
+	for _, name := range []string{"Omar", "Belghaouti"} {
+		created, err := env.client.CreateCustomer(ctx, &pb.Customer{Name: name})
+		if err != nil || created.Name != name || created.Version != 1 {
+			t.Fatalf("create: expected the customer, got %v (%v)", created, err)
+		}
+		ids = append(ids, created.Id)
+	}
+
+	stream, err := env.client.GetAllCustomers(ctx, &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []string
+	for {
+		customer, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("stream: %s", err)
+		}
+		streamed = append(streamed, customer.Id)
+	}
+	if !reflect.DeepEqual(streamed, ids) {
+		t.Fatalf("stream: expected %v, got %v", ids, streamed)
+	}
+
+	updated, err := env.client.UpdateCustomer(ctx, &pb.Customer{Id: ids[0], Name: "Omar B", Version: 1})
+	if err != nil || updated.Name != "Omar B" || updated.Version != 2 {
+		t.Fatalf("update: expected the customer at version 2, got %v (%v)", updated, err)
+	}
+
+	env.orders.mu.Lock()
+	env.orders.counts[ids[1]] = 1
+	env.orders.mu.Unlock()
+	failures := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"missing name", func() error {
+			_, err := env.client.CreateCustomer(ctx, &pb.Customer{})
+			return err
+		}, codes.InvalidArgument},
+		{"stale update", func() error {
+			_, err := env.client.UpdateCustomer(ctx, &pb.Customer{Id: ids[0], Name: "Omar", Version: 1})
+			return err
+		}, codes.FailedPrecondition},
+		{"invalid id", func() error {
+			_, err := env.client.UpdateCustomer(ctx, &pb.Customer{Id: "nope", Name: "Omar"})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown customer", func() error {
+			_, err := env.client.DeleteCustomer(ctx, &pb.Customer{Id: primitive.NewObjectID().Hex()})
+			return err
+		}, codes.NotFound},
+		{"customer with orders", func() error {
+			_, err := env.client.DeleteCustomer(ctx, &pb.Customer{Id: ids[1]})
+			return err
+		}, codes.FailedPrecondition},
+	}
+	for _, tt := range failures {
+		if code := status.Code(tt.call()); code != tt.code {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.code, code)
+		}
+	}
+
+	deleted, err := env.client.DeleteCustomer(ctx, &pb.Customer{Id: ids[0]})
+	if err != nil || deleted.Id != ids[0] {
+		t.Fatalf("delete: expected the deleted customer, got %v (%v)", deleted, err)
+	}
+	code, body := env.request(t, http.MethodGet, "/customers/trash", nil)
+	var trash data.Customers
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 1 || trash[0].DeletedBy != testutil.User {
+		t.Fatalf("trash: expected the customer deleted by %s, got %d: %s", testutil.User, code, body)
+	}
+}
+
+func TestCustomerCache(t *testing.T) {
+	env := setup(t)
+	missing := primitive.NewObjectID().Hex()
+	for i := 0; i < 2; i++ {
+		code, body := env.request(t, http.MethodGet, "/customers/"+missing, nil)
+		if code != http.StatusNotFound {
+			t.Fatalf("get missing %d: expected 404, got %d: %s", i, code, body)
+		}
+	}
+	if !env.mr.Exists(cacheKey(missing)) {
+		t.Fatal("a missing customer should be cached as not found")
+	}
+
+	code, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var customer data.Customer
+	json.Unmarshal(body, &customer)
+	id := customer.ID.Hex()
+
+	// the customers are read from the database while Redis is down
+	env.mr.Close()
+	code, body = env.request(t, http.MethodGet, "/customers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get with Redis down: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodPut, "/customers/"+id, data.Customer{Name: "Belghaouti"})
+	if code != http.StatusOK {
+		t.Fatalf("update with Redis down: expected 200, got %d: %s", code, body)
+	}
+	res, err := env.client.BatchGetCustomers(context.Background(), &pb.Ids{Ids: []string{id}})
+	if err != nil || len(res.Customers) != 1 || res.Customers[0].Name != "Belghaouti" {
+		t.Fatalf("batch get with Redis down: expected the updated customer, got %v (%v)", res, err)
+	}
+}
+
+func TestCountCustomers(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	count := func(expected int64) {
+		t.Helper()
+		res, err := env.client.CountCustomers(ctx, &pb.Empty{})
+		if err != nil || res.Count != expected {
+			t.Fatalf("expected %d customers, got %v (%v)", expected, res, err)
+		}
+	}
+	var ids []string
+	for _, name := range []string{"Omar", "Belghaouti"} {
+		created, err := env.client.CreateCustomer(ctx, &pb.Customer{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.Id)
+	}
+	count(2)
+
+	// the changes are counted and the stored figures are dropped with them
+	if _, err := env.client.CreateCustomer(ctx, &pb.Customer{Name: "Ali"}); err != nil {
+		t.Fatal(err)
+	}
+	if env.mr.Exists("customers:stats") {
+		t.Fatal("expected the stored figures to be invalidated")
+	}
+	count(3)
+	if code, body := env.request(t, http.MethodDelete, "/customers/"+ids[0], nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	count(2)
+	if code, body := env.request(t, http.MethodPost, "/customers/"+ids[0]+"/restore", nil); code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", code, body)
+	}
+	count(3)
+}
+
+func TestBatchGetCustomers(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	var ids []string
+	for _, name := range []string{"Omar", "Belghaouti"} {
+		created, err := env.client.CreateCustomer(ctx, &pb.Customer{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.Id)
+	}
+	env.mr.FlushAll()
+
+	missing := primitive.NewObjectID().Hex()
+	for _, cached := range []bool{false, true} {
+		res, err := env.client.BatchGetCustomers(ctx, &pb.Ids{Ids: []string{ids[1], missing, ids[0], ids[1]}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, customer := range res.Customers {
+			got = append(got, customer.Id)
+		}
+		if expected := []string{ids[1], ids[0]}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("cached %t: expected %v, got %v", cached, expected, got)
+		}
+		for _, id := range ids {
+			if !env.mr.Exists(cacheKey(id)) {
+				t.Errorf("cached %t: expected %s to be cached", cached, id)
+			}
+		}
+	}
+
+	tooMany := make([]string, data.MaxBatch+1)
+	for i := range tooMany {
+		tooMany[i] = ids[0]
+	}
+	for name, batch := range map[string][]string{"invalid id": {ids[0], "nope"}, "too large": tooMany} {
+		if _, err := env.client.BatchGetCustomers(ctx, &pb.Ids{Ids: batch}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected %s, got %v", name, codes.InvalidArgument, err)
+		}
+	}
+}
+
+func TestSearchCustomers(t *testing.T) {
+	env := setup(t)
+	ids := map[string]string{}
+	for _, name := range []string{"Acme Corporation", "Acme Labs", "Globex", "Initech"} {
+		status, body := env.request(t, http.MethodPost, "/customers", data.Customer{Name: name})
+		if status != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", status, body)
+		}
+		var created data.Customer
+		json.Unmarshal(body, &created)
+		ids[name] = created.ID.Hex()
+	}
+	if status, body := env.request(t, http.MethodPut, "/customers/"+ids["Initech"], data.Customer{Name: "Umbrella"}); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+
+	tests := []struct {
+		q        string
+		expected []string
+	}{
+		{"acme", []string{"Acme Corporation", "Acme Labs"}},
+		{"acm lab", []string{"Acme Labs"}},
+		{"globx", []string{"Globex"}},
+		{"umbrela", []string{"Umbrella"}},
+		{"initech", nil},
+	}
+	for _, tt := range tests {
+		status, body := env.request(t, http.MethodGet, "/customers?q="+url.QueryEscape(tt.q), nil)
+		if status != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.q, status, body)
+		}
+		var hits struct{ Items []data.CustomerHit }
+		json.Unmarshal(body, &hits)
+		var names []string
+		for _, hit := range hits.Items {
+			names = append(names, hit.Name)
+		}
+		if !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.q, tt.expected, names)
+		}
+	}
+
+	status, body := env.request(t, http.MethodGet, "/customers?q=lab&fields=name", nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+	var selected struct{ Items []map[string]interface{} }
+	json.Unmarshal(body, &selected)
+	expected := map[string]interface{}{"id": ids["Acme Labs"], "name": "Acme Labs", "score": 0.8, "highlight": "Acme <mark>Lab</mark>s"}
+	if len(selected.Items) != 1 || !reflect.DeepEqual(selected.Items[0], expected) {
+		t.Errorf("expected %v, got %s", expected, body)
+	}
+
+	filtered := url.QueryEscape(`name != "Acme Labs"`)
+	if status, body := env.request(t, http.MethodGet, "/customers?q=acme&filter="+filtered, nil); status != http.StatusOK || bytes.Count(body, []byte(`"score"`)) != 1 {
+		t.Errorf("expected a hit, got %d: %s", status, body)
+	}
+	for _, target := range []string{"/customers?q=acme&sort=name", "/customers?q=%21%21"} {
+		if status, body := env.request(t, http.MethodGet, target, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", target, status, body)
+		}
+	}
+
+	res, err := env.client.SearchCustomers(context.Background(), &pb.SearchRequest{Q: "acme", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Customer.Name != "Acme Corporation" || res.Hits[0].Highlight != "<mark>Acme</mark> Corporation" {
+		t.Errorf("unexpected hits %v", res.Hits)
+	}
+}
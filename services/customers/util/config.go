@@ -0,0 +1,66 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config stores all configuration for the service
+type Config struct {
+	MongoURI         string        `mapstructure:"MONGO_URI"`
+	RedisAddr        string        `mapstructure:"REDIS_ADDR"`
+	RequestTimeout   time.Duration `mapstructure:"REQUEST_TIMEOUT"`
+	DBTimeout        time.Duration `mapstructure:"DB_TIMEOUT"`
+	CacheTimeout     time.Duration `mapstructure:"CACHE_TIMEOUT"`
+	CacheTTL         time.Duration `mapstructure:"CACHE_TTL"`
+	CacheNegativeTTL time.Duration `mapstructure:"CACHE_NEGATIVE_TTL"`
+	CacheLocalSize   int           `mapstructure:"CACHE_LOCAL_SIZE"`
+	CacheLocalTTL    time.Duration `mapstructure:"CACHE_LOCAL_TTL"`
+	StatsTTL         time.Duration `mapstructure:"STATS_TTL"`
+	StatsInterval    time.Duration `mapstructure:"STATS_INTERVAL"`
+	RPCTimeout       time.Duration `mapstructure:"RPC_TIMEOUT"`
+	TrustGateway     bool          `mapstructure:"TRUST_GATEWAY"`
+	IdempotencyTTL   time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
+	RequireIfMatch   bool          `mapstructure:"REQUIRE_IF_MATCH"`
+	TrashRetention   time.Duration `mapstructure:"TRASH_RETENTION"`
+	PurgeInterval    time.Duration `mapstructure:"PURGE_INTERVAL"`
+	OutboxInterval   time.Duration `mapstructure:"OUTBOX_INTERVAL"`
+	OrdersOnDelete   string        `mapstructure:"ORDERS_ON_DELETE"`
+}
+
+// LoadConfig loads the configuration from the given file, falling back to
+// the environment and defaults when the file does not exist
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	viper.SetDefault("MONGO_URI", "mongodb://mongo:27017/?replicaSet=rs0")
+	viper.SetDefault("REDIS_ADDR", "redis:6379")
+	viper.SetDefault("REQUEST_TIMEOUT", 10*time.Second)
+	viper.SetDefault("DB_TIMEOUT", 5*time.Second)
+	viper.SetDefault("CACHE_TIMEOUT", 500*time.Millisecond)
+	viper.SetDefault("CACHE_TTL", 5*time.Minute)
+	viper.SetDefault("CACHE_NEGATIVE_TTL", 30*time.Second)
+	viper.SetDefault("CACHE_LOCAL_SIZE", 0)
+	viper.SetDefault("CACHE_LOCAL_TTL", 30*time.Second)
+	viper.SetDefault("STATS_TTL", 10*time.Minute)
+	viper.SetDefault("STATS_INTERVAL", time.Hour)
+	viper.SetDefault("RPC_TIMEOUT", 3*time.Second)
+	viper.SetDefault("TRUST_GATEWAY", false)
+	viper.SetDefault("IDEMPOTENCY_TTL", 24*time.Hour)
+	viper.SetDefault("REQUIRE_IF_MATCH", false)
+	viper.SetDefault("TRASH_RETENTION", 30*24*time.Hour)
+	viper.SetDefault("PURGE_INTERVAL", time.Hour)
+	viper.SetDefault("OUTBOX_INTERVAL", 5*time.Second)
+	viper.SetDefault("ORDERS_ON_DELETE", "restrict")
+	viper.AddConfigPath(path)
+	viper.SetConfigFile(".env")
+	viper.AutomaticEnv()
+	err := viper.ReadInConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, err
+	}
+	err = viper.Unmarshal(&config)
+	return config, err
+}
@@ -37,8 +37,20 @@ export interface User {
   updated_at: string;
 }
 
-export interface OrdersEventData {
-  length: number;
+export interface Page<T> {
+  items: T[];
+  total: number;
+  next_cursor?: string;
+}
+
+export interface Stats {
+  customers: number;
+  suppliers: number;
+  orders: number;
+  revenue: number;
+  average_order_value: number;
+  orders_per_customer: number;
+  revenue_per_customer: number;
 }
 
 export interface EventMessage<T> {
@@ -11,7 +11,8 @@ import {
   EventMessage,
   Order,
   Orders,
-  OrdersEventData,
+  Page,
+  Stats,
   Supplier,
   Suppliers,
   User,
@@ -21,67 +22,49 @@ import { Signal, createResource, createSignal } from "solid-js";
 export const [token, setToken] = createStoredSignal("token", null);
 let ws = new WebSocket(ORDERS_WS_URL);
 ws.onmessage = (e) => {
-  const res: EventMessage<OrdersEventData> = JSON.parse(e.data);
-  if (res.event == "orders") {
-    setOrdersLength(res.data.length);
+  const res: EventMessage<Stats> = JSON.parse(e.data);
+  if (res.event == "stats") {
+    setOrdersLength(res.data.orders);
   }
 };
 
-async function fetchCustomers(): Promise<Customers> {
-  if (token()) {
-    const res = await fetch(CUSTOMERS_URL, {
+// fetchAll follows the pages of a list until the last one
+async function fetchAll<T>(url: string): Promise<T[]> {
+  const items: T[] = [];
+  let after = "";
+  while (token()) {
+    const query = "limit=500" + (after ? "&after=" + after : "");
+    const res = await fetch(url + (url.includes("?") ? "&" : "?") + query, {
       headers: {
         Authorization: `Bearer ${token()}`,
       },
     });
-    if (res.status == 200) {
-      return await res.json();
-    }
-    if (res.status == 401 || res.status == 500) {
-      setToken(null);
-    }
-    return [];
-  } else {
-    return [];
+    if (res.status != 200) {
+      if (res.status == 401 || res.status == 500) {
+        setToken(null);
+      }
+      return items;
+    }
+    const page: Page<T> = await res.json();
+    items.push(...page.items);
+    if (!page.next_cursor) {
+      return items;
+    }
+    after = page.next_cursor;
   }
+  return items;
+}
+
+async function fetchCustomers(): Promise<Customers> {
+  return fetchAll<Customer>(CUSTOMERS_URL);
 }
 
 async function fetchOrders(): Promise<Orders> {
-  if (token()) {
-    const res = await fetch(ORDERS_URL, {
-      headers: {
-        Authorization: `Bearer ${token()}`,
-      },
-    });
-    if (res.status == 200) {
-      return await res.json();
-    }
-    if (res.status == 401 || res.status == 500) {
-      setToken(null);
-    }
-    return [];
-  } else {
-    return [];
-  }
+  return fetchAll<Order>(ORDERS_URL);
 }
 
 async function fetchSuppliers(): Promise<Suppliers> {
-  if (token()) {
-    const res = await fetch(SUPPLIERS_URL, {
-      headers: {
-        Authorization: `Bearer ${token()}`,
-      },
-    });
-    if (res.status == 200) {
-      return await res.json();
-    }
-    if (res.status == 401 || res.status == 500) {
-      setToken(null);
-    }
-    return [];
-  } else {
-    return [];
-  }
+  return fetchAll<Supplier>(SUPPLIERS_URL);
 }
 
 export const [customers, { refetch: refetchCustomers }] =
@@ -130,41 +113,11 @@ export async function fetchCustomer(id: string): Promise<Customer> {
 }
 
 export async function fetchSupplierOrders(id: string): Promise<Orders> {
-  if (token()) {
-    const res = await fetch(ORDERS_URL + "?supplier_id=" + id, {
-      headers: {
-        Authorization: `Bearer ${token()}`,
-      },
-    });
-    if (res.status == 200) {
-      return await res.json();
-    }
-    if (res.status == 401 || res.status == 500) {
-      setToken(null);
-    }
-    return [];
-  } else {
-    return [];
-  }
+  return fetchAll<Order>(ORDERS_URL + "?supplier_id=" + id);
 }
 
 export async function fetchCustomerOrders(id: string): Promise<Orders> {
-  if (token()) {
-    const res = await fetch(ORDERS_URL + "?customer_id=" + id, {
-      headers: {
-        Authorization: `Bearer ${token()}`,
-      },
-    });
-    if (res.status == 200) {
-      return await res.json();
-    }
-    if (res.status == 401 || res.status == 500) {
-      setToken(null);
-    }
-    return [];
-  } else {
-    return [];
-  }
+  return fetchAll<Order>(ORDERS_URL + "?customer_id=" + id);
 }
 
 export async function updateOrder(order: Order) {
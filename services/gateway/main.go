@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/ratelimit"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/gateway/util"
+	swagger "github.com/arsmn/fiber-swagger/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/websocket/v2"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	config, err := util.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err.Error())
+	}
+
+	// Connect to the Auth gRPC server, the connection is shared by all requests
+	log.Printf("Dialing Auth gRPC server on %s", config.AuthGRPCAddr)
+	authOptions := rpc.DefaultOptions("pb.AuthService", "VerifyToken")
+	authOptions.Timeout = config.RPCTimeout
+	authConn, err := rpc.Dial(config.AuthGRPCAddr, authOptions)
+	if err != nil {
+		log.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer authConn.Close()
+
+	// Connect to the gRPC servers of the services searched by /api/search
+	dial := func(name, addr, service string, idempotent ...string) *grpc.ClientConn {
+		log.Printf("Dialing %s gRPC server on %s", name, addr)
+		opts := rpc.DefaultOptions(service, idempotent...)
+		opts.Timeout = config.RPCTimeout
+		conn, err := rpc.Dial(addr, opts)
+		if err != nil {
+			log.Fatalf("failed to dial: %s", err.Error())
+		}
+		return conn
+	}
+	customersConn := dial("Customers", config.CustomersGRPCAddr, "pb.CustomerService", "SearchCustomers")
+	defer customersConn.Close()
+	suppliersConn := dial("Suppliers", config.SuppliersGRPCAddr, "pb.SupplierService", "SearchSuppliers")
+	defer suppliersConn.Close()
+	ordersConn := dial("Orders", config.OrdersGRPCAddr, "pb.OrderService", "SearchOrders")
+	defer ordersConn.Close()
+
+	// Rate limits are shared by every gateway replica through Redis
+	rdb := redis.NewClient(&redis.Options{
+		Addr: config.RedisAddr,
+	})
+	defer rdb.Close()
+
+	app := newApp(config, pb.NewAuthServiceClient(authConn), rdb, search.Handler(
+		pb.NewCustomerServiceClient(customersConn),
+		pb.NewSupplierServiceClient(suppliersConn),
+		pb.NewOrderServiceClient(ordersConn),
+	))
+	log.Print("Starting gateway on port 8000")
+	if err := app.Listen("0.0.0.0:8000"); err != nil {
+		log.Fatalf("failed to serve: %s", err.Error())
+	}
+}
+
+// newApp creates the http application of the gateway, searchHandler serves
+// the combined search of the services
+func newApp(config util.Config, authClient pb.AuthServiceClient, rdb *redis.Client, searchHandler fiber.Handler) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+	proxy := newProxy(config.RequestTimeout)
+
+	// CORS
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  config.AllowOrigins,
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE",
+		ExposeHeaders: "ETag, Idempotent-Replayed, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After",
+	}))
+
+	// Identity headers are only ever set by the gateway
+	app.Use(func(c *fiber.Ctx) error {
+		c.Request().Header.Del(middleware.UserHeader)
+		return c.Next()
+	})
+
+	// Rate limiting, per user on authenticated routes and per IP otherwise
+	limit := ratelimit.New(rdb, ratelimit.Config{
+		Default: ratelimit.Rule{Limit: config.RateLimit, Window: config.RateLimitWindow},
+		Routes:  config.RateLimitRules,
+		Timeout: config.RedisTimeout,
+	})
+
+	// Swagger, aggregated from the specs of the backends
+	app.Get("/swagger/doc.json", proxy.swagger(
+		swaggerSpec{service: "auth", url: config.AuthURL},
+		swaggerSpec{service: "customers", url: config.CustomersURL},
+		swaggerSpec{service: "suppliers", url: config.SuppliersURL},
+		swaggerSpec{service: "orders", url: config.OrdersURL},
+	))
+	app.Get("/swagger/*", swagger.New(swagger.Config{URL: "/swagger/doc.json"}))
+
+	// Websocket
+	app.Use("/ws", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	app.Get("/ws", limit, proxy.websocket(config.OrdersWSURL))
+
+	// Users are public, they are how callers get a token
+	app.Use("/api/users", limit, proxy.forward(config.AuthURL))
+
+	// Every other route is authenticated once here, the backends trust the
+	// identity headers set by the gateway
+	api := app.Group("/api", middleware.Auth(authClient, false), func(c *fiber.Ctx) error {
+		c.Request().Header.Set(middleware.UserHeader, c.Locals("user").(string))
+		return c.Next()
+	}, limit)
+	api.Get("/audit", proxy.audit(
+		auditTrail{entity: "customer", url: config.CustomersURL + "/customers/audit"},
+		auditTrail{entity: "supplier", url: config.SuppliersURL + "/suppliers/audit"},
+		auditTrail{entity: "order", url: config.OrdersURL + "/orders/audit"},
+	))
+	api.Get("/search", searchHandler)
+	api.Use("/customers", proxy.forward(config.CustomersURL))
+	api.Use("/suppliers", proxy.forward(config.SuppliersURL))
+	api.Use("/orders", proxy.forward(config.OrdersURL))
+	api.Use("/stats", proxy.forward(config.OrdersURL))
+
+	return app
+}
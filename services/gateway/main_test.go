@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/ratelimit"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/Omar-Belghaouti/pdash/services/gateway/util"
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"google.golang.org/grpc"
+)
+
+// customerServer finds a single customer, named after the user searching
+type customerServer struct {
+	pb.UnimplementedCustomerServiceServer
+}
+
+func (customerServer) SearchCustomers(ctx context.Context, in *pb.SearchRequest) (*pb.CustomerHits, error) {
+	return &pb.CustomerHits{Hits: []*pb.CustomerHit{{Customer: &pb.Customer{Id: "1", Name: rpc.User(ctx)}, Score: 1}}}, nil
+}
+
+type supplierServer struct {
+	pb.UnimplementedSupplierServiceServer
+}
+
+func (supplierServer) SearchSuppliers(ctx context.Context, in *pb.SearchRequest) (*pb.SupplierHits, error) {
+	return &pb.SupplierHits{}, nil
+}
+
+type orderServer struct {
+	pb.UnimplementedOrderServiceServer
+}
+
+func (orderServer) SearchOrders(ctx context.Context, in *pb.SearchRequest) (*pb.OrderHits, error) {
+	return &pb.OrderHits{}, nil
+}
+
+// echo is what the fake backends answer with
+type echo struct {
+	URL  string `json:"url"`
+	User string `json:"user"`
+}
+
+// listen serves app on a random local port and returns its address
+func listen(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(lis)
+	t.Cleanup(func() { app.Shutdown() })
+	return lis.Addr().String()
+}
+
+// auditTimes are the times of the single entry in the audit trail of each
+// fake backend
+var auditTimes = map[string]string{
+	"customers": "2022-01-01T00:00:01Z",
+	"suppliers": "2022-01-01T00:00:03Z",
+	"orders":    "2022-01-01T00:00:02Z",
+}
+
+// backend starts a fake service echoing the requests it receives
+func backend(t *testing.T, name string) string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/swagger/doc.json", func(c *fiber.Ctx) error {
+		// every service has its own problem.Problem
+		return c.JSON(fiber.Map{
+			"paths": fiber.Map{"/" + name: fiber.Map{"get": fiber.Map{"responses": fiber.Map{
+				"400": fiber.Map{"schema": fiber.Map{"$ref": "#/definitions/problem.Problem"}},
+			}}}},
+			"definitions": fiber.Map{
+				name:              fiber.Map{},
+				"problem.Problem": fiber.Map{"description": name},
+			},
+		})
+	})
+	app.Get("/"+name+"/audit", func(c *fiber.Ctx) error {
+		if c.Query("actor") == "invalid" {
+			return problem.Write(c, problem.Validation("invalid_query", "invalid actor"))
+		}
+		return c.JSON(history.Entries{{
+			Entity:   strings.TrimSuffix(name, "s"),
+			RecordID: c.Query("record_id"),
+			Actor:    c.Get(middleware.UserHeader),
+			At:       auditTimes[name],
+		}})
+	})
+	app.Use("/ws", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
+		for {
+			t, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(t, msg)
+		}
+	}))
+	app.Use(func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderAccessControlAllowOrigin, "http://backend")
+		return c.JSON(echo{URL: c.OriginalURL(), User: c.Get(middleware.UserHeader)})
+	})
+	return "http://" + listen(t, app)
+}
+
+func setup(t *testing.T, config util.Config) *fiber.App {
+	t.Helper()
+	_, authClient := testutil.Auth(t)
+
+	if config.AuthURL == "" {
+		config.AuthURL = backend(t, "users")
+	}
+	config.CustomersURL = backend(t, "customers")
+	config.SuppliersURL = backend(t, "suppliers")
+	config.OrdersURL = backend(t, "orders")
+	config.OrdersWSURL = "ws" + config.OrdersURL[len("http"):] + "/ws"
+	config.AllowOrigins = "*"
+	config.RequestTimeout = 2 * time.Second
+	if config.RateLimit == 0 {
+		config.RateLimit = 100
+	}
+	config.RateLimitWindow = time.Minute
+	config.RedisTimeout = time.Second
+	_, rdb := testutil.Redis(t)
+	customers := grpc.NewServer(grpc.UnaryInterceptor(rpc.Authenticate(authClient)))
+	suppliers, orders := grpc.NewServer(), grpc.NewServer()
+	pb.RegisterCustomerServiceServer(customers, customerServer{})
+	pb.RegisterSupplierServiceServer(suppliers, supplierServer{})
+	pb.RegisterOrderServiceServer(orders, orderServer{})
+	return newApp(config, authClient, rdb, search.Handler(
+		pb.NewCustomerServiceClient(testutil.ServeGRPC(t, customers)),
+		pb.NewSupplierServiceClient(testutil.ServeGRPC(t, suppliers)),
+		pb.NewOrderServiceClient(testutil.ServeGRPC(t, orders)),
+	))
+}
+
+func TestForward(t *testing.T) {
+	app := setup(t, util.Config{})
+	tests := []struct {
+		name     string
+		target   string
+		headers  []string
+		expected int
+		echo     echo
+	}{
+		{"public route", "/api/users/login", []string{middleware.UserHeader, "mallory"}, http.StatusOK, echo{URL: "/users/login"}},
+		{"missing token", "/api/customers", nil, http.StatusUnauthorized, echo{}},
+		{"spoofed identity", "/api/customers", []string{middleware.UserHeader, "omar"}, http.StatusUnauthorized, echo{}},
+		{"customers", "/api/customers?page=2", []string{"Authorization", "Bearer " + testutil.Token}, http.StatusOK, echo{URL: "/customers?page=2", User: "omar"}},
+		{"suppliers", "/api/suppliers/1", []string{"Authorization", "Bearer " + testutil.Token}, http.StatusOK, echo{URL: "/suppliers/1", User: "omar"}},
+		{"orders", "/api/orders", []string{"Authorization", "Bearer " + testutil.Token, middleware.UserHeader, "mallory"}, http.StatusOK, echo{URL: "/orders", User: "omar"}},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodGet, tt.target, nil, tt.headers...)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			continue
+		}
+		var got echo
+		json.Unmarshal(body, &got)
+		if got != tt.echo {
+			t.Errorf("%s: expected backend to see %+v, got %+v", tt.name, tt.echo, got)
+		}
+		if origin := res.Header.Values(fiber.HeaderAccessControlAllowOrigin); len(origin) != 1 || origin[0] != "*" {
+			t.Errorf("%s: expected the gateway CORS headers, got %v", tt.name, origin)
+		}
+	}
+}
+
+func TestBackendUnavailable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	app := setup(t, util.Config{AuthURL: "http://" + lis.Addr().String()})
+	res, body := testutil.Request(t, app, http.MethodPost, "/api/users/login", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if res.StatusCode != http.StatusServiceUnavailable || p.Code != "backend_unavailable" {
+		t.Fatalf("expected 503 backend_unavailable, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := setup(t, util.Config{
+		RateLimit:      2,
+		RateLimitRules: ratelimit.Rules{"POST /api/users/login": {Limit: 1, Window: time.Minute}},
+	})
+	auth := []string{"Authorization", "Bearer " + testutil.Token}
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		headers  []string
+		expected int
+	}{
+		{"login", http.MethodPost, "/api/users/login", nil, http.StatusOK},
+		{"login limit reached", http.MethodPost, "/api/users/login", nil, http.StatusTooManyRequests},
+		{"anonymous default limit", http.MethodPost, "/api/users/register", nil, http.StatusOK},
+		{"user", http.MethodGet, "/api/customers", auth, http.StatusOK},
+		{"user on another route", http.MethodGet, "/api/orders", auth, http.StatusOK},
+		{"user limit reached", http.MethodGet, "/api/customers", auth, http.StatusTooManyRequests},
+		{"user limit is separate from the IP one", http.MethodPost, "/api/users/register", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, tt.method, tt.target, nil, tt.headers...)
+		if res.StatusCode != tt.expected {
+			t.Fatalf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+		}
+		if res.Header.Get("RateLimit-Limit") == "" {
+			t.Errorf("%s: expected RateLimit headers", tt.name)
+		}
+		if res.StatusCode == http.StatusTooManyRequests && res.Header.Get(fiber.HeaderRetryAfter) == "" {
+			t.Errorf("%s: expected a Retry-After header", tt.name)
+		}
+	}
+}
+
+func TestSwaggerAggregation(t *testing.T) {
+	app := setup(t, util.Config{})
+	res, body := testutil.Request(t, app, http.MethodGet, "/swagger/doc.json", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.StatusCode, body)
+	}
+	var spec struct {
+		Paths map[string]struct {
+			Get struct {
+				Responses map[string]struct {
+					Schema struct {
+						Ref string `json:"$ref"`
+					} `json:"schema"`
+				} `json:"responses"`
+			} `json:"get"`
+		} `json:"paths"`
+		Definitions map[string]struct {
+			Description string `json:"description"`
+		} `json:"definitions"`
+	}
+	json.Unmarshal(body, &spec)
+	services := map[string]string{"users": "auth", "customers": "customers", "suppliers": "suppliers", "orders": "orders"}
+	for name, service := range services {
+		path, ok := spec.Paths["/api/"+name]
+		if !ok {
+			t.Errorf("expected path /api/%s in %s", name, body)
+		}
+		if _, ok := spec.Definitions[service+"."+name]; !ok {
+			t.Errorf("expected definition %s.%s in %s", service, name, body)
+		}
+		// the definitions named alike are kept apart
+		if d := spec.Definitions[service+".problem.Problem"]; d.Description != name {
+			t.Errorf("expected the problem.Problem of %s, got %q", service, d.Description)
+		}
+		if ref := path.Get.Responses["400"].Schema.Ref; ref != "#/definitions/"+service+".problem.Problem" {
+			t.Errorf("expected the path of %s to reference its own problem.Problem, got %q", service, ref)
+		}
+	}
+}
+
+func TestWebsocketProxy(t *testing.T) {
+	app := setup(t, util.Config{})
+	addr := listen(t, app)
+
+	conn, _, err := fws.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to websocket: %s", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(fws.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "ping" {
+		t.Fatalf("expected ping to be relayed, got %q (%v)", msg, err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	app := setup(t, util.Config{})
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+		entities []string
+	}{
+		{"all entities", "", http.StatusOK, []string{"supplier", "order", "customer"}},
+		{"limit", "?limit=2", http.StatusOK, []string{"supplier", "order"}},
+		{"single entity", "?entity=order&record_id=1", http.StatusOK, []string{"order"}},
+		{"unknown entity", "?entity=user", http.StatusBadRequest, nil},
+		{"invalid limit", "?limit=nope", http.StatusBadRequest, nil},
+		{"rejected by backend", "?actor=invalid", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodGet, "/api/audit"+tt.query, nil, "Authorization", "Bearer "+testutil.Token)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			continue
+		}
+		var entries history.Entries
+		json.Unmarshal(body, &entries)
+		var entities []string
+		for _, e := range entries {
+			if e.Actor != "omar" {
+				t.Errorf("%s: expected the entries of omar, got %+v", tt.name, e)
+			}
+			entities = append(entities, e.Entity)
+		}
+		if !reflect.DeepEqual(entities, tt.entities) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.entities, entities)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := setup(t, util.Config{})
+	if res, body := testutil.Request(t, app, http.MethodGet, "/api/search?q=acme", nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("missing token: expected 401, got %d: %s", res.StatusCode, body)
+	}
+	res, body := testutil.Request(t, app, http.MethodGet, "/api/search?q=acme", nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.StatusCode, body)
+	}
+	var results search.Results
+	json.Unmarshal(body, &results)
+	if len(results.Customers) != 1 || results.Customers[0].Customer.Name != "omar" {
+		t.Errorf("expected the customers searched on behalf of omar, got %s", body)
+	}
+}
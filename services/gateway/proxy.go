@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	fws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// proxy forwards gateway requests to the backends
+type proxy struct {
+	client  *fasthttp.Client
+	timeout time.Duration
+}
+
+func newProxy(timeout time.Duration) *proxy {
+	return &proxy{
+		client: &fasthttp.Client{
+			NoDefaultUserAgentHeader: true,
+			DisablePathNormalizing:   true,
+		},
+		timeout: timeout,
+	}
+}
+
+// gatewayHeaders are the prefixes of the response headers owned by the
+// gateway, the ones set by the backends are dropped
+var gatewayHeaders = [][]byte{[]byte("Access-Control-"), []byte("Ratelimit-")}
+
+// isGatewayHeader reports whether k is a response header owned by the gateway
+func isGatewayHeader(k []byte) bool {
+	for _, prefix := range gatewayHeaders {
+		if bytes.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// forward sends the request to the backend at base and copies its response
+// back, keeping the CORS and rate limit headers set by the gateway
+func (p *proxy) forward(base string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		kept := map[string]string{}
+		c.Response().Header.VisitAll(func(k, v []byte) {
+			if isGatewayHeader(k) {
+				kept[string(k)] = string(v)
+			}
+		})
+
+		req := c.Request()
+		req.SetRequestURI(base + backendPath(c))
+		req.Header.Del(fiber.HeaderConnection)
+		if err := p.client.DoTimeout(req, c.Response(), p.timeout); err != nil {
+			if errors.Is(err, fasthttp.ErrTimeout) {
+				return problem.Write(c, problem.Upstream("backend_timeout", "backend timed out", err))
+			}
+			return problem.Write(c, problem.Upstream("backend_unavailable", "backend unavailable", err))
+		}
+
+		res := &c.Response().Header
+		res.Del(fiber.HeaderConnection)
+		var backend []string
+		res.VisitAll(func(k, v []byte) {
+			if isGatewayHeader(k) {
+				backend = append(backend, string(k))
+			}
+		})
+		for _, k := range backend {
+			res.Del(k)
+		}
+		for k, v := range kept {
+			res.Set(k, v)
+		}
+		return nil
+	}
+}
+
+// websocket relays messages between the client and the backend websocket at
+// target until either side closes
+func (p *proxy) websocket(target string) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		backend, _, err := fws.DefaultDialer.Dial(target, nil)
+		if err != nil {
+			log.Printf("failed to dial websocket backend: %s", err.Error())
+			return
+		}
+		errc := make(chan error, 2)
+		go relay(backend, conn.Conn, errc)
+		go relay(conn.Conn, backend, errc)
+		<-errc
+		backend.Close()
+		conn.Close()
+		<-errc
+	})
+}
+
+// relay copies messages from src to dst until reading or writing fails
+func relay(dst, src *fws.Conn, errc chan<- error) {
+	for {
+		t, msg, err := src.ReadMessage()
+		if err != nil {
+			errc <- err
+			return
+		}
+		if err := dst.WriteMessage(t, msg); err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+// swaggerSpec is the swagger spec served by the backend at url, its
+// definitions are prefixed with service
+type swaggerSpec struct {
+	service string
+	url     string
+}
+
+// swagger merges the swagger specs of the backends into a single spec, with
+// their paths under the /api prefix of the gateway and their definitions
+// prefixed with their service so that the types named alike in several
+// services, e.g. problem.Problem, do not overwrite each other. Backends that
+// cannot be reached are left out.
+func (p *proxy) swagger(specs ...swaggerSpec) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		paths := map[string]json.RawMessage{}
+		definitions := map[string]json.RawMessage{}
+		for _, s := range specs {
+			req := fasthttp.AcquireRequest()
+			res := fasthttp.AcquireResponse()
+			req.SetRequestURI(s.url + "/swagger/doc.json")
+			err := p.client.DoTimeout(req, res, p.timeout)
+			var spec struct {
+				Paths       map[string]json.RawMessage `json:"paths"`
+				Definitions map[string]json.RawMessage `json:"definitions"`
+			}
+			if err == nil && res.StatusCode() == http.StatusOK {
+				err = json.Unmarshal(res.Body(), &spec)
+			}
+			fasthttp.ReleaseRequest(req)
+			fasthttp.ReleaseResponse(res)
+			if err != nil {
+				log.Printf("failed to fetch swagger spec of %s: %s", s.service, err.Error())
+				continue
+			}
+			for path, v := range spec.Paths {
+				paths["/api"+path] = s.prefix(v)
+			}
+			for name, v := range spec.Definitions {
+				definitions[s.service+"."+name] = s.prefix(v)
+			}
+		}
+		return c.JSON(fiber.Map{
+			"swagger": "2.0",
+			"info": fiber.Map{
+				"title":       "pdash api",
+				"description": "pdash api gateway",
+				"version":     "1.0",
+				"contact": fiber.Map{
+					"name":  "Omar Belghaouti",
+					"email": "omarbelghaouti@gmail.com",
+				},
+			},
+			"basePath":    "/",
+			"paths":       paths,
+			"definitions": definitions,
+		})
+	}
+}
+
+// refPrefix starts the references to the definitions of a swagger spec
+var refPrefix = []byte(`"#/definitions/`)
+
+// prefix returns v with its references to the definitions of the spec
+// prefixed with its service
+func (s swaggerSpec) prefix(v json.RawMessage) json.RawMessage {
+	return bytes.ReplaceAll(v, refPrefix, []byte(`"#/definitions/`+s.service+"."))
+}
+
+// get sends a GET request to target on behalf of the caller of c and
+// returns the status and body of the response
+func (p *proxy) get(c *fiber.Ctx, target string) (int, []byte, error) {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.SetRequestURI(target)
+	req.Header.Set(fiber.HeaderAuthorization, c.Get(fiber.HeaderAuthorization))
+	req.Header.Set(middleware.UserHeader, c.Get(middleware.UserHeader))
+	if err := p.client.DoTimeout(req, res, p.timeout); err != nil {
+		if errors.Is(err, fasthttp.ErrTimeout) {
+			return 0, nil, problem.Upstream("backend_timeout", "backend timed out", err)
+		}
+		return 0, nil, problem.Upstream("backend_unavailable", "backend unavailable", err)
+	}
+	return res.StatusCode(), append([]byte(nil), res.Body()...), nil
+}
+
+// auditTrail is the endpoint serving the audit trail of an entity
+type auditTrail struct {
+	entity string
+	url    string
+}
+
+// audit merges the audit trails of the backends into a single one, most
+// recent first. The entity query parameter selects the trail of a single
+// entity, the others are passed on to the backends.
+func (p *proxy) audit(trails ...auditTrail) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := history.ParseQuery(c)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		entity := c.Query("entity")
+		entries := history.Entries{}
+		found := false
+		for _, trail := range trails {
+			if entity != "" && entity != trail.entity {
+				continue
+			}
+			found = true
+			status, body, err := p.get(c, trail.url+"?"+string(c.Request().URI().QueryString()))
+			if err != nil {
+				return problem.Write(c, err)
+			}
+			if status != http.StatusOK {
+				// the backend rejected the query, pass its problem on
+				c.Set(fiber.HeaderContentType, problem.ContentType)
+				return c.Status(status).Send(body)
+			}
+			var trailEntries history.Entries
+			if err := json.Unmarshal(body, &trailEntries); err != nil {
+				return problem.Write(c, problem.Upstream("invalid_backend_response", "invalid audit trail from backend", err))
+			}
+			entries = append(entries, trailEntries...)
+		}
+		if !found {
+			return problem.Write(c, problem.Validation("invalid_query", "unknown entity "+entity))
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].At != entries[j].At {
+				return entries[i].At > entries[j].At
+			}
+			return entries[i].ID.Hex() > entries[j].ID.Hex()
+		})
+		if int64(len(entries)) > q.Limit {
+			entries = entries[:q.Limit]
+		}
+		return c.JSON(entries)
+	}
+}
+
+// backendPath returns the path of a gateway request on its backend
+func backendPath(c *fiber.Ctx) string {
+	return strings.TrimPrefix(c.OriginalURL(), "/api")
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/ratelimit"
+	"github.com/spf13/viper"
+)
+
+// Config stores all configuration for the gateway
+type Config struct {
+	AuthGRPCAddr      string        `mapstructure:"AUTH_GRPC_ADDR"`
+	CustomersGRPCAddr string        `mapstructure:"CUSTOMERS_GRPC_ADDR"`
+	SuppliersGRPCAddr string        `mapstructure:"SUPPLIERS_GRPC_ADDR"`
+	OrdersGRPCAddr    string        `mapstructure:"ORDERS_GRPC_ADDR"`
+	AuthURL           string        `mapstructure:"AUTH_URL"`
+	CustomersURL      string        `mapstructure:"CUSTOMERS_URL"`
+	SuppliersURL      string        `mapstructure:"SUPPLIERS_URL"`
+	OrdersURL         string        `mapstructure:"ORDERS_URL"`
+	OrdersWSURL       string        `mapstructure:"ORDERS_WS_URL"`
+	AllowOrigins      string        `mapstructure:"ALLOW_ORIGINS"`
+	RequestTimeout    time.Duration `mapstructure:"REQUEST_TIMEOUT"`
+	RPCTimeout        time.Duration `mapstructure:"RPC_TIMEOUT"`
+	RateLimit         int           `mapstructure:"RATE_LIMIT"`
+	RateLimitWindow   time.Duration `mapstructure:"RATE_LIMIT_WINDOW"`
+	RateLimits        string        `mapstructure:"RATE_LIMITS"`
+	RedisAddr         string        `mapstructure:"REDIS_ADDR"`
+	RedisTimeout      time.Duration `mapstructure:"REDIS_TIMEOUT"`
+	// RateLimitRules are the parsed RateLimits
+	RateLimitRules ratelimit.Rules `mapstructure:"-"`
+}
+
+// LoadConfig loads the configuration from the given file, falling back to
+// the environment and defaults when the file does not exist
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	viper.SetDefault("AUTH_GRPC_ADDR", "auth:4004")
+	viper.SetDefault("AUTH_URL", "http://auth:3004")
+	viper.SetDefault("CUSTOMERS_GRPC_ADDR", "customers:4001")
+	viper.SetDefault("SUPPLIERS_GRPC_ADDR", "suppliers:4003")
+	viper.SetDefault("ORDERS_GRPC_ADDR", "orders:4002")
+	viper.SetDefault("CUSTOMERS_URL", "http://customers:3001")
+	viper.SetDefault("SUPPLIERS_URL", "http://suppliers:3003")
+	viper.SetDefault("ORDERS_URL", "http://orders:3002")
+	viper.SetDefault("ORDERS_WS_URL", "ws://orders:3002/ws")
+	viper.SetDefault("ALLOW_ORIGINS", "*")
+	viper.SetDefault("REQUEST_TIMEOUT", 10*time.Second)
+	viper.SetDefault("RPC_TIMEOUT", 3*time.Second)
+	viper.SetDefault("RATE_LIMIT", 300)
+	viper.SetDefault("RATE_LIMIT_WINDOW", time.Minute)
+	viper.SetDefault("RATE_LIMITS", "POST /api/users/login=10/1m")
+	viper.SetDefault("REDIS_ADDR", "redis:6379")
+	viper.SetDefault("REDIS_TIMEOUT", 500*time.Millisecond)
+	viper.AddConfigPath(path)
+	viper.SetConfigFile(".env")
+	viper.AutomaticEnv()
+	err := viper.ReadInConfig()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, err
+	}
+	if err := viper.Unmarshal(&config); err != nil {
+		return Config{}, err
+	}
+	config.RateLimitRules, err = ratelimit.ParseRules(config.RateLimits)
+	return config, err
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/orders/data"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type server struct {
+	pb.UnimplementedOrderServiceServer
+	customers pb.CustomerServiceClient
+	suppliers pb.SupplierServiceClient
+}
+
+// toPB converts a Order to its gRPC message
+func toPB(order data.Order) *pb.Order {
+	return &pb.Order{
+		Id:         order.ID.Hex(),
+		CustomerId: order.CustomerID.Hex(),
+		SupplierId: order.SupplierID.Hex(),
+		TotalPrice: float32(order.TotalPrice),
+		Version:    order.Version,
+		CreatedAt:  order.CreatedAt,
+		UpdatedAt:  order.UpdatedAt,
+	}
+}
+
+// fromPB converts the fields of a gRPC message a client may set to a Order
+func fromPB(in *pb.Order) (data.Order, error) {
+	var order data.Order
+	var err error
+	if order.CustomerID, err = primitive.ObjectIDFromHex(in.CustomerId); err != nil {
+		return order, problem.Validation("invalid_id", "invalid customer id")
+	}
+	if order.SupplierID, err = primitive.ObjectIDFromHex(in.SupplierId); err != nil {
+		return order, problem.Validation("invalid_id", "invalid supplier id")
+	}
+	order.TotalPrice = float64(in.TotalPrice)
+	return order, nil
+}
+
+// sendOrder returns the function streaming an Order with send
+func sendOrder(send func(*pb.Order) error) func(data.Order) error {
+	return func(order data.Order) error {
+		return send(toPB(order))
+	}
+}
+
+// GetOrder implementation for Order gRPC server
+func (s *server) GetOrder(ctx context.Context, in *pb.Order) (*pb.Order, error) {
+	order, err := data.GetOrder(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toPB(order), nil
+}
+
+// GetAllOrders implementation for Order gRPC server, the Orders are
+// streamed as they are read from the database
+func (s *server) GetAllOrders(in *pb.Empty, stream pb.OrderService_GetAllOrdersServer) error {
+	return data.StreamOrders(stream.Context(), "", "", sendOrder(stream.Send), nil, nil)
+}
+
+// GetAllOrdersByCustomer implementation for Order gRPC server, the Orders are
+// streamed as they are read from the database
+func (s *server) GetAllOrdersByCustomer(in *pb.Customer, stream pb.OrderService_GetAllOrdersByCustomerServer) error {
+	return data.StreamOrders(stream.Context(), in.Id, "", sendOrder(stream.Send), s.customers, nil)
+}
+
+// GetAllOrdersBySupplier implementation for Order gRPC server, the Orders are
+// streamed as they are read from the database
+func (s *server) GetAllOrdersBySupplier(in *pb.Supplier, stream pb.OrderService_GetAllOrdersBySupplierServer) error {
+	return data.StreamOrders(stream.Context(), "", in.Id, sendOrder(stream.Send), nil, s.suppliers)
+}
+
+// SearchOrders implementation for Order gRPC server, the best matching
+// Orders come first
+func (s *server) SearchOrders(ctx context.Context, in *pb.SearchRequest) (*pb.OrderHits, error) {
+	limit := in.Limit
+	if limit <= 0 {
+		limit = search.DefaultLimit
+	}
+	hits, err := data.SearchOrders(ctx, in.Q, "", "", nil, limit, s.customers, s.suppliers)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.OrderHits{}
+	for _, hit := range hits {
+		res.Hits = append(res.Hits, &pb.OrderHit{Order: toPB(hit.Order), Score: hit.Score, Highlight: hit.Highlight})
+	}
+	return res, nil
+}
+
+// CreateOrder implementation for Order gRPC server
+func (s *server) CreateOrder(ctx context.Context, in *pb.Order) (*pb.Order, error) {
+	order, err := fromPB(in)
+	if err != nil {
+		return nil, err
+	}
+	order, err = data.CreateOrder(ctx, order, rpc.User(ctx), s.customers, s.suppliers)
+	if err != nil {
+		return nil, err
+	}
+	return toPB(order), nil
+}
+
+// UpdateOrder implementation for Order gRPC server, the version of in is the
+// version the Order is expected to be at, 0 updates whatever its current
+// version
+func (s *server) UpdateOrder(ctx context.Context, in *pb.Order) (*pb.Order, error) {
+	order, err := fromPB(in)
+	if err != nil {
+		return nil, err
+	}
+	order, err = data.UpdateOrder(ctx, in.Id, order, in.Version, rpc.User(ctx), s.customers, s.suppliers)
+	if err != nil {
+		return nil, err
+	}
+	return toPB(order), nil
+}
+
+// DeleteOrder implementation for Order gRPC server, it returns the Order as
+// it was before moving to the trash
+func (s *server) DeleteOrder(ctx context.Context, in *pb.Order) (*pb.Order, error) {
+	order, err := data.GetOrder(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.DeleteOrder(ctx, in.Id, rpc.User(ctx)); err != nil {
+		return nil, err
+	}
+	return toPB(order), nil
+}
+
+// CountOrdersByCustomer implementation for Order gRPC server
+func (s *server) CountOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	count, err := data.CountOrdersByCustomer(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// CountOrdersBySupplier implementation for Order gRPC server
+func (s *server) CountOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	count, err := data.CountOrdersBySupplier(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// DeleteOrdersByCustomer implementation for Order gRPC server
+func (s *server) DeleteOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	count, err := data.DeleteOrdersByCustomer(ctx, in.Id, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// DeleteOrdersBySupplier implementation for Order gRPC server
+func (s *server) DeleteOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	count, err := data.DeleteOrdersBySupplier(ctx, in.Id, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// RestoreOrdersByCustomer implementation for Order gRPC server
+func (s *server) RestoreOrdersByCustomer(ctx context.Context, in *pb.Customer) (*pb.OrdersCount, error) {
+	count, err := data.RestoreOrdersByCustomer(ctx, in.Id, rpc.User(ctx), s.suppliers)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// RestoreOrdersBySupplier implementation for Order gRPC server
+func (s *server) RestoreOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	count, err := data.RestoreOrdersBySupplier(ctx, in.Id, rpc.User(ctx), s.customers)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// ReassignOrdersByCustomer implementation for Order gRPC server
+func (s *server) ReassignOrdersByCustomer(ctx context.Context, in *pb.Reassignment) (*pb.OrdersCount, error) {
+	count, err := data.ReassignOrdersByCustomer(ctx, in.FromId, in.ToId, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// ReassignOrdersBySupplier implementation for Order gRPC server
+func (s *server) ReassignOrdersBySupplier(ctx context.Context, in *pb.Reassignment) (*pb.OrdersCount, error) {
+	count, err := data.ReassignOrdersBySupplier(ctx, in.FromId, in.ToId, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.OrdersCount{Count: count}, nil
+}
+
+// GetStats implementation for Order gRPC server
+func (s *server) GetStats(ctx context.Context, in *pb.Empty) (*pb.Stats, error) {
+	stats, err := data.GetStats(ctx, s.customers, s.suppliers)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Stats{
+		Customers:          stats.Customers,
+		Suppliers:          stats.Suppliers,
+		Orders:             stats.Orders,
+		Revenue:            stats.Revenue,
+		AverageOrderValue:  stats.AverageOrderValue,
+		OrdersPerCustomer:  stats.OrdersPerCustomer,
+		RevenuePerCustomer: stats.RevenuePerCustomer,
+	}, nil
+}
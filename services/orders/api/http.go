@@ -0,0 +1,564 @@
+// Package api contains the http server of the orders service
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/etag"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/idempotency"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/orders/data"
+	"github.com/Omar-Belghaouti/pdash/services/orders/util"
+	"github.com/antoniodipinto/ikisocket"
+	swagger "github.com/arsmn/fiber-swagger/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/websocket/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type Response struct {
+	Message string `json:"message"`
+}
+
+type EventMessage struct {
+	Event string     `json:"event"`
+	Data  data.Stats `json:"data"`
+}
+
+// BroadcastStats returns the handler of the order, customer and supplier
+// events sending the Stats to the websocket clients after an event changing
+// them. The Stats are only logged when they cannot be read, the event would
+// not make them readable again.
+func BroadcastStats(grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) func(ctx context.Context, e events.Event) error {
+	return func(ctx context.Context, e events.Event) error {
+		// updating a customer or a supplier leaves the counts as they are
+		if !strings.HasPrefix(e.Type, "order.") && strings.HasSuffix(e.Type, ".updated") {
+			return nil
+		}
+		stats, err := data.GetStats(ctx, grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			log.Printf("cannot broadcast the stats: %s", err.Error())
+			return nil
+		}
+		b, err := json.Marshal(EventMessage{
+			Event: "stats",
+			Data:  stats,
+		})
+		if err != nil {
+			return err
+		}
+		ikisocket.Broadcast(b)
+		return nil
+	}
+}
+
+// NewApp creates the http application of the service
+func NewApp(config util.Config, grpcAuthClient pb.AuthServiceClient, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+
+	// CORS
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  "*",
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE",
+		ExposeHeaders: "ETag",
+	}))
+
+	// Request timeout
+	app.Use(middleware.Timeout(config.RequestTimeout))
+
+	// Swagger
+	app.Get("/swagger/*", swagger.HandlerDefault)
+
+	// Setup websocket
+	app.Use("/ws", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			c.Locals("allowed", true)
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+
+	// Websocket
+	app.Get("/ws", ikisocket.New(func(kws *ikisocket.Websocket) {}))
+
+	// Retried creations replay the response of the first attempt
+	idempotent := idempotency.New(data.Redis(), idempotency.Config{
+		TTL:     config.IdempotencyTTL,
+		LockTTL: 2 * config.RequestTimeout,
+		Timeout: config.CacheTimeout,
+	})
+
+	// Auth middleware
+	app.Use(middleware.Auth(grpcAuthClient, config.TrustGateway))
+
+	// Create a new Order
+	app.Post("/orders", idempotent, func(c *fiber.Ctx) error {
+		order := data.Order{}
+		if err := c.BodyParser(&order); err != nil {
+			return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+		}
+		order, err := data.CreateOrder(c.UserContext(), order, middleware.User(c), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, order.Version)
+		return c.Status(http.StatusCreated).JSON(order)
+	})
+
+	// Get a page of Orders
+	app.Get("/orders", func(c *fiber.Ctx) error {
+		where, err := filter.Parse(c.Query("filter"), data.FilterFields)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		expansion, err := data.ParseExpansion(c.Query("expand"))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		q, err := page.Parse(c, data.SortableFields, data.Fields)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		// the references expanded are selected along with their ids
+		if len(q.Fields) > 0 && expansion.Customer {
+			q.Fields = append(q.Fields, "customer_id", "customer")
+		}
+		if len(q.Fields) > 0 && expansion.Supplier {
+			q.Fields = append(q.Fields, "supplier_id", "supplier")
+		}
+		customerID := strings.TrimSpace(c.Query("customer_id"))
+		supplierID := strings.TrimSpace(c.Query("supplier_id"))
+		text, err := search.Parse(c)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		if text != "" {
+			hits, err := data.SearchOrders(c.UserContext(), text, customerID, supplierID, where, q.Limit, grpcCustomerClient, grpcSupplierClient)
+			if err != nil {
+				return problem.Write(c, err)
+			}
+			if expansion != (data.Expansion{}) {
+				orders := make(data.Orders, len(hits))
+				for i, hit := range hits {
+					orders[i] = hit.Order
+				}
+				expanded, err := data.ExpandOrders(c.UserContext(), orders, expansion, grpcCustomerClient, grpcSupplierClient)
+				if err != nil {
+					return problem.Write(c, err)
+				}
+				for i := range hits {
+					hits[i].ExpandedOrder = expanded[i]
+				}
+			}
+			if len(q.Fields) > 0 {
+				q.Fields = append(q.Fields, "score", "highlight")
+			}
+			return page.Write(c, q, hits, page.Info{Total: int64(len(hits))})
+		}
+		orders, info, err := data.GetOrdersByReferences(c.UserContext(), customerID, supplierID, where, q, grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		if expansion == (data.Expansion{}) {
+			return page.Write(c, q, orders, info)
+		}
+		expanded, err := data.ExpandOrders(c.UserContext(), orders, expansion, grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return page.Write(c, q, expanded, info)
+	})
+
+	// Get the Orders in the trash
+	app.Get("/orders/trash", GetDeletedOrders)
+
+	// Get the changes made to the Orders
+	app.Get("/orders/audit", GetOrderAudit)
+
+	// Get the Orders referencing a missing Customer or Supplier
+	app.Get("/orders/orphans", GetOrphanedOrders(grpcCustomerClient, grpcSupplierClient))
+
+	// Get the figures of the dashboard
+	app.Get("/stats", GetStats(grpcCustomerClient, grpcSupplierClient))
+
+	// Get a Order by ID
+	app.Get("/orders/:id", GetOrderByID(grpcCustomerClient, grpcSupplierClient))
+
+	// Update a Order by ID
+	app.Put("/orders/:id", UpdateOrderByID(config.RequireIfMatch, grpcCustomerClient, grpcSupplierClient))
+
+	// Patch a Order by ID
+	app.Patch("/orders/:id", PatchOrderByID(config.RequireIfMatch, grpcCustomerClient, grpcSupplierClient))
+
+	// Delete a Order by ID
+	app.Delete("/orders/:id", DeleteOrderByID)
+
+	// Restore a Order from the trash
+	app.Post("/orders/:id/restore", RestoreOrderByID(grpcCustomerClient, grpcSupplierClient))
+
+	// Get the changes made to a Order by ID
+	app.Get("/orders/:id/history", GetOrderHistoryByID)
+
+	return app
+}
+
+// NewGRPCServer creates the gRPC server of the service, the changes are only
+// made on behalf of callers whose token authClient verifies
+func NewGRPCServer(authClient pb.AuthServiceClient, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			problem.UnaryServerInterceptor,
+			rpc.Authenticate(authClient,
+				"CreateOrder", "UpdateOrder", "DeleteOrder",
+				"DeleteOrdersByCustomer", "DeleteOrdersBySupplier",
+				"RestoreOrdersByCustomer", "RestoreOrdersBySupplier",
+				"ReassignOrdersByCustomer", "ReassignOrdersBySupplier",
+			),
+		),
+		grpc.StreamInterceptor(problem.StreamServerInterceptor),
+	)
+	pb.RegisterOrderServiceServer(s, &server{
+		customers: grpcCustomerClient,
+		suppliers: grpcSupplierClient,
+	})
+	reflection.Register(s)
+	return s
+}
+
+// CreateOrder creates a new Order
+// @Summary Create a new Order
+// @Description Create a new Order
+// @ID create-order
+// @Accept  json
+// @Produce  json
+// @Param order body data.Order true "Order"
+// @Param Idempotency-Key header string false "Key making retries of the request safe"
+// @Success 201 {object} data.Order
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders [post]
+func CreateOrder() {}
+
+// GetOrders returns a page of Orders
+// @Summary Get a page of Orders
+// @Description Get a page of Orders sorted and with the selected fields, or the Orders whose id or Customer or Supplier names best match a search along with their score and highlighted match
+// @ID get-orders
+// @Accept  json
+// @Produce  json
+// @Param q query string false "Search of the ids and of the names of the Customers and Suppliers, matching prefixes and typos, cannot be combined with sort or after"
+// @Param supplier_id query string false "Supplier ID"
+// @Param customer_id query string false "Customer ID"
+// @Param expand query string false "References to resolve, customer and/or supplier, comma separated"
+// @Param filter query string false "Filter on id, supplier_id, customer_id, total_price, version, created_at and updated_at, e.g. total_price >= 100 and supplier_id in (...)"
+// @Param limit query int false "Number of Orders of the page, 50 by default and 500 at most"
+// @Param after query string false "next_cursor of the previous page"
+// @Param sort query string false "Field to sort by, total_price, created_at or updated_at, prefixed with - to sort in descending order"
+// @Param fields query string false "Fields to return along with the id, comma separated"
+// @Success 200 {object} page.Page{items=[]data.ExpandedOrder}
+// @Header 200 {string} Link "Relative link to the next page"
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders [get]
+func GetOrders() {}
+
+// GetOrderByID returns a Order by ID
+// @Summary Get a Order by ID
+// @Description Get a Order by ID
+// @ID get-order-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Order ID"
+// @Param expand query string false "References to resolve, customer and/or supplier, comma separated"
+// @Success 200 {object} data.ExpandedOrder
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id} [get]
+func GetOrderByID(grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		expansion, err := data.ParseExpansion(c.Query("expand"))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		order, err := data.GetOrder(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, order.Version)
+		if expansion == (data.Expansion{}) {
+			return c.Status(http.StatusOK).JSON(order)
+		}
+		expanded, err := data.ExpandOrders(c.UserContext(), data.Orders{order}, expansion, grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return c.Status(http.StatusOK).JSON(expanded[0])
+	}
+}
+
+// UpdateOrderByID returns the handler updating a Order by ID,
+// requireIfMatch rejects the updates without an If-Match header
+// @Summary Update a Order by ID
+// @Description Update a Order by ID
+// @ID update-order-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Order ID"
+// @Param If-Match header string false "ETag of the version being updated"
+// @Param order body data.Order true "Order"
+// @Success 200 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id} [put]
+func UpdateOrderByID(requireIfMatch bool, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		order := data.Order{}
+		if err := c.BodyParser(&order); err != nil {
+			return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		order, err = data.UpdateOrder(c.UserContext(), id, order, version, middleware.User(c), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, order.Version)
+		return c.Status(http.StatusOK).JSON(order)
+	}
+}
+
+// PatchOrderByID returns the handler applying a JSON merge patch to a
+// Order by ID, requireIfMatch rejects the patches without an If-Match header
+// @Summary Patch a Order by ID
+// @Description Apply a JSON merge patch (RFC 7396) to a Order by ID, id and created_at cannot be changed and the referenced customer and supplier must exist
+// @ID patch-order-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Order ID"
+// @Param If-Match header string false "ETag of the version being patched"
+// @Param order body data.Order true "Merge patch of the Order"
+// @Success 200 {object} data.Order
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id} [patch]
+func PatchOrderByID(requireIfMatch bool, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !patch.IsMergePatch(c) {
+			return fiber.ErrUnsupportedMediaType
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		order, err := data.PatchOrder(c.UserContext(), c.Params("id"), c.Body(), version, middleware.User(c), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, order.Version)
+		return c.Status(http.StatusOK).JSON(order)
+	}
+}
+
+// DeleteOrderByID deletes a Order by ID
+// @Summary Delete a Order by ID
+// @Description Move a Order to the trash, it is purged after the retention period
+// @ID delete-order-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Order ID"
+// @Success 200 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id} [delete]
+func DeleteOrderByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+	err := data.DeleteOrder(c.UserContext(), id, middleware.User(c))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(Response{
+		Message: "Order deleted",
+	})
+}
+
+// GetDeletedOrders gets the Orders in the trash
+// @Summary Get the Orders in the trash
+// @Description Get the deleted Orders not purged yet, most recently deleted first
+// @ID get-deleted-orders
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} data.Order
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/trash [get]
+func GetDeletedOrders(c *fiber.Ctx) error {
+	orders, err := data.GetDeletedOrders(c.UserContext())
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(orders)
+}
+
+// GetOrphanedOrders returns the handler reporting the orphaned Orders
+// @Summary Get the orphaned Orders
+// @Description Get the Orders referencing a Customer or a Supplier that does not exist anymore, with the references missing
+// @ID get-orphaned-orders
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} data.Orphan
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/orphans [get]
+func GetOrphanedOrders(grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orphans, err := data.GetOrphanedOrders(c.UserContext(), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return c.Status(http.StatusOK).JSON(orphans)
+	}
+}
+
+// GetStats returns the handler reporting the figures of the dashboard
+// @Summary Get the figures of the dashboard
+// @Description Get the number of Customers, Suppliers and Orders, the revenue and its averages, the Orders in the trash are left out
+// @ID get-stats
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} data.Stats
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /stats [get]
+func GetStats(grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stats, err := data.GetStats(c.UserContext(), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return c.Status(http.StatusOK).JSON(stats)
+	}
+}
+
+// RestoreOrderByID returns the handler restoring a Order from the trash
+// @Summary Restore a Order from the trash
+// @Description Restore a deleted Order by ID, its customer and supplier must still exist
+// @ID restore-order-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Order ID"
+// @Success 200 {object} data.Order
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id}/restore [post]
+func RestoreOrderByID(grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		order, err := data.RestoreOrder(c.UserContext(), c.Params("id"), middleware.User(c), grpcCustomerClient, grpcSupplierClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, order.Version)
+		return c.Status(http.StatusOK).JSON(order)
+	}
+}
+
+// GetOrderHistoryByID gets the changes made to a Order by ID
+// @Summary Get the history of a Order by ID
+// @Description Get the changes made to a Order by ID with who made them and the fields they changed, most recent first
+// @ID get-order-history-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/{id}/history [get]
+func GetOrderHistoryByID(c *fiber.Ctx) error {
+	entries, err := data.GetOrderHistory(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
+
+// GetOrderAudit gets the changes made to the Orders
+// @Summary Get the audit trail of the Orders
+// @Description Get the changes made to the Orders, most recent first
+// @ID get-order-audit
+// @Accept  json
+// @Produce  json
+// @Param record_id query string false "ID of the Order"
+// @Param actor query string false "User who made the changes"
+// @Param action query string false "create, update, delete or restore"
+// @Param since query string false "RFC 3339 timestamp of the oldest change"
+// @Param until query string false "RFC 3339 timestamp of the newest change"
+// @Param limit query int false "Maximum number of changes, 100 by default"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /orders/audit [get]
+func GetOrderAudit(c *fiber.Ctx) error {
+	q, err := history.ParseQuery(c)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	entries, err := data.GetOrderAudit(c.UserContext(), q)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
@@ -3,38 +3,277 @@ package data
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"net/http"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/Omar-Belghaouti/pdash/services/orders/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/cache"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/outbox"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/stats"
+	"github.com/Omar-Belghaouti/pdash/services/common/tx"
+	"github.com/Omar-Belghaouti/pdash/services/orders/util"
 	"github.com/go-redis/redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 var (
-	ctx        context.Context
-	collection *mongo.Collection
+	db         *mongo.Database
+	collection Collection
+	changes    *history.Log
+	box        *outbox.Outbox
+	transact   tx.Func
 	rdb        *redis.Client
+	cached     *cache.Cache
+	figures    *stats.Figures
+	bus        *events.Publisher
+	config     util.Config
 )
 
+// Collection is the subset of *mongo.Collection used by the data package
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+}
+
+// SortableFields are the fields Orders can be listed sorted by
+var SortableFields = []string{"total_price", "created_at", "updated_at"}
+
+// Fields are the fields of Orders that can be selected when listing them
+var Fields = []string{"id", "supplier_id", "customer_id", "total_price", "version", "created_at", "updated_at"}
+
+// FilterFields are the fields Orders can be filtered on when listing them
+var FilterFields = filter.Fields{
+	"id":          filter.ID,
+	"supplier_id": filter.ID,
+	"customer_id": filter.ID,
+	"total_price": filter.Number,
+	"version":     filter.Number,
+	"created_at":  filter.String,
+	"updated_at":  filter.String,
+}
+
 func init() {
-	ctx = context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
+	var err error
+	config, err = util.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err.Error())
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %s", err.Error())
 	}
-	collection = client.Database("db").Collection("orders")
+	db = client.Database("db")
+	collection = db.Collection("orders")
+	changes = history.New(client.Database("db").Collection("history"), "order")
+	box = outbox.New(client.Database("db").Collection("orders_outbox"))
+	transact = tx.Mongo(client)
 	rdb = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
+		Addr: config.RedisAddr,
+	})
+	bus = events.NewPublisher(rdb)
+	cached = newCache(rdb)
+	figures = newFigures(client.Database("db").Collection("stats"), rdb)
+}
+
+// Use replaces the collection, the history, outbox and stats collections and
+// the Redis client used by the data package, e.g. with in-memory stand-ins
+// that have no transactions nor migrations
+func Use(c Collection, h history.Collection, o outbox.Collection, s stats.Collection, r *redis.Client) {
+	db = nil
+	collection = c
+	changes = history.New(h, "order")
+	box = outbox.New(o)
+	transact = tx.None
+	rdb = r
+	bus = events.NewPublisher(r)
+	cached = newCache(r)
+	figures = newFigures(s, r)
+}
+
+// cacheVersion is the version of the representation of the cached Orders,
+// bump it when Order changes
+const cacheVersion = 2
+
+// batchSize is the most Customers or Suppliers looked up in a single call
+const batchSize = 1000
+
+// newCache returns the cache of the Orders stored in r
+func newCache(r *redis.Client) *cache.Cache {
+	return cache.New(r, cache.Config{
+		Service:     "orders",
+		Version:     cacheVersion,
+		TTL:         config.CacheTTL,
+		NegativeTTL: config.CacheNegativeTTL,
+		Timeout:     config.CacheTimeout,
+		LocalSize:   config.CacheLocalSize,
+		LocalTTL:    config.CacheLocalTTL,
+	})
+}
+
+// newFigures returns the figures of the Orders counted in s and cached in r,
+// the number of Orders and their revenue are aggregated by the database when
+// they are recomputed
+func newFigures(s stats.Collection, r *redis.Client) *stats.Figures {
+	return stats.New(r, s, stats.Config{Key: "orders:stats", TTL: config.StatsTTL, Timeout: config.CacheTimeout, DBTimeout: config.DBTimeout}, func(ctx context.Context) (map[string]float64, error) {
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		cursor, err := collection.Aggregate(dbCtx, mongo.Pipeline{
+			{{Key: "$match", Value: live(bson.M{})}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "orders", Value: bson.M{"$sum": 1}},
+				{Key: "revenue", Value: bson.M{"$sum": "$total_price"}},
+			}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var totals []struct {
+			Orders  int64   `bson:"orders"`
+			Revenue float64 `bson:"revenue"`
+		}
+		if err := cursor.All(dbCtx, &totals); err != nil {
+			return nil, err
+		}
+		// there is no group without orders
+		figures := map[string]float64{"orders": 0, "revenue": 0}
+		if len(totals) > 0 {
+			figures["orders"] = float64(totals[0].Orders)
+			figures["revenue"] = totals[0].Revenue
+		}
+		return figures, nil
 	})
 }
 
+// ListenCacheInvalidations removes from memory the Orders changed by the other
+// replicas until ctx is done, when they are kept in memory
+func ListenCacheInvalidations(ctx context.Context) {
+	cached.Listen(ctx)
+}
+
+// Migrations are the schema migrations of the orders collections
+var Migrations = []migrate.Migration{
+	migrate.CreateIndexes(1, "index the sortable fields of the orders", "orders", page.Indexes(SortableFields)...),
+	migrate.CreateIndexes(2, "index the orders in the trash", "orders", mongo.IndexModel{Keys: bson.D{{Key: "deleted_at", Value: 1}}}),
+	migrate.CreateIndexes(3, "index the orders outbox", "orders_outbox", outbox.Indexes()...),
+	history.Migration(4, "history"),
+	migrate.CreateIndexes(5, "index the customers and suppliers of the orders", "orders",
+		mongo.IndexModel{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "_id", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "supplier_id", Value: 1}, {Key: "_id", Value: 1}}},
+	),
+}
+
+// Migrator returns the Migrator of the orders database, it is only
+// available with MongoDB
+func Migrator() *migrate.Migrator {
+	return migrate.New(db, "orders", Migrations)
+}
+
+// Migrate applies the pending migrations, in-memory collections have none
+func Migrate(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	_, err := Migrator().Up(ctx, 0)
+	return err
+}
+
+// Redis returns the Redis client used by the data package
+func Redis() *redis.Client {
+	return rdb
+}
+
+// versionFilter matches the documents at version, documents written before
+// versions were introduced have none and are at version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	return version
+}
+
+// live adds to filter the condition excluding the documents in the trash
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// eventTypes are the events published for the actions of the history
+var eventTypes = map[string]string{
+	history.Created:  "order.created",
+	history.Updated:  "order.updated",
+	history.Deleted:  "order.deleted",
+	history.Restored: "order.restored",
+}
+
+// record adds the change of an Order from before to after, made by actor, to
+// its history and its event to the outbox, ctx must be the one of the
+// transaction storing the change
+func record(ctx context.Context, action, actor string, before interface{}, after Order) error {
+	if err := changes.Record(ctx, after.ID.Hex(), action, actor, after.Version, before, after); err != nil {
+		return err
+	}
+	e, err := events.New(eventTypes[action], after.ID.Hex(), actor, events.Change{Before: before, After: after})
+	if err != nil {
+		return err
+	}
+	return box.Add(ctx, e)
+}
+
+// RelayOutbox publishes the events of the outbox to the event bus every
+// interval and as soon as a change is stored, until ctx is done
+func RelayOutbox(ctx context.Context, interval time.Duration) {
+	box.Run(ctx, interval, "orders outbox relay", func(ctx context.Context, e events.Event) error {
+		// the change of the event is stored, the figures are read again
+		// before anyone is told about it
+		figures.Invalidate(ctx)
+		cacheCtx, cancel := middleware.WithTimeout(ctx, config.CacheTimeout)
+		defer cancel()
+		return bus.PublishEvent(cacheCtx, e)
+	})
+}
+
+// replicaLease is how long the group of a replica outlives it when it stops
+// without destroying it
+const replicaLease = time.Minute
+
+// ConsumeEvents hands the events of the Orders, Customers and Suppliers to
+// handler until ctx is done. Every replica consumes them in its own group,
+// named after replica, so that each of them receives every event. The group
+// is destroyed when ctx is done, or by the other replicas once its lease
+// expires, so the groups of past replicas do not pile up.
+func ConsumeEvents(ctx context.Context, replica string, handler events.Handler) {
+	events.NewConsumer(rdb, events.ConsumerConfig{
+		Group:   "orders:" + replica,
+		Name:    replica,
+		Streams: []string{events.Stream("order.created"), events.Stream("customer.created"), events.Stream("supplier.created")},
+		Lease:   replicaLease,
+	}, handler).Run(ctx)
+}
+
 // MarshalBinary is a marshalling function for Order
 func (order Order) MarshalBinary() ([]byte, error) {
 	return json.Marshal(order)
@@ -46,233 +285,1130 @@ type Order struct {
 	SupplierID primitive.ObjectID `bson:"supplier_id" json:"supplier_id"`
 	CustomerID primitive.ObjectID `bson:"customer_id" json:"customer_id"`
 	TotalPrice float64            `bson:"total_price" json:"total_price"`
+	Version    int64              `bson:"version" json:"version"`
 	CreatedAt  string             `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt  string             `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	DeletedAt  string             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy  string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	// DeletedWith is the customer or supplier the Order was moved to the
+	// trash with, e.g. customer:<id>, it is restored along with it
+	DeletedWith string `bson:"deleted_with,omitempty" json:"deleted_with,omitempty"`
 }
 
 // Orders is a slice of Order structs
 type Orders []Order
 
-// CreateOrder creates a new Order document
-func CreateOrder(order Order, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, int, error) {
+// CreateOrder creates a new Order document, actor is the user creating it
+func CreateOrder(ctx context.Context, order Order, actor string, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, error) {
 	order.ID = primitive.NewObjectID()
 	order.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
+	if err := checkReferences(ctx, order, grpcCustomerClient, grpcSupplierClient); err != nil {
+		return order, err
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err := transact(dbCtx, func(ctx context.Context) error {
+		if _, err := collection.InsertOne(ctx, order); err != nil {
+			return err
+		}
+		if err := figures.Add(ctx, counted(history.Created, order)); err != nil {
+			return err
+		}
+		return record(ctx, history.Created, actor, nil, order)
+	})
+	if err != nil {
+		return order, problem.From(err)
+	}
+	box.Notify()
+	figures.Invalidate(ctx)
+	// the customer or supplier may have been moved to the trash since they
+	// were checked, after their orders were handled, the order then goes to
+	// the trash too
+	if err := checkReferences(ctx, order, grpcCustomerClient, grpcSupplierClient); err != nil {
+		if derr := DeleteOrder(middleware.Detach(ctx), order.ID.Hex(), actor); derr != nil {
+			log.Printf("cannot delete order %s referencing a deleted customer or supplier: %s", order.ID.Hex(), derr.Error())
+		}
+		return order, err
+	}
+	return order, nil
+}
+
+// checkReferences checks that the customer and supplier order references
+// exist and are not in the trash
+func checkReferences(ctx context.Context, order Order, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) error {
 	// check if customer exists
 	_, err := grpcCustomerClient.GetCustomer(ctx, &pb.Customer{
 		Id: order.CustomerID.Hex(),
 	})
 	if err != nil {
-		s, ok := status.FromError(err)
-		if ok {
-			if s.Code() == codes.NotFound {
-				return order, http.StatusNotFound, err
-			} else {
-				return order, http.StatusInternalServerError, err
-			}
-		} else {
-			return order, http.StatusInternalServerError, err
-		}
+		return problem.From(err)
 	}
 	// check if supplier exists
 	_, err = grpcSupplierClient.GetSupplier(ctx, &pb.Supplier{
 		Id: order.SupplierID.Hex(),
 	})
 	if err != nil {
-		s, ok := status.FromError(err)
-		if ok {
-			if s.Code() == codes.NotFound {
-				return order, http.StatusNotFound, err
-			} else {
-				return order, http.StatusInternalServerError, err
-			}
-		} else {
-			return order, http.StatusInternalServerError, err
-		}
+		return problem.From(err)
 	}
-	_, err = collection.InsertOne(ctx, order)
+	return nil
+}
+
+// GetOrders returns the page of the Orders matched by where selected by q
+func GetOrders(ctx context.Context, where filter.Node, q page.Query) (Orders, page.Info, error) {
+	return listOrders(ctx, bson.M{}, where, q)
+}
+
+// GetOrdersByCustomerID returns the page of Orders by Customer ID selected by q
+func GetOrdersByCustomerID(ctx context.Context, id string, q page.Query, grpcCustomerClient pb.CustomerServiceClient) (Orders, page.Info, error) {
+	return GetOrdersByReferences(ctx, id, "", nil, q, grpcCustomerClient, nil)
+}
+
+// GetOrdersBySupplierID returns the page of Orders by Supplier ID selected by q
+func GetOrdersBySupplierID(ctx context.Context, id string, q page.Query, grpcSupplierClient pb.SupplierServiceClient) (Orders, page.Info, error) {
+	return GetOrdersByReferences(ctx, "", id, nil, q, nil, grpcSupplierClient)
+}
+
+// GetOrdersByReferences returns the page of the Orders by Customer ID and by
+// Supplier ID matched by where selected by q, an empty ID matches any
+// Customer or Supplier
+func GetOrdersByReferences(ctx context.Context, customerID, supplierID string, where filter.Node, q page.Query, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Orders, page.Info, error) {
+	match, err := referencesMatch(ctx, customerID, supplierID, grpcCustomerClient, grpcSupplierClient)
 	if err != nil {
-		return order, http.StatusInternalServerError, err
+		return Orders{}, page.Info{}, err
 	}
-	return order, http.StatusCreated, err
+	return listOrders(ctx, match, where, q)
 }
 
-// GetOrders returns all Orders
-func GetOrders() (Orders, int, error) {
-	var orders Orders
-	cursor, err := collection.Find(ctx, bson.M{})
+// StreamOrders calls send with every Order by Customer ID and by Supplier ID,
+// one at a time as they are read from the database, an empty ID matches any
+// Customer or Supplier
+func StreamOrders(ctx context.Context, customerID, supplierID string, send func(Order) error, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) error {
+	match, err := referencesMatch(ctx, customerID, supplierID, grpcCustomerClient, grpcSupplierClient)
+	if err != nil {
+		return err
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, live(match))
 	if err != nil {
-		return orders, http.StatusInternalServerError, err
+		return problem.From(err)
 	}
-	if err := cursor.All(ctx, &orders); err != nil {
-		return orders, http.StatusInternalServerError, err
+	// the cursor outlives the timeout of the query, it is bound to ctx
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var order Order
+		if err := cursor.Decode(&order); err != nil {
+			return problem.From(err)
+		}
+		if err := send(order); err != nil {
+			return err
+		}
 	}
-	if orders == nil {
-		return Orders{}, http.StatusNotFound, nil
+	if err := cursor.Err(); err != nil {
+		return problem.From(err)
 	}
-	return orders, http.StatusOK, nil
+	return nil
 }
 
-// GetOrdersByCustomerID returns all Orders by Customer ID
-func GetOrdersByCustomerID(id string, grpcCustomerClient pb.CustomerServiceClient) (Orders, int, error) {
-	// check if customer exists
-	_, err := grpcCustomerClient.GetCustomer(ctx, &pb.Customer{
-		Id: id,
-	})
-	if err != nil {
-		s, ok := status.FromError(err)
-		if ok {
-			if s.Code() == codes.NotFound {
-				return Orders{}, http.StatusNotFound, err
-			} else {
-				return Orders{}, http.StatusInternalServerError, err
-			}
-		} else {
-			return Orders{}, http.StatusInternalServerError, err
+// referencesMatch returns the query matching the Orders by Customer ID and by
+// Supplier ID once checked that they exist, an empty ID matches any Customer
+// or Supplier
+func referencesMatch(ctx context.Context, customerID, supplierID string, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (bson.M, error) {
+	match := bson.M{}
+	if customerID != "" {
+		// check if customer exists
+		_, err := grpcCustomerClient.GetCustomer(ctx, &pb.Customer{
+			Id: customerID,
+		})
+		if err != nil {
+			return nil, problem.From(err)
+		}
+		oid, err := primitive.ObjectIDFromHex(customerID)
+		if err != nil {
+			return nil, problem.Validation("invalid_id", "invalid id")
 		}
+		match["customer_id"] = oid
 	}
-	var orders Orders
-	oid, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return orders, http.StatusInternalServerError, err
+	if supplierID != "" {
+		// check if supplier exists
+		_, err := grpcSupplierClient.GetSupplier(ctx, &pb.Supplier{
+			Id: supplierID,
+		})
+		if err != nil {
+			return nil, problem.From(err)
+		}
+		oid, err := primitive.ObjectIDFromHex(supplierID)
+		if err != nil {
+			return nil, problem.Validation("invalid_id", "invalid id")
+		}
+		match["supplier_id"] = oid
 	}
-	cursor, err := collection.Find(ctx, bson.M{"customer_id": oid})
+	return match, nil
+}
+
+// listOrders returns the page of the Orders matching match and where
+// selected by q
+func listOrders(ctx context.Context, match bson.M, where filter.Node, q page.Query) (Orders, page.Info, error) {
+	orders := Orders{}
+	var info page.Info
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	match = filter.Restrict(live(match), where)
+	total, err := collection.CountDocuments(dbCtx, match)
 	if err != nil {
-		return orders, http.StatusInternalServerError, err
+		return orders, info, problem.From(err)
 	}
-	if err := cursor.All(ctx, &orders); err != nil {
-		return orders, http.StatusInternalServerError, err
+	cursor, err := collection.Find(dbCtx, q.Filter(match), q.Options())
+	if err != nil {
+		return orders, info, problem.From(err)
 	}
-	if orders == nil {
-		return Orders{}, http.StatusNotFound, nil
+	next, err := q.Collect(dbCtx, cursor, &orders)
+	if err != nil {
+		return orders, info, problem.From(err)
 	}
-	return orders, http.StatusOK, nil
+	return orders, page.Info{Total: total, NextCursor: next}, nil
 }
 
-// GetOrdersBySupplierID returns all Orders by Supplier ID
-func GetOrdersBySupplierID(id string, grpcSupplierClient pb.SupplierServiceClient) (Orders, int, error) {
-	// check if supplier exists
-	_, err := grpcSupplierClient.GetSupplier(ctx, &pb.Supplier{
-		Id: id,
-	})
+// OrderHit is an Order matching a search, through its id or the name of the
+// Customer or Supplier it references, which is highlighted
+type OrderHit struct {
+	ExpandedOrder
+	search.Hit
+}
+
+// SearchOrders returns the limit Orders by Customer ID and by Supplier ID
+// matched by where that best match the search q, best first. Orders match
+// by their id or by the names of the Customers and Suppliers they reference,
+// searched through their services, and are scored as the best of them.
+func SearchOrders(ctx context.Context, q, customerID, supplierID string, where filter.Node, limit int64, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) ([]OrderHit, error) {
+	hits := []OrderHit{}
+	if err := search.Validate(q); err != nil {
+		return hits, err
+	}
+	match, err := referencesMatch(ctx, customerID, supplierID, grpcCustomerClient, grpcSupplierClient)
 	if err != nil {
-		s, ok := status.FromError(err)
-		if ok {
-			if s.Code() == codes.NotFound {
-				return Orders{}, http.StatusNotFound, err
-			} else {
-				return Orders{}, http.StatusInternalServerError, err
-			}
-		} else {
-			return Orders{}, http.StatusInternalServerError, err
-		}
+		return hits, err
 	}
-	var orders Orders
-	oid, err := primitive.ObjectIDFromHex(id)
+	req := &pb.SearchRequest{Q: q, Limit: search.MaxCandidates}
+	customers, err := grpcCustomerClient.SearchCustomers(ctx, req)
 	if err != nil {
-		return orders, http.StatusInternalServerError, err
+		return hits, problem.From(err)
 	}
-	cursor, err := collection.Find(ctx, bson.M{"supplier_id": oid})
+	suppliers, err := grpcSupplierClient.SearchSuppliers(ctx, req)
 	if err != nil {
-		return orders, http.StatusInternalServerError, err
+		return hits, problem.From(err)
 	}
-	if err := cursor.All(ctx, &orders); err != nil {
-		return orders, http.StatusInternalServerError, err
+	references := map[string]search.Hit{}
+	customerIDs, supplierIDs := bson.A{}, bson.A{}
+	for _, hit := range customers.Hits {
+		if id, err := primitive.ObjectIDFromHex(hit.Customer.Id); err == nil {
+			references["customer "+hit.Customer.Id] = search.Hit{Score: hit.Score, Highlight: hit.Highlight}
+			customerIDs = append(customerIDs, id)
+		}
+	}
+	for _, hit := range suppliers.Hits {
+		if id, err := primitive.ObjectIDFromHex(hit.Supplier.Id); err == nil {
+			references["supplier "+hit.Supplier.Id] = search.Hit{Score: hit.Score, Highlight: hit.Highlight}
+			supplierIDs = append(supplierIDs, id)
+		}
+	}
+	conds := bson.A{
+		bson.M{"customer_id": bson.M{"$in": customerIDs}},
+		bson.M{"supplier_id": bson.M{"$in": supplierIDs}},
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(q))
+	if err == nil {
+		conds = append(conds, bson.M{"_id": id})
+	}
+	if len(customerIDs) == 0 && len(supplierIDs) == 0 && id.IsZero() {
+		return hits, nil
+	}
+	match["$or"] = conds
+
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(search.MaxCandidates)
+	cursor, err := collection.Find(dbCtx, filter.Restrict(live(match), where), opts)
+	if err != nil {
+		return hits, problem.From(err)
+	}
+	var candidates Orders
+	if err := cursor.All(dbCtx, &candidates); err != nil {
+		return hits, problem.From(err)
+	}
+	for _, order := range candidates {
+		best := references["customer "+order.CustomerID.Hex()]
+		if hit := references["supplier "+order.SupplierID.Hex()]; hit.Score > best.Score {
+			best = hit
+		}
+		if order.ID == id {
+			best = search.Hit{Score: 1, Highlight: "<mark>" + id.Hex() + "</mark>"}
+		}
+		hits = append(hits, OrderHit{ExpandedOrder: ExpandedOrder{Order: order}, Hit: best})
 	}
-	if orders == nil {
-		return Orders{}, http.StatusNotFound, nil
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if limit > 0 && int64(len(hits)) > limit {
+		hits = hits[:limit]
 	}
-	return orders, http.StatusOK, nil
+	return hits, nil
 }
 
 // GetOrder returns a Order by ID
-func GetOrder(id string) (Order, int, error) {
+func GetOrder(ctx context.Context, id string) (Order, error) {
 	var order Order
-	// get order from cache
-	val, err := rdb.Get(ctx, id).Result()
-	if err == redis.Nil {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return order, problem.Validation("invalid_id", "invalid order id")
+	}
+	found, err := cached.Get(ctx, id, &order, func(ctx context.Context) (interface{}, bool, error) {
 		// order not in cache
-		objectID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return order, http.StatusBadRequest, err
+		var stored Order
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		err := collection.FindOne(dbCtx, live(bson.M{"_id": objectID})).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			return nil, false, nil
 		}
-		err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&order)
+		return stored, err == nil, err
+	})
+	if err != nil {
+		return order, problem.From(err)
+	}
+	if !found {
+		return order, problem.NotFound("order_not_found", "order not found")
+	}
+	return order, nil
+}
+
+// Expansion lists the references of Orders to resolve
+type Expansion struct {
+	Customer bool
+	Supplier bool
+}
+
+// ParseExpansion parses a comma separated list of the references to resolve,
+// customer and supplier
+func ParseExpansion(s string) (Expansion, error) {
+	var expansion Expansion
+	for _, field := range strings.Split(s, ",") {
+		switch strings.TrimSpace(field) {
+		case "":
+		case "customer":
+			expansion.Customer = true
+		case "supplier":
+			expansion.Supplier = true
+		default:
+			return expansion, problem.Validation("invalid_expand", fmt.Sprintf("%q cannot be expanded, only customer and supplier can", field))
+		}
+	}
+	return expansion, nil
+}
+
+// ExpandedOrder is an Order with the Customer and the Supplier it references,
+// they are left out when not expanded or missing
+type ExpandedOrder struct {
+	Order
+	Customer *pb.Customer `json:"customer,omitempty"`
+	Supplier *pb.Supplier `json:"supplier,omitempty"`
+}
+
+// ExpandOrders resolves the references of orders listed in expansion with a
+// single batched call per service
+func ExpandOrders(ctx context.Context, orders Orders, expansion Expansion, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) ([]ExpandedOrder, error) {
+	expanded := make([]ExpandedOrder, len(orders))
+	customers := map[string]*pb.Customer{}
+	suppliers := map[string]*pb.Supplier{}
+	var customerIDs, supplierIDs []string
+	for i, order := range orders {
+		expanded[i].Order = order
+		if _, ok := customers[order.CustomerID.Hex()]; !ok && expansion.Customer {
+			customers[order.CustomerID.Hex()] = nil
+			customerIDs = append(customerIDs, order.CustomerID.Hex())
+		}
+		if _, ok := suppliers[order.SupplierID.Hex()]; !ok && expansion.Supplier {
+			suppliers[order.SupplierID.Hex()] = nil
+			supplierIDs = append(supplierIDs, order.SupplierID.Hex())
+		}
+	}
+	if len(customerIDs) > 0 {
+		res, err := grpcCustomerClient.BatchGetCustomers(ctx, &pb.Ids{Ids: customerIDs})
 		if err != nil {
-			return order, http.StatusNotFound, err
+			return expanded, problem.From(err)
+		}
+		for _, customer := range res.Customers {
+			customers[customer.Id] = customer
 		}
-		// set order in cache for 5 minutes
-		err = rdb.Set(ctx, id, order, time.Minute*5).Err()
+	}
+	if len(supplierIDs) > 0 {
+		res, err := grpcSupplierClient.BatchGetSuppliers(ctx, &pb.Ids{Ids: supplierIDs})
 		if err != nil {
-			return order, http.StatusInternalServerError, err
+			return expanded, problem.From(err)
 		}
-		return order, http.StatusOK, nil
-	} else if err != nil {
-		return order, http.StatusInternalServerError, err
+		for _, supplier := range res.Suppliers {
+			suppliers[supplier.Id] = supplier
+		}
+	}
+	for i, order := range orders {
+		expanded[i].Customer = customers[order.CustomerID.Hex()]
+		expanded[i].Supplier = suppliers[order.SupplierID.Hex()]
+	}
+	return expanded, nil
+}
+
+// UpdateOrder replaces a Order by ID, version is the version the Order is
+// expected to be at, 0 updates whatever its current version. actor is the user
+// updating it.
+func UpdateOrder(ctx context.Context, id string, order Order, version int64, actor string, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, error) {
+	return replaceOrder(ctx, id, version, actor, func(current Order) (Order, error) {
+		return order, nil
+	}, grpcCustomerClient, grpcSupplierClient)
+}
+
+// PatchOrder applies a JSON merge patch to a Order by ID, version is the
+// version the Order is expected to be at, 0 patches whatever its current
+// version. actor is the user patching it.
+func PatchOrder(ctx context.Context, id string, p []byte, version int64, actor string, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, error) {
+	return replaceOrder(ctx, id, version, actor, func(current Order) (Order, error) {
+		order := current
+		err := patch.Apply(&order, p)
+		return order, err
+	}, grpcCustomerClient, grpcSupplierClient)
+}
+
+// replaceOrder stores the Order change makes of the current one in its place,
+// the fields managed by the service cannot be changed and the customer and
+// supplier it references must exist. The current Order is read in the
+// transaction so that version is compared with the stored one rather than a
+// cached copy.
+func replaceOrder(ctx context.Context, id string, version int64, actor string, change func(current Order) (Order, error), grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, error) {
+	var order, current Order
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return order, problem.Validation("invalid_id", "invalid order id")
 	}
-	// order in cache
-	err = json.Unmarshal([]byte(val), &order)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(txCtx context.Context) error {
+		err := collection.FindOne(txCtx, live(bson.M{"_id": objectID})).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("order_not_found", "order not found")
+		} else if err != nil {
+			return err
+		}
+		if version != 0 && current.Version != version {
+			return problem.PreconditionFailed("version_mismatch", fmt.Sprintf("order is at version %d", current.Version))
+		}
+		if order, err = change(current); err != nil {
+			return err
+		}
+		if !order.ID.IsZero() && order.ID != current.ID {
+			return problem.Validation("immutable_field", "id cannot be changed")
+		}
+		if order.CreatedAt != "" && order.CreatedAt != current.CreatedAt {
+			return problem.Validation("immutable_field", "created_at cannot be changed")
+		}
+		if order.DeletedAt != current.DeletedAt || order.DeletedBy != current.DeletedBy || order.DeletedWith != current.DeletedWith {
+			return problem.Validation("immutable_field", "deleted_at, deleted_by and deleted_with cannot be changed")
+		}
+		if order.CustomerID.IsZero() {
+			return problem.Validation("missing_field", "customer_id is required")
+		}
+		if order.SupplierID.IsZero() {
+			return problem.Validation("missing_field", "supplier_id is required")
+		}
+		// check if the customer and supplier still exist when they change
+		if order.CustomerID != current.CustomerID {
+			_, err := grpcCustomerClient.GetCustomer(ctx, &pb.Customer{
+				Id: order.CustomerID.Hex(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if order.SupplierID != current.SupplierID {
+			_, err := grpcSupplierClient.GetSupplier(ctx, &pb.Supplier{
+				Id: order.SupplierID.Hex(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		order.ID = current.ID
+		order.CreatedAt = current.CreatedAt
+		order.Version = current.Version + 1
+		order.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		// the version in the filter makes the update fail if the order
+		// changed since it was read when there are no transactions
+		res, err := collection.UpdateOne(txCtx, live(bson.M{"_id": current.ID, "version": versionFilter(current.Version)}), bson.M{"$set": order})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			if version != 0 {
+				return problem.PreconditionFailed("version_mismatch", "order was modified concurrently")
+			}
+			return problem.Conflict("concurrent_update", "order was modified concurrently")
+		}
+		if err := figures.Add(txCtx, map[string]float64{"revenue": order.TotalPrice - current.TotalPrice}); err != nil {
+			return err
+		}
+		return record(txCtx, history.Updated, actor, current, order)
+	})
 	if err != nil {
-		return order, http.StatusInternalServerError, err
+		return order, problem.From(err)
+	}
+	box.Notify()
+	cached.Set(ctx, order.ID.Hex(), order)
+	figures.Invalidate(ctx)
+	if order.CustomerID == current.CustomerID && order.SupplierID == current.SupplierID {
+		return order, nil
+	}
+	// the new customer or supplier may have been moved to the trash since
+	// they were checked, after their orders were handled, the order then
+	// references the previous ones again
+	if err := checkReferences(ctx, order, grpcCustomerClient, grpcSupplierClient); err != nil {
+		_, rerr := replaceOrder(middleware.Detach(ctx), id, order.Version, actor, func(changed Order) (Order, error) {
+			changed.CustomerID = current.CustomerID
+			changed.SupplierID = current.SupplierID
+			return changed, nil
+		}, grpcCustomerClient, grpcSupplierClient)
+		if rerr != nil {
+			log.Printf("cannot revert order %s referencing a deleted customer or supplier: %s", id, rerr.Error())
+		}
+		return order, err
 	}
-	return order, http.StatusOK, nil
+	return order, nil
 }
 
-// UpdateOrder updates a Order by ID
-func UpdateOrder(id string, order Order) (Order, int, error) {
+// DeleteOrder moves a Order to the trash, actor is the user deleting it
+func DeleteOrder(ctx context.Context, id string, actor string) error {
 	// check if order exists
-	_, status, err := GetOrder(id)
+	current, err := GetOrder(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		// the change is recorded from the order as it was when deleted, the
+		// copy read above may be stale
+		var deleted Order
+		err := collection.FindOneAndUpdate(ctx, live(bson.M{"_id": current.ID}), bson.M{
+			"$set": bson.M{"deleted_at": now, "deleted_by": actor, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("order_not_found", "order not found")
+		} else if err != nil {
+			return err
+		}
+		before := deleted
+		deleted.DeletedAt = now
+		deleted.DeletedBy = actor
+		deleted.UpdatedAt = now
+		deleted.Version++
+		if err := figures.Add(ctx, counted(history.Deleted, deleted)); err != nil {
+			return err
+		}
+		return record(ctx, history.Deleted, actor, before, deleted)
+	})
 	if err != nil {
-		return order, status, err
+		return problem.From(err)
 	}
+	box.Notify()
+	cached.Delete(ctx, id)
+	figures.Invalidate(ctx)
+	return nil
+}
+
+// GetDeletedOrders returns the Orders in the trash, most recently deleted
+// first
+func GetDeletedOrders(ctx context.Context) (Orders, error) {
+	orders := Orders{}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, bson.M{"deleted_at": bson.M{"$exists": true}}, options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}))
+	if err != nil {
+		return orders, problem.From(err)
+	}
+	if err := cursor.All(dbCtx, &orders); err != nil {
+		return orders, problem.From(err)
+	}
+	return orders, nil
+}
+
+// RestoreOrder moves a Order out of the trash, the customer and supplier it
+// references must still exist. actor is the user restoring it.
+func RestoreOrder(ctx context.Context, id string, actor string, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Order, error) {
+	var order Order
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return order, http.StatusBadRequest, err
+		return order, problem.Validation("invalid_id", "invalid order id")
 	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	deleted := bson.M{"_id": objectID, "deleted_at": bson.M{"$exists": true}}
+	err = collection.FindOne(dbCtx, deleted).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, problem.NotFound("order_not_in_trash", "order not found in the trash")
+	} else if err != nil {
+		return order, problem.From(err)
+	}
+	// check if customer and supplier still exist
+	_, err = grpcCustomerClient.GetCustomer(ctx, &pb.Customer{
+		Id: order.CustomerID.Hex(),
+	})
+	if err != nil {
+		return order, problem.From(err)
+	}
+	_, err = grpcSupplierClient.GetSupplier(ctx, &pb.Supplier{
+		Id: order.SupplierID.Hex(),
+	})
+	if err != nil {
+		return order, problem.From(err)
+	}
+	before := order
+	order.DeletedAt = ""
+	order.DeletedBy = ""
+	order.DeletedWith = ""
 	order.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": order})
+	deleted["version"] = versionFilter(order.Version)
+	order.Version++
+	err = transact(dbCtx, func(ctx context.Context) error {
+		res, err := collection.UpdateOne(ctx, deleted, bson.M{
+			"$set":   bson.M{"updated_at": order.UpdatedAt, "version": order.Version},
+			"$unset": bson.M{"deleted_at": "", "deleted_by": "", "deleted_with": ""},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return problem.Conflict("concurrent_update", "order was modified concurrently")
+		}
+		if err := figures.Add(ctx, counted(history.Restored, order)); err != nil {
+			return err
+		}
+		return record(ctx, history.Restored, actor, before, order)
+	})
 	if err != nil {
-		return order, http.StatusInternalServerError, err
+		return order, problem.From(err)
 	}
-	// set order in cache for 5 minutes
-	err = rdb.Set(ctx, id, order, time.Minute*5).Err()
+	box.Notify()
+	// the order may be cached as not found since it was deleted
+	cached.Set(ctx, id, order)
+	figures.Invalidate(ctx)
+	return order, nil
+}
+
+// PurgeOrders permanently deletes the Orders in the trash for longer than
+// retention
+func PurgeOrders(ctx context.Context, retention time.Duration) error {
+	cutoff := time.Now().UTC().Add(-retention).Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	res, err := collection.DeleteMany(dbCtx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
 	if err != nil {
-		return order, http.StatusInternalServerError, err
+		return err
+	}
+	if res.DeletedCount > 0 {
+		log.Printf("purged %d orders from the trash", res.DeletedCount)
 	}
-	return order, http.StatusOK, nil
+	return nil
 }
 
-// DeleteOrder deletes a Order by ID
-func DeleteOrder(id string) (int, error) {
-	// check if order exists
-	_, status, err := GetOrder(id)
+// CountOrdersByCustomer returns the number of Orders referencing a Customer
+func CountOrdersByCustomer(ctx context.Context, id string) (int64, error) {
+	return countOrders(ctx, "customer_id", id)
+}
+
+// CountOrdersBySupplier returns the number of Orders referencing a Supplier
+func CountOrdersBySupplier(ctx context.Context, id string) (int64, error) {
+	return countOrders(ctx, "supplier_id", id)
+}
+
+// countOrders returns the number of Orders whose field references id
+func countOrders(ctx context.Context, field, id string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return status, err
+		return 0, problem.Validation("invalid_id", "invalid id")
 	}
-	objectID, err := primitive.ObjectIDFromHex(id)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	count, err := collection.CountDocuments(dbCtx, live(bson.M{field: oid}))
 	if err != nil {
-		return http.StatusBadRequest, err
+		return 0, problem.From(err)
 	}
-	_, err = collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	return count, nil
+}
+
+// DeleteOrdersByCustomer moves the Orders referencing a Customer to the
+// trash, actor is the user deleting the Customer. It returns the number of
+// Orders deleted.
+func DeleteOrdersByCustomer(ctx context.Context, id string, actor string) (int64, error) {
+	filter, err := referencing("customer_id", id)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return 0, err
 	}
-	// remove order from cache
-	err = rdb.Del(ctx, id).Err()
+	return changeOrders(ctx, live(filter), history.Deleted, actor, trash(actor, "customer:"+id))
+}
+
+// DeleteOrdersBySupplier moves the Orders referencing a Supplier to the
+// trash, actor is the user deleting the Supplier. It returns the number of
+// Orders deleted.
+func DeleteOrdersBySupplier(ctx context.Context, id string, actor string) (int64, error) {
+	filter, err := referencing("supplier_id", id)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return 0, err
 	}
-	return http.StatusOK, nil
+	return changeOrders(ctx, live(filter), history.Deleted, actor, trash(actor, "supplier:"+id))
+}
+
+// trash returns the change moving an Order to the trash on behalf of actor
+// along with the record with
+func trash(actor, with string) func(order Order, now string) (Order, bson.M) {
+	return func(order Order, now string) (Order, bson.M) {
+		order.DeletedAt = now
+		order.DeletedBy = actor
+		order.DeletedWith = with
+		order.UpdatedAt = now
+		order.Version++
+		return order, bson.M{
+			"$set": bson.M{"deleted_at": now, "deleted_by": actor, "deleted_with": with, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+	}
+}
+
+// RestoreOrdersByCustomer moves the Orders deleted along with a Customer out
+// of the trash, those whose Supplier does not exist anymore are left in it.
+// actor is the user restoring the Customer. It returns the number of Orders
+// restored.
+func RestoreOrdersByCustomer(ctx context.Context, id string, actor string, grpcSupplierClient pb.SupplierServiceClient) (int64, error) {
+	return restoreOrders(ctx, "customer_id", id, "customer:"+id, "supplier_id", actor, func(ids []string) (map[string]bool, error) {
+		return existingSuppliers(ctx, ids, grpcSupplierClient)
+	})
+}
+
+// RestoreOrdersBySupplier moves the Orders deleted along with a Supplier out
+// of the trash, those whose Customer does not exist anymore are left in it.
+// actor is the user restoring the Supplier. It returns the number of Orders
+// restored.
+func RestoreOrdersBySupplier(ctx context.Context, id string, actor string, grpcCustomerClient pb.CustomerServiceClient) (int64, error) {
+	return restoreOrders(ctx, "supplier_id", id, "supplier:"+id, "customer_id", actor, func(ids []string) (map[string]bool, error) {
+		return existingCustomers(ctx, ids, grpcCustomerClient)
+	})
 }
 
-// GetOrdersLength returns the number of Orders
-func GetOrdersLength() int {
-	cursor, err := collection.Find(ctx, bson.M{})
+// restoreOrders moves the Orders whose field references id and that were
+// deleted along with the record with out of the trash, provided the record
+// their other field references exists. The Orders are restored in pages of
+// changeBatchSize, as with changeOrders.
+func restoreOrders(ctx context.Context, field, id, with, other, actor string, exist func(ids []string) (map[string]bool, error)) (int64, error) {
+	filter, err := referencing(field, id)
 	if err != nil {
-		return 0
+		return 0, err
 	}
-	orders := Orders{}
-	if err := cursor.All(ctx, &orders); err != nil {
-		return 0
+	filter["deleted_with"] = with
+	ref := func(order Order) string {
+		if other == "customer_id" {
+			return order.CustomerID.Hex()
+		}
+		return order.SupplierID.Hex()
+	}
+	existing := map[string]bool{}
+	var count int64
+	err = pageOrders(ctx, filter, changeBatchSize, func(orders Orders) error {
+		if err := lookup(existing, orders, ref, exist); err != nil {
+			return err
+		}
+		var restorable Orders
+		for _, order := range orders {
+			if existing[ref(order)] {
+				restorable = append(restorable, order)
+			}
+		}
+		if len(restorable) == 0 {
+			return nil
+		}
+		err := changeBatch(ctx, restorable, history.Restored, actor, func(order Order, now string) (Order, bson.M) {
+			order.DeletedAt = ""
+			order.DeletedBy = ""
+			order.DeletedWith = ""
+			order.UpdatedAt = now
+			order.Version++
+			return order, bson.M{
+				"$set":   bson.M{"updated_at": now},
+				"$unset": bson.M{"deleted_at": "", "deleted_by": "", "deleted_with": ""},
+				"$inc":   bson.M{"version": 1},
+			}
+		})
+		if err != nil {
+			return err
+		}
+		count += int64(len(restorable))
+		return nil
+	})
+	return count, err
+}
+
+// ReassignOrdersByCustomer moves the Orders referencing the Customer from to
+// the Customer to, which must exist. actor is the user reassigning them. It
+// returns the number of Orders reassigned.
+func ReassignOrdersByCustomer(ctx context.Context, from, to string, actor string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(to)
+	if err != nil {
+		return 0, problem.Validation("invalid_id", "invalid customer id to reassign the orders to")
+	}
+	filter, err := referencing("customer_id", from)
+	if err != nil {
+		return 0, err
+	}
+	return changeOrders(ctx, live(filter), history.Updated, actor, func(order Order, now string) (Order, bson.M) {
+		order.CustomerID = oid
+		order.UpdatedAt = now
+		order.Version++
+		return order, bson.M{
+			"$set": bson.M{"customer_id": oid, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+	})
+}
+
+// ReassignOrdersBySupplier moves the Orders referencing the Supplier from to
+// the Supplier to, which must exist. actor is the user reassigning them. It
+// returns the number of Orders reassigned.
+func ReassignOrdersBySupplier(ctx context.Context, from, to string, actor string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(to)
+	if err != nil {
+		return 0, problem.Validation("invalid_id", "invalid supplier id to reassign the orders to")
+	}
+	filter, err := referencing("supplier_id", from)
+	if err != nil {
+		return 0, err
+	}
+	return changeOrders(ctx, live(filter), history.Updated, actor, func(order Order, now string) (Order, bson.M) {
+		order.SupplierID = oid
+		order.UpdatedAt = now
+		order.Version++
+		return order, bson.M{
+			"$set": bson.M{"supplier_id": oid, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+	})
+}
+
+// referencing returns the filter matching the Orders whose field references
+// id
+func referencing(field, id string) (bson.M, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, problem.Validation("invalid_id", "invalid id")
+	}
+	return bson.M{field: oid}, nil
+}
+
+// changeBatchSize is the most Orders changed in a single transaction
+const changeBatchSize = 100
+
+// changeOrders applies change to every Order matching filter, each change is
+// recorded as action made by actor. The Orders are changed in pages of
+// changeBatchSize read in _id order, each page in its own transaction, so the
+// pages changed before a failure stay changed. It returns the number of Orders
+// changed.
+func changeOrders(ctx context.Context, filter bson.M, action, actor string, change func(order Order, now string) (Order, bson.M)) (int64, error) {
+	var count int64
+	err := pageOrders(ctx, filter, changeBatchSize, func(orders Orders) error {
+		if err := changeBatch(ctx, orders, action, actor, change); err != nil {
+			return err
+		}
+		count += int64(len(orders))
+		return nil
+	})
+	return count, err
+}
+
+// changeBatch applies change to orders in a single transaction, each change
+// is recorded as action made by actor
+func changeBatch(ctx context.Context, orders Orders, action, actor string, change func(order Order, now string) (Order, bson.M)) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	now := time.Now().UTC().Format(time.RFC3339)
+	changed := make(Orders, len(orders))
+	err := transact(dbCtx, func(ctx context.Context) error {
+		for i, current := range orders {
+			order, update := change(current, now)
+			res, err := collection.UpdateOne(ctx, bson.M{"_id": current.ID, "version": versionFilter(current.Version)}, update)
+			if err != nil {
+				return err
+			}
+			if res.MatchedCount == 0 {
+				return problem.Conflict("concurrent_update", "order was modified concurrently")
+			}
+			if err := figures.Add(ctx, counted(action, order)); err != nil {
+				return err
+			}
+			if err := record(ctx, action, actor, current, order); err != nil {
+				return err
+			}
+			changed[i] = order
+		}
+		return nil
+	})
+	if err != nil {
+		return problem.From(err)
+	}
+	box.Notify()
+	for _, order := range changed {
+		if action == history.Restored {
+			// the order may be cached as not found since it was deleted
+			cached.Set(ctx, order.ID.Hex(), order)
+		} else {
+			cached.Delete(ctx, order.ID.Hex())
+		}
+	}
+	figures.Invalidate(ctx)
+	return nil
+}
+
+// counted returns the deltas of the figures once order is stored as action,
+// the updates done in batches leave the prices alone
+func counted(action string, order Order) map[string]float64 {
+	switch action {
+	case history.Created, history.Restored:
+		return map[string]float64{"orders": 1, "revenue": order.TotalPrice}
+	case history.Deleted:
+		return map[string]float64{"orders": -1, "revenue": -order.TotalPrice}
+	}
+	return nil
+}
+
+// pageOrders calls fn with the Orders matching filter in pages of at most size
+// read in _id order, the page after the last one read is looked up by _id so
+// that the Orders fn changes are not read again
+func pageOrders(ctx context.Context, filter bson.M, size int64, fn func(orders Orders) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(size)
+	after := bson.M{}
+	for key, value := range filter {
+		after[key] = value
+	}
+	for {
+		orders, err := findOrders(ctx, after, opts)
+		if err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			return nil
+		}
+		if err := fn(orders); err != nil {
+			return err
+		}
+		after["_id"] = bson.M{"$gt": orders[len(orders)-1].ID}
+	}
+}
+
+// Orphan is an Order referencing a Customer or a Supplier that does not exist
+// anymore
+type Orphan struct {
+	Order
+	MissingCustomer bool `json:"missing_customer"`
+	MissingSupplier bool `json:"missing_supplier"`
+}
+
+// Orphans is a slice of Orphan structs
+type Orphans []Orphan
+
+// GetOrphanedOrders returns the Orders referencing a Customer or a Supplier
+// that does not exist anymore. The Orders are read in pages of batchSize and
+// the references of each page are checked with a batched call per service,
+// every referenced record is looked up once.
+func GetOrphanedOrders(ctx context.Context, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Orphans, error) {
+	orphans := Orphans{}
+	customers := map[string]bool{}
+	suppliers := map[string]bool{}
+	err := pageOrders(ctx, live(bson.M{}), batchSize, func(orders Orders) error {
+		if err := lookup(customers, orders, func(order Order) string { return order.CustomerID.Hex() }, func(ids []string) (map[string]bool, error) {
+			return existingCustomers(ctx, ids, grpcCustomerClient)
+		}); err != nil {
+			return err
+		}
+		if err := lookup(suppliers, orders, func(order Order) string { return order.SupplierID.Hex() }, func(ids []string) (map[string]bool, error) {
+			return existingSuppliers(ctx, ids, grpcSupplierClient)
+		}); err != nil {
+			return err
+		}
+		for _, order := range orders {
+			customer, supplier := customers[order.CustomerID.Hex()], suppliers[order.SupplierID.Hex()]
+			if !customer || !supplier {
+				orphans = append(orphans, Orphan{
+					Order:           order,
+					MissingCustomer: !customer,
+					MissingSupplier: !supplier,
+				})
+			}
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// findOrders returns the Orders matching filter
+func findOrders(ctx context.Context, filter bson.M, opts ...*options.FindOptions) (Orders, error) {
+	var orders Orders
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, filter, opts...)
+	if err != nil {
+		return orders, problem.From(err)
+	}
+	if err := cursor.All(dbCtx, &orders); err != nil {
+		return orders, problem.From(err)
+	}
+	return orders, nil
+}
+
+// lookup adds to existing whether the records referenced by orders through
+// ref exist, the ones not known yet are checked with exist
+func lookup(existing map[string]bool, orders Orders, ref func(order Order) string, exist func(ids []string) (map[string]bool, error)) error {
+	var ids []string
+	for _, order := range orders {
+		id := ref(order)
+		if _, ok := existing[id]; !ok {
+			existing[id] = false
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	found, err := exist(ids)
+	if err != nil {
+		return err
+	}
+	for id := range found {
+		existing[id] = true
+	}
+	return nil
+}
+
+// existingCustomers reports which of the Customers ids exist, they are looked
+// up in batches of at most batchSize
+func existingCustomers(ctx context.Context, ids []string, grpcCustomerClient pb.CustomerServiceClient) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		res, err := grpcCustomerClient.BatchGetCustomers(ctx, &pb.Ids{Ids: ids[start:end]})
+		if err != nil {
+			return existing, problem.From(err)
+		}
+		for _, customer := range res.Customers {
+			existing[customer.Id] = true
+		}
+	}
+	return existing, nil
+}
+
+// existingSuppliers reports which of the Suppliers ids exist, they are looked
+// up in batches of at most batchSize
+func existingSuppliers(ctx context.Context, ids []string, grpcSupplierClient pb.SupplierServiceClient) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		res, err := grpcSupplierClient.BatchGetSuppliers(ctx, &pb.Ids{Ids: ids[start:end]})
+		if err != nil {
+			return existing, problem.From(err)
+		}
+		for _, supplier := range res.Suppliers {
+			existing[supplier.Id] = true
+		}
+	}
+	return existing, nil
+}
+
+// GetOrderHistory returns the changes made to an Order by ID, most recent
+// first
+func GetOrderHistory(ctx context.Context, id string) (history.Entries, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return history.Entries{}, problem.Validation("invalid_id", "invalid order id")
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, history.Query{RecordID: id, Limit: history.MaxLimit})
+}
+
+// GetOrderAudit returns the changes made to the Orders matching q, most
+// recent first
+func GetOrderAudit(ctx context.Context, q history.Query) (history.Entries, error) {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, q)
+}
+
+// Stats are the figures of the dashboard, the Orders in the trash are left
+// out
+type Stats struct {
+	Customers          int64   `json:"customers"`
+	Suppliers          int64   `json:"suppliers"`
+	Orders             int64   `json:"orders"`
+	Revenue            float64 `json:"revenue"`
+	AverageOrderValue  float64 `json:"average_order_value"`
+	OrdersPerCustomer  float64 `json:"orders_per_customer"`
+	RevenuePerCustomer float64 `json:"revenue_per_customer"`
+}
+
+// GetStats returns the Stats, the figures of every service are kept in Redis
+// and computed again once a change is stored, so nothing is counted on every call
+func GetStats(ctx context.Context, grpcCustomerClient pb.CustomerServiceClient, grpcSupplierClient pb.SupplierServiceClient) (Stats, error) {
+	var s Stats
+	totals, err := figures.Get(ctx)
+	if err != nil {
+		return s, problem.From(err)
+	}
+	customers, err := grpcCustomerClient.CountCustomers(ctx, &pb.Empty{})
+	if err != nil {
+		return s, problem.From(err)
+	}
+	suppliers, err := grpcSupplierClient.CountSuppliers(ctx, &pb.Empty{})
+	if err != nil {
+		return s, problem.From(err)
+	}
+	s.Customers = customers.Count
+	s.Suppliers = suppliers.Count
+	s.Orders = int64(math.Round(totals["orders"]))
+	s.Revenue = cents(totals["revenue"])
+	if s.Orders > 0 {
+		s.AverageOrderValue = cents(totals["revenue"] / float64(s.Orders))
+	}
+	if s.Customers > 0 {
+		s.OrdersPerCustomer = cents(float64(s.Orders) / float64(s.Customers))
+		s.RevenuePerCustomer = cents(totals["revenue"] / float64(s.Customers))
+	}
+	return s, nil
+}
+
+// cents rounds x to two decimals, the sums of floats drift a little
+func cents(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// RecomputeStats aggregates the Orders again to correct the drift of the
+// counters kept along with the changes
+func RecomputeStats(ctx context.Context) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	if err := transact(dbCtx, figures.Recompute); err != nil {
+		return err
 	}
-	return len(orders)
+	figures.Invalidate(ctx)
+	return nil
 }
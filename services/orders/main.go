@@ -2,39 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"net/http"
-	"strings"
+	"net"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/schedule"
+	"github.com/Omar-Belghaouti/pdash/services/orders/api"
 	"github.com/Omar-Belghaouti/pdash/services/orders/data"
 	_ "github.com/Omar-Belghaouti/pdash/services/orders/docs"
-	"github.com/Omar-Belghaouti/pdash/services/orders/pb"
-	"github.com/antoniodipinto/ikisocket"
-	swagger "github.com/arsmn/fiber-swagger/v2"
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/websocket/v2"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/status"
+	"github.com/Omar-Belghaouti/pdash/services/orders/util"
 )
 
-type Response struct {
-	Message string `json:"message"`
-}
-
-type OrdersData struct {
-	Length int `json:"length"`
-}
-
-type EventMessage struct {
-	Event string     `json:"event"`
-	Data  OrdersData `json:"data"`
-}
-
 // @title pdash orders service
 // @version 1.0
 // @description pdash orders service
@@ -44,271 +27,100 @@ type EventMessage struct {
 // @BasePath /
 func main() {
 	var wg sync.WaitGroup
-	grpcCustomerClient := make(chan pb.CustomerServiceClient)
-	grpcSupplierClient := make(chan pb.SupplierServiceClient)
-	grpcAuthClient := make(chan pb.AuthServiceClient)
-
-	wg.Add(4)
-	// Start the grpc client to Auth gRPC server
-	go func() {
-		defer wg.Done()
-		for {
-			log.Print("Dialing Auth gRPC server on port 4004")
-			cc, err := grpc.Dial("auth:4004", grpc.WithTransportCredentials(insecure.NewCredentials()))
-			if err != nil {
-				log.Fatalf("failed to dial: %s", err.Error())
-			}
-			grpcAuthClient <- pb.NewAuthServiceClient(cc)
-		}
-	}()
+	config, err := util.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err.Error())
+	}
 
-	// Start the grpc client to Customers gRPC server
-	go func() {
-		defer wg.Done()
-		for {
-			log.Print("Dialing Customers gRPC server on port 4001")
-			cc, err := grpc.Dial("customers:4001", grpc.WithTransportCredentials(insecure.NewCredentials()))
-			if err != nil {
-				log.Fatalf("failed to dial: %s", err.Error())
-			}
-			grpcCustomerClient <- pb.NewCustomerServiceClient(cc)
-		}
-	}()
+	// Connect to the upstream gRPC servers, each connection is shared by all requests
+	log.Print("Dialing Auth gRPC server on port 4004")
+	authOptions := rpc.DefaultOptions("pb.AuthService", "VerifyToken")
+	authOptions.Timeout = config.RPCTimeout
+	authConn, err := rpc.Dial("auth:4004", authOptions)
+	if err != nil {
+		log.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer authConn.Close()
+	grpcAuthClient := pb.NewAuthServiceClient(authConn)
 
-	// Start the grpc client to Suppliers gRPC server
-	go func() {
-		defer wg.Done()
-		for {
-			log.Print("Dialing Suppliers gRPC server on port 4003")
-			cc, err := grpc.Dial("suppliers:4003", grpc.WithTransportCredentials(insecure.NewCredentials()))
-			if err != nil {
-				log.Fatalf("failed to dial: %s", err.Error())
-			}
-			grpcSupplierClient <- pb.NewSupplierServiceClient(cc)
-		}
-	}()
+	log.Print("Dialing Customers gRPC server on port 4001")
+	customersOptions := rpc.DefaultOptions("pb.CustomerService", "GetCustomer", "GetAllCustomers", "BatchGetCustomers", "SearchCustomers", "CountCustomers")
+	customersOptions.Timeout = config.RPCTimeout
+	customersConn, err := rpc.Dial("customers:4001", customersOptions)
+	if err != nil {
+		log.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer customersConn.Close()
+	grpcCustomerClient := pb.NewCustomerServiceClient(customersConn)
 
-	// Start the http server
-	go func() {
-		defer wg.Done()
-		app := fiber.New()
+	log.Print("Dialing Suppliers gRPC server on port 4003")
+	suppliersOptions := rpc.DefaultOptions("pb.SupplierService", "GetSupplier", "GetAllSuppliers", "BatchGetSuppliers", "SearchSuppliers", "CountSuppliers")
+	suppliersOptions.Timeout = config.RPCTimeout
+	suppliersConn, err := rpc.Dial("suppliers:4003", suppliersOptions)
+	if err != nil {
+		log.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer suppliersConn.Close()
+	grpcSupplierClient := pb.NewSupplierServiceClient(suppliersConn)
 
-		// CORS
-		app.Use(cors.New(cors.Config{
-			AllowOrigins: "*",
-			AllowMethods: "GET, POST, PUT, DELETE",
-		}))
+	// Bring the schema up to date, indexes included
+	if err := data.Migrate(context.Background()); err != nil {
+		log.Fatalf("cannot migrate the database: %s", err.Error())
+	}
 
-		// Swagger
-		app.Get("/swagger/*", swagger.HandlerDefault)
+	// Keep the cached records in memory coherent with the other replicas
+	go data.ListenCacheInvalidations(context.Background())
 
-		// Setup websocket
-		app.Use("/ws", func(c *fiber.Ctx) error {
-			if websocket.IsWebSocketUpgrade(c) {
-				c.Locals("allowed", true)
-				return c.Next()
-			}
-			return fiber.ErrUpgradeRequired
-		})
+	// Publish the events stored with the changes
+	go data.RelayOutbox(context.Background(), config.OutboxInterval)
 
-		// Websocket
-		app.Get("/ws", ikisocket.New(func(kws *ikisocket.Websocket) {}))
+	// Broadcast the stats to the websocket clients of the replica when orders,
+	// customers and suppliers change
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("cannot get the hostname: %s", err.Error())
+	}
+	stopped, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// the group of the replica goes away with it
+		data.ConsumeEvents(stopped, hostname, api.BroadcastStats(grpcCustomerClient, grpcSupplierClient))
+		os.Exit(0)
+	}()
 
-		// Auth middleware
-		app.Use(func(c *fiber.Ctx) error {
-			authClient := <-grpcAuthClient
-			authHeader := c.Get("Authorization")
-			if authHeader == "" {
-				return c.Status(http.StatusUnauthorized).JSON(Response{Message: "Unauthorized"})
-			}
-			fields := strings.Fields(authHeader)
-			if len(fields) != 2 || fields[0] != "Bearer" {
-				return c.Status(http.StatusUnauthorized).JSON(Response{Message: "Unauthorized"})
-			}
-			token := fields[1]
-			_, err := authClient.VerifyToken(context.Background(), &pb.Auth{AccessToken: token})
-			if err != nil {
-				s, ok := status.FromError(err)
-				if ok {
-					if s.Code() == codes.Unauthenticated {
-						return c.Status(http.StatusUnauthorized).JSON(Response{Message: "Unauthorized"})
-					}
-					return c.Status(http.StatusInternalServerError).JSON(Response{Message: "Internal server error: " + err.Error()})
-				}
-				return c.Status(http.StatusInternalServerError).JSON(Response{Message: "Internal server error: " + err.Error()})
-			}
-			return c.Next()
-		})
+	// Purge the trash periodically
+	go schedule.Every(context.Background(), config.PurgeInterval, "orders purge", func(ctx context.Context) error {
+		return data.PurgeOrders(ctx, config.TrashRetention)
+	})
 
-		// Create a new Order
-		app.Post("/orders", func(c *fiber.Ctx) error {
-			order := data.Order{}
-			if err := c.BodyParser(&order); err != nil {
-				return c.Status(http.StatusBadRequest).JSON(Response{Message: err.Error()})
-			}
-			order, status, err := data.CreateOrder(order, <-grpcCustomerClient, <-grpcSupplierClient)
-			if err != nil {
-				return c.Status(status).JSON(Response{Message: err.Error()})
-			}
-			b, _ := json.Marshal(EventMessage{
-				Event: "orders",
-				Data: OrdersData{
-					Length: data.GetOrdersLength(),
-				},
-			})
-			ikisocket.Broadcast(b)
-			return c.Status(status).JSON(order)
-		})
+	// Correct the drift of the stats periodically
+	go schedule.Every(context.Background(), config.StatsInterval, "orders stats", data.RecomputeStats)
 
-		// Get all Orders
-		app.Get("/orders", func(c *fiber.Ctx) error {
-			supplierID := c.Query("supplier_id")
-			customerID := c.Query("customer_id")
-			if strings.TrimSpace(supplierID) != "" && strings.TrimSpace(customerID) != "" {
-				return c.Status(http.StatusBadRequest).JSON(Response{Message: "supplier_id and customer_id are mutually exclusive"})
-			}
-			if strings.TrimSpace(supplierID) != "" {
-				orders, status, err := data.GetOrdersBySupplierID(supplierID, <-grpcSupplierClient)
-				if err != nil {
-					return c.Status(status).JSON(Response{Message: err.Error()})
-				}
-				return c.Status(status).JSON(orders)
-			}
-			if strings.TrimSpace(customerID) != "" {
-				orders, status, err := data.GetOrdersByCustomerID(customerID, <-grpcCustomerClient)
-				if err != nil {
-					return c.Status(status).JSON(Response{Message: err.Error()})
-				}
-				return c.Status(status).JSON(orders)
-			}
-			orders, status, err := data.GetOrders()
-			if err != nil {
-				return c.Status(status).JSON(Response{Message: err.Error()})
-			}
-			return c.Status(status).JSON(orders)
-		})
+	wg.Add(2)
 
-		// Get a Order by ID
-		app.Get("/orders/:id", GetOrderByID)
+	// Start the grpc server
+	go func() {
+		defer wg.Done()
+		lis, err := net.Listen("tcp", "0.0.0.0:4002")
+		if err != nil {
+			log.Fatalf("failed to listen: %s", err.Error())
+		}
+		defer lis.Close()
 
-		// Update a Order by ID
-		app.Put("/orders/:id", UpdateOrderByID)
+		s := api.NewGRPCServer(grpcAuthClient, grpcCustomerClient, grpcSupplierClient)
 
-		// Delete a Order by ID
-		app.Delete("/orders/:id", DeleteOrderByID)
+		log.Print("Starting Order gRPC server on port 4002")
+		if err := s.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %s", err.Error())
+		}
+	}()
 
+	// Start the http server
+	go func() {
+		defer wg.Done()
+		app := api.NewApp(config, grpcAuthClient, grpcCustomerClient, grpcSupplierClient)
 		app.Listen("0.0.0.0:3002")
 	}()
 
 	wg.Wait()
 }
-
-// CreateOrder creates a new Order
-// @Summary Create a new Order
-// @Description Create a new Order
-// @ID create-order
-// @Accept  json
-// @Produce  json
-// @Param order body data.Order true "Order"
-// @Success 201 {object} data.Order
-// @Failure 401 {object} Response
-// @Failure 400 {object} Response
-// @Failure 500 {object} Response
-// @Router /orders [post]
-func CreateOrder() {}
-
-// GetOrders returns all Orders
-// @Summary Get all Orders
-// @Description Get all Orders
-// @ID get-orders
-// @Accept  json
-// @Produce  json
-// @Param supplier_id query string false "Supplier ID"
-// @Param customer_id query string false "Customer ID"
-// @Success 200 {array} data.Order
-// @Failure 401 {object} Response
-// @Failure 404 {object} Response
-// @Failure 400 {object} Response
-// @Failure 500 {object} Response
-// @Router /orders [get]
-func GetOrders() {}
-
-// GetOrderByID returns a Order by ID
-// @Summary Get a Order by ID
-// @Description Get a Order by ID
-// @ID get-order-by-id
-// @Accept  json
-// @Produce  json
-// @Param id path string true "Order ID"
-// @Success 200 {object} data.Order
-// @Failure 401 {object} Response
-// @Failure 404 {object} Response
-// @Failure 400 {object} Response
-// @Failure 500 {object} Response
-// @Router /orders/{id} [get]
-func GetOrderByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	order, status, err := data.GetOrder(id)
-	if err != nil {
-		return c.Status(status).JSON(Response{Message: err.Error()})
-	}
-	return c.Status(status).JSON(order)
-}
-
-// UpdateOrderByID updates a Order by ID
-// @Summary Update a Order by ID
-// @Description Update a Order by ID
-// @ID update-order-by-id
-// @Accept  json
-// @Produce  json
-// @Param id path string true "Order ID"
-// @Param order body data.Order true "Order"
-// @Success 200 {object} Response
-// @Failure 401 {object} Response
-// @Failure 404 {object} Response
-// @Failure 400 {object} Response
-// @Failure 500 {object} Response
-// @Router /orders/{id} [put]
-func UpdateOrderByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	order := data.Order{}
-	if err := c.BodyParser(&order); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(Response{Message: err.Error()})
-	}
-	order, status, err := data.UpdateOrder(id, order)
-	if err != nil {
-		return c.Status(status).JSON(Response{Message: err.Error()})
-	}
-	return c.Status(status).JSON(Response{Message: "Order updated successfully"})
-}
-
-// DeleteOrderByID deletes a Order by ID
-// @Summary Delete a Order by ID
-// @Description Delete a Order by ID
-// @ID delete-order-by-id
-// @Accept  json
-// @Produce  json
-// @Param id path string true "Order ID"
-// @Success 200 {object} Response
-// @Failure 401 {object} Response
-// @Failure 404 {object} Response
-// @Failure 400 {object} Response
-// @Failure 500 {object} Response
-// @Router /orders/{id} [delete]
-func DeleteOrderByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	status, err := data.DeleteOrder(id)
-	if err != nil {
-		return c.Status(status).JSON(Response{Message: err.Error()})
-	}
-	b, _ := json.Marshal(EventMessage{
-		Event: "orders",
-		Data: OrdersData{
-			Length: data.GetOrdersLength(),
-		},
-	})
-	ikisocket.Broadcast(b)
-	return c.Status(status).JSON(Response{
-		Message: "Order deleted",
-	})
-}
@@ -0,0 +1,1075 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/idempotency"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/Omar-Belghaouti/pdash/services/orders/api"
+	"github.com/Omar-Belghaouti/pdash/services/orders/data"
+	"github.com/Omar-Belghaouti/pdash/services/orders/util"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// customerServer knows about a fixed set of customers
+type customerServer struct {
+	pb.UnimplementedCustomerServiceServer
+	ids     map[string]bool
+	batches *int32
+}
+
+func (s customerServer) GetCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	if !s.ids[in.Id] {
+		return nil, problem.NotFound("customer_not_found", "customer not found")
+	}
+	return &pb.Customer{Id: in.Id, Name: "customer"}, nil
+}
+
+func (s customerServer) BatchGetCustomers(ctx context.Context, in *pb.Ids) (*pb.Customers, error) {
+	atomic.AddInt32(s.batches, 1)
+	res := &pb.Customers{}
+	for _, id := range in.Ids {
+		if s.ids[id] {
+			res.Customers = append(res.Customers, &pb.Customer{Id: id, Name: "customer"})
+		}
+	}
+	return res, nil
+}
+
+func (s customerServer) CountCustomers(ctx context.Context, in *pb.Empty) (*pb.Count, error) {
+	return &pb.Count{Count: int64(len(s.ids))}, nil
+}
+
+func (s customerServer) SearchCustomers(ctx context.Context, in *pb.SearchRequest) (*pb.CustomerHits, error) {
+	res := &pb.CustomerHits{}
+	for id := range s.ids {
+		if hit, ok := search.Match(in.Q, "customer"); ok {
+			res.Hits = append(res.Hits, &pb.CustomerHit{Customer: &pb.Customer{Id: id, Name: "customer"}, Score: hit.Score, Highlight: hit.Highlight})
+		}
+	}
+	return res, nil
+}
+
+// trashingCustomerServer moves the customer id to the trash once it has been
+// looked up
+type trashingCustomerServer struct {
+	customerServer
+	id   string
+	gets int32
+}
+
+func (s *trashingCustomerServer) GetCustomer(ctx context.Context, in *pb.Customer) (*pb.Customer, error) {
+	if in.Id != s.id {
+		return s.customerServer.GetCustomer(ctx, in)
+	}
+	if atomic.AddInt32(&s.gets, 1) > 1 {
+		return nil, problem.NotFound("customer_not_found", "customer not found")
+	}
+	return &pb.Customer{Id: in.Id, Name: "customer"}, nil
+}
+
+// supplierServer knows about a fixed set of suppliers
+type supplierServer struct {
+	pb.UnimplementedSupplierServiceServer
+	ids     map[string]bool
+	batches *int32
+}
+
+func (s supplierServer) GetSupplier(ctx context.Context, in *pb.Supplier) (*pb.Supplier, error) {
+	if !s.ids[in.Id] {
+		return nil, problem.NotFound("supplier_not_found", "supplier not found")
+	}
+	return &pb.Supplier{Id: in.Id, Name: "supplier"}, nil
+}
+
+func (s supplierServer) BatchGetSuppliers(ctx context.Context, in *pb.Ids) (*pb.Suppliers, error) {
+	atomic.AddInt32(s.batches, 1)
+	res := &pb.Suppliers{}
+	for _, id := range in.Ids {
+		if s.ids[id] {
+			res.Suppliers = append(res.Suppliers, &pb.Supplier{Id: id, Name: "supplier"})
+		}
+	}
+	return res, nil
+}
+
+func (s supplierServer) CountSuppliers(ctx context.Context, in *pb.Empty) (*pb.Count, error) {
+	return &pb.Count{Count: int64(len(s.ids))}, nil
+}
+
+func (s supplierServer) SearchSuppliers(ctx context.Context, in *pb.SearchRequest) (*pb.SupplierHits, error) {
+	res := &pb.SupplierHits{}
+	for id := range s.ids {
+		if hit, ok := search.Match(in.Q, "supplier"); ok {
+			res.Hits = append(res.Hits, &pb.SupplierHit{Supplier: &pb.Supplier{Id: id, Name: "supplier"}, Score: hit.Score, Highlight: hit.Highlight})
+		}
+	}
+	return res, nil
+}
+
+type testEnv struct {
+	app            *fiber.App
+	client         pb.OrderServiceClient
+	customers      pb.CustomerServiceClient
+	suppliers      pb.SupplierServiceClient
+	mr             *miniredis.Miniredis
+	customerID     primitive.ObjectID
+	supplierID     primitive.ObjectID
+	customerIDs    map[string]bool
+	customerServer *grpc.Server
+	batches        *int32
+}
+
+func setup(t *testing.T) testEnv {
+	t.Helper()
+	mr, rdb := testutil.Redis(t)
+	data.Use(memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), rdb)
+
+	customerID := primitive.NewObjectID()
+	supplierID := primitive.NewObjectID()
+
+	_, authClient := testutil.Auth(t)
+	customerIDs := map[string]bool{customerID.Hex(): true}
+	batches := new(int32)
+	customers := grpc.NewServer()
+	pb.RegisterCustomerServiceServer(customers, customerServer{ids: customerIDs, batches: batches})
+	suppliers := grpc.NewServer()
+	pb.RegisterSupplierServiceServer(suppliers, supplierServer{ids: map[string]bool{supplierID.Hex(): true}, batches: batches})
+
+	config := util.Config{RequestTimeout: 5 * time.Second}
+	customerClient := pb.NewCustomerServiceClient(testutil.ServeGRPC(t, customers))
+	supplierClient := pb.NewSupplierServiceClient(testutil.ServeGRPC(t, suppliers))
+	app := api.NewApp(config, authClient, customerClient, supplierClient)
+	return testEnv{
+		app:            app,
+		client:         pb.NewOrderServiceClient(testutil.ServeGRPC(t, api.NewGRPCServer(authClient, customerClient, supplierClient))),
+		customers:      customerClient,
+		suppliers:      supplierClient,
+		mr:             mr,
+		customerID:     customerID,
+		supplierID:     supplierID,
+		customerIDs:    customerIDs,
+		customerServer: customers,
+		batches:        batches,
+	}
+}
+
+// cacheKey is the Redis key of the cached order id
+func cacheKey(id string) string {
+	return "orders:v2:" + id
+}
+
+func (env testEnv) request(t *testing.T, method, target string, body interface{}) (int, []byte) {
+	t.Helper()
+	res, b := testutil.Request(t, env.app, method, target, body, "Authorization", "Bearer "+testutil.Token)
+	return res.StatusCode, b
+}
+
+func TestCreateOrderChecksReferences(t *testing.T) {
+	env := setup(t)
+	tests := []struct {
+		name     string
+		order    data.Order
+		expected int
+		code     string
+	}{
+		{"unknown customer", data.Order{CustomerID: primitive.NewObjectID(), SupplierID: env.supplierID}, http.StatusNotFound, "customer_not_found"},
+		{"unknown supplier", data.Order{CustomerID: env.customerID, SupplierID: primitive.NewObjectID()}, http.StatusNotFound, "supplier_not_found"},
+		{"valid order", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42}, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		code, body := env.request(t, http.MethodPost, "/orders", tt.order)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if code != tt.expected || p.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.name, tt.expected, tt.code, code, body)
+		}
+	}
+
+	env.customerServer.Stop()
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID})
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusServiceUnavailable || p.Code != "upstream_unavailable" {
+		t.Errorf("customers down: expected 503 upstream_unavailable, got %d: %s", code, body)
+	}
+}
+
+func TestOrdersOfTrashedReferences(t *testing.T) {
+	env := setup(t)
+	ctx := context.Background()
+	trashed := primitive.NewObjectID()
+	trashing := func() pb.CustomerServiceClient {
+		server := grpc.NewServer()
+		pb.RegisterCustomerServiceServer(server, &trashingCustomerServer{customerServer: customerServer{ids: env.customerIDs, batches: env.batches}, id: trashed.Hex()})
+		return pb.NewCustomerServiceClient(testutil.ServeGRPC(t, server))
+	}
+
+	// an order created while its customer goes to the trash goes there too
+	order, err := data.CreateOrder(ctx, data.Order{CustomerID: trashed, SupplierID: env.supplierID}, testutil.User, trashing(), env.suppliers)
+	if problem.From(err).Code != "customer_not_found" {
+		t.Fatalf("create: expected customer_not_found, got %v", err)
+	}
+	if _, err := data.GetOrder(ctx, order.ID.Hex()); problem.From(err).Code != "order_not_found" {
+		t.Errorf("create: expected the order to be in the trash, got %v", err)
+	}
+
+	// an order moved to a customer going to the trash gets its customer back
+	order, err = data.CreateOrder(ctx, data.Order{CustomerID: env.customerID, SupplierID: env.supplierID}, testutil.User, env.customers, env.suppliers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = data.UpdateOrder(ctx, order.ID.Hex(), data.Order{CustomerID: trashed, SupplierID: env.supplierID}, 0, testutil.User, trashing(), env.suppliers)
+	if problem.From(err).Code != "customer_not_found" {
+		t.Fatalf("update: expected customer_not_found, got %v", err)
+	}
+	order, err = data.GetOrder(ctx, order.ID.Hex())
+	if err != nil || order.CustomerID != env.customerID {
+		t.Errorf("update: expected the order to reference its customer again, got %v (%v)", order.CustomerID, err)
+	}
+}
+
+func TestOrderCRUD(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var order data.Order
+	json.Unmarshal(body, &order)
+	id := order.ID.Hex()
+
+	for _, target := range []string{"/orders", "/orders?customer_id=" + env.customerID.Hex(), "/orders?supplier_id=" + env.supplierID.Hex()} {
+		code, body = env.request(t, http.MethodGet, target, nil)
+		var orders struct {
+			Items data.Orders `json:"items"`
+		}
+		json.Unmarshal(body, &orders)
+		if code != http.StatusOK || len(orders.Items) != 1 {
+			t.Fatalf("list %s: expected 1 order, got %d: %s", target, code, body)
+		}
+	}
+	code, body = env.request(t, http.MethodGet, "/orders?customer_id="+env.customerID.Hex()+"&supplier_id="+env.supplierID.Hex(), nil)
+	var both struct {
+		Items data.Orders `json:"items"`
+	}
+	json.Unmarshal(body, &both)
+	if code != http.StatusOK || len(both.Items) != 1 {
+		t.Fatalf("list by customer and supplier: expected 1 order, got %d: %s", code, body)
+	}
+
+	code, body = env.request(t, http.MethodGet, "/orders/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d: %s", code, body)
+	}
+	if !env.mr.Exists(cacheKey(id)) {
+		t.Fatal("order should be cached after a get")
+	}
+
+	order.TotalPrice = 50
+	code, body = env.request(t, http.MethodPut, "/orders/"+id, order)
+	var updated data.Order
+	if json.Unmarshal(body, &updated); code != http.StatusOK || updated.TotalPrice != 50 || updated.Version != 2 {
+		t.Fatalf("update: expected the order at version 2, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/"+id, nil)
+	json.Unmarshal(body, &order)
+	if code != http.StatusOK || order.TotalPrice != 50 || order.Version != 2 {
+		t.Fatalf("get updated: expected total price 50 at version 2, got %d: %s", code, body)
+	}
+	res, body := testutil.Request(t, env.app, http.MethodPut, "/orders/"+id, order, "Authorization", "Bearer "+testutil.Token, fiber.HeaderIfMatch, `"1"`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("update stale version: expected 412, got %d: %s", res.StatusCode, body)
+	}
+
+	code, body = env.request(t, http.MethodDelete, "/orders/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	if env.mr.Exists(cacheKey(id)) {
+		t.Fatal("order should be evicted from the cache after a delete")
+	}
+	code, _ = env.request(t, http.MethodGet, "/orders/"+id, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("get deleted: expected 404, got %d", code)
+	}
+}
+
+func TestOrderCache(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var order data.Order
+	json.Unmarshal(body, &order)
+	id := order.ID.Hex()
+
+	env.request(t, http.MethodDelete, "/orders/"+id, nil)
+	for i := 0; i < 2; i++ {
+		code, body = env.request(t, http.MethodGet, "/orders/"+id, nil)
+		if code != http.StatusNotFound {
+			t.Fatalf("get deleted %d: expected 404, got %d: %s", i, code, body)
+		}
+	}
+	if !env.mr.Exists(cacheKey(id)) {
+		t.Fatal("a deleted order should be cached as not found")
+	}
+	code, body = env.request(t, http.MethodPost, "/orders/"+id+"/restore", nil)
+	if code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get restored: expected 200, got %d: %s", code, body)
+	}
+
+	// the orders are read from the database while Redis is down
+	env.mr.Close()
+	code, body = env.request(t, http.MethodGet, "/orders/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get with Redis down: expected 200, got %d: %s", code, body)
+	}
+	order.TotalPrice = 50
+	order.Version = 0
+	code, body = env.request(t, http.MethodPut, "/orders/"+id, order)
+	if code != http.StatusOK {
+		t.Fatalf("update with Redis down: expected 200, got %d: %s", code, body)
+	}
+}
+
+func TestPatchOrder(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var created data.Order
+	json.Unmarshal(body, &created)
+	target := "/orders/" + created.ID.Hex()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        interface{}
+		expected    int
+		code        string
+		totalPrice  float64
+	}{
+		{"merge patch", http.MethodPatch, patch.ContentType, json.RawMessage(`{"total_price":50}`), http.StatusOK, "", 50},
+		{"plain json", http.MethodPatch, fiber.MIMEApplicationJSON, json.RawMessage(`{"total_price":60}`), http.StatusOK, "", 60},
+		{"unsupported media type", http.MethodPatch, "application/json-patch+json", json.RawMessage(`[{"op":"remove","path":"/total_price"}]`), http.StatusUnsupportedMediaType, "unsupported_media_type", 60},
+		{"unknown customer", http.MethodPatch, patch.ContentType, json.RawMessage(`{"customer_id":"` + primitive.NewObjectID().Hex() + `"}`), http.StatusNotFound, "customer_not_found", 60},
+		{"removed supplier", http.MethodPatch, patch.ContentType, json.RawMessage(`{"supplier_id":null}`), http.StatusBadRequest, "missing_field", 60},
+		{"immutable id", http.MethodPatch, patch.ContentType, json.RawMessage(`{"id":"` + primitive.NewObjectID().Hex() + `"}`), http.StatusBadRequest, "immutable_field", 60},
+		{"immutable created_at", http.MethodPatch, patch.ContentType, json.RawMessage(`{"created_at":"2000-01-01T00:00:00Z"}`), http.StatusBadRequest, "immutable_field", 60},
+		{"unknown field", http.MethodPatch, patch.ContentType, json.RawMessage(`{"discount":5}`), http.StatusBadRequest, "invalid_patch", 60},
+		{"put without customer", http.MethodPut, fiber.MIMEApplicationJSON, data.Order{SupplierID: env.supplierID, TotalPrice: 70}, http.StatusBadRequest, "missing_field", 60},
+		{"put", http.MethodPut, fiber.MIMEApplicationJSON, data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 70}, http.StatusOK, "", 70},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, env.app, tt.method, target, tt.body, "Authorization", "Bearer "+testutil.Token, fiber.HeaderContentType, tt.contentType)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != tt.expected || p.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.name, tt.expected, tt.code, res.StatusCode, body)
+		}
+		code, body := env.request(t, http.MethodGet, target, nil)
+		var order data.Order
+		json.Unmarshal(body, &order)
+		if code != http.StatusOK || order.TotalPrice != tt.totalPrice || order.CustomerID != env.customerID || order.SupplierID != env.supplierID || order.CreatedAt != created.CreatedAt {
+			t.Errorf("%s: expected total price %v with the other fields kept, got %d: %s", tt.name, tt.totalPrice, code, body)
+		}
+	}
+}
+
+func TestRestoreOrder(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var order data.Order
+	json.Unmarshal(body, &order)
+	target := "/orders/" + order.ID.Hex()
+
+	code, body = env.request(t, http.MethodDelete, target, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/trash", nil)
+	var trash data.Orders
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 1 || trash[0].ID != order.ID {
+		t.Fatalf("trash: expected the deleted order, got %d: %s", code, body)
+	}
+
+	// an order cannot be restored once its customer is gone
+	delete(env.customerIDs, env.customerID.Hex())
+	code, body = env.request(t, http.MethodPost, target+"/restore", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusNotFound || p.Code != "customer_not_found" {
+		t.Fatalf("restore without customer: expected 404 customer_not_found, got %d: %s", code, body)
+	}
+
+	env.customerIDs[env.customerID.Hex()] = true
+	code, body = env.request(t, http.MethodPost, target+"/restore", nil)
+	json.Unmarshal(body, &order)
+	if code != http.StatusOK || order.DeletedAt != "" || order.Version != 3 {
+		t.Fatalf("restore: expected the order at version 3, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, target, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get restored: expected 200, got %d: %s", code, body)
+	}
+}
+
+func TestIdempotentCreateOrder(t *testing.T) {
+	env := setup(t)
+	order := data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		res, body := testutil.Request(t, env.app, http.MethodPost, "/orders", order, "Authorization", "Bearer "+testutil.Token, idempotency.Header, "retry-me")
+		if res.StatusCode != http.StatusCreated || res.Header.Get(fiber.HeaderETag) != `"1"` {
+			t.Fatalf("attempt %d: expected 201 with the ETag, got %d %q: %s", i, res.StatusCode, res.Header.Get(fiber.HeaderETag), body)
+		}
+		var created data.Order
+		json.Unmarshal(body, &created)
+		ids = append(ids, created.ID.Hex())
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("expected the retry to replay order %s, got %s", ids[0], ids[1])
+	}
+	code, body := env.request(t, http.MethodGet, "/orders", nil)
+	var orders struct {
+		Items data.Orders `json:"items"`
+	}
+	json.Unmarshal(body, &orders)
+	if code != http.StatusOK || len(orders.Items) != 1 {
+		t.Errorf("expected a single order, got %d: %s", code, body)
+	}
+
+	order.TotalPrice = 43
+	res, body := testutil.Request(t, env.app, http.MethodPost, "/orders", order, "Authorization", "Bearer "+testutil.Token, idempotency.Header, "retry-me")
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if res.StatusCode != http.StatusConflict || p.Code != "idempotency_key_reused" {
+		t.Errorf("expected 409 idempotency_key_reused, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestWebsocketBroadcast(t *testing.T) {
+	env := setup(t)
+	// the stats are broadcast once the outbox is relayed and its events are
+	// consumed, the consumer group only receives the events published once it
+	// exists
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go data.RelayOutbox(ctx, 10*time.Millisecond)
+	go data.ConsumeEvents(ctx, "test", api.BroadcastStats(env.customers, env.suppliers))
+	grouped := testutil.Eventually(2*time.Second, func() bool {
+		return data.Redis().XPending(ctx, events.Stream("order"), "orders:test").Err() == nil
+	})
+	if !grouped {
+		t.Fatal("timed out waiting for the consumer group")
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go env.app.Listener(lis)
+	t.Cleanup(func() { env.app.Shutdown() })
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+lis.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to connect to websocket: %s", err)
+	}
+	defer conn.Close()
+	msgs := make(chan api.EventMessage, 100)
+	go func() {
+		defer close(msgs)
+		for {
+			var msg api.EventMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	// the connection is registered once it receives the pings broadcast
+	registered := testutil.Eventually(2*time.Second, func() bool {
+		ikisocket.Broadcast([]byte(`{"event":"ping"}`))
+		select {
+		case <-msgs:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	})
+	if !registered {
+		t.Fatal("timed out waiting for the websocket connection to be registered")
+	}
+
+	// stats returns the next stats broadcast, ok is false after timeout
+	stats := func(timeout time.Duration) (api.EventMessage, bool) {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					t.Fatal("websocket closed before the broadcast")
+				}
+				if msg.Event == "ping" {
+					continue
+				}
+				return msg, true
+			case <-deadline:
+				return api.EventMessage{}, false
+			}
+		}
+	}
+
+	code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	msg, ok := stats(2 * time.Second)
+	if !ok {
+		t.Fatal("timed out waiting for the broadcast")
+	}
+	if msg.Event != "stats" || msg.Data.Orders != 1 || msg.Data.Revenue != 42 {
+		t.Fatalf("unexpected broadcast %+v", msg)
+	}
+
+	// a customer created is broadcast too
+	env.customerIDs[primitive.NewObjectID().Hex()] = true
+	if err := events.NewPublisher(data.Redis()).Publish(ctx, "customer.created", primitive.NewObjectID().Hex(), testutil.User, nil); err != nil {
+		t.Fatal(err)
+	}
+	msg, ok = stats(2 * time.Second)
+	if !ok {
+		t.Fatal("timed out waiting for the broadcast of the customer")
+	}
+	if msg.Event != "stats" || msg.Data.Customers != 2 || msg.Data.Orders != 1 {
+		t.Fatalf("unexpected broadcast %+v", msg)
+	}
+}
+
+func TestStats(t *testing.T) {
+	env := setup(t)
+	stats := func(expected data.Stats) {
+		t.Helper()
+		code, body := env.request(t, http.MethodGet, "/stats", nil)
+		var got data.Stats
+		json.Unmarshal(body, &got)
+		if code != http.StatusOK || got != expected {
+			t.Fatalf("expected %+v, got %d: %s", expected, code, body)
+		}
+	}
+	stats(data.Stats{Customers: 1, Suppliers: 1})
+
+	var ids []string
+	for _, price := range []float64{42, 8.5} {
+		code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: price})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var order data.Order
+		json.Unmarshal(body, &order)
+		ids = append(ids, order.ID.Hex())
+	}
+	// the changes are counted and the stored figures are dropped with them
+	if env.mr.Exists("orders:stats") {
+		t.Fatal("expected the stored figures to be invalidated")
+	}
+	stats(data.Stats{Customers: 1, Suppliers: 1, Orders: 2, Revenue: 50.5, AverageOrderValue: 25.25, OrdersPerCustomer: 2, RevenuePerCustomer: 50.5})
+
+	code, body := env.request(t, http.MethodPut, "/orders/"+ids[0], data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 10})
+	if code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", code, body)
+	}
+	if code, body := env.request(t, http.MethodDelete, "/orders/"+ids[1], nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	stats(data.Stats{Customers: 1, Suppliers: 1, Orders: 1, Revenue: 10, AverageOrderValue: 10, OrdersPerCustomer: 1, RevenuePerCustomer: 10})
+
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	if _, err := env.client.DeleteOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := env.client.GetStats(ctx, &pb.Empty{})
+	if err != nil || res.Orders != 0 || res.Revenue != 0 || res.Customers != 1 {
+		t.Fatalf("expected no orders left after the trash, got %v (%v)", res, err)
+	}
+
+	// the counters are read again once the figures expire
+	env.mr.Del("orders:stats")
+	if code, body := env.request(t, http.MethodPost, "/orders/"+ids[1]+"/restore", nil); code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", code, body)
+	}
+	stats(data.Stats{Customers: 1, Suppliers: 1, Orders: 1, Revenue: 8.5, AverageOrderValue: 8.5, OrdersPerCustomer: 1, RevenuePerCustomer: 8.5})
+
+	// recomputing the figures agrees with the counters
+	if err := data.RecomputeStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if env.mr.Exists("orders:stats") {
+		t.Fatal("expected the stored figures to be invalidated once recomputed")
+	}
+	stats(data.Stats{Customers: 1, Suppliers: 1, Orders: 1, Revenue: 8.5, AverageOrderValue: 8.5, OrdersPerCustomer: 1, RevenuePerCustomer: 8.5})
+}
+
+func TestOrdersOfDeletedReferences(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	client := env.client
+	var ids []string
+	for i := 0; i < 2; i++ {
+		code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var order data.Order
+		json.Unmarshal(body, &order)
+		ids = append(ids, order.ID.Hex())
+	}
+
+	count, err := client.CountOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()})
+	if err != nil || count.Count != 2 {
+		t.Fatalf("count: expected 2 orders, got %v (%v)", count, err)
+	}
+
+	// the customer is gone, the report lists its orders
+	delete(env.customerIDs, env.customerID.Hex())
+	code, body := env.request(t, http.MethodGet, "/orders/orphans", nil)
+	var orphans data.Orphans
+	json.Unmarshal(body, &orphans)
+	if code != http.StatusOK || len(orphans) != 2 || !orphans[0].MissingCustomer || orphans[0].MissingSupplier {
+		t.Fatalf("orphans: expected the 2 orders missing their customer, got %d: %s", code, body)
+	}
+	if n := atomic.LoadInt32(env.batches); n != 2 {
+		t.Errorf("orphans: expected a single lookup of the customers and the suppliers, got %d", n)
+	}
+
+	// reassigning them to another customer fixes them
+	to := primitive.NewObjectID()
+	env.customerIDs[to.Hex()] = true
+	count, err = client.ReassignOrdersByCustomer(ctx, &pb.Reassignment{FromId: env.customerID.Hex(), ToId: to.Hex()})
+	if err != nil || count.Count != 2 {
+		t.Fatalf("reassign: expected 2 orders, got %v (%v)", count, err)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/"+ids[0], nil)
+	var order data.Order
+	json.Unmarshal(body, &order)
+	if code != http.StatusOK || order.CustomerID != to || order.Version != 2 {
+		t.Fatalf("reassign: expected the order at version 2 of the new customer, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/orphans", nil)
+	if code != http.StatusOK || string(body) != "[]" {
+		t.Fatalf("orphans: expected none after the reassign, got %d: %s", code, body)
+	}
+
+	// deleting the new customer moves them to the trash
+	count, err = client.DeleteOrdersByCustomer(ctx, &pb.Customer{Id: to.Hex()})
+	if err != nil || count.Count != 2 {
+		t.Fatalf("cascade: expected 2 orders, got %v (%v)", count, err)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/trash", nil)
+	var trash data.Orders
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 2 || trash[0].DeletedBy != "omar" {
+		t.Fatalf("cascade: expected the 2 orders in the trash deleted by omar, got %d: %s", code, body)
+	}
+	if count, err := client.CountOrdersBySupplier(ctx, &pb.Supplier{Id: env.supplierID.Hex()}); err != nil || count.Count != 0 {
+		t.Fatalf("count: expected no orders left, got %v (%v)", count, err)
+	}
+}
+
+func TestRestoreOrdersWithTheirCustomer(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 42})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var order data.Order
+		json.Unmarshal(body, &order)
+		ids = append(ids, order.ID.Hex())
+	}
+	if code, body := env.request(t, http.MethodDelete, "/orders/"+ids[0], nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+
+	count, err := env.client.DeleteOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()})
+	if err != nil || count.Count != 2 {
+		t.Fatalf("cascade: expected 2 orders, got %v (%v)", count, err)
+	}
+	code, body := env.request(t, http.MethodGet, "/orders/trash", nil)
+	var trash data.Orders
+	json.Unmarshal(body, &trash)
+	with := 0
+	for _, order := range trash {
+		if order.DeletedWith == "customer:"+env.customerID.Hex() {
+			with++
+		}
+	}
+	if code != http.StatusOK || len(trash) != 3 || with != 2 {
+		t.Fatalf("cascade: expected 2 of the 3 orders in the trash deleted with the customer, got %d: %s", code, body)
+	}
+
+	// only the orders deleted with the customer come back with it
+	count, err = env.client.RestoreOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()})
+	if err != nil || count.Count != 2 {
+		t.Fatalf("restore: expected 2 orders, got %v (%v)", count, err)
+	}
+	code, body = env.request(t, http.MethodGet, "/orders/"+ids[1], nil)
+	var order data.Order
+	json.Unmarshal(body, &order)
+	if code != http.StatusOK || order.DeletedAt != "" || order.DeletedWith != "" || order.Version != 3 {
+		t.Fatalf("restore: expected the order back at version 3, got %d: %s", code, body)
+	}
+	if code, _ := env.request(t, http.MethodGet, "/orders/"+ids[0], nil); code != http.StatusNotFound {
+		t.Fatalf("restore: expected the order deleted on its own to stay in the trash, got %d", code)
+	}
+	if count, err := env.client.RestoreOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()}); err != nil || count.Count != 0 {
+		t.Fatalf("restore: expected nothing left to restore, got %v (%v)", count, err)
+	}
+}
+
+func TestChangeOrdersInPages(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	const n = 250
+	for i := 0; i < n; i++ {
+		if _, err := data.CreateOrder(ctx, data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: 1}, testutil.User, env.customers, env.suppliers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id := &pb.Customer{Id: env.customerID.Hex()}
+	if count, err := env.client.DeleteOrdersByCustomer(ctx, id); err != nil || count.Count != n {
+		t.Fatalf("cascade: expected %d orders, got %v (%v)", n, count, err)
+	}
+	if count, err := env.client.RestoreOrdersByCustomer(ctx, id); err != nil || count.Count != n {
+		t.Fatalf("restore: expected %d orders, got %v (%v)", n, count, err)
+	}
+	to := primitive.NewObjectID()
+	env.customerIDs[to.Hex()] = true
+	if count, err := env.client.ReassignOrdersByCustomer(ctx, &pb.Reassignment{FromId: env.customerID.Hex(), ToId: to.Hex()}); err != nil || count.Count != n {
+		t.Fatalf("reassign: expected %d orders, got %v (%v)", n, count, err)
+	}
+	if count, err := env.client.CountOrdersByCustomer(ctx, &pb.Customer{Id: to.Hex()}); err != nil || count.Count != n {
+		t.Fatalf("reassign: expected %d orders for the new customer, got %v (%v)", n, count, err)
+	}
+}
+
+func TestExpandOrders(t *testing.T) {
+	env := setup(t)
+	missing := primitive.NewObjectID()
+	env.customerIDs[missing.Hex()] = true
+	for _, customerID := range []primitive.ObjectID{env.customerID, env.customerID, missing} {
+		if code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: customerID, SupplierID: env.supplierID}); code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", code, body)
+		}
+	}
+	delete(env.customerIDs, missing.Hex())
+
+	code, body := env.request(t, http.MethodGet, "/orders?expand=customer,supplier", nil)
+	var expanded struct {
+		Items []data.ExpandedOrder `json:"items"`
+	}
+	if err := json.Unmarshal(body, &expanded); code != http.StatusOK || err != nil || len(expanded.Items) != 3 {
+		t.Fatalf("expected 3 expanded orders, got %d: %s", code, body)
+	}
+	orders := expanded.Items
+	if n := atomic.LoadInt32(env.batches); n != 2 {
+		t.Errorf("expected a single batch per service, got %d batches", n)
+	}
+	for _, order := range orders {
+		if order.Supplier == nil || order.Supplier.Id != env.supplierID.Hex() {
+			t.Errorf("expected the supplier of %s to be expanded, got %+v", order.ID.Hex(), order.Supplier)
+		}
+		if expanded := order.Customer != nil; expanded != (order.CustomerID == env.customerID) {
+			t.Errorf("expected only the existing customer to be expanded, got %+v for %s", order.Customer, order.CustomerID.Hex())
+		}
+	}
+
+	code, body = env.request(t, http.MethodGet, "/orders/"+orders[0].ID.Hex()+"?expand=supplier", nil)
+	var order data.ExpandedOrder
+	if err := json.Unmarshal(body, &order); code != http.StatusOK || err != nil || order.Supplier == nil || order.Customer != nil {
+		t.Errorf("expected the supplier only to be expanded, got %d: %s", code, body)
+	}
+
+	code, body = env.request(t, http.MethodGet, "/orders?expand=product", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusBadRequest || p.Code != "invalid_expand" {
+		t.Errorf("expected 400 invalid_expand, got %d: %s", code, body)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodGet, "/orders?customer_id="+env.customerID.Hex(), nil)
+	if code != http.StatusOK || string(body) != `{"items":[],"total":0}` {
+		t.Fatalf("expected an empty page, got %d: %s", code, body)
+	}
+	for _, price := range []float64{30, 10, 20} {
+		if code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: price}); code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", code, body)
+		}
+	}
+
+	var prices []float64
+	target := "/orders?customer_id=" + env.customerID.Hex() + "&sort=-total_price&limit=2"
+	for target != "" {
+		res, body := testutil.Request(t, env.app, http.MethodGet, target, nil, "Authorization", "Bearer "+testutil.Token)
+		var p struct {
+			Items      data.Orders `json:"items"`
+			Total      int64       `json:"total"`
+			NextCursor string      `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil || res.StatusCode != http.StatusOK || p.Total != 3 {
+			t.Fatalf("expected a page of 3 orders, got %d: %s", res.StatusCode, body)
+		}
+		for _, order := range p.Items {
+			prices = append(prices, order.TotalPrice)
+		}
+		target = ""
+		if p.NextCursor != "" {
+			target = "/orders?" + strings.TrimSuffix(strings.TrimPrefix(res.Header.Get("Link"), "<?"), `>; rel="next"`)
+		}
+	}
+	if !reflect.DeepEqual(prices, []float64{30, 20, 10}) {
+		t.Errorf("expected the orders by descending price, got %v", prices)
+	}
+
+	filters := []struct {
+		filter   string
+		expected int
+		total    int64
+		code     string
+	}{
+		{"total_price >= 20", http.StatusOK, 2, ""},
+		{`total_price >= 20 and customer_id in ("` + env.customerID.Hex() + `") and created_at > "2000-01-01"`, http.StatusOK, 2, ""},
+		{`total_price < 20 or not supplier_id = "` + env.supplierID.Hex() + `"`, http.StatusOK, 1, ""},
+		{"deleted_by = 1", http.StatusBadRequest, 0, "invalid_filter"},
+	}
+	for _, tt := range filters {
+		code, body := env.request(t, http.MethodGet, "/orders?filter="+url.QueryEscape(tt.filter), nil)
+		var p struct {
+			Total int64  `json:"total"`
+			Code  string `json:"code"`
+		}
+		json.Unmarshal(body, &p)
+		if code != tt.expected || p.Code != tt.code || p.Total != tt.total {
+			t.Errorf("%s: expected %d %s, got %d: %s", tt.filter, tt.expected, tt.code, code, body)
+		}
+	}
+
+	code, body = env.request(t, http.MethodGet, "/orders?fields=total_price&expand=customer&limit=1", nil)
+	var selected struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	json.Unmarshal(body, &selected)
+	if code != http.StatusOK || len(selected.Items) != 1 {
+		t.Fatalf("expected a single order, got %d: %s", code, body)
+	}
+	keys := make([]string, 0, len(selected.Items[0]))
+	for key := range selected.Items[0] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if expected := []string{"customer", "customer_id", "id", "total_price"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected the fields %v, got %v", expected, keys)
+	}
+}
+
+func TestOrderGRPC(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+
+	created, err := env.client.CreateOrder(ctx, &pb.Order{CustomerId: env.customerID.Hex(), SupplierId: env.supplierID.Hex(), TotalPrice: 42})
+	if err != nil || created.Id == "" || created.Version != 1 {
+		t.Fatalf("create: expected the created order, got %v (%v)", created, err)
+	}
+	got, err := env.client.GetOrder(ctx, &pb.Order{Id: created.Id})
+	if err != nil || got.TotalPrice != 42 || got.CustomerId != env.customerID.Hex() {
+		t.Fatalf("get: expected the order, got %v (%v)", got, err)
+	}
+
+	updated, err := env.client.UpdateOrder(ctx, &pb.Order{Id: created.Id, CustomerId: env.customerID.Hex(), SupplierId: env.supplierID.Hex(), TotalPrice: 50, Version: 1})
+	if err != nil || updated.TotalPrice != 50 || updated.Version != 2 {
+		t.Fatalf("update: expected the order at version 2, got %v (%v)", updated, err)
+	}
+
+	failures := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"stale update", func() error {
+			_, err := env.client.UpdateOrder(ctx, &pb.Order{Id: created.Id, CustomerId: env.customerID.Hex(), SupplierId: env.supplierID.Hex(), Version: 1})
+			return err
+		}, codes.FailedPrecondition},
+		{"unknown customer", func() error {
+			_, err := env.client.CreateOrder(ctx, &pb.Order{CustomerId: primitive.NewObjectID().Hex(), SupplierId: env.supplierID.Hex()})
+			return err
+		}, codes.NotFound},
+		{"invalid id", func() error {
+			_, err := env.client.GetOrder(ctx, &pb.Order{Id: "nope"})
+			return err
+		}, codes.InvalidArgument},
+		{"invalid customer id", func() error {
+			_, err := env.client.CreateOrder(ctx, &pb.Order{CustomerId: "nope", SupplierId: env.supplierID.Hex()})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range failures {
+		if code := status.Code(tt.call()); code != tt.code {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.code, code)
+		}
+	}
+
+	type orderStream interface {
+		Recv() (*pb.Order, error)
+	}
+	streams := []struct {
+		name string
+		open func() (orderStream, error)
+	}{
+		{"all", func() (orderStream, error) {
+			return env.client.GetAllOrders(ctx, &pb.Empty{})
+		}},
+		{"by customer", func() (orderStream, error) {
+			return env.client.GetAllOrdersByCustomer(ctx, &pb.Customer{Id: env.customerID.Hex()})
+		}},
+		{"by supplier", func() (orderStream, error) {
+			return env.client.GetAllOrdersBySupplier(ctx, &pb.Supplier{Id: env.supplierID.Hex()})
+		}},
+	}
+	for _, tt := range streams {
+		stream, err := tt.open()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		var ids []string
+		for {
+			order, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			ids = append(ids, order.Id)
+		}
+		if len(ids) != 1 || ids[0] != created.Id {
+			t.Errorf("%s: expected the order, got %v", tt.name, ids)
+		}
+	}
+
+	deleted, err := env.client.DeleteOrder(ctx, &pb.Order{Id: created.Id})
+	if err != nil || deleted.Id != created.Id {
+		t.Fatalf("delete: expected the deleted order, got %v (%v)", deleted, err)
+	}
+	if _, err := env.client.GetOrder(ctx, &pb.Order{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Fatalf("get deleted: expected NotFound, got %v", err)
+	}
+	// no orders left is an empty stream
+	stream, err := env.client.GetAllOrders(ctx, &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("all after delete: expected an empty stream, got %v", err)
+	}
+	code, body := env.request(t, http.MethodGet, "/orders/trash", nil)
+	var trash data.Orders
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 1 || trash[0].DeletedBy != "omar" {
+		t.Fatalf("trash: expected the order deleted by omar, got %d: %s", code, body)
+	}
+}
+
+func TestSearchOrders(t *testing.T) {
+	env := setup(t)
+	var ids []string
+	for _, price := range []float64{10, 20} {
+		code, body := env.request(t, http.MethodPost, "/orders", data.Order{CustomerID: env.customerID, SupplierID: env.supplierID, TotalPrice: price})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var order data.Order
+		json.Unmarshal(body, &order)
+		ids = append(ids, order.ID.Hex())
+	}
+
+	tests := []struct {
+		q         string
+		expected  int
+		score     float64
+		highlight string
+	}{
+		{"custom", 2, 0.8, "<mark>custom</mark>er"},
+		{"suplier", 2, 0.4 * (1 - 1.0/7), "<mark>supplier</mark>"},
+		{ids[1], 1, 1, "<mark>" + ids[1] + "</mark>"},
+		{"globex", 0, 0, ""},
+	}
+	for _, tt := range tests {
+		code, body := env.request(t, http.MethodGet, "/orders?expand=customer&q="+tt.q, nil)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.q, code, body)
+		}
+		var hits struct{ Items []data.OrderHit }
+		json.Unmarshal(body, &hits)
+		if len(hits.Items) != tt.expected {
+			t.Fatalf("%s: expected %d hits, got %s", tt.q, tt.expected, body)
+		}
+		for _, hit := range hits.Items {
+			if hit.Score != math.Round(tt.score*1000)/1000 || hit.Highlight != tt.highlight || hit.Customer == nil {
+				t.Errorf("%s: expected %v %q and the customer, got %s", tt.q, tt.score, tt.highlight, body)
+			}
+		}
+	}
+
+	code, body := env.request(t, http.MethodGet, "/orders?q=custom&customer_id="+primitive.NewObjectID().Hex(), nil)
+	if code != http.StatusNotFound {
+		t.Errorf("unknown customer: expected 404, got %d: %s", code, body)
+	}
+
+	res, err := env.client.SearchOrders(context.Background(), &pb.SearchRequest{Q: "supplier", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Score != 1 || res.Hits[0].Order.TotalPrice != 20 {
+		t.Errorf("expected the latest order, got %v", res.Hits)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	authapi "github.com/Omar-Belghaouti/pdash/services/auth/api"
+	authdata "github.com/Omar-Belghaouti/pdash/services/auth/data"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/schedule"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	customersapi "github.com/Omar-Belghaouti/pdash/services/customers/api"
+	customersdata "github.com/Omar-Belghaouti/pdash/services/customers/data"
+	customersutil "github.com/Omar-Belghaouti/pdash/services/customers/util"
+	ordersapi "github.com/Omar-Belghaouti/pdash/services/orders/api"
+	ordersdata "github.com/Omar-Belghaouti/pdash/services/orders/data"
+	ordersutil "github.com/Omar-Belghaouti/pdash/services/orders/util"
+	suppliersapi "github.com/Omar-Belghaouti/pdash/services/suppliers/api"
+	suppliersdata "github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+	suppliersutil "github.com/Omar-Belghaouti/pdash/services/suppliers/util"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+)
+
+// route mounts the http application of a service under a path prefix
+type route struct {
+	prefix  string
+	handler fasthttp.RequestHandler
+}
+
+// allInOne runs auth, customers, suppliers and orders in a single process
+func allInOne(args []string) error {
+	flags := flag.NewFlagSet("all-in-one", flag.ExitOnError)
+	addr := flags.String("addr", "0.0.0.0:8000", "address of the http listener")
+	memory := flags.Bool("memory", false, "keep data in memory instead of MongoDB and Redis")
+	flags.Parse(args)
+
+	if *memory {
+		mr, err := miniredis.Run()
+		if err != nil {
+			return err
+		}
+		defer mr.Close()
+		useMemory(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		log.Print("Using in-memory storage, data is lost on exit")
+	}
+
+	handler, stop, err := newHandler()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	log.Printf("Starting pdash all-in-one on %s", *addr)
+	server := &fasthttp.Server{Handler: handler}
+	return server.ListenAndServe(*addr)
+}
+
+// useMemory switches the data package of every service to in-memory storage
+func useMemory(rdb *redis.Client) {
+	// the services share the history and stats collections, as they do in
+	// MongoDB
+	changes, counters := memdb.NewCollection(), memdb.NewCollection()
+	authdata.Use(memdb.NewCollection().Unique("username"), memdb.NewCollection(), rdb)
+	customersdata.Use(memdb.NewCollection(), changes, memdb.NewCollection(), counters, rdb)
+	suppliersdata.Use(memdb.NewCollection(), changes, memdb.NewCollection(), counters, rdb)
+	ordersdata.Use(memdb.NewCollection(), changes, memdb.NewCollection(), counters, rdb)
+}
+
+// newHandler wires the services together over in-process gRPC connections
+// and returns the http handler serving all of them, stop releases the gRPC
+// servers and connections
+func newHandler() (fasthttp.RequestHandler, func(), error) {
+	customersConfig, err := customersutil.LoadConfig(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	suppliersConfig, err := suppliersutil.LoadConfig(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	ordersConfig, err := ordersutil.LoadConfig(".")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var servers []*grpc.Server
+	var conns []*grpc.ClientConn
+	stop := func() {
+		cancel()
+		for _, cc := range conns {
+			cc.Close()
+		}
+		for _, s := range servers {
+			s.Stop()
+		}
+	}
+	// every service gets a pipe dialed before serving it, the customers and
+	// suppliers servers call the orders server and the other way around
+	serve := func(p *rpc.Pipe, s *grpc.Server) {
+		servers = append(servers, s)
+		p.Serve(s)
+	}
+	dial := func(p *rpc.Pipe, service string, idempotent ...string) (*grpc.ClientConn, error) {
+		opts := rpc.DefaultOptions(service, idempotent...)
+		opts.Timeout = ordersConfig.RPCTimeout
+		cc, err := p.Dial(opts)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, cc)
+		return cc, nil
+	}
+
+	authPipe, customersPipe, suppliersPipe, ordersPipe := rpc.NewPipe(), rpc.NewPipe(), rpc.NewPipe(), rpc.NewPipe()
+	authConn, err := dial(authPipe, "pb.AuthService", "VerifyToken")
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	customersConn, err := dial(customersPipe, "pb.CustomerService", "GetCustomer", "GetAllCustomers", "BatchGetCustomers", "SearchCustomers", "CountCustomers")
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	suppliersConn, err := dial(suppliersPipe, "pb.SupplierService", "GetSupplier", "GetAllSuppliers", "BatchGetSuppliers", "SearchSuppliers", "CountSuppliers")
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	ordersConn, err := dial(ordersPipe, "pb.OrderService",
+		"GetOrder", "GetAllOrders", "GetAllOrdersByCustomer", "GetAllOrdersBySupplier", "CountOrdersByCustomer", "CountOrdersBySupplier", "SearchOrders", "GetStats")
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	authClient := pb.NewAuthServiceClient(authConn)
+	customerClient := pb.NewCustomerServiceClient(customersConn)
+	supplierClient := pb.NewSupplierServiceClient(suppliersConn)
+	orderClient := pb.NewOrderServiceClient(ordersConn)
+	serve(authPipe, authapi.NewGRPCServer())
+	serve(customersPipe, customersapi.NewGRPCServer(customersConfig.OrdersOnDelete, authClient, orderClient))
+	serve(suppliersPipe, suppliersapi.NewGRPCServer(suppliersConfig.OrdersOnDelete, authClient, orderClient))
+	serve(ordersPipe, ordersapi.NewGRPCServer(authClient, customerClient, supplierClient))
+
+	// Bring the schemas up to date, indexes included
+	for _, migrate := range []func(context.Context) error{authdata.Migrate, customersdata.Migrate, suppliersdata.Migrate, ordersdata.Migrate} {
+		if err := migrate(ctx); err != nil {
+			stop()
+			return nil, nil, err
+		}
+	}
+
+	// Keep the cached records in memory coherent with the other replicas
+	go customersdata.ListenCacheInvalidations(ctx)
+	go suppliersdata.ListenCacheInvalidations(ctx)
+	go ordersdata.ListenCacheInvalidations(ctx)
+
+	// Publish the events stored with the changes, the stats are broadcast
+	// after each of them is consumed, in the group of the host as an orders
+	// replica does
+	replica, err := os.Hostname()
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	go authdata.RelayOutbox(ctx, authdata.Config().OutboxInterval)
+	go customersdata.RelayOutbox(ctx, customersConfig.OutboxInterval)
+	go suppliersdata.RelayOutbox(ctx, suppliersConfig.OutboxInterval)
+	go ordersdata.RelayOutbox(ctx, ordersConfig.OutboxInterval)
+	go ordersdata.ConsumeEvents(ctx, replica, ordersapi.BroadcastStats(customerClient, supplierClient))
+
+	// Purge the trashes periodically
+	go schedule.Every(ctx, customersConfig.PurgeInterval, "customers purge", func(ctx context.Context) error {
+		return customersdata.PurgeCustomers(ctx, customersConfig.TrashRetention)
+	})
+	go schedule.Every(ctx, suppliersConfig.PurgeInterval, "suppliers purge", func(ctx context.Context) error {
+		return suppliersdata.PurgeSuppliers(ctx, suppliersConfig.TrashRetention)
+	})
+	go schedule.Every(ctx, ordersConfig.PurgeInterval, "orders purge", func(ctx context.Context) error {
+		return ordersdata.PurgeOrders(ctx, ordersConfig.TrashRetention)
+	})
+
+	// Correct the drift of the stats periodically
+	go schedule.Every(ctx, customersConfig.StatsInterval, "customers stats", customersdata.RecomputeStats)
+	go schedule.Every(ctx, suppliersConfig.StatsInterval, "suppliers stats", suppliersdata.RecomputeStats)
+	go schedule.Every(ctx, ordersConfig.StatsInterval, "orders stats", ordersdata.RecomputeStats)
+
+	ordersApp := ordersapi.NewApp(ordersConfig, authClient, customerClient, supplierClient)
+	searchApp := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+	searchApp.Get("/search", middleware.Auth(authClient, false), search.Handler(customerClient, supplierClient, orderClient))
+	routes := []route{
+		{"/users", authapi.NewApp().Handler()},
+		{"/customers", customersapi.NewApp(customersConfig, authClient, orderClient).Handler()},
+		{"/suppliers", suppliersapi.NewApp(suppliersConfig, authClient, orderClient).Handler()},
+		{"/orders", ordersApp.Handler()},
+		{"/stats", ordersApp.Handler()},
+		{"/ws", ordersApp.Handler()},
+		{"/search", searchApp.Handler()},
+	}
+	return mount(routes), stop, nil
+}
+
+// mount dispatches every request to the route whose prefix matches its path
+func mount(routes []route) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		path := string(ctx.Path())
+		for _, r := range routes {
+			if path == r.prefix || strings.HasPrefix(path, r.prefix+"/") {
+				r.handler(ctx)
+				return
+			}
+		}
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+	}
+}
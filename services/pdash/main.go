@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+const usage = `usage: pdash <command> [flags]
+
+commands:
+  all-in-one  run every service in a single process
+  replay      deliver the events of a stream or the dead letters again
+  migrate     apply (up), revert (down) or list (status) the schema migrations
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "all-in-one":
+		if err := allInOne(os.Args[2:]); err != nil {
+			log.Fatalf("all-in-one: %s", err.Error())
+		}
+	case "replay":
+		if err := replay(os.Args[2:]); err != nil {
+			log.Fatalf("replay: %s", err.Error())
+		}
+	case "migrate":
+		if err := migrateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %s", err.Error())
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
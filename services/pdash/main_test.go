@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	authdata "github.com/Omar-Belghaouti/pdash/services/auth/data"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	customersdata "github.com/Omar-Belghaouti/pdash/services/customers/data"
+	ordersdata "github.com/Omar-Belghaouti/pdash/services/orders/data"
+	suppliersdata "github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+	"github.com/valyala/fasthttp"
+)
+
+// serve starts the all-in-one handler with in-memory storage and returns
+// its base url
+func serve(t *testing.T) string {
+	t.Helper()
+	_, rdb := testutil.Redis(t)
+	useMemory(rdb)
+	handler, stop, err := newHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fasthttp.Server{Handler: handler}
+	go server.Serve(lis)
+	t.Cleanup(func() { server.Shutdown() })
+	return "http://" + lis.Addr().String()
+}
+
+func request(t *testing.T, method, url, token string, body interface{}) (int, []byte) {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, url, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ = io.ReadAll(res.Body)
+	return res.StatusCode, b
+}
+
+func TestAllInOne(t *testing.T) {
+	url := serve(t)
+
+	user := authdata.User{Username: "omar", Password: "secret"}
+	if code, body := request(t, http.MethodPost, url+"/users", "", user); code != http.StatusCreated {
+		t.Fatalf("create user: expected 201, got %d: %s", code, body)
+	}
+	code, body := request(t, http.MethodPost, url+"/users/login", "", authdata.LoginUserRequest{Username: "omar", Password: "secret"})
+	if code != http.StatusOK {
+		t.Fatalf("login: expected 200, got %d: %s", code, body)
+	}
+	var login authdata.LoginUserResponse
+	json.Unmarshal(body, &login)
+	token := login.AccessToken
+
+	if code, _ := request(t, http.MethodGet, url+"/customers", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("customers without token: expected 401, got %d", code)
+	}
+
+	code, body = request(t, http.MethodPost, url+"/customers", token, customersdata.Customer{Name: "customer"})
+	if code != http.StatusCreated {
+		t.Fatalf("create customer: expected 201, got %d: %s", code, body)
+	}
+	var customer customersdata.Customer
+	json.Unmarshal(body, &customer)
+
+	code, body = request(t, http.MethodPost, url+"/suppliers", token, suppliersdata.Supplier{Name: "supplier"})
+	if code != http.StatusCreated {
+		t.Fatalf("create supplier: expected 201, got %d: %s", code, body)
+	}
+	var supplier suppliersdata.Supplier
+	json.Unmarshal(body, &supplier)
+
+	order := ordersdata.Order{CustomerID: customer.ID, SupplierID: supplier.ID, TotalPrice: 42}
+	if code, body := request(t, http.MethodPost, url+"/orders", token, order); code != http.StatusCreated {
+		t.Fatalf("create order: expected 201, got %d: %s", code, body)
+	}
+	code, body = request(t, http.MethodGet, url+"/orders?customer_id="+customer.ID.Hex(), token, nil)
+	var orders struct {
+		Items ordersdata.Orders `json:"items"`
+	}
+	json.Unmarshal(body, &orders)
+	if code != http.StatusOK || len(orders.Items) != 1 {
+		t.Fatalf("list orders: expected 1 order, got %d: %s", code, body)
+	}
+
+	code, body = request(t, http.MethodGet, url+"/search?q=custom", token, nil)
+	var results search.Results
+	json.Unmarshal(body, &results)
+	if code != http.StatusOK || len(results.Customers) != 1 || len(results.Suppliers) != 0 || len(results.Orders) != 1 {
+		t.Fatalf("search: expected the customer and its order, got %d: %s", code, body)
+	}
+
+	code, body = request(t, http.MethodGet, url+"/stats", token, nil)
+	var stats ordersdata.Stats
+	json.Unmarshal(body, &stats)
+	if code != http.StatusOK || stats.Customers != 1 || stats.Suppliers != 1 || stats.Orders != 1 || stats.Revenue != 42 {
+		t.Fatalf("stats: expected a customer, a supplier and an order of 42, got %d: %s", code, body)
+	}
+
+	if code, _ := request(t, http.MethodGet, url+"/unknown", token, nil); code != http.StatusNotFound {
+		t.Fatalf("unknown prefix: expected 404, got %d", code)
+	}
+}
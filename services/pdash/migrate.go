@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	authdata "github.com/Omar-Belghaouti/pdash/services/auth/data"
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	customersdata "github.com/Omar-Belghaouti/pdash/services/customers/data"
+	ordersdata "github.com/Omar-Belghaouti/pdash/services/orders/data"
+	suppliersdata "github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+)
+
+// migrators returns the Migrators of the services, the databases are the
+// ones of the MONGO_URI the services are configured with
+func migrators() []*migrate.Migrator {
+	return []*migrate.Migrator{authdata.Migrator(), customersdata.Migrator(), suppliersdata.Migrator(), ordersdata.Migrator()}
+}
+
+// migrateCommand applies, reverts or lists the schema migrations of the
+// services
+func migrateCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: pdash migrate <up|down|status> [flags]")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	service := flags.String("service", "", "service to migrate, auth, customers, suppliers or orders, all of them when empty")
+	to := flags.Int("to", -1, "version to migrate up or down to, the latest with up and the one before the latest applied with down by default")
+	flags.Parse(args[1:])
+
+	var selected []*migrate.Migrator
+	for _, m := range migrators() {
+		if *service == "" || m.Service() == *service {
+			selected = append(selected, m)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("unknown service %q", *service)
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		target := *to
+		if target < 0 {
+			target = 0
+		}
+		for _, m := range selected {
+			n, err := m.Up(ctx, target)
+			fmt.Printf("%s: %d migrations applied\n", m.Service(), n)
+			if err != nil {
+				return err
+			}
+		}
+	case "down":
+		if *service == "" {
+			return errors.New("down reverts the migrations of a single service, -service is required")
+		}
+		m := selected[0]
+		target := *to
+		if target < 0 {
+			previous, err := previousVersion(ctx, m)
+			if err != nil {
+				return err
+			}
+			target = previous
+		}
+		n, err := m.Down(ctx, target)
+		fmt.Printf("%s: %d migrations reverted\n", m.Service(), n)
+		return err
+	case "status":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tVERSION\tDESCRIPTION\tAPPLIED AT")
+		for _, m := range selected {
+			statuses, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+			for _, s := range statuses {
+				applied := s.AppliedAt
+				if applied == "" {
+					applied = "pending"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Service, s.Version, s.Description, applied)
+			}
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+	return nil
+}
+
+// previousVersion returns the version of the migration applied before the
+// latest one applied by m, 0 when there is none
+func previousVersion(ctx context.Context, m *migrate.Migrator) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var applied []int
+	for _, s := range statuses {
+		if s.AppliedAt != "" {
+			applied = append(applied, s.Version)
+		}
+	}
+	if len(applied) < 2 {
+		return 0, nil
+	}
+	return applied[len(applied)-2], nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/go-redis/redis/v9"
+)
+
+// replay delivers events again, either the ones of a stream between two IDs
+// or the dead letters
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr := flags.String("redis", "localhost:6379", "address of Redis")
+	stream := flags.String("stream", "", "stream to replay, e.g. events:order")
+	from := flags.String("from", "-", "ID of the first event to replay")
+	to := flags.String("to", "+", "ID of the last event to replay")
+	dead := flags.Bool("dead", false, "move the dead letters back to their streams instead")
+	count := flags.Int64("count", 0, "number of dead letters to move, all of them when 0")
+	flags.Parse(args)
+
+	rdb := redis.NewClient(&redis.Options{Addr: *addr})
+	defer rdb.Close()
+	ctx := context.Background()
+	if *dead {
+		n, err := events.Redrive(ctx, rdb, *count)
+		log.Printf("Moved %d dead letters back to their streams", n)
+		return err
+	}
+	if *stream == "" {
+		return errors.New("either -stream or -dead is required")
+	}
+	n, err := events.Replay(ctx, rdb, *stream, *from, *to)
+	log.Printf("Replayed %d events of %s", n, *stream)
+	return err
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+)
+
+type server struct {
+	pb.UnimplementedSupplierServiceServer
+	ordersOnDelete string
+	orders         pb.OrderServiceClient
+}
+
+// toPB converts a Supplier to its gRPC message
+func toPB(supplier data.Supplier) *pb.Supplier {
+	return &pb.Supplier{
+		Id:        supplier.ID.Hex(),
+		Name:      supplier.Name,
+		Version:   supplier.Version,
+		CreatedAt: supplier.CreatedAt,
+		UpdatedAt: supplier.UpdatedAt,
+	}
+}
+
+// GetSupplier implementation for Supplier gRPC server
+func (s *server) GetSupplier(ctx context.Context, in *pb.Supplier) (*pb.Supplier, error) {
+	supplier, err := data.GetSupplier(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toPB(supplier), nil
+}
+
+// GetAllSuppliers implementation for Supplier gRPC server, the Suppliers
+// are streamed as they are read from the database
+func (s *server) GetAllSuppliers(in *pb.Empty, stream pb.SupplierService_GetAllSuppliersServer) error {
+	return data.StreamSuppliers(stream.Context(), func(supplier data.Supplier) error {
+		return stream.Send(toPB(supplier))
+	})
+}
+
+// BatchGetSuppliers implementation for Supplier gRPC server, the Suppliers not found are
+// left out of the response
+func (s *server) BatchGetSuppliers(ctx context.Context, in *pb.Ids) (*pb.Suppliers, error) {
+	suppliers, err := data.GetSuppliersByIDs(ctx, in.Ids)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.Suppliers{}
+	for _, supplier := range suppliers {
+		res.Suppliers = append(res.Suppliers, toPB(supplier))
+	}
+	return res, nil
+}
+
+// SearchSuppliers implementation for Supplier gRPC server, the best
+// matching Suppliers come first
+func (s *server) SearchSuppliers(ctx context.Context, in *pb.SearchRequest) (*pb.SupplierHits, error) {
+	limit := in.Limit
+	if limit <= 0 {
+		limit = search.DefaultLimit
+	}
+	hits, err := data.SearchSuppliers(ctx, in.Q, nil, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.SupplierHits{}
+	for _, hit := range hits {
+		res.Hits = append(res.Hits, &pb.SupplierHit{Supplier: toPB(hit.Supplier), Score: hit.Score, Highlight: hit.Highlight})
+	}
+	return res, nil
+}
+
+// CountSuppliers implementation for Supplier gRPC server
+func (s *server) CountSuppliers(ctx context.Context, in *pb.Empty) (*pb.Count, error) {
+	count, err := data.CountSuppliers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Count{Count: count}, nil
+}
+
+// CreateSupplier implementation for Supplier gRPC server
+func (s *server) CreateSupplier(ctx context.Context, in *pb.Supplier) (*pb.Supplier, error) {
+	supplier, err := data.CreateSupplier(ctx, data.Supplier{Name: in.Name}, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return toPB(supplier), nil
+}
+
+// UpdateSupplier implementation for Supplier gRPC server, the version of in is the
+// version the Supplier is expected to be at, 0 updates whatever its current
+// version
+func (s *server) UpdateSupplier(ctx context.Context, in *pb.Supplier) (*pb.Supplier, error) {
+	supplier, err := data.UpdateSupplier(ctx, in.Id, data.Supplier{Name: in.Name}, in.Version, rpc.User(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return toPB(supplier), nil
+}
+
+// DeleteSupplier implementation for Supplier gRPC server, it returns the Supplier as it
+// was before moving to the trash. The orders policy of the service applies,
+// reassigning the orders needs the HTTP API which takes the Supplier to reassign
+// them to.
+func (s *server) DeleteSupplier(ctx context.Context, in *pb.Supplier) (*pb.Supplier, error) {
+	supplier, err := data.GetSupplier(ctx, in.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.DeleteSupplier(ctx, in.Id, rpc.User(ctx), s.ordersOnDelete, "", s.orders); err != nil {
+		return nil, err
+	}
+	return toPB(supplier), nil
+}
@@ -0,0 +1,415 @@
+// Package api contains the http and gRPC servers of the suppliers service
+package api
+
+import (
+	"net/http"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/etag"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/idempotency"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/util"
+	swagger "github.com/arsmn/fiber-swagger/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+type Response struct {
+	Message string `json:"message"`
+}
+
+// NewApp creates the http application of the service
+func NewApp(config util.Config, authClient pb.AuthServiceClient, grpcOrderClient pb.OrderServiceClient) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.ErrorHandler,
+	})
+
+	// CORS
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  "*",
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE",
+		ExposeHeaders: "ETag",
+	}))
+
+	// Request timeout
+	app.Use(middleware.Timeout(config.RequestTimeout))
+
+	// Swagger
+	app.Get("/swagger/*", swagger.HandlerDefault)
+
+	// Retried creations replay the response of the first attempt
+	idempotent := idempotency.New(data.Redis(), idempotency.Config{
+		TTL:     config.IdempotencyTTL,
+		LockTTL: 2 * config.RequestTimeout,
+		Timeout: config.CacheTimeout,
+	})
+
+	// Auth middleware
+	app.Use(middleware.Auth(authClient, config.TrustGateway))
+
+	// Create a new Supplier
+	app.Post("/suppliers", idempotent, CreateSupplier)
+
+	// Get all Suppliers
+	app.Get("/suppliers", GetSuppliers)
+
+	// Get the Suppliers in the trash
+	app.Get("/suppliers/trash", GetDeletedSuppliers)
+
+	// Get the changes made to the Suppliers
+	app.Get("/suppliers/audit", GetSupplierAudit)
+
+	// Get a Supplier by ID
+	app.Get("/suppliers/:id", GetSupplierByID)
+
+	// Update a Supplier by ID
+	app.Put("/suppliers/:id", UpdateSupplierByID(config.RequireIfMatch))
+
+	// Patch a Supplier by ID
+	app.Patch("/suppliers/:id", PatchSupplierByID(config.RequireIfMatch))
+
+	// Delete a Supplier by ID
+	app.Delete("/suppliers/:id", DeleteSupplierByID(config.OrdersOnDelete, grpcOrderClient))
+
+	// Restore a Supplier from the trash
+	app.Post("/suppliers/:id/restore", RestoreSupplierByID(grpcOrderClient))
+
+	// Get the changes made to a Supplier by ID
+	app.Get("/suppliers/:id/history", GetSupplierHistoryByID)
+
+	return app
+}
+
+// NewGRPCServer creates the gRPC server of the service, deletes apply the
+// ordersOnDelete policy to the orders through grpcOrderClient. The changes
+// are only made on behalf of callers whose token authClient verifies.
+func NewGRPCServer(ordersOnDelete string, authClient pb.AuthServiceClient, grpcOrderClient pb.OrderServiceClient) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			problem.UnaryServerInterceptor,
+			rpc.Authenticate(authClient, "CreateSupplier", "UpdateSupplier", "DeleteSupplier"),
+		),
+		grpc.StreamInterceptor(problem.StreamServerInterceptor),
+	)
+	pb.RegisterSupplierServiceServer(s, &server{
+		ordersOnDelete: ordersOnDelete,
+		orders:         grpcOrderClient,
+	})
+	reflection.Register(s)
+	return s
+}
+
+// CreateSupplier creates a new Supplier
+// @Summary Create a new Supplier
+// @Description Create a new Supplier
+// @ID create-supplier
+// @Accept  json
+// @Produce  json
+// @Param supplier body data.Supplier true "Supplier"
+// @Param Idempotency-Key header string false "Key making retries of the request safe"
+// @Success 201 {object} data.Supplier
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers [post]
+func CreateSupplier(c *fiber.Ctx) error {
+	supplier := data.Supplier{}
+	if err := c.BodyParser(&supplier); err != nil {
+		return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+	}
+	supplier, err := data.CreateSupplier(c.UserContext(), supplier, middleware.User(c))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	etag.Set(c, supplier.Version)
+	return c.Status(http.StatusCreated).JSON(supplier)
+}
+
+// GetSuppliers gets a page of Suppliers
+// @Summary Get a page of Suppliers
+// @Description Get a page of Suppliers sorted and with the selected fields, or the Suppliers whose name best matches a search along with their score and highlighted name
+// @ID get-suppliers
+// @Accept  json
+// @Produce  json
+// @Param q query string false "Search of the names, matching prefixes and typos, cannot be combined with sort or after"
+// @Param filter query string false "Filter on id, name, version, created_at and updated_at, e.g. version > 1 and not name in (...)"
+// @Param limit query int false "Number of Suppliers of the page, 50 by default and 500 at most"
+// @Param after query string false "next_cursor of the previous page"
+// @Param sort query string false "Field to sort by, name, created_at or updated_at, prefixed with - to sort in descending order"
+// @Param fields query string false "Fields to return along with the id, comma separated"
+// @Success 200 {object} page.Page{items=[]data.Supplier}
+// @Header 200 {string} Link "Relative link to the next page"
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers [get]
+func GetSuppliers(c *fiber.Ctx) error {
+	where, err := filter.Parse(c.Query("filter"), data.FilterFields)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	q, err := page.Parse(c, data.SortableFields, data.Fields)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	text, err := search.Parse(c)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	if text != "" {
+		hits, err := data.SearchSuppliers(c.UserContext(), text, where, q.Limit)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		if len(q.Fields) > 0 {
+			q.Fields = append(q.Fields, "score", "highlight")
+		}
+		return page.Write(c, q, hits, page.Info{Total: int64(len(hits))})
+	}
+	suppliers, info, err := data.GetSuppliers(c.UserContext(), where, q)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return page.Write(c, q, suppliers, info)
+}
+
+// GetSupplierByID gets a Supplier by ID
+// @Summary Get a Supplier by ID
+// @Description Get a Supplier by ID
+// @ID get-supplier-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {object} data.Supplier
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id} [get]
+func GetSupplierByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+	supplier, err := data.GetSupplier(c.UserContext(), id)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	etag.Set(c, supplier.Version)
+	return c.Status(http.StatusOK).JSON(supplier)
+}
+
+// UpdateSupplierByID returns the handler updating a Supplier by ID,
+// requireIfMatch rejects the updates without an If-Match header
+// @Summary Update a Supplier by ID
+// @Description Update a Supplier by ID
+// @ID update-supplier-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param If-Match header string false "ETag of the version being updated"
+// @Param supplier body data.Supplier true "Supplier"
+// @Success 200 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id} [put]
+func UpdateSupplierByID(requireIfMatch bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		supplier := data.Supplier{}
+		if err := c.BodyParser(&supplier); err != nil {
+			return problem.Write(c, problem.Validation("invalid_body", err.Error()))
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		supplier, err = data.UpdateSupplier(c.UserContext(), id, supplier, version, middleware.User(c))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, supplier.Version)
+		return c.Status(http.StatusOK).JSON(supplier)
+	}
+}
+
+// PatchSupplierByID returns the handler applying a JSON merge patch to a
+// Supplier by ID, requireIfMatch rejects the patches without an If-Match header
+// @Summary Patch a Supplier by ID
+// @Description Apply a JSON merge patch (RFC 7396) to a Supplier by ID, id and created_at cannot be changed
+// @ID patch-supplier-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param If-Match header string false "ETag of the version being patched"
+// @Param supplier body data.Supplier true "Merge patch of the Supplier"
+// @Success 200 {object} data.Supplier
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id} [patch]
+func PatchSupplierByID(requireIfMatch bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !patch.IsMergePatch(c) {
+			return fiber.ErrUnsupportedMediaType
+		}
+		version, err := etag.IfMatch(c, requireIfMatch)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		supplier, err := data.PatchSupplier(c.UserContext(), c.Params("id"), c.Body(), version, middleware.User(c))
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, supplier.Version)
+		return c.Status(http.StatusOK).JSON(supplier)
+	}
+}
+
+// DeleteSupplierByID returns the handler deleting a Supplier by ID, policy applies to
+// the Orders referencing it
+// @Summary Delete a Supplier by ID
+// @Description Move a Supplier to the trash, it is purged after the retention period. Depending on the configured policy its Orders prevent the delete (409), go to the trash with it or are reassigned to reassign_to
+// @ID delete-supplier-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Param reassign_to query string false "Supplier ID the Orders are reassigned to"
+// @Success 200 {object} Response
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id} [delete]
+func DeleteSupplierByID(policy string, grpcOrderClient pb.OrderServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		err := data.DeleteSupplier(c.UserContext(), id, middleware.User(c), policy, c.Query("reassign_to"), grpcOrderClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		return c.Status(http.StatusOK).JSON(Response{Message: "Supplier deleted successfully"})
+	}
+}
+
+// GetDeletedSuppliers gets the Suppliers in the trash
+// @Summary Get the Suppliers in the trash
+// @Description Get the deleted Suppliers not purged yet, most recently deleted first
+// @ID get-deleted-suppliers
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} data.Supplier
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/trash [get]
+func GetDeletedSuppliers(c *fiber.Ctx) error {
+	suppliers, err := data.GetDeletedSuppliers(c.UserContext())
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(suppliers)
+}
+
+// RestoreSupplierByID returns the handler restoring a Supplier from the trash
+// along with the Orders deleted with it
+// @Summary Restore a Supplier from the trash
+// @Description Restore a deleted Supplier by ID, the Orders that went to the trash with it are restored too unless their other reference is gone
+// @ID restore-supplier-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {object} data.Supplier
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id}/restore [post]
+func RestoreSupplierByID(grpcOrderClient pb.OrderServiceClient) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		supplier, err := data.RestoreSupplier(c.UserContext(), c.Params("id"), middleware.User(c), grpcOrderClient)
+		if err != nil {
+			return problem.Write(c, err)
+		}
+		etag.Set(c, supplier.Version)
+		return c.Status(http.StatusOK).JSON(supplier)
+	}
+}
+
+// GetSupplierHistoryByID gets the changes made to a Supplier by ID
+// @Summary Get the history of a Supplier by ID
+// @Description Get the changes made to a Supplier by ID with who made them and the fields they changed, most recent first
+// @ID get-supplier-history-by-id
+// @Accept  json
+// @Produce  json
+// @Param id path string true "ID"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/{id}/history [get]
+func GetSupplierHistoryByID(c *fiber.Ctx) error {
+	entries, err := data.GetSupplierHistory(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
+
+// GetSupplierAudit gets the changes made to the Suppliers
+// @Summary Get the audit trail of the Suppliers
+// @Description Get the changes made to the Suppliers, most recent first
+// @ID get-supplier-audit
+// @Accept  json
+// @Produce  json
+// @Param record_id query string false "ID of the Supplier"
+// @Param actor query string false "User who made the changes"
+// @Param action query string false "create, update, delete or restore"
+// @Param since query string false "RFC 3339 timestamp of the oldest change"
+// @Param until query string false "RFC 3339 timestamp of the newest change"
+// @Param limit query int false "Maximum number of changes, 100 by default"
+// @Success 200 {array} history.Entry
+// @Failure 401 {object} problem.Problem
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} problem.Problem
+// @Router /suppliers/audit [get]
+func GetSupplierAudit(c *fiber.Ctx) error {
+	q, err := history.ParseQuery(c)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	entries, err := data.GetSupplierAudit(c.UserContext(), q)
+	if err != nil {
+		return problem.Write(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(entries)
+}
@@ -3,10 +3,27 @@ package data
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Omar-Belghaouti/pdash/services/common/cache"
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/filter"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/middleware"
+	"github.com/Omar-Belghaouti/pdash/services/common/migrate"
+	"github.com/Omar-Belghaouti/pdash/services/common/outbox"
+	"github.com/Omar-Belghaouti/pdash/services/common/page"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/search"
+	"github.com/Omar-Belghaouti/pdash/services/common/stats"
+	"github.com/Omar-Belghaouti/pdash/services/common/tx"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/util"
 	"github.com/go-redis/redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,20 +32,194 @@ import (
 )
 
 var (
-	ctx        context.Context
-	collection *mongo.Collection
+	db         *mongo.Database
+	collection Collection
+	changes    *history.Log
+	box        *outbox.Outbox
+	transact   tx.Func
 	rdb        *redis.Client
+	cached     *cache.Cache
+	figures    *stats.Figures
+	bus        *events.Publisher
+	config     util.Config
 )
 
+// Collection is the subset of *mongo.Collection used by the data package
+type Collection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+}
+
+// SortableFields are the fields Suppliers can be listed sorted by
+var SortableFields = []string{"name", "created_at", "updated_at"}
+
+// Fields are the fields of Suppliers that can be selected when listing them
+var Fields = []string{"id", "name", "version", "created_at", "updated_at"}
+
+// FilterFields are the fields Suppliers can be filtered on when listing them
+var FilterFields = filter.Fields{
+	"id":         filter.ID,
+	"name":       filter.String,
+	"version":    filter.Number,
+	"created_at": filter.String,
+	"updated_at": filter.String,
+}
+
 func init() {
-	ctx = context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
+	var err error
+	config, err = util.LoadConfig(".")
+	if err != nil {
+		log.Fatalf("cannot load config: %s", err.Error())
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.MongoURI))
 	if err != nil {
 		log.Fatalf("Error connecting to MongoDB: %s", err.Error())
 	}
-	collection = client.Database("db").Collection("suppliers")
+	db = client.Database("db")
+	collection = db.Collection("suppliers")
+	changes = history.New(client.Database("db").Collection("history"), "supplier")
+	box = outbox.New(client.Database("db").Collection("suppliers_outbox"))
+	transact = tx.Mongo(client)
 	rdb = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
+		Addr: config.RedisAddr,
+	})
+	bus = events.NewPublisher(rdb)
+	cached = newCache(rdb)
+	figures = newFigures(client.Database("db").Collection("stats"), rdb)
+}
+
+// Use replaces the collection, the history, outbox and stats collections and
+// the Redis client used by the data package, e.g. with in-memory stand-ins
+// that have no transactions nor migrations
+func Use(c Collection, h history.Collection, o outbox.Collection, s stats.Collection, r *redis.Client) {
+	db = nil
+	collection = c
+	changes = history.New(h, "supplier")
+	box = outbox.New(o)
+	transact = tx.None
+	rdb = r
+	bus = events.NewPublisher(r)
+	cached = newCache(r)
+	figures = newFigures(s, r)
+}
+
+// cacheVersion is the version of the representation of the cached Suppliers,
+// bump it when Supplier changes
+const cacheVersion = 1
+
+// newCache returns the cache of the Suppliers stored in r
+func newCache(r *redis.Client) *cache.Cache {
+	return cache.New(r, cache.Config{
+		Service:     "suppliers",
+		Version:     cacheVersion,
+		TTL:         config.CacheTTL,
+		NegativeTTL: config.CacheNegativeTTL,
+		Timeout:     config.CacheTimeout,
+		LocalSize:   config.CacheLocalSize,
+		LocalTTL:    config.CacheLocalTTL,
+	})
+}
+
+// newFigures returns the figures of the Suppliers counted in s and cached in r
+func newFigures(s stats.Collection, r *redis.Client) *stats.Figures {
+	return stats.New(r, s, stats.Config{Key: "suppliers:stats", TTL: config.StatsTTL, Timeout: config.CacheTimeout, DBTimeout: config.DBTimeout}, func(ctx context.Context) (map[string]float64, error) {
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		count, err := collection.CountDocuments(dbCtx, live(bson.M{}))
+		return map[string]float64{"count": float64(count)}, err
+	})
+}
+
+// ListenCacheInvalidations removes from memory the Suppliers changed by the other
+// replicas until ctx is done, when they are kept in memory
+func ListenCacheInvalidations(ctx context.Context) {
+	cached.Listen(ctx)
+}
+
+// Migrations are the schema migrations of the suppliers collections
+var Migrations = []migrate.Migration{
+	migrate.CreateIndexes(1, "index the sortable fields of the suppliers", "suppliers", page.Indexes(SortableFields)...),
+	migrate.CreateIndexes(2, "index the suppliers in the trash", "suppliers", mongo.IndexModel{Keys: bson.D{{Key: "deleted_at", Value: 1}}}),
+	migrate.CreateIndexes(3, "index the suppliers outbox", "suppliers_outbox", outbox.Indexes()...),
+	history.Migration(4, "history"),
+	migrate.CreateIndexes(5, "index the names of the suppliers for search", "suppliers", search.Indexes("name")...),
+	search.Backfill(6, "store the search grams of the names of the suppliers", "suppliers", "name"),
+}
+
+// Migrator returns the Migrator of the suppliers database, it is only
+// available with MongoDB
+func Migrator() *migrate.Migrator {
+	return migrate.New(db, "suppliers", Migrations)
+}
+
+// Migrate applies the pending migrations, in-memory collections have none
+func Migrate(ctx context.Context) error {
+	if db == nil {
+		return nil
+	}
+	_, err := Migrator().Up(ctx, 0)
+	return err
+}
+
+// Redis returns the Redis client used by the data package
+func Redis() *redis.Client {
+	return rdb
+}
+
+// versionFilter matches the documents at version, documents written before
+// versions were introduced have none and are at version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	return version
+}
+
+// live adds to filter the condition excluding the documents in the trash
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// eventTypes are the events published for the actions of the history
+var eventTypes = map[string]string{
+	history.Created:  "supplier.created",
+	history.Updated:  "supplier.updated",
+	history.Deleted:  "supplier.deleted",
+	history.Restored: "supplier.restored",
+}
+
+// record adds the change of a Supplier from before to after, made by actor, to
+// its history and its event to the outbox, ctx must be the one of the
+// transaction storing the change
+func record(ctx context.Context, action, actor string, before interface{}, after Supplier) error {
+	if err := changes.Record(ctx, after.ID.Hex(), action, actor, after.Version, before, after); err != nil {
+		return err
+	}
+	e, err := events.New(eventTypes[action], after.ID.Hex(), actor, events.Change{Before: before, After: after})
+	if err != nil {
+		return err
+	}
+	return box.Add(ctx, e)
+}
+
+// RelayOutbox publishes the events of the outbox to the event bus every
+// interval and as soon as a change is stored, until ctx is done
+func RelayOutbox(ctx context.Context, interval time.Duration) {
+	box.Run(ctx, interval, "suppliers outbox relay", func(ctx context.Context, e events.Event) error {
+		// the change of the event is stored, the figures are read again
+		// before anyone is told about it
+		figures.Invalidate(ctx)
+		cacheCtx, cancel := middleware.WithTimeout(ctx, config.CacheTimeout)
+		defer cancel()
+		return bus.PublishEvent(cacheCtx, e)
 	})
 }
 
@@ -36,8 +227,13 @@ func init() {
 type Supplier struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Name      string             `bson:"name" json:"name"`
+	Version   int64              `bson:"version" json:"version"`
 	CreatedAt string             `bson:"created_at,omitempty" json:"created_at,omitempty"`
 	UpdatedAt string             `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	DeletedAt string             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	// SearchGrams are the trigrams of the name the Supplier is searched by
+	SearchGrams []string `bson:"search_grams,omitempty" json:"-"`
 }
 
 // MarshalBinary is a marshalling function for Customer
@@ -48,110 +244,520 @@ func (s Supplier) MarshalBinary() ([]byte, error) {
 // Suppliers is a slice of Supplier structs
 type Suppliers []Supplier
 
-// CreateSupplier creates a new Supplier document
-func CreateSupplier(supplier Supplier) (Supplier, int, error) {
+// CreateSupplier creates a new Supplier document, actor is the user creating it
+func CreateSupplier(ctx context.Context, supplier Supplier, actor string) (Supplier, error) {
+	if strings.TrimSpace(supplier.Name) == "" {
+		return supplier, problem.Validation("missing_field", "name is required")
+	}
 	supplier.ID = primitive.NewObjectID()
 	supplier.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	supplier.UpdatedAt = supplier.CreatedAt
-	_, err := collection.InsertOne(ctx, supplier)
+	supplier.Version = 1
+	supplier.SearchGrams = search.Grams(supplier.Name)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err := transact(dbCtx, func(ctx context.Context) error {
+		if _, err := collection.InsertOne(ctx, supplier); err != nil {
+			return err
+		}
+		if err := figures.Add(ctx, map[string]float64{"count": 1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Created, actor, nil, supplier)
+	})
+	if err != nil {
+		return supplier, problem.From(err)
+	}
+	box.Notify()
+	figures.Invalidate(ctx)
+	return supplier, nil
+}
+
+// GetSuppliers returns the page of the Suppliers matched by where selected by q
+func GetSuppliers(ctx context.Context, where filter.Node, q page.Query) (Suppliers, page.Info, error) {
+	suppliers := Suppliers{}
+	var info page.Info
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	match := filter.Restrict(live(bson.M{}), where)
+	total, err := collection.CountDocuments(dbCtx, match)
+	if err != nil {
+		return suppliers, info, problem.From(err)
+	}
+	cursor, err := collection.Find(dbCtx, q.Filter(match), q.Options())
+	if err != nil {
+		return suppliers, info, problem.From(err)
+	}
+	next, err := q.Collect(dbCtx, cursor, &suppliers)
+	if err != nil {
+		return suppliers, info, problem.From(err)
+	}
+	return suppliers, page.Info{Total: total, NextCursor: next}, nil
+}
+
+// SupplierHit is a Supplier matching a search
+type SupplierHit struct {
+	Supplier
+	search.Hit
+}
+
+// SearchSuppliers returns the limit Suppliers matched by where whose name
+// best matches the search q, best first
+func SearchSuppliers(ctx context.Context, q string, where filter.Node, limit int64) ([]SupplierHit, error) {
+	hits := []SupplierHit{}
+	if err := search.Validate(q); err != nil {
+		return hits, err
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Aggregate(dbCtx, search.Candidates(q, filter.Restrict(live(search.Query(q)), where)))
 	if err != nil {
-		return supplier, http.StatusInternalServerError, err
+		return hits, problem.From(err)
+	}
+	var candidates Suppliers
+	if err := cursor.All(dbCtx, &candidates); err != nil {
+		return hits, problem.From(err)
+	}
+	for _, supplier := range candidates {
+		if hit, ok := search.Match(q, supplier.Name); ok {
+			hits = append(hits, SupplierHit{Supplier: supplier, Hit: hit})
+		}
 	}
-	return supplier, http.StatusCreated, err
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if limit > 0 && int64(len(hits)) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
 }
 
-// GetSuppliers returns all Suppliers
-func GetSuppliers() (Suppliers, int, error) {
-	var suppliers Suppliers
-	cursor, err := collection.Find(ctx, bson.M{})
+// StreamSuppliers calls send with every Supplier, one at a time as they are read
+// from the database
+func StreamSuppliers(ctx context.Context, send func(Supplier) error) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, live(bson.M{}))
 	if err != nil {
-		return suppliers, http.StatusInternalServerError, err
+		return problem.From(err)
 	}
-	if err := cursor.All(ctx, &suppliers); err != nil {
-		return suppliers, http.StatusInternalServerError, err
+	// the cursor outlives the timeout of the query, it is bound to ctx
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var supplier Supplier
+		if err := cursor.Decode(&supplier); err != nil {
+			return problem.From(err)
+		}
+		if err := send(supplier); err != nil {
+			return err
+		}
 	}
-	if suppliers == nil {
-		return Suppliers{}, http.StatusNotFound, nil
+	if err := cursor.Err(); err != nil {
+		return problem.From(err)
 	}
-	return suppliers, http.StatusOK, nil
+	return nil
 }
 
 // GetSupplier returns a Supplier by ID
-func GetSupplier(id string) (Supplier, int, error) {
+func GetSupplier(ctx context.Context, id string) (Supplier, error) {
 	var supplier Supplier
-	// get supplier from cache
-	val, err := rdb.Get(ctx, id).Result()
-	if err == redis.Nil {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return supplier, problem.Validation("invalid_id", "invalid supplier id")
+	}
+	found, err := cached.Get(ctx, id, &supplier, func(ctx context.Context) (interface{}, bool, error) {
 		// supplier not in cache
-		objectID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return supplier, http.StatusBadRequest, err
+		var stored Supplier
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		err := collection.FindOne(dbCtx, live(bson.M{"_id": objectID})).Decode(&stored)
+		if err == mongo.ErrNoDocuments {
+			return nil, false, nil
 		}
-		filter := bson.M{"_id": objectID}
-		err = collection.FindOne(ctx, filter).Decode(&supplier)
-		if err != nil {
-			return supplier, http.StatusNotFound, err
+		return stored, err == nil, err
+	})
+	if err != nil {
+		return supplier, problem.From(err)
+	}
+	if !found {
+		return supplier, problem.NotFound("supplier_not_found", "supplier not found")
+	}
+	return supplier, nil
+}
+
+// MaxBatch is the maximum number of Suppliers looked up at once
+const MaxBatch = 1000
+
+// GetSuppliersByIDs returns the Suppliers with the given IDs in the order of ids,
+// the missing ones are left out. The cached Suppliers are read from the cache and
+// the others from the database in a single query.
+func GetSuppliersByIDs(ctx context.Context, ids []string) (Suppliers, error) {
+	suppliers := Suppliers{}
+	if len(ids) > MaxBatch {
+		return suppliers, problem.Validation("batch_too_large", fmt.Sprintf("at most %d suppliers can be looked up at once", MaxBatch))
+	}
+	for _, id := range ids {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			return suppliers, problem.Validation("invalid_id", "invalid supplier id "+id)
+		}
+	}
+	found := make(map[string]Supplier, len(ids))
+	err := cached.GetMany(ctx, ids, func(ctx context.Context, missing []string) (map[string]interface{}, error) {
+		// suppliers not in cache
+		objectIDs := make(bson.A, len(missing))
+		for i, id := range missing {
+			objectIDs[i], _ = primitive.ObjectIDFromHex(id)
 		}
-		// set supplier in cache for 5 minutes
-		err = rdb.Set(ctx, id, supplier, time.Minute*5).Err()
+		var stored Suppliers
+		dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+		defer cancel()
+		cursor, err := collection.Find(dbCtx, live(bson.M{"_id": bson.M{"$in": objectIDs}}))
 		if err != nil {
-			return supplier, http.StatusInternalServerError, err
+			return nil, err
 		}
-		return supplier, http.StatusOK, nil
-	} else if err != nil {
-		return supplier, http.StatusInternalServerError, err
+		if err := cursor.All(dbCtx, &stored); err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(stored))
+		for _, supplier := range stored {
+			values[supplier.ID.Hex()] = supplier
+		}
+		return values, nil
+	}, func(id string, data []byte) error {
+		var supplier Supplier
+		if err := json.Unmarshal(data, &supplier); err != nil {
+			return err
+		}
+		found[id] = supplier
+		return nil
+	})
+	if err != nil {
+		return suppliers, problem.From(err)
+	}
+	for _, id := range ids {
+		if supplier, ok := found[id]; ok {
+			suppliers = append(suppliers, supplier)
+			// every Supplier is returned once
+			delete(found, id)
+		}
+	}
+	return suppliers, nil
+}
+
+// UpdateSupplier replaces a Supplier by ID, version is the version the Supplier is
+// expected to be at, 0 updates whatever its current version. actor is the
+// user updating it.
+func UpdateSupplier(ctx context.Context, id string, supplier Supplier, version int64, actor string) (Supplier, error) {
+	return replaceSupplier(ctx, id, version, actor, func(current Supplier) (Supplier, error) {
+		return supplier, nil
+	})
+}
+
+// PatchSupplier applies a JSON merge patch to a Supplier by ID, version is the
+// version the Supplier is expected to be at, 0 patches whatever its current
+// version. actor is the user patching it.
+func PatchSupplier(ctx context.Context, id string, p []byte, version int64, actor string) (Supplier, error) {
+	return replaceSupplier(ctx, id, version, actor, func(current Supplier) (Supplier, error) {
+		supplier := current
+		err := patch.Apply(&supplier, p)
+		return supplier, err
+	})
+}
+
+// replaceSupplier stores the Supplier change makes of the current one in its place,
+// the fields managed by the service cannot be changed. The current Supplier is
+// read in the transaction so that version is compared with the stored one
+// rather than a cached copy.
+func replaceSupplier(ctx context.Context, id string, version int64, actor string, change func(current Supplier) (Supplier, error)) (Supplier, error) {
+	var supplier Supplier
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return supplier, problem.Validation("invalid_id", "invalid supplier id")
 	}
-	// supplier in cache
-	err = json.Unmarshal([]byte(val), &supplier)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		var current Supplier
+		err := collection.FindOne(ctx, live(bson.M{"_id": objectID})).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("supplier_not_found", "supplier not found")
+		} else if err != nil {
+			return err
+		}
+		if version != 0 && current.Version != version {
+			return problem.PreconditionFailed("version_mismatch", fmt.Sprintf("supplier is at version %d", current.Version))
+		}
+		if supplier, err = change(current); err != nil {
+			return err
+		}
+		if !supplier.ID.IsZero() && supplier.ID != current.ID {
+			return problem.Validation("immutable_field", "id cannot be changed")
+		}
+		if supplier.CreatedAt != "" && supplier.CreatedAt != current.CreatedAt {
+			return problem.Validation("immutable_field", "created_at cannot be changed")
+		}
+		if supplier.DeletedAt != current.DeletedAt || supplier.DeletedBy != current.DeletedBy {
+			return problem.Validation("immutable_field", "deleted_at and deleted_by cannot be changed")
+		}
+		if strings.TrimSpace(supplier.Name) == "" {
+			return problem.Validation("missing_field", "name is required")
+		}
+		supplier.ID = current.ID
+		supplier.CreatedAt = current.CreatedAt
+		supplier.Version = current.Version + 1
+		supplier.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		supplier.SearchGrams = search.Grams(supplier.Name)
+		// the version in the filter makes the update fail if the supplier
+		// changed since it was read when there are no transactions
+		res, err := collection.UpdateOne(ctx, live(bson.M{"_id": current.ID, "version": versionFilter(current.Version)}), bson.M{"$set": supplier})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			if version != 0 {
+				return problem.PreconditionFailed("version_mismatch", "supplier was modified concurrently")
+			}
+			return problem.Conflict("concurrent_update", "supplier was modified concurrently")
+		}
+		return record(ctx, history.Updated, actor, current, supplier)
+	})
 	if err != nil {
-		return supplier, http.StatusInternalServerError, err
+		return supplier, problem.From(err)
 	}
-	return supplier, http.StatusOK, nil
+	box.Notify()
+	cached.Set(ctx, supplier.ID.Hex(), supplier)
+	return supplier, nil
 }
 
-// UpdateSupplier updates a Supplier by ID
-func UpdateSupplier(id string, supplier Supplier) (Supplier, int, error) {
-	// check if supplier exists
-	_, status, err := GetSupplier(id)
+// The policies applied to the Orders referencing a deleted Supplier
+const (
+	// RestrictOrders refuses to delete a Supplier referenced by Orders
+	RestrictOrders = "restrict"
+	// CascadeOrders moves the Orders of a deleted Supplier to the trash with it
+	CascadeOrders = "cascade"
+	// ReassignOrders moves the Orders of a deleted Supplier to another Supplier
+	ReassignOrders = "reassign"
+)
+
+// DeleteSupplier moves a Supplier to the trash, actor is the user deleting it. The
+// Orders referencing it are then handled according to policy, reassignTo is the
+// Supplier they are moved to with ReassignOrders. The supplier goes to the trash first so
+// that no Order can reference it meanwhile, and it is restored when the Orders
+// cannot be handled.
+func DeleteSupplier(ctx context.Context, id string, actor string, policy string, reassignTo string, grpcOrderClient pb.OrderServiceClient) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return supplier, status, err
+		return problem.Validation("invalid_id", "invalid supplier id")
 	}
-	supplierID, err := primitive.ObjectIDFromHex(id)
+	if err := checkOrdersPolicy(ctx, id, policy, reassignTo); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	err = transact(dbCtx, func(ctx context.Context) error {
+		// the change is recorded from the supplier as it was when deleted
+		var deleted Supplier
+		err := collection.FindOneAndUpdate(ctx, live(bson.M{"_id": objectID}), bson.M{
+			"$set": bson.M{"deleted_at": now, "deleted_by": actor, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			return problem.NotFound("supplier_not_found", "supplier not found")
+		} else if err != nil {
+			return err
+		}
+		before := deleted
+		deleted.DeletedAt = now
+		deleted.DeletedBy = actor
+		deleted.UpdatedAt = now
+		deleted.Version++
+		if err := figures.Add(ctx, map[string]float64{"count": -1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Deleted, actor, before, deleted)
+	})
 	if err != nil {
-		return supplier, http.StatusBadRequest, err
+		return problem.From(err)
+	}
+	box.Notify()
+	cached.Delete(ctx, id)
+	figures.Invalidate(ctx)
+
+	if err := applyOrdersPolicy(ctx, id, policy, reassignTo, grpcOrderClient); err != nil {
+		// the supplier comes back along with the orders moved to the trash with
+		// it, even when the request timed out
+		if _, rerr := RestoreSupplier(middleware.Detach(ctx), id, actor, grpcOrderClient); rerr != nil {
+			log.Printf("cannot restore supplier %s after failing to handle its orders: %s", id, rerr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+// checkOrdersPolicy checks that policy can be applied to the Orders of the
+// Supplier id before it is deleted
+func checkOrdersPolicy(ctx context.Context, id string, policy string, reassignTo string) error {
+	switch policy {
+	case RestrictOrders, CascadeOrders:
+		return nil
+	case ReassignOrders:
+		if reassignTo == "" {
+			return problem.Validation("missing_reassign_to", "reassign_to is required to reassign the orders of the supplier")
+		}
+		if reassignTo == id {
+			return problem.Validation("invalid_reassign_to", "the orders cannot be reassigned to the deleted supplier")
+		}
+		// check if the supplier the orders move to exists
+		_, err := GetSupplier(ctx, reassignTo)
+		return err
+	}
+	return problem.Internal(fmt.Errorf("unknown orders policy %q", policy))
+}
+
+// applyOrdersPolicy handles the Orders referencing the Supplier id once it is in
+// the trash, the calls to the orders service carry the token of the caller
+// from ctx
+func applyOrdersPolicy(ctx context.Context, id string, policy string, reassignTo string, grpcOrderClient pb.OrderServiceClient) error {
+	var err error
+	switch policy {
+	case RestrictOrders:
+		var res *pb.OrdersCount
+		res, err = grpcOrderClient.CountOrdersBySupplier(ctx, &pb.Supplier{Id: id})
+		if err == nil && res.Count > 0 {
+			return problem.Referenced("supplier_has_orders", fmt.Sprintf("supplier is referenced by %d orders", res.Count)).WithCount(res.Count)
+		}
+	case CascadeOrders:
+		_, err = grpcOrderClient.DeleteOrdersBySupplier(ctx, &pb.Supplier{Id: id})
+	case ReassignOrders:
+		_, err = grpcOrderClient.ReassignOrdersBySupplier(ctx, &pb.Reassignment{FromId: id, ToId: reassignTo})
 	}
-	supplier.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": supplierID}, bson.M{"$set": supplier})
 	if err != nil {
-		return supplier, http.StatusInternalServerError, err
+		return problem.From(err)
 	}
-	// set supplier in cache for 5 minutes
-	err = rdb.Set(ctx, id, supplier, time.Minute*5).Err()
+	return nil
+}
+
+// GetDeletedSuppliers returns the Suppliers in the trash, most recently deleted first
+func GetDeletedSuppliers(ctx context.Context) (Suppliers, error) {
+	suppliers := Suppliers{}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	cursor, err := collection.Find(dbCtx, bson.M{"deleted_at": bson.M{"$exists": true}}, options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}))
 	if err != nil {
-		return supplier, http.StatusInternalServerError, err
+		return suppliers, problem.From(err)
+	}
+	if err := cursor.All(dbCtx, &suppliers); err != nil {
+		return suppliers, problem.From(err)
 	}
-	return supplier, http.StatusOK, nil
+	return suppliers, nil
 }
 
-// DeleteSupplier deletes a Supplier by ID
-func DeleteSupplier(id string) (int, error) {
-	// check if supplier exists
-	_, status, err := GetSupplier(id)
+// RestoreSupplier moves a Supplier out of the trash along with the Orders deleted
+// with it through grpcOrderClient, actor is the user restoring it
+func RestoreSupplier(ctx context.Context, id string, actor string, grpcOrderClient pb.OrderServiceClient) (Supplier, error) {
+	var supplier Supplier
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return status, err
+		return supplier, problem.Validation("invalid_id", "invalid supplier id")
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	deleted := bson.M{"_id": objectID, "deleted_at": bson.M{"$exists": true}}
+	err = collection.FindOne(dbCtx, deleted).Decode(&supplier)
+	if err == mongo.ErrNoDocuments {
+		return supplier, problem.NotFound("supplier_not_in_trash", "supplier not found in the trash")
+	} else if err != nil {
+		return supplier, problem.From(err)
 	}
-	supplierID, err := primitive.ObjectIDFromHex(id)
+	// the orders come back first so that a failed restore can be retried
+	_, err = grpcOrderClient.RestoreOrdersBySupplier(ctx, &pb.Supplier{Id: id})
 	if err != nil {
-		return http.StatusBadRequest, err
+		return supplier, problem.From(err)
 	}
-	_, err = collection.DeleteOne(ctx, bson.M{"_id": supplierID})
+	before := supplier
+	supplier.DeletedAt = ""
+	supplier.DeletedBy = ""
+	supplier.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	deleted["version"] = versionFilter(supplier.Version)
+	supplier.Version++
+	err = transact(dbCtx, func(ctx context.Context) error {
+		res, err := collection.UpdateOne(ctx, deleted, bson.M{
+			"$set":   bson.M{"updated_at": supplier.UpdatedAt, "version": supplier.Version},
+			"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return problem.Conflict("concurrent_update", "supplier was modified concurrently")
+		}
+		if err := figures.Add(ctx, map[string]float64{"count": 1}); err != nil {
+			return err
+		}
+		return record(ctx, history.Restored, actor, before, supplier)
+	})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return supplier, problem.From(err)
 	}
-	// remove supplier from cache
-	err = rdb.Del(ctx, id).Err()
+	box.Notify()
+	// the supplier may be cached as not found since it was deleted
+	cached.Set(ctx, id, supplier)
+	figures.Invalidate(ctx)
+	return supplier, nil
+}
+
+// PurgeSuppliers permanently deletes the Suppliers in the trash for longer than
+// retention
+func PurgeSuppliers(ctx context.Context, retention time.Duration) error {
+	cutoff := time.Now().UTC().Add(-retention).Format(time.RFC3339)
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	res, err := collection.DeleteMany(dbCtx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return err
+	}
+	if res.DeletedCount > 0 {
+		log.Printf("purged %d suppliers from the trash", res.DeletedCount)
 	}
-	return http.StatusOK, nil
+	return nil
+}
+
+// CountSuppliers returns the number of Suppliers, out of the trash
+func CountSuppliers(ctx context.Context) (int64, error) {
+	counts, err := figures.Get(ctx)
+	if err != nil {
+		return 0, problem.From(err)
+	}
+	return int64(counts["count"]), nil
+}
+
+// RecomputeStats counts the Suppliers again to correct the drift of the counters
+// kept along with the changes
+func RecomputeStats(ctx context.Context) error {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	if err := transact(dbCtx, figures.Recompute); err != nil {
+		return err
+	}
+	figures.Invalidate(ctx)
+	return nil
+}
+
+// GetSupplierHistory returns the changes made to a Supplier by ID, most recent first
+func GetSupplierHistory(ctx context.Context, id string) (history.Entries, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return history.Entries{}, problem.Validation("invalid_id", "invalid supplier id")
+	}
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, history.Query{RecordID: id, Limit: history.MaxLimit})
+}
+
+// GetSupplierAudit returns the changes made to the Suppliers matching q, most recent
+// first
+func GetSupplierAudit(ctx context.Context, q history.Query) (history.Entries, error) {
+	dbCtx, cancel := middleware.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	return changes.Find(dbCtx, q)
 }
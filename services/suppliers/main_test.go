@@ -0,0 +1,760 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Omar-Belghaouti/pdash/services/common/events"
+	"github.com/Omar-Belghaouti/pdash/services/common/history"
+	"github.com/Omar-Belghaouti/pdash/services/common/memdb"
+	"github.com/Omar-Belghaouti/pdash/services/common/patch"
+	"github.com/Omar-Belghaouti/pdash/services/common/pb"
+	"github.com/Omar-Belghaouti/pdash/services/common/problem"
+	"github.com/Omar-Belghaouti/pdash/services/common/rpc"
+	"github.com/Omar-Belghaouti/pdash/services/common/testutil"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/api"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/data"
+	"github.com/Omar-Belghaouti/pdash/services/suppliers/util"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// orderServer pretends suppliers have the orders in counts and records the
+// orders policies applied to them
+type orderServer struct {
+	pb.UnimplementedOrderServiceServer
+	mu      sync.Mutex
+	counts  map[string]int64
+	applied []string
+}
+
+func (s *orderServer) CountOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) DeleteOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "delete "+in.Id+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) RestoreOrdersBySupplier(ctx context.Context, in *pb.Supplier) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "restore "+in.Id+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.Id]}, nil
+}
+
+func (s *orderServer) ReassignOrdersBySupplier(ctx context.Context, in *pb.Reassignment) (*pb.OrdersCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, "reassign "+in.FromId+" to "+in.ToId+" by "+rpc.User(ctx))
+	return &pb.OrdersCount{Count: s.counts[in.FromId]}, nil
+}
+
+type testEnv struct {
+	app         *fiber.App
+	mr          *miniredis.Miniredis
+	client      pb.SupplierServiceClient
+	authClient  pb.AuthServiceClient
+	authServer  *grpc.Server
+	orders      *orderServer
+	orderClient pb.OrderServiceClient
+}
+
+func setup(t *testing.T) testEnv {
+	t.Helper()
+	mr, rdb := testutil.Redis(t)
+	data.Use(memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), memdb.NewCollection(), rdb)
+
+	auth, authClient := testutil.Auth(t)
+
+	orders := &orderServer{counts: map[string]int64{}}
+	ordersServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.Authenticate(authClient)))
+	pb.RegisterOrderServiceServer(ordersServer, orders)
+
+	config := util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.RestrictOrders}
+	orderClient := pb.NewOrderServiceClient(testutil.ServeGRPC(t, ordersServer))
+	return testEnv{
+		app:         api.NewApp(config, authClient, orderClient),
+		mr:          mr,
+		client:      pb.NewSupplierServiceClient(testutil.ServeGRPC(t, api.NewGRPCServer(config.OrdersOnDelete, authClient, orderClient))),
+		authClient:  authClient,
+		authServer:  auth,
+		orders:      orders,
+		orderClient: orderClient,
+	}
+}
+
+// cacheKey is the Redis key of the cached supplier id
+func cacheKey(id string) string {
+	return "suppliers:v1:" + id
+}
+
+func (env testEnv) request(t *testing.T, method, target string, body interface{}) (int, []byte) {
+	t.Helper()
+	res, b := testutil.Request(t, env.app, method, target, body, "Authorization", "Bearer "+testutil.Token)
+	return res.StatusCode, b
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	env := setup(t)
+	tests := []struct {
+		name     string
+		header   string
+		expected int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"malformed header", testutil.Token, http.StatusUnauthorized},
+		{"invalid token", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "Bearer " + testutil.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, env.app, http.MethodGet, "/suppliers", nil, "Authorization", tt.header)
+		if res.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.expected, res.StatusCode, body)
+		}
+	}
+
+	env.authServer.Stop()
+	res, body := testutil.Request(t, env.app, http.MethodGet, "/suppliers", nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("auth down: expected 503, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestSupplierCRUD(t *testing.T) {
+	env := setup(t)
+	ctx := context.Background()
+
+	code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Acme"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	id := supplier.ID.Hex()
+
+	code, body = env.request(t, http.MethodGet, "/suppliers", nil)
+	var suppliers struct {
+		Items data.Suppliers `json:"items"`
+	}
+	json.Unmarshal(body, &suppliers)
+	if code != http.StatusOK || len(suppliers.Items) != 1 {
+		t.Fatalf("list: expected 1 supplier, got %d: %s", code, body)
+	}
+
+	code, body = env.request(t, http.MethodGet, "/suppliers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d: %s", code, body)
+	}
+	if !env.mr.Exists(cacheKey(id)) {
+		t.Fatal("supplier should be cached after a get")
+	}
+
+	code, body = env.request(t, http.MethodPut, "/suppliers/"+id, data.Supplier{Name: "Acme Corp"})
+	if code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", code, body)
+	}
+	res, err := env.client.GetSupplier(ctx, &pb.Supplier{Id: id})
+	if err != nil || res.Name != "Acme Corp" {
+		t.Fatalf("grpc get: expected updated supplier, got %v (%v)", res, err)
+	}
+
+	// a supplier with orders is kept
+	env.orders.counts[id] = 1
+	code, body = env.request(t, http.MethodDelete, "/suppliers/"+id, nil)
+	if code != http.StatusConflict {
+		t.Fatalf("delete with orders: expected 409, got %d: %s", code, body)
+	}
+	delete(env.orders.counts, id)
+
+	code, body = env.request(t, http.MethodDelete, "/suppliers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	if env.mr.Exists(cacheKey(id)) {
+		t.Fatal("supplier should be evicted from the cache after a delete")
+	}
+	code, _ = env.request(t, http.MethodGet, "/suppliers/"+id, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("get deleted: expected 404, got %d", code)
+	}
+	_, err = env.client.GetSupplier(ctx, &pb.Supplier{Id: id})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("grpc get deleted: expected NotFound, got %v", err)
+	}
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	env := setup(t)
+	app := api.NewApp(util.Config{RequestTimeout: 5 * time.Second, RequireIfMatch: true, OrdersOnDelete: data.RestrictOrders}, env.authClient, env.orderClient)
+	auth := []string{"Authorization", "Bearer " + testutil.Token}
+
+	res, body := testutil.Request(t, app, http.MethodPost, "/suppliers", data.Supplier{Name: "Omar"}, auth...)
+	if res.StatusCode != http.StatusCreated || res.Header.Get(fiber.HeaderETag) != `"1"` {
+		t.Fatalf("create: expected 201 with ETag \"1\", got %d %s: %s", res.StatusCode, res.Header.Get(fiber.HeaderETag), body)
+	}
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	target := "/suppliers/" + supplier.ID.Hex()
+
+	tests := []struct {
+		name     string
+		ifMatch  string
+		expected int
+		etag     string
+		code     string
+	}{
+		{"missing If-Match", "", http.StatusPreconditionRequired, "", "if_match_required"},
+		{"current version", `"1"`, http.StatusOK, `"2"`, ""},
+		{"stale version", `"1"`, http.StatusPreconditionFailed, "", "version_mismatch"},
+		{"any version", "*", http.StatusOK, `"3"`, ""},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodPut, target, data.Supplier{Name: tt.name}, append(auth, fiber.HeaderIfMatch, tt.ifMatch)...)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != tt.expected || res.Header.Get(fiber.HeaderETag) != tt.etag || p.Code != tt.code {
+			t.Errorf("%s: expected %d %s %s, got %d %s: %s", tt.name, tt.expected, tt.etag, tt.code, res.StatusCode, res.Header.Get(fiber.HeaderETag), body)
+		}
+		var updated data.Supplier
+		if json.Unmarshal(body, &updated); tt.expected == http.StatusOK && (updated.Name != tt.name || `"`+strconv.FormatInt(updated.Version, 10)+`"` != tt.etag) {
+			t.Errorf("%s: expected the updated supplier, got %s", tt.name, body)
+		}
+	}
+
+	res, body = testutil.Request(t, app, http.MethodGet, target, nil, auth...)
+	json.Unmarshal(body, &supplier)
+	if res.Header.Get(fiber.HeaderETag) != `"3"` || supplier.Version != 3 || supplier.Name != "any version" {
+		t.Errorf("get: expected the third version, got %s: %s", res.Header.Get(fiber.HeaderETag), body)
+	}
+	grpcSupplier, err := env.client.GetSupplier(context.Background(), &pb.Supplier{Id: supplier.ID.Hex()})
+	if err != nil || grpcSupplier.Version != 3 {
+		t.Errorf("grpc get: expected version 3, got %v (%v)", grpcSupplier, err)
+	}
+
+	// updates without If-Match are accepted when it is not required
+	code, body := env.request(t, http.MethodPut, target, data.Supplier{Name: "Omar"})
+	if code != http.StatusOK {
+		t.Errorf("update without If-Match: expected 200, got %d: %s", code, body)
+	}
+}
+
+func TestPatchSupplier(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Acme"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var created data.Supplier
+	json.Unmarshal(body, &created)
+	target := "/suppliers/" + created.ID.Hex()
+
+	res, body := testutil.Request(t, env.app, http.MethodPatch, target, json.RawMessage(`{"name":"Acme Corp"}`), "Authorization", "Bearer "+testutil.Token, fiber.HeaderContentType, patch.ContentType)
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	if res.StatusCode != http.StatusOK || supplier.Name != "Acme Corp" || supplier.ID != created.ID || supplier.CreatedAt != created.CreatedAt || supplier.Version != 2 {
+		t.Fatalf("patch: expected the renamed supplier at version 2, got %d: %s", res.StatusCode, body)
+	}
+	res, body = testutil.Request(t, env.app, http.MethodPatch, target, json.RawMessage(`{"id":"`+primitive.NewObjectID().Hex()+`"}`), "Authorization", "Bearer "+testutil.Token, fiber.HeaderContentType, patch.ContentType)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if res.StatusCode != http.StatusBadRequest || p.Code != "immutable_field" {
+		t.Fatalf("patch id: expected 400 immutable_field, got %d: %s", res.StatusCode, body)
+	}
+	grpcSupplier, err := env.client.GetSupplier(context.Background(), &pb.Supplier{Id: created.ID.Hex()})
+	if err != nil || grpcSupplier.Name != "Acme Corp" || grpcSupplier.CreatedAt != created.CreatedAt {
+		t.Fatalf("grpc get: expected the patched supplier, got %v (%v)", grpcSupplier, err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Acme"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	target := "/suppliers/" + supplier.ID.Hex()
+	env.request(t, http.MethodPut, target, data.Supplier{Name: "Acme Corp"})
+	env.request(t, http.MethodDelete, target, nil)
+	env.request(t, http.MethodPost, target+"/restore", nil)
+
+	code, body = env.request(t, http.MethodGet, target+"/history", nil)
+	var entries history.Entries
+	json.Unmarshal(body, &entries)
+	var actions []string
+	for _, e := range entries {
+		if e.Actor != testutil.User || e.RecordID != supplier.ID.Hex() || e.Entity != "supplier" {
+			t.Errorf("history: unexpected entry %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(actions, []string{history.Restored, history.Deleted, history.Updated, history.Created}) {
+		t.Fatalf("history: expected restore, delete, update and create, got %d: %s", code, body)
+	}
+	if rename := entries[2]; rename.Version != 2 || !reflect.DeepEqual(rename.Changes, []history.Change{{Field: "name", Before: "Acme", After: "Acme Corp"}}) {
+		t.Errorf("history: expected the rename at version 2, got %+v", rename)
+	}
+
+	// every change is stored in the outbox with its event and published once
+	// the outbox is relayed
+	if msgs, _ := env.mr.Stream(events.Stream("supplier")); len(msgs) != 0 {
+		t.Errorf("events: expected no event before the relay")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go data.RelayOutbox(ctx, 10*time.Millisecond)
+	var types []string
+	testutil.Eventually(2*time.Second, func() bool {
+		msgs, _ := env.mr.Stream(events.Stream("supplier"))
+		types = nil
+		for _, msg := range msgs {
+			for i := 0; i+1 < len(msg.Values); i += 2 {
+				if msg.Values[i] == "type" {
+					types = append(types, msg.Values[i+1])
+				}
+			}
+		}
+		return len(types) >= 4
+	})
+	if !reflect.DeepEqual(types, []string{"supplier.created", "supplier.updated", "supplier.deleted", "supplier.restored"}) {
+		t.Errorf("events: expected the 4 changes, got %v", types)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+		entries  int
+	}{
+		{"", http.StatusOK, 4},
+		{"?action=update", http.StatusOK, 1},
+		{"?actor=nobody", http.StatusOK, 0},
+		{"?record_id=" + supplier.ID.Hex() + "&limit=2", http.StatusOK, 2},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		code, body := env.request(t, http.MethodGet, "/suppliers/audit"+tt.query, nil)
+		entries := history.Entries{}
+		json.Unmarshal(body, &entries)
+		if code != tt.expected || len(entries) != tt.entries {
+			t.Errorf("audit %s: expected %d with %d entries, got %d: %s", tt.query, tt.expected, tt.entries, code, body)
+		}
+	}
+}
+
+func TestGetSupplierInvalidID(t *testing.T) {
+	env := setup(t)
+	code, body := env.request(t, http.MethodGet, "/suppliers/not-an-id", nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", code, body)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	env := setup(t)
+	ctx := context.Background()
+	code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Acme"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	id := supplier.ID.Hex()
+
+	code, body = env.request(t, http.MethodDelete, "/suppliers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodGet, "/suppliers/trash", nil)
+	var trash data.Suppliers
+	json.Unmarshal(body, &trash)
+	if code != http.StatusOK || len(trash) != 1 || trash[0].DeletedBy != testutil.User || trash[0].DeletedAt == "" {
+		t.Fatalf("trash: expected the deleted supplier, got %d: %s", code, body)
+	}
+	code, _ = env.request(t, http.MethodPut, "/suppliers/"+id, data.Supplier{Name: "Acme Corp"})
+	if code != http.StatusNotFound {
+		t.Fatalf("update deleted: expected 404, got %d", code)
+	}
+
+	code, body = env.request(t, http.MethodPost, "/suppliers/"+id+"/restore", nil)
+	json.Unmarshal(body, &supplier)
+	if code != http.StatusOK || supplier.DeletedAt != "" || supplier.Version != 3 {
+		t.Fatalf("restore: expected the supplier at version 3, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodPost, "/suppliers/"+id+"/restore", nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusNotFound || p.Code != "supplier_not_in_trash" {
+		t.Fatalf("restore twice: expected 404 supplier_not_in_trash, got %d: %s", code, body)
+	}
+	if _, err := env.client.GetSupplier(ctx, &pb.Supplier{Id: id}); err != nil {
+		t.Fatalf("grpc get restored: %v", err)
+	}
+
+	// only the suppliers in the trash for longer than the retention are purged
+	env.request(t, http.MethodDelete, "/suppliers/"+id, nil)
+	if err := data.PurgeSuppliers(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	_, body = env.request(t, http.MethodGet, "/suppliers/trash", nil)
+	json.Unmarshal(body, &trash)
+	if len(trash) != 1 {
+		t.Fatalf("purge: expected the supplier to stay in the trash, got %s", body)
+	}
+	if err := data.PurgeSuppliers(ctx, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	_, body = env.request(t, http.MethodGet, "/suppliers/trash", nil)
+	json.Unmarshal(body, &trash)
+	if len(trash) != 0 {
+		t.Fatalf("purge: expected an empty trash, got %s", body)
+	}
+	code, _ = env.request(t, http.MethodPost, "/suppliers/"+id+"/restore", nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("restore purged: expected 404, got %d", code)
+	}
+}
+
+func TestDeletePolicies(t *testing.T) {
+	env := setup(t)
+	create := func() string {
+		code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Acme"})
+		if code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", code, body)
+		}
+		var supplier data.Supplier
+		json.Unmarshal(body, &supplier)
+		env.orders.mu.Lock()
+		env.orders.counts[supplier.ID.Hex()] = 2
+		env.orders.mu.Unlock()
+		return supplier.ID.Hex()
+	}
+
+	// restrict refuses to delete a supplier with orders
+	id := create()
+	code, body := env.request(t, http.MethodDelete, "/suppliers/"+id, nil)
+	var p problem.Problem
+	json.Unmarshal(body, &p)
+	if code != http.StatusConflict || p.Code != "supplier_has_orders" || p.Count != 2 {
+		t.Fatalf("restrict: expected 409 supplier_has_orders with 2 orders, got %d: %s", code, body)
+	}
+	if code, _ := env.request(t, http.MethodGet, "/suppliers/"+id, nil); code != http.StatusOK {
+		t.Errorf("restrict: expected the supplier to be restored, got %d", code)
+	}
+	if !reflect.DeepEqual(env.orders.applied, []string{"restore " + id + " by " + testutil.User}) {
+		t.Errorf("restrict: expected the supplier to be restored along with its orders, got %v", env.orders.applied)
+	}
+
+	// cascade moves the orders to the trash on behalf of the caller
+	env.orders.applied = nil
+	app := api.NewApp(util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.CascadeOrders}, env.authClient, env.orderClient)
+	res, body := testutil.Request(t, app, http.MethodDelete, "/suppliers/"+id, nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusOK || !reflect.DeepEqual(env.orders.applied, []string{"delete " + id + " by " + testutil.User}) {
+		t.Fatalf("cascade: expected the orders to be deleted, got %d: %s (%v)", res.StatusCode, body, env.orders.applied)
+	}
+
+	// restoring the supplier brings its orders back too
+	env.orders.applied = nil
+	res, body = testutil.Request(t, app, http.MethodPost, "/suppliers/"+id+"/restore", nil, "Authorization", "Bearer "+testutil.Token)
+	if res.StatusCode != http.StatusOK || !reflect.DeepEqual(env.orders.applied, []string{"restore " + id + " by " + testutil.User}) {
+		t.Fatalf("cascade: expected the orders to be restored, got %d: %s (%v)", res.StatusCode, body, env.orders.applied)
+	}
+
+	// reassign moves the orders to an existing supplier
+	app = api.NewApp(util.Config{RequestTimeout: 5 * time.Second, OrdersOnDelete: data.ReassignOrders}, env.authClient, env.orderClient)
+	from, to := create(), create()
+	env.orders.applied = nil
+	tests := []struct {
+		query    string
+		expected int
+		code     string
+	}{
+		{"", http.StatusBadRequest, "missing_reassign_to"},
+		{"?reassign_to=" + from, http.StatusBadRequest, "invalid_reassign_to"},
+		{"?reassign_to=" + primitive.NewObjectID().Hex(), http.StatusNotFound, "supplier_not_found"},
+		{"?reassign_to=" + to, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		res, body := testutil.Request(t, app, http.MethodDelete, "/suppliers/"+from+tt.query, nil, "Authorization", "Bearer "+testutil.Token)
+		var p problem.Problem
+		json.Unmarshal(body, &p)
+		if res.StatusCode != tt.expected || p.Code != tt.code {
+			t.Errorf("reassign %q: expected %d %s, got %d: %s", tt.query, tt.expected, tt.code, res.StatusCode, body)
+		}
+	}
+	if !reflect.DeepEqual(env.orders.applied, []string{"reassign " + from + " to " + to + " by " + testutil.User}) {
+		t.Errorf("reassign: expected the orders to be reassigned once, got %v", env.orders.applied)
+	}
+}
+
+func TestSupplierGRPC(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+
+	// changes are only made on behalf of a verified caller
+	if _, err := env.client.CreateSupplier(context.Background(), &pb.Supplier{Name: "Acme"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("create without a token: expected Unauthenticated, got %v", err)
+	}
+
+	if _, err := env.client.CreateSupplier(ctx, &pb.Supplier{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("create without a name: expected InvalidArgument, got %v", err)
+	}
+	created, err := env.client.CreateSupplier(ctx, &pb.Supplier{Name: "Acme"})
+	if err != nil || created.Name != "Acme" {
+		t.Fatalf("create: expected the supplier, got %v (%v)", created, err)
+	}
+	updated, err := env.client.UpdateSupplier(ctx, &pb.Supplier{Id: created.Id, Name: "Acme Corp"})
+	if err != nil || updated.Name != "Acme Corp" || updated.Version != 2 {
+		t.Fatalf("update: expected the supplier at version 2, got %v (%v)", updated, err)
+	}
+
+	stream, err := env.client.GetAllSuppliers(ctx, &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	supplier, err := stream.Recv()
+	if err != nil || supplier.Name != "Acme Corp" {
+		t.Fatalf("stream: expected the supplier, got %v (%v)", supplier, err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream: expected the end of the stream, got %v", err)
+	}
+
+	batch, err := env.client.BatchGetSuppliers(ctx, &pb.Ids{Ids: []string{created.Id, created.Id, "000000000000000000000000"}})
+	if err != nil || len(batch.Suppliers) != 1 || batch.Suppliers[0].Name != "Acme Corp" {
+		t.Fatalf("batch: expected the supplier once, got %v (%v)", batch, err)
+	}
+	if _, err := env.client.BatchGetSuppliers(ctx, &pb.Ids{Ids: []string{"nope"}}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("batch with an invalid id: expected InvalidArgument, got %v", err)
+	}
+
+	hits, err := env.client.SearchSuppliers(ctx, &pb.SearchRequest{Q: "acm corp"})
+	if err != nil || len(hits.Hits) != 1 || hits.Hits[0].Highlight != "<mark>Acm</mark>e <mark>Corp</mark>" {
+		t.Fatalf("search: expected the supplier highlighted, got %v (%v)", hits, err)
+	}
+	if code, body := env.request(t, http.MethodGet, "/suppliers?q=corq", nil); code != http.StatusOK || !strings.Contains(string(body), `"highlight":"Acme \u003cmark\u003eCorp\u003c/mark\u003e"`) {
+		t.Fatalf("search: expected the supplier highlighted, got %d: %s", code, body)
+	}
+
+	if _, err := env.client.DeleteSupplier(ctx, &pb.Supplier{Id: created.Id}); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, err := env.client.DeleteSupplier(ctx, &pb.Supplier{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Fatalf("delete again: expected NotFound, got %v", err)
+	}
+	if hits, err := env.client.SearchSuppliers(ctx, &pb.SearchRequest{Q: "acme"}); err != nil || len(hits.Hits) != 0 {
+		t.Fatalf("search: expected no supplier in the trash, got %v (%v)", hits, err)
+	}
+}
+
+func TestSupplierCache(t *testing.T) {
+	env := setup(t)
+	missing := primitive.NewObjectID().Hex()
+	for i := 0; i < 2; i++ {
+		code, body := env.request(t, http.MethodGet, "/suppliers/"+missing, nil)
+		if code != http.StatusNotFound {
+			t.Fatalf("get missing %d: expected 404, got %d: %s", i, code, body)
+		}
+	}
+	if !env.mr.Exists(cacheKey(missing)) {
+		t.Fatal("a missing supplier should be cached as not found")
+	}
+
+	code, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: "Omar"})
+	if code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", code, body)
+	}
+	var supplier data.Supplier
+	json.Unmarshal(body, &supplier)
+	id := supplier.ID.Hex()
+
+	// the suppliers are read from the database while Redis is down
+	env.mr.Close()
+	code, body = env.request(t, http.MethodGet, "/suppliers/"+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("get with Redis down: expected 200, got %d: %s", code, body)
+	}
+	code, body = env.request(t, http.MethodPut, "/suppliers/"+id, data.Supplier{Name: "Belghaouti"})
+	if code != http.StatusOK {
+		t.Fatalf("update with Redis down: expected 200, got %d: %s", code, body)
+	}
+	res, err := env.client.BatchGetSuppliers(context.Background(), &pb.Ids{Ids: []string{id}})
+	if err != nil || len(res.Suppliers) != 1 || res.Suppliers[0].Name != "Belghaouti" {
+		t.Fatalf("batch get with Redis down: expected the updated supplier, got %v (%v)", res, err)
+	}
+}
+
+func TestCountSuppliers(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	count := func(expected int64) {
+		t.Helper()
+		res, err := env.client.CountSuppliers(ctx, &pb.Empty{})
+		if err != nil || res.Count != expected {
+			t.Fatalf("expected %d suppliers, got %v (%v)", expected, res, err)
+		}
+	}
+	var ids []string
+	for _, name := range []string{"Omar", "Belghaouti"} {
+		created, err := env.client.CreateSupplier(ctx, &pb.Supplier{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.Id)
+	}
+	count(2)
+
+	// the changes are counted and the stored figures are dropped with them
+	if _, err := env.client.CreateSupplier(ctx, &pb.Supplier{Name: "Ali"}); err != nil {
+		t.Fatal(err)
+	}
+	if env.mr.Exists("suppliers:stats") {
+		t.Fatal("expected the stored figures to be invalidated")
+	}
+	count(3)
+	if code, body := env.request(t, http.MethodDelete, "/suppliers/"+ids[0], nil); code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", code, body)
+	}
+	count(2)
+	if code, body := env.request(t, http.MethodPost, "/suppliers/"+ids[0]+"/restore", nil); code != http.StatusOK {
+		t.Fatalf("restore: expected 200, got %d: %s", code, body)
+	}
+	count(3)
+}
+
+func TestBatchGetSuppliers(t *testing.T) {
+	env := setup(t)
+	ctx := rpc.WithToken(context.Background(), testutil.Token)
+	var ids []string
+	for _, name := range []string{"Acme", "Acme Corp"} {
+		created, err := env.client.CreateSupplier(ctx, &pb.Supplier{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.Id)
+	}
+	env.mr.FlushAll()
+
+	missing := primitive.NewObjectID().Hex()
+	for _, cached := range []bool{false, true} {
+		res, err := env.client.BatchGetSuppliers(ctx, &pb.Ids{Ids: []string{ids[1], missing, ids[0], ids[1]}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, supplier := range res.Suppliers {
+			got = append(got, supplier.Id)
+		}
+		if expected := []string{ids[1], ids[0]}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("cached %t: expected %v, got %v", cached, expected, got)
+		}
+		for _, id := range ids {
+			if !env.mr.Exists(cacheKey(id)) {
+				t.Errorf("cached %t: expected %s to be cached", cached, id)
+			}
+		}
+	}
+
+	tooMany := make([]string, data.MaxBatch+1)
+	for i := range tooMany {
+		tooMany[i] = ids[0]
+	}
+	for name, batch := range map[string][]string{"invalid id": {ids[0], "nope"}, "too large": tooMany} {
+		if _, err := env.client.BatchGetSuppliers(ctx, &pb.Ids{Ids: batch}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected %s, got %v", name, codes.InvalidArgument, err)
+		}
+	}
+}
+
+func TestSearchSuppliers(t *testing.T) {
+	env := setup(t)
+	ids := map[string]string{}
+	for _, name := range []string{"Acme Corporation", "Acme Labs", "Globex", "Initech"} {
+		status, body := env.request(t, http.MethodPost, "/suppliers", data.Supplier{Name: name})
+		if status != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", status, body)
+		}
+		var created data.Supplier
+		json.Unmarshal(body, &created)
+		ids[name] = created.ID.Hex()
+	}
+	if status, body := env.request(t, http.MethodPut, "/suppliers/"+ids["Initech"], data.Supplier{Name: "Umbrella"}); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+
+	tests := []struct {
+		q        string
+		expected []string
+	}{
+		{"acme", []string{"Acme Corporation", "Acme Labs"}},
+		{"acm lab", []string{"Acme Labs"}},
+		{"globx", []string{"Globex"}},
+		{"umbrela", []string{"Umbrella"}},
+		{"initech", nil},
+	}
+	for _, tt := range tests {
+		status, body := env.request(t, http.MethodGet, "/suppliers?q="+url.QueryEscape(tt.q), nil)
+		if status != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.q, status, body)
+		}
+		var hits struct{ Items []data.SupplierHit }
+		json.Unmarshal(body, &hits)
+		var names []string
+		for _, hit := range hits.Items {
+			names = append(names, hit.Name)
+		}
+		if !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.q, tt.expected, names)
+		}
+	}
+
+	status, body := env.request(t, http.MethodGet, "/suppliers?q=lab&fields=name", nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", status, body)
+	}
+	var selected struct{ Items []map[string]interface{} }
+	json.Unmarshal(body, &selected)
+	expected := map[string]interface{}{"id": ids["Acme Labs"], "name": "Acme Labs", "score": 0.8, "highlight": "Acme <mark>Lab</mark>s"}
+	if len(selected.Items) != 1 || !reflect.DeepEqual(selected.Items[0], expected) {
+		t.Errorf("expected %v, got %s", expected, body)
+	}
+
+	filtered := url.QueryEscape(`name != "Acme Labs"`)
+	if status, body := env.request(t, http.MethodGet, "/suppliers?q=acme&filter="+filtered, nil); status != http.StatusOK || bytes.Count(body, []byte(`"score"`)) != 1 {
+		t.Errorf("expected a hit, got %d: %s", status, body)
+	}
+	for _, target := range []string{"/suppliers?q=acme&sort=name", "/suppliers?q=%21%21"} {
+		if status, body := env.request(t, http.MethodGet, target, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", target, status, body)
+		}
+	}
+
+	res, err := env.client.SearchSuppliers(context.Background(), &pb.SearchRequest{Q: "acme", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Supplier.Name != "Acme Corporation" || res.Hits[0].Highlight != "<mark>Acme</mark> Corporation" {
+		t.Errorf("unexpected hits %v", res.Hits)
+	}
+}